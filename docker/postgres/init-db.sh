@@ -15,4 +15,6 @@ psql -U "$POSTGRES_USER" -c "CREATE DATABASE $POSTGRES_DB;"
 echo "Restoring database from init.dump..."
 pg_restore -U "$POSTGRES_USER" -d "$POSTGRES_DB" -F c /docker-entrypoint-initdb.d/init.dump
 
+# Миграции схемы применяет reporting-service при запуске, см. internal/repository/postgre/migrate.go
+
 echo "Database restoration complete."
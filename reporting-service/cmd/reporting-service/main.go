@@ -8,6 +8,8 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,7 +24,9 @@ import (
 	"github.com/rs/cors"
 	"go.uber.org/zap"
 
+	"reporting-service/internal/mailer"
 	"reporting-service/internal/services/audience"
+	"reporting-service/internal/storage"
 
 	middleware "reporting-service/internal/middleware"
 
@@ -89,6 +93,13 @@ func main() {
 	}
 	defer postgresDB.Close()
 
+	// Схема Postgres обновляется при каждом запуске, а не только при создании базы
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), migrationTimeout)
+	if err := postgreRepo.Migrate(migrateCtx, postgresDB, logger); err != nil {
+		logger.Fatal("Failed to migrate PostgreSQL", zap.Error(err))
+	}
+	cancelMigrate()
+
 	// Connect to RabbitMQ
 	amqpConn, err := amqp.Dial(cfg.RabbitMQ.URL)
 	if err != nil {
@@ -110,12 +121,53 @@ func main() {
 	mysqlAudienceRepo := mysqlRepo.NewMySQLAudienceRepository(mysqlDB, logger)
 	postgresAudienceRepo := postgreRepo.NewPostgresAudienceRepository(postgresDB, logger)
 
+	// Ключом подписываются ссылки на выгрузки: без него ссылки не переживут перезапуск
+	// и не совпадут между экземплярами сервиса
+	if cfg.Service.ExportSigningKey == "" {
+		logger.Fatal("SERVICE_EXPORT_SIGNING_KEY is required")
+	}
+
+	// Хранилище файлов выгрузок, по умолчанию каталог SERVICE_EXPORT_PATH
+	if cfg.Storage.LocalPath == "" {
+		cfg.Storage.LocalPath = cfg.Service.ExportPath
+	}
+	exportStorage, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Fatal("Failed to initialize export storage", zap.Error(err))
+	} else {
+		logger.Info("Export storage initialized", zap.String("backend", cfg.Storage.Backend))
+	}
+
+	// Почта для подписок на отчеты, без SMTP_HOST рассылка выключена
+	var reportMailer mailer.Mailer
+	if cfg.SMTP.Host != "" {
+		smtpMailer, err := mailer.NewSMTPMailer(cfg.SMTP)
+		if err != nil {
+			logger.Fatal("Failed to initialize mailer", zap.Error(err))
+		}
+		reportMailer = smtpMailer
+	}
+
 	// Initialize services
 	audienceService := audience.NewService(audience.Config{
 		UpdateTime: cfg.Service.UpdateTime,
 		BatchSize:  cfg.Service.BatchSize,
 		ExportPath: cfg.Service.ExportPath,
-	}, mysqlAudienceRepo, postgresAudienceRepo, amqpChan, logger)
+		ExportSigningKey: cfg.Service.ExportSigningKey,
+		ExportLinkTTL:    cfg.Service.ExportLinkTTL,
+		ExportRetention:  cfg.Service.ExportRetention,
+		PDFFonts: audience.PDFFontConfig{
+			Regular: cfg.Service.PDFFontPath,
+			Bold:    cfg.Service.PDFBoldFontPath,
+		},
+		Anomalies: audience.AnomalyConfig{
+			Metrics:      cfg.Service.AnomalyMetrics,
+			BelowAverage: cfg.Service.AnomalyBelowAverage,
+			ZScore:       cfg.Service.AnomalyZScore,
+			WeekDrop:     cfg.Service.AnomalyWeekDrop,
+			MinBase:      cfg.Service.AnomalyMinBase,
+		},
+	}, mysqlAudienceRepo, postgresAudienceRepo, exportStorage, reportMailer, amqpChan, logger)
 
 	// Initialize HTTP handler
 	handler := api.NewHandler(audienceService, logger)
@@ -216,6 +268,119 @@ func main() {
 		}
 	}()
 
+	// Фоновые задачи останавливаются вместе с сервером
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	// Старые фильтры аудиторий по названиям статусов переводим на идентификаторы. Поиск по MacroCRM
+	// идет в фоне, чтобы не задерживать запуск сервера, и ограничен по времени
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		ctx, cancel := context.WithTimeout(workerCtx, filterMigrationTimeout)
+		defer cancel()
+		if err := audienceService.MigrateFilterIDs(ctx); err != nil {
+			logger.Error("Failed to migrate audience filters", zap.Error(err))
+		}
+	}()
+
+	// Поисковый индекс заявок: полная загрузка при первом запуске, дальше только изменения
+	// и периодическая пересборка ради контактов, изменения которых синхронизация не видит
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		if err := audienceService.SyncSearchIndex(workerCtx); err != nil && workerCtx.Err() == nil {
+			logger.Error("Failed to sync search index", zap.Error(err))
+		}
+
+		syncInterval := cfg.Service.SearchSyncInterval
+		if syncInterval <= 0 {
+			syncInterval = 5 * time.Minute
+		}
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+
+		rebuildInterval := cfg.Service.SearchRebuildInterval
+		if rebuildInterval <= 0 {
+			rebuildInterval = 24 * time.Hour
+		}
+		rebuild := time.NewTicker(rebuildInterval)
+		defer rebuild.Stop()
+
+		for {
+			select {
+			case <-workerCtx.Done():
+				return
+			case <-ticker.C:
+				if err := audienceService.SyncSearchIndex(workerCtx); err != nil && workerCtx.Err() == nil {
+					logger.Error("Failed to sync search index", zap.Error(err))
+				}
+			case <-rebuild.C:
+				if err := audienceService.RebuildSearchIndex(workerCtx); err != nil && workerCtx.Err() == nil {
+					logger.Error("Failed to rebuild search index", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	// Фоновые выгрузки: очередь проверяется часто, устаревшие файлы удаляются раз в час
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		workerInterval := cfg.Service.ExportWorkerInterval
+		if workerInterval <= 0 {
+			workerInterval = 5 * time.Second
+		}
+		worker := time.NewTicker(workerInterval)
+		defer worker.Stop()
+
+		cleanup := time.NewTicker(time.Hour)
+		defer cleanup.Stop()
+
+		for {
+			select {
+			case <-workerCtx.Done():
+				return
+			case <-worker.C:
+				if err := audienceService.ProcessExportJobs(workerCtx); err != nil && workerCtx.Err() == nil {
+					logger.Error("Failed to process export jobs", zap.Error(err))
+				}
+			case <-cleanup.C:
+				if err := audienceService.CleanupExports(workerCtx); err != nil && workerCtx.Err() == nil {
+					logger.Error("Failed to clean up exports", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	// Рассылка отчетов по подпискам: расписания cron проверяются раз в минуту
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		if reportMailer == nil {
+			logger.Warn("SMTP_HOST is not set, scheduled report delivery is disabled")
+			return
+		}
+
+		workerInterval := cfg.Service.SubscriptionWorkerInterval
+		if workerInterval <= 0 {
+			workerInterval = time.Minute
+		}
+		ticker := time.NewTicker(workerInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-workerCtx.Done():
+				return
+			case <-ticker.C:
+				if err := audienceService.ProcessReportSubscriptions(workerCtx); err != nil && workerCtx.Err() == nil {
+					logger.Error("Failed to process report subscriptions", zap.Error(err))
+				}
+			}
+		}
+	}()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -229,10 +394,26 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		logger.Warn("Background workers did not stop in time")
+	}
+
 	logger.Info("Server exited properly")
 }
 
 
+const filterMigrationTimeout = 10 * time.Minute
+
+const migrationTimeout = 5 * time.Minute
+
 func getEnvOrDefault(key, defaultValue string) string {
     if value := os.Getenv(key); value != "" {
         return value
@@ -249,6 +430,26 @@ func getEnvAsInt(key string, defaultValue int) int {
     return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+    if value := os.Getenv(key); value != "" {
+        if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+            return floatVal
+        }
+    }
+    return defaultValue
+}
+
+// Список через запятую, пустые элементы пропускаются
+func getEnvAsList(key string) []string {
+    var values []string
+    for _, value := range strings.Split(os.Getenv(key), ",") {
+        if value = strings.TrimSpace(value); value != "" {
+            values = append(values, value)
+        }
+    }
+    return values
+}
+
 func Load() (*config.Config, error) {
     return &config.Config{
         Server: config.ServerConfig{
@@ -295,6 +496,40 @@ func Load() (*config.Config, error) {
             UpdateTime: (time.Duration(getEnvAsInt("SERVICE_UPDATE_TIME", 24)) * time.Hour).String(),
             BatchSize:  getEnvAsInt("SERVICE_BATCH_SIZE", 1000),
             ExportPath: getEnvOrDefault("SERVICE_EXPORT_PATH", "./export"),
+            SearchSyncInterval: time.Duration(getEnvAsInt("SERVICE_SEARCH_SYNC_INTERVAL", 300)) * time.Second,
+            SearchRebuildInterval: time.Duration(getEnvAsInt("SERVICE_SEARCH_REBUILD_INTERVAL", 24)) * time.Hour,
+            ExportWorkerInterval: time.Duration(getEnvAsInt("SERVICE_EXPORT_WORKER_INTERVAL", 5)) * time.Second,
+            ExportSigningKey:     getEnvOrDefault("SERVICE_EXPORT_SIGNING_KEY", ""),
+            ExportLinkTTL:        time.Duration(getEnvAsInt("SERVICE_EXPORT_LINK_TTL", 3600)) * time.Second,
+            ExportRetention:      time.Duration(getEnvAsInt("SERVICE_EXPORT_RETENTION", 72)) * time.Hour,
+            PDFFontPath:          getEnvOrDefault("SERVICE_PDF_FONT_PATH", ""),
+            PDFBoldFontPath:      getEnvOrDefault("SERVICE_PDF_BOLD_FONT_PATH", ""),
+            SubscriptionWorkerInterval: time.Duration(getEnvAsInt("SERVICE_SUBSCRIPTION_WORKER_INTERVAL", 60)) * time.Second,
+            AnomalyMetrics:      getEnvAsList("SERVICE_ANOMALY_METRICS"),
+            AnomalyBelowAverage: getEnvAsFloat("SERVICE_ANOMALY_BELOW_AVERAGE", 0.5),
+            AnomalyZScore:       getEnvAsFloat("SERVICE_ANOMALY_Z_SCORE", 2),
+            AnomalyWeekDrop:     getEnvAsFloat("SERVICE_ANOMALY_WEEK_DROP", 0),
+            AnomalyMinBase:      getEnvAsInt("SERVICE_ANOMALY_MIN_BASE", 10),
+        },
+        Storage: storage.Config{
+            Backend:     getEnvOrDefault("STORAGE_BACKEND", storage.BackendLocal),
+            LocalPath:   getEnvOrDefault("SERVICE_EXPORT_PATH", "./export"),
+            S3Endpoint:  getEnvOrDefault("STORAGE_S3_ENDPOINT", ""),
+            S3Region:    getEnvOrDefault("STORAGE_S3_REGION", "us-east-1"),
+            S3Bucket:    getEnvOrDefault("STORAGE_S3_BUCKET", ""),
+            S3AccessKey: getEnvOrDefault("STORAGE_S3_ACCESS_KEY", ""),
+            S3SecretKey: getEnvOrDefault("STORAGE_S3_SECRET_KEY", ""),
+            S3UseSSL:    getEnvOrDefault("STORAGE_S3_USE_SSL", "false") == "true",
+            S3Prefix:    getEnvOrDefault("STORAGE_S3_PREFIX", ""),
+        },
+        SMTP: mailer.Config{
+            Host:        getEnvOrDefault("SMTP_HOST", ""),
+            Port:        getEnvAsInt("SMTP_PORT", 25),
+            Username:    getEnvOrDefault("SMTP_USERNAME", ""),
+            Password:    getEnvOrDefault("SMTP_PASSWORD", ""),
+            From:        getEnvOrDefault("SMTP_FROM", "reports@localhost"),
+            ImplicitTLS: getEnvOrDefault("SMTP_IMPLICIT_TLS", "false") == "true",
+            Timeout:     time.Duration(getEnvAsInt("SMTP_TIMEOUT", 60)) * time.Second,
         },
     }, nil
 }
\ No newline at end of file
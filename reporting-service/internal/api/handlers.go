@@ -1,14 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"mime"
 
 	//"go/token"
-	//"fmt"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
@@ -16,12 +22,14 @@ import (
 	//"gorm.io/gorm/logger"
 
 	"reporting-service/internal/domain"
+	"reporting-service/internal/middleware"
 	"reporting-service/internal/services/audience"
 )
 
 type Response struct {
-	Error string      `json:"error,omitempty"`
-	Data  interface{} `json:"data,omitempty"`
+	Error  string              `json:"error,omitempty"`
+	Errors []domain.FieldError `json:"errors,omitempty"`
+	Data   interface{}         `json:"data,omitempty"`
 }
 
 type Handler struct {
@@ -41,6 +49,8 @@ func (h *Handler) RegisterPublicRoutes(r *mux.Router) {
 
 	api.HandleFunc("/auth/login", h.Login).Methods(http.MethodPost)
 	api.HandleFunc("/health", h.HealthCheck).Methods(http.MethodGet)
+	// Ссылка на скачивание подписана, авторизация по токену не нужна
+	api.HandleFunc("/exports/{jobId:[0-9]+}/download", h.DownloadExport).Methods(http.MethodGet)
 }
 
 func (h *Handler) RegisterProtectedRoutes(r *mux.Router) {
@@ -54,15 +64,26 @@ func (h *Handler) RegisterProtectedRoutes(r *mux.Router) {
 	api.HandleFunc("/audiences", h.GetAudiences).Methods(http.MethodGet)
 	api.HandleFunc("/audiences", h.CreateAudience).Methods(http.MethodPost)
 	api.HandleFunc("/audiences/integrations", h.CreateIntegrations).Methods(http.MethodPost)
+	api.HandleFunc("/audiences/preview", h.PreviewAudience).Methods(http.MethodPost)
+	api.HandleFunc("/audiences/folders", h.GetAudienceLabels).Methods(http.MethodGet)
 	api.HandleFunc("/audiences/{audienceId}", h.GetAudience).Methods(http.MethodGet)
+	api.HandleFunc("/audiences/{audienceId}", h.UpdateAudience).Methods(http.MethodPut)
 	api.HandleFunc("/audiences/{audienceId}", h.DeleteAudience).Methods(http.MethodDelete)
 	api.HandleFunc("/audiences/{audienceId}/disconnect", h.DisconnectAudience).Methods(http.MethodDelete)
 	api.HandleFunc("/audiences/{audienceId}/export", h.ExportAudience).Methods(http.MethodGet)
+	api.HandleFunc("/audiences/{audienceId}/stats", h.GetAudienceStats).Methods(http.MethodGet)
 	
 	// Applications endpoints
 	api.HandleFunc("/applications/filters", h.GetAudienceFilters).Methods(http.MethodGet)
+	api.HandleFunc("/applications/dictionaries", h.GetFilterDictionary).Methods(http.MethodGet)
 	api.HandleFunc("/applications", h.ListApplications).Methods(http.MethodGet)
 	api.HandleFunc("/applications/export", h.ExportApplications).Methods(http.MethodGet)
+	api.HandleFunc("/applications/{applicationId:[0-9]+}", h.GetApplication).Methods(http.MethodGet)
+	api.HandleFunc("/applications/views", h.ListApplicationViews).Methods(http.MethodGet)
+	api.HandleFunc("/applications/views", h.CreateApplicationView).Methods(http.MethodPost)
+	api.HandleFunc("/applications/views/{viewId:[0-9]+}", h.GetApplicationView).Methods(http.MethodGet)
+	api.HandleFunc("/applications/views/{viewId:[0-9]+}", h.UpdateApplicationView).Methods(http.MethodPut)
+	api.HandleFunc("/applications/views/{viewId:[0-9]+}", h.DeleteApplicationView).Methods(http.MethodDelete)
 	
 	// Regions endpoints
 	api.HandleFunc("/regions", h.GetRegions).Methods(http.MethodGet)
@@ -71,10 +92,33 @@ func (h *Handler) RegisterProtectedRoutes(r *mux.Router) {
 	// Call center endpoints
 	api.HandleFunc("/call-center", h.GetCallCenterReport).Methods(http.MethodGet)
 	api.HandleFunc("/call-center/export", h.ExportCallCenterReport).Methods(http.MethodGet)
+	api.HandleFunc("/call-center/metrics", h.GetCallCenterMetrics).Methods(http.MethodGet)
 
 	// Speed endpoints
 	api.HandleFunc("/speed", h.GetStatusDurationReport).Methods(http.MethodGet)
-	//api.HandleFunc("/speed/export", h.ExportStatusDurationReport).Methods(http.MethodGet)
+	api.HandleFunc("/speed/export", h.ExportStatusDurationReport).Methods(http.MethodGet)
+	api.HandleFunc("/process-speed", h.GetStatusDurationReport).Methods(http.MethodGet)
+	api.HandleFunc("/process-speed/export", h.ExportStatusDurationReport).Methods(http.MethodGet)
+
+	// Background export endpoints
+	api.HandleFunc("/exports", h.CreateExportJob).Methods(http.MethodPost)
+	api.HandleFunc("/exports/{jobId:[0-9]+}", h.GetExportJob).Methods(http.MethodGet)
+
+	// Report subscription endpoints
+	api.HandleFunc("/report-subscriptions", h.ListReportSubscriptions).Methods(http.MethodGet)
+	api.HandleFunc("/report-subscriptions", h.CreateReportSubscription).Methods(http.MethodPost)
+	api.HandleFunc("/report-subscriptions/{subscriptionId:[0-9]+}", h.GetReportSubscription).Methods(http.MethodGet)
+	api.HandleFunc("/report-subscriptions/{subscriptionId:[0-9]+}", h.UpdateReportSubscription).Methods(http.MethodPut)
+	api.HandleFunc("/report-subscriptions/{subscriptionId:[0-9]+}", h.DeleteReportSubscription).Methods(http.MethodDelete)
+	api.HandleFunc("/report-subscriptions/{subscriptionId:[0-9]+}/deliveries", h.ListReportDeliveries).Methods(http.MethodGet)
+	api.HandleFunc("/report-subscriptions/{subscriptionId:[0-9]+}/send", h.SendReportSubscription).Methods(http.MethodPost)
+
+	// Funnel configuration endpoints, changes are allowed to admins only
+	api.HandleFunc("/funnels", h.ListReportFunnels).Methods(http.MethodGet)
+	api.HandleFunc("/funnels", h.CreateReportFunnel).Methods(http.MethodPost)
+	api.HandleFunc("/funnels/{code}", h.GetReportFunnel).Methods(http.MethodGet)
+	api.HandleFunc("/funnels/{code}", h.UpdateReportFunnel).Methods(http.MethodPut)
+	api.HandleFunc("/funnels/{code}", h.DeleteReportFunnel).Methods(http.MethodDelete)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
@@ -163,10 +207,36 @@ func (h *Handler) GetAudienceFilters(w http.ResponseWriter, r *http.Request) {
 	h.jsonResponse(w, filters, http.StatusOK)
 }
 
+func (h *Handler) GetFilterDictionary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	dictionary, err := h.audienceService.GetFilterDictionary(ctx)
+	if err != nil {
+		h.errorResponse(w, "failed to get filter dictionary: "+err.Error(), err, http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, dictionary, http.StatusOK)
+}
+
 func (h *Handler) GetAudiences(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	audiences, err := h.audienceService.AudienceList(ctx)
+	pagination, err := parsePagination(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	filter := &domain.AudienceListFilter{
+		Search:         r.URL.Query().Get("search"),
+		Tag:            r.URL.Query().Get("tag"),
+		Folder:         r.URL.Query().Get("folder"),
+		OrderField:     r.URL.Query().Get("order_field"),
+		OrderDirection: r.URL.Query().Get("order_direction"),
+	}
+
+	audiences, err := h.audienceService.AudienceList(ctx, pagination, filter)
 	if err != nil {
 		h.errorResponse(w, "failed to get audiences: "+err.Error(), err, http.StatusInternalServerError)
 		return
@@ -175,6 +245,43 @@ func (h *Handler) GetAudiences(w http.ResponseWriter, r *http.Request) {
 	h.jsonResponse(w, audiences, http.StatusOK)
 }
 
+func (h *Handler) GetAudienceLabels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	labels, err := h.audienceService.AudienceLabels(ctx)
+	if err != nil {
+		h.errorResponse(w, "failed to get audience folders: "+err.Error(), err, http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, labels, http.StatusOK)
+}
+
+func (h *Handler) UpdateAudience(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	var req domain.AudienceUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	audience, err := h.audienceService.Update(ctx, audienceID, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to update audience", err)
+		return
+	}
+
+	h.jsonResponse(w, audience, http.StatusOK)
+}
+
 func (h *Handler) GetAudience(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -186,7 +293,7 @@ func (h *Handler) GetAudience(w http.ResponseWriter, r *http.Request) {
 	}
 	audiences, err := h.audienceService.GetById(ctx, audienceID)
 	if err != nil {
-		h.errorResponse(w, "failed to get audience by id: "+err.Error(), err, http.StatusInternalServerError)
+		h.serviceErrorResponse(w, "failed to get audience by id", err)
 		return
 	}
 
@@ -210,208 +317,713 @@ func (h *Handler) CreateIntegrations(w http.ResponseWriter, r *http.Request) {
 	h.jsonResponse(w, integrations, http.StatusCreated)
 }
 
-func (h *Handler) CreateAudience(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateAudience(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req domain.AudienceCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	audience, err := h.audienceService.Create(ctx, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to create audience", err)
+		return
+	}
+
+	h.jsonResponse(w, audience, http.StatusCreated)
+}
+
+func (h *Handler) PreviewAudience(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req domain.AudiencePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	preview, err := h.audienceService.Preview(ctx, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to preview audience", err)
+		return
+	}
+
+	h.jsonResponse(w, preview, http.StatusOK)
+}
+
+func (h *Handler) DeleteAudience(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.audienceService.Delete(ctx, audienceID); err != nil {
+		h.serviceErrorResponse(w, "failed to delete audience", err)
+		return
+	}
+
+	h.jsonResponse(w, map[string]string{"status": "success"}, http.StatusOK)
+}
+
+func (h *Handler) DisconnectAudience(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.audienceService.DisconnectAll(ctx, audienceID); err != nil {
+		h.errorResponse(w, "failed to disconnect audience: "+err.Error(), err, http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, map[string]string{"status": "success"}, http.StatusOK)
+}
+
+func (h *Handler) ExportAudience(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	filePath, fileName, err := h.audienceService.ExportAudience(ctx, audienceID)
+	if err != nil {
+		h.errorResponse(w, "failed to export audience: "+err.Error(), err, http.StatusInternalServerError)
+		return
+	}
+
+	h.serveTemporaryExport(w, r, filePath, fileName)
+}
+
+func (h *Handler) GetAudienceStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	filter := &domain.AudienceStatsFilter{}
+
+	if startDate := r.URL.Query().Get("start_date"); startDate != "" {
+		date, err := time.Parse(time.RFC3339, startDate)
+		if err != nil {
+			h.errorResponse(w, "invalid start date format", err, http.StatusBadRequest)
+			return
+		}
+		filter.StartDate = &date
+	}
+
+	if endDate := r.URL.Query().Get("end_date"); endDate != "" {
+		date, err := time.Parse(time.RFC3339, endDate)
+		if err != nil {
+			h.errorResponse(w, "invalid end date format", err, http.StatusBadRequest)
+			return
+		}
+		filter.EndDate = &date
+	}
+
+	// compare=all или список id через запятую
+	if compare := r.URL.Query().Get("compare"); compare == "all" {
+		filter.CompareAll = true
+	} else if compare != "" {
+		for _, rawID := range strings.Split(compare, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(rawID), 10, 64)
+			if err != nil {
+				h.errorResponse(w, "invalid compare audience id: "+rawID, err, http.StatusBadRequest)
+				return
+			}
+			filter.CompareIDs = append(filter.CompareIDs, id)
+		}
+	}
+
+	stats, err := h.audienceService.GetAudienceStats(ctx, audienceID, filter)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get audience stats", err)
+		return
+	}
+
+	h.jsonResponse(w, stats, http.StatusOK)
+}
+
+func (h *Handler) ListApplications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pagination, err := parsePagination(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseApplicationFilter(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+	if err := h.applyApplicationView(r, filter); err != nil {
+		h.serviceErrorResponse(w, "failed to apply view", err)
+		return
+	}
+
+	response, err := h.audienceService.ListApplications(ctx, pagination, filter)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get applications", err)
+		return
+	}
+
+	h.jsonResponse(w, response, http.StatusOK)
+}
+
+func (h *Handler) GetApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	applicationID, err := strconv.ParseInt(vars["applicationId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid application id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	detail, err := h.audienceService.GetApplicationDetail(ctx, applicationID)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get application", err)
+		return
+	}
+
+	h.jsonResponse(w, detail, http.StatusOK)
+}
+
+// Фильтр сохраненного представления из view_id, параметры запроса его дополняют
+func (h *Handler) applyApplicationView(r *http.Request, filter *domain.ApplicationFilterRequest) error {
+	value := r.URL.Query().Get("view_id")
+	if value == "" {
+		return nil
+	}
+	viewID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return domain.NewValidationError("view_id", domain.ValidationCodeInvalidValue, "invalid view id")
+	}
+	return h.audienceService.ApplyApplicationView(r.Context(), middleware.UserID(r.Context()), viewID, filter)
+}
+
+func (h *Handler) ListApplicationViews(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	views, err := h.audienceService.ListApplicationViews(ctx, ownerID)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to list views", err)
+		return
+	}
+
+	h.jsonResponse(w, views, http.StatusOK)
+}
+
+func (h *Handler) GetApplicationView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	viewID, err := strconv.ParseInt(mux.Vars(r)["viewId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid view id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	view, err := h.audienceService.GetApplicationView(ctx, ownerID, viewID)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get view", err)
+		return
+	}
+
+	h.jsonResponse(w, view, http.StatusOK)
+}
+
+func (h *Handler) CreateApplicationView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	var req domain.ApplicationViewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	view, err := h.audienceService.CreateApplicationView(ctx, ownerID, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to create view", err)
+		return
+	}
+
+	h.jsonResponse(w, view, http.StatusCreated)
+}
+
+func (h *Handler) UpdateApplicationView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	viewID, err := strconv.ParseInt(mux.Vars(r)["viewId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid view id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	var req domain.ApplicationViewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	view, err := h.audienceService.UpdateApplicationView(ctx, ownerID, viewID, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to update view", err)
+		return
+	}
+
+	h.jsonResponse(w, view, http.StatusOK)
+}
+
+func (h *Handler) DeleteApplicationView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	viewID, err := strconv.ParseInt(mux.Vars(r)["viewId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid view id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.audienceService.DeleteApplicationView(ctx, ownerID, viewID); err != nil {
+		h.serviceErrorResponse(w, "failed to delete view", err)
+		return
+	}
+
+	h.jsonResponse(w, map[string]string{"status": "success"}, http.StatusOK)
+}
+
+func (h *Handler) ExportApplications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	filter, err := parseApplicationFilter(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+	if err := h.applyApplicationView(r, filter); err != nil {
+		h.serviceErrorResponse(w, "failed to apply view", err)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	switch format {
+	case "", audience.ExportFormatXLSX:
+	case audience.ExportFormatCSV, audience.ExportFormatTSV:
+		h.streamApplications(w, r, *filter, format)
+		return
+	default:
+		h.errorResponse(w, "unsupported export format", fmt.Errorf("format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	filePath, fileName, err := h.audienceService.ExportApplications(ctx, *filter)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to export applications", err)
+		return
+	}
+
+	h.serveTemporaryExport(w, r, filePath, fileName)
+}
+
+// CSV и TSV отдаются по мере чтения из базы, без файла на диске
+func (h *Handler) streamApplications(w http.ResponseWriter, r *http.Request, filter domain.ApplicationFilterRequest, format string) {
+	contentType := "text/csv; charset=utf-8"
+	if format == audience.ExportFormatTSV {
+		contentType = "text/tab-separated-values; charset=utf-8"
+	}
+	fileName := fmt.Sprintf("applications_export_%s.%s", time.Now().Format("20060102_150405"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", attachmentDisposition(fileName))
+
+	writer := &trackingWriter{ResponseWriter: w}
+	if err := h.audienceService.StreamApplications(r.Context(), filter, format, writer); err != nil {
+		// Если часть файла уже отправлена, сменить статус нельзя - только оборвать соединение,
+		// иначе клиент сохранит обрезанный файл как полный
+		if writer.written {
+			h.logger.Error("applications stream interrupted", zap.Error(err))
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Del("Content-Disposition")
+		h.serviceErrorResponse(w, "failed to export applications", err)
+	}
+}
+
+// Запоминает, начата ли запись тела ответа
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *trackingWriter) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) CreateExportJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	var req domain.ExportJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.audienceService.CreateExportJob(ctx, ownerID, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to create export job", err)
+		return
+	}
+
+	h.jsonResponse(w, job, http.StatusAccepted)
+}
+
+func (h *Handler) GetExportJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobID, err := strconv.ParseInt(mux.Vars(r)["jobId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid export job id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.audienceService.GetExportJob(ctx, middleware.UserID(ctx), jobID)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get export job", err)
+		return
+	}
+
+	h.jsonResponse(w, job, http.StatusOK)
+}
+
+func (h *Handler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobID, err := strconv.ParseInt(mux.Vars(r)["jobId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid export job id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.audienceService.GetExportDownload(ctx, jobID, r.URL.Query().Get("expires"), r.URL.Query().Get("signature"))
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to download export", err)
+		return
+	}
+
+	h.serveExport(w, r, job.FilePath, job.FileName)
+}
+
+// Отдает файл выгрузки из хранилища с поддержкой Range. Заголовки скачивания выставляются
+// только после открытия файла, чтобы ошибка пришла обычным JSON-ответом
+func (h *Handler) serveExport(w http.ResponseWriter, r *http.Request, key, fileName string) {
+	file, err := h.audienceService.OpenExport(r.Context(), key)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to open export file", err)
+		return
+	}
+	defer file.Close()
+
+	if contentType := audience.ExportContentType(key); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", attachmentDisposition(fileName))
+	http.ServeContent(w, r, fileName, file.ModTime, file)
+}
+
+// Разовая выгрузка: файл нужен только для этого ответа и удаляется сразу после отдачи
+func (h *Handler) serveTemporaryExport(w http.ResponseWriter, r *http.Request, key, fileName string) {
+	defer func() {
+		if err := h.audienceService.RemoveExport(context.Background(), key); err != nil {
+			h.logger.Error("failed to remove export file", zap.String("path", key), zap.Error(err))
+		}
+	}()
+	h.serveExport(w, r, key, fileName)
+}
+
+func (h *Handler) ListReportSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	subscriptions, err := h.audienceService.ListReportSubscriptions(ctx, middleware.UserID(ctx))
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to list report subscriptions", err)
+		return
+	}
+
+	h.jsonResponse(w, subscriptions, http.StatusOK)
+}
+
+func (h *Handler) GetReportSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["subscriptionId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid subscription id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := h.audienceService.GetReportSubscription(ctx, middleware.UserID(ctx), subscriptionID)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get report subscription", err)
+		return
+	}
+
+	h.jsonResponse(w, subscription, http.StatusOK)
+}
+
+func (h *Handler) CreateReportSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ownerID := middleware.UserID(ctx)
+	if ownerID == "" {
+		h.errorResponse(w, "user is not identified", nil, http.StatusUnauthorized)
+		return
+	}
+
+	var req domain.ReportSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := h.audienceService.CreateReportSubscription(ctx, ownerID, req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to create report subscription", err)
+		return
+	}
+
+	h.jsonResponse(w, subscription, http.StatusCreated)
+}
+
+func (h *Handler) UpdateReportSubscription(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	var req domain.AudienceCreateRequest
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["subscriptionId"], 10, 64)
+	if err != nil {
+		h.errorResponse(w, "invalid subscription id: "+err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	var req domain.ReportSubscriptionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
 		return
 	}
 
-	audience, err := h.audienceService.Create(ctx, req)
+	subscription, err := h.audienceService.UpdateReportSubscription(ctx, middleware.UserID(ctx), subscriptionID, req)
 	if err != nil {
-		h.errorResponse(w, "failed to create audience: "+err.Error(), err, http.StatusInternalServerError)
+		h.serviceErrorResponse(w, "failed to update report subscription", err)
 		return
 	}
 
-	h.jsonResponse(w, audience, http.StatusCreated)
+	h.jsonResponse(w, subscription, http.StatusOK)
 }
 
-func (h *Handler) DeleteAudience(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteReportSubscription(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
 
-	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["subscriptionId"], 10, 64)
 	if err != nil {
-		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		h.errorResponse(w, "invalid subscription id: "+err.Error(), err, http.StatusBadRequest)
 		return
 	}
 
-	if err := h.audienceService.Delete(ctx, audienceID); err != nil {
-		h.errorResponse(w, "failed to delete audience: "+err.Error(), err, http.StatusInternalServerError)
+	if err := h.audienceService.DeleteReportSubscription(ctx, middleware.UserID(ctx), subscriptionID); err != nil {
+		h.serviceErrorResponse(w, "failed to delete report subscription", err)
 		return
 	}
 
 	h.jsonResponse(w, map[string]string{"status": "success"}, http.StatusOK)
 }
 
-func (h *Handler) DisconnectAudience(w http.ResponseWriter, r *http.Request) {
+// История отправок подписки, параметр limit ограничивает количество записей
+func (h *Handler) ListReportDeliveries(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
 
-	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["subscriptionId"], 10, 64)
 	if err != nil {
-		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		h.errorResponse(w, "invalid subscription id: "+err.Error(), err, http.StatusBadRequest)
 		return
 	}
 
-	if err := h.audienceService.DisconnectAll(ctx, audienceID); err != nil {
-		h.errorResponse(w, "failed to disconnect audience: "+err.Error(), err, http.StatusInternalServerError)
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			h.errorResponse(w, "invalid limit: "+err.Error(), err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := h.audienceService.ListReportDeliveries(ctx, middleware.UserID(ctx), subscriptionID, limit)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to list report deliveries", err)
 		return
 	}
 
-	h.jsonResponse(w, map[string]string{"status": "success"}, http.StatusOK)
+	h.jsonResponse(w, deliveries, http.StatusOK)
 }
 
-func (h *Handler) ExportAudience(w http.ResponseWriter, r *http.Request) {
+// Ставит внеочередную отправку в очередь, письмо уходит при следующем проходе рассылки
+func (h *Handler) SendReportSubscription(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
 
-	audienceID, err := strconv.ParseInt(vars["audienceId"], 10, 64)
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["subscriptionId"], 10, 64)
 	if err != nil {
-		h.errorResponse(w, "invalid audience id: "+err.Error(), err, http.StatusBadRequest)
+		h.errorResponse(w, "invalid subscription id: "+err.Error(), err, http.StatusBadRequest)
 		return
 	}
 
-	filePath, fileName, err := h.audienceService.ExportAudience(ctx, audienceID)
+	delivery, err := h.audienceService.SendReportSubscription(ctx, middleware.UserID(ctx), subscriptionID)
 	if err != nil {
-		h.errorResponse(w, "failed to export audience: "+err.Error(), err, http.StatusInternalServerError)
+		h.serviceErrorResponse(w, "failed to send report subscription", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	http.ServeFile(w, r, filePath)
+	h.jsonResponse(w, delivery, http.StatusAccepted)
 }
 
-func (h *Handler) ListApplications(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	page := r.URL.Query().Get("page")
-	pageSize := r.URL.Query().Get("page_size")
+func (h *Handler) ListReportFunnels(w http.ResponseWriter, r *http.Request) {
+	funnels, err := h.audienceService.ListReportFunnels(r.Context())
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to list funnels", err)
+		return
+	}
 
-	pagination := &domain.PaginationRequest{}
+	h.jsonResponse(w, funnels, http.StatusOK)
+}
 
-	if page != "" {
-		pageNum, err := strconv.Atoi(page)
-		if err != nil || pageNum < 1 {
-			h.errorResponse(w, "invalid page number", err, http.StatusBadRequest)
-			return
-		}
-		pagination.Page = pageNum
+func (h *Handler) GetReportFunnel(w http.ResponseWriter, r *http.Request) {
+	funnel, err := h.audienceService.GetReportFunnel(r.Context(), mux.Vars(r)["code"])
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to get funnel", err)
+		return
 	}
 
-	if pageSize != "" {
-		size, err := strconv.Atoi(pageSize)
-		if err != nil || size < 1 {
-			h.errorResponse(w, "invalid page size", err, http.StatusBadRequest)
-			return
-		}
-		pagination.PageSize = size
-	}
+	h.jsonResponse(w, funnel, http.StatusOK)
+}
 
-	time_from := time.Time{}
-	time_to := time.Time{}
-    err:= error(nil)
-	if r.URL.Query().Get("start_date") != "" {
-		time_from, err = time.Parse(time.RFC3339, r.URL.Query().Get("start_date"))
-		if err != nil  {
-			h.errorResponse(w, "invalid date_from format", err, http.StatusBadRequest)
-			return
-		}
-	}
-	if r.URL.Query().Get("end_date") != "" {
-		time_to, err = time.Parse(time.RFC3339, r.URL.Query().Get("end_date"))
-		if err != nil {
-			h.errorResponse(w, "invalid date_to format", err, http.StatusBadRequest)
-			return
-		}
+func (h *Handler) CreateReportFunnel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !h.requireAdmin(w, r) {
+		return
 	}
-	filter := &domain.ApplicationFilterRequest{
-		OrderField:     r.URL.Query().Get("order_field"),
-		OrderDirection: r.URL.Query().Get("order_direction"),
-		Status:         r.URL.Query().Get("status"),
-		ProjectName:    r.URL.Query().Get("project_name"),
-		PropertyType:   r.URL.Query().Get("property_type"),
-		AudienceName:   r.URL.Query().Get("audience_name"),
-		RegionName:     r.URL.Query().Get("region"),
-	}
-	if !time_from.IsZero() {
-		filter.StartDate = &time_from
-	} 
-	if !time_to.IsZero() {
-		filter.EndDate = &time_to
-	} 
-
-	if daysInStatus := r.URL.Query().Get("days_in_status"); daysInStatus != "" && daysInStatus != "0" {
-		if days, err := strconv.Atoi(daysInStatus); err == nil {
-			filter.StatusDuration = days
-		}
+
+	var req domain.FunnelConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
 	}
 
-	response, err := h.audienceService.ListApplications(ctx, pagination, filter)
+	funnel, err := h.audienceService.CreateReportFunnel(ctx, req)
 	if err != nil {
-		h.errorResponse(w, "failed to get applications", err, http.StatusInternalServerError)
+		h.serviceErrorResponse(w, "failed to create funnel", err)
 		return
 	}
 
-	h.jsonResponse(w, response, http.StatusOK)
+	h.jsonResponse(w, funnel, http.StatusCreated)
 }
 
-func (h *Handler) ExportApplications(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdateReportFunnel(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	if !h.requireAdmin(w, r) {
+		return
+	}
 
-	// vars := mux.Vars(r)
-	time_from := time.Time{}
-	time_to := time.Time{}
-    err:= error(nil)
-	if r.URL.Query().Get("start_date") != "" {
-		time_from, err = time.Parse(time.RFC3339, r.URL.Query().Get("start_date"))
-		if err != nil {
-			h.errorResponse(w, "invalid date_from format", err, http.StatusBadRequest)
-			return
-		}
+	var req domain.FunnelConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, "invalid request body: "+err.Error(), err, http.StatusBadRequest)
+		return
 	}
-	if r.URL.Query().Get("end_date") != "" {
-		time_to, err = time.Parse(time.RFC3339, r.URL.Query().Get("end_date"))
-		if err != nil {
-			h.errorResponse(w, "invalid date_to format", err, http.StatusBadRequest)
-			return
-		}
+
+	funnel, err := h.audienceService.UpdateReportFunnel(ctx, mux.Vars(r)["code"], req)
+	if err != nil {
+		h.serviceErrorResponse(w, "failed to update funnel", err)
+		return
 	}
-	// Parse filter parameters
-	filter := &domain.ApplicationFilterRequest{
-		OrderField:     r.URL.Query().Get("order_field"),
-		OrderDirection: r.URL.Query().Get("order_direction"),
-		Status:         r.URL.Query().Get("status"),
-		ProjectName:    r.URL.Query().Get("project_name"),
-		PropertyType:   r.URL.Query().Get("property_type"),
-	}
-	if !time_from.IsZero() && !time_to.IsZero() {
-		filter.StartDate = &time_from
-		filter.EndDate = &time_to
-	} 
-
-
-	// Parse days_in_status if provided
-	if daysStr := r.URL.Query().Get("days_in_status"); daysStr != "" {
-		if days, err := strconv.Atoi(daysStr); err == nil {
-			filter.StatusDuration = days
-		} else {
-			h.logger.Warn("invalid days_in_status parameter", zap.Error(err))
-		}
+
+	h.jsonResponse(w, funnel, http.StatusOK)
+}
+
+func (h *Handler) DeleteReportFunnel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !h.requireAdmin(w, r) {
+		return
 	}
 
-	filePath, fileName, err := h.audienceService.ExportApplications(ctx, *filter)
-	if err != nil {
-		h.errorResponse(w, "failed to export applications: "+err.Error(), err, http.StatusInternalServerError)
+	if err := h.audienceService.DeleteReportFunnel(ctx, mux.Vars(r)["code"]); err != nil {
+		h.serviceErrorResponse(w, "failed to delete funnel", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	http.ServeFile(w, r, filePath)
+	h.jsonResponse(w, map[string]string{"status": "success"}, http.StatusOK)
+}
+
+// Роль берется из токена сервиса авторизации
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if middleware.UserRole(r.Context()) != "admin" {
+		h.errorResponse(w, "admin role is required", domain.ErrForbidden, http.StatusForbidden)
+		return false
+	}
+	return true
 }
 
 func (h *Handler) GetRegions(w http.ResponseWriter, r *http.Request) {
@@ -484,15 +1096,19 @@ func (h *Handler) ExportRegions(w http.ResponseWriter, r *http.Request) {
 		filter.EndDate = &date
 	}
 
-	filePath, fileName, err := h.audienceService.ExportRegions(ctx, filter)
+	format, err := parseReportFormat(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	filePath, fileName, err := h.audienceService.ExportRegions(ctx, filter, format)
 	if err != nil {
 		h.errorResponse(w, "failed to export regions data"+err.Error(), err, http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	http.ServeFile(w, r, filePath)
+	h.serveTemporaryExport(w, r, filePath, fileName)
 }
 
 func (h *Handler) GetCallCenterReport(w http.ResponseWriter, r *http.Request) {
@@ -518,12 +1134,16 @@ func (h *Handler) GetCallCenterReport(w http.ResponseWriter, r *http.Request) {
 	filter := &domain.CallCenterReportFilter{
 		StartDate: &time_from, 
 		EndDate:   &time_to} 
+	if err := parseCallCenterOptions(r, filter); err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
 		
 	h.logger.Info("GetCallCenterReport", zap.Any("time_from", filter.StartDate.Format("2006-01-02")), zap.Any("time_to", filter.EndDate.Format("2006-01-02")))
     // Get report from service
     report, err := h.audienceService.GetCallCenterReport(ctx, filter)
     if err != nil {
-        h.errorResponse(w, "failed to get sales report", err, http.StatusInternalServerError)
+        h.serviceErrorResponse(w, "failed to get sales report", err)
         return
     }
 
@@ -554,115 +1174,300 @@ func (h *Handler) ExportCallCenterReport(w http.ResponseWriter, r *http.Request)
 	filter := &domain.CallCenterReportFilter{
 		StartDate: &time_from,
 		EndDate:   &time_to,}
+	if err := parseCallCenterOptions(r, filter); err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
 
+	format, err := parseReportFormat(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
 
     // Get exported file path
-    filePath, fileName, err := h.audienceService.ExportCallCenterReport(ctx, filter)
+    filePath, fileName, err := h.audienceService.ExportCallCenterReport(ctx, filter, format)
     if err != nil {
-        h.errorResponse(w, "failed to export sales report", err, http.StatusInternalServerError)
+        h.serviceErrorResponse(w, "failed to export sales report", err)
         return
     }
 
-    // Get filename from path
+	h.serveTemporaryExport(w, r, filePath, fileName)
+}
 
+func (h *Handler) GetCallCenterMetrics(w http.ResponseWriter, r *http.Request) {
+	h.jsonResponse(w, h.audienceService.GetCallCenterMetrics(), http.StatusOK)
+}
 
-    // Set headers for file download
-    w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	http.ServeFile(w, r, filePath)
+// Воронка (funnel), дополнительные колонки воронки (show_optional) и режим сравнения периодов:
+// compare и для произвольного периода compare_start_date, compare_end_date
+func parseCallCenterOptions(r *http.Request, filter *domain.CallCenterReportFilter) error {
+	query := r.URL.Query()
+	if value := query.Get("show_optional"); value != "" {
+		showOptional, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid show_optional value: %w", err)
+		}
+		filter.ShowOptional = showOptional
+	}
+	filter.Compare = query.Get("compare")
+	filter.Funnel = query.Get("funnel")
+	for name, target := range map[string]**time.Time{
+		"compare_start_date": &filter.CompareStartDate,
+		"compare_end_date":   &filter.CompareEndDate,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s format: %w", name, err)
+		}
+		*target = &date
+	}
+	return nil
 }
 
 func (h *Handler) GetStatusDurationReport(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	filter, err := parseStatusDurationFilter(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	h.logger.Info("GetStatusDurationReport", zap.Time("time_from", *filter.StartDate), zap.Time("time_to", *filter.EndDate), zap.Int("trashold", filter.ThresholdDays))
+
+	response, err := h.audienceService.GetSpeedReport(ctx, filter)
+	if err != nil {
+		h.errorResponse(w, "failed to get status duration report", err, http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, response, http.StatusOK)
+}
+
+func (h *Handler) ExportStatusDurationReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	filter, err := parseStatusDurationFilter(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	format, err := parseReportFormat(r)
+	if err != nil {
+		h.errorResponse(w, err.Error(), err, http.StatusBadRequest)
+		return
+	}
+
+	filePath, fileName, err := h.audienceService.ExportStatusDurationReport(ctx, filter, format)
+	if err != nil {
+		h.errorResponse(w, "failed to export status duration report: "+err.Error(), err, http.StatusInternalServerError)
+		return
+	}
+
+	h.serveTemporaryExport(w, r, filePath, fileName)
+}
+
+// Формат выгрузки отчета, по умолчанию xlsx
+func parseReportFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return audience.ExportFormatXLSX, nil
+	}
+	if !audience.IsReportFormat(format) {
+		return "", fmt.Errorf("invalid format %q: expected xlsx, csv, tsv or pdf", format)
+	}
+	return format, nil
+}
+
+// Период и порог отчета по скорости обработки. Пустые даты запрос к базе заменяет последним годом
+func parseStatusDurationFilter(r *http.Request) (*domain.StatusDurationFilter, error) {
 	time_from := time.Time{}
 	time_to := time.Time{}
-    err:= error(nil)
+	err := error(nil)
 	if r.URL.Query().Get("start_date") != "" {
 		time_from, err = time.Parse(time.RFC3339, r.URL.Query().Get("start_date"))
-		if err != nil  {
-			h.errorResponse(w, "invalid date_from format", err, http.StatusBadRequest)
-			return
+		if err != nil {
+			return nil, fmt.Errorf("invalid date_from format: %w", err)
 		}
 	}
 	if r.URL.Query().Get("end_date") != "" {
 		time_to, err = time.Parse(time.RFC3339, r.URL.Query().Get("end_date"))
 		if err != nil {
-			h.errorResponse(w, "invalid date_to format", err, http.StatusBadRequest)
-			return
+			return nil, fmt.Errorf("invalid date_to format: %w", err)
 		}
 	}
 	trashold := 0
 	if r.URL.Query().Get("over_threshold") != "" {
 		trashold, err = strconv.Atoi(r.URL.Query().Get("over_threshold"))
 		if err != nil {
-			h.errorResponse(w, "invalid over_threshold format", err, http.StatusBadRequest)
-			return
+			return nil, fmt.Errorf("invalid over_threshold format: %w", err)
 		}
 	}
-	
-	h.logger.Info("GetStatusDurationReport", zap.Time("time_from", time_from), zap.Time("time_to", time_to), zap.Int("trashold", trashold))
 
-	filter := &domain.StatusDurationFilter{
-		StartDate: &time_from,
-		EndDate:   &time_to,
+	return &domain.StatusDurationFilter{
+		StartDate:     &time_from,
+		EndDate:       &time_to,
 		ThresholdDays: trashold,
+	}, nil
+}
+
+// Максимальный размер страницы в списках
+const maxPageSize = 500
+
+func parsePagination(r *http.Request) (*domain.PaginationRequest, error) {
+	pagination := &domain.PaginationRequest{}
+
+	if page := r.URL.Query().Get("page"); page != "" {
+		pageNum, err := strconv.Atoi(page)
+		if err != nil || pageNum < 1 {
+			return nil, fmt.Errorf("invalid page number")
+		}
+		pagination.Page = pageNum
 	}
 
-	response, err := h.audienceService.GetSpeedReport(ctx, filter)
-	if err != nil {
-		h.errorResponse(w, "failed to get status duration report", err, http.StatusInternalServerError)
-		return
+	if pageSize := r.URL.Query().Get("page_size"); pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size < 1 || size > maxPageSize {
+			return nil, fmt.Errorf("invalid page size, expected 1..%d", maxPageSize)
+		}
+		pagination.PageSize = size
 	}
 
-	h.jsonResponse(w, response, http.StatusOK)
+	pagination.Cursor = r.URL.Query().Get("cursor")
+
+	switch count := r.URL.Query().Get("count"); count {
+	case "", domain.CountModeExact, domain.CountModeEstimate, domain.CountModeNone:
+		pagination.CountMode = count
+	default:
+		return nil, fmt.Errorf("invalid count mode, expected exact, estimate or none")
+	}
+
+	return pagination, nil
+}
+
+// Фильтры списка заявок, одинаковые для списка и выгрузки.
+// Несколько значений передаются через запятую или повтором параметра: status=A,B или status=A&status=B.
+// Параметр с "!" исключает значения: project_name!=X
+func parseApplicationFilter(r *http.Request) (*domain.ApplicationFilterRequest, error) {
+	query := r.URL.Query()
+	filter := &domain.ApplicationFilterRequest{
+		OrderField:            query.Get("order_field"),
+		OrderDirection:        query.Get("order_direction"),
+		Statuses:              queryValues(query, "status"),
+		ExcludedStatuses:      queryValues(query, "status!"),
+		ProjectNames:          queryValues(query, "project_name"),
+		ExcludedProjectNames:  queryValues(query, "project_name!"),
+		PropertyTypes:         queryValues(query, "property_type"),
+		ExcludedPropertyTypes: queryValues(query, "property_type!"),
+		RegionNames:           queryValues(query, "region"),
+		ExcludedRegionNames:   queryValues(query, "region!"),
+		ManagerNames:          queryValues(query, "manager"),
+		ExcludedManagerNames:  queryValues(query, "manager!"),
+		AudienceName:          query.Get("audience_name"),
+		Query:                 strings.TrimSpace(query.Get("q")),
+		Columns:               queryValues(query, "columns"),
+	}
+	if filter.Query != "" && utf8.RuneCountInString(filter.Query) < 2 {
+		return nil, fmt.Errorf("q must contain at least 2 characters")
+	}
+
+	var err error
+	idParams := map[string]*[]int64{
+		"manager_id":            &filter.ManagerIDs,
+		"manager_id!":           &filter.ExcludedManagerIDs,
+		"rejection_reason_ids":  &filter.RejectionReasonIDs,
+		"non_target_reason_ids": &filter.NonTargetReasonIDs,
+	}
+	for param, target := range idParams {
+		if *target, err = parseIDList(strings.Join(query[param], ",")); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", param, err)
+		}
+	}
+
+	if startDate := query.Get("start_date"); startDate != "" {
+		date, err := time.Parse(time.RFC3339, startDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date_from format")
+		}
+		filter.StartDate = &date
+	}
+	if endDate := query.Get("end_date"); endDate != "" {
+		date, err := time.Parse(time.RFC3339, endDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date_to format")
+		}
+		filter.EndDate = &date
+	}
+
+	// days_in_status оставлен для совместимости и означает нижнюю границу
+	minParam := "days_in_status_min"
+	if query.Get(minParam) == "" && query.Get("days_in_status") != "0" {
+		minParam = "days_in_status"
+	}
+	if filter.DaysInStatusMin, err = queryInt(query, minParam); err != nil {
+		return nil, err
+	}
+	if filter.DaysInStatusMax, err = queryInt(query, "days_in_status_max"); err != nil {
+		return nil, err
+	}
+	if filter.DaysInStatusMin != nil && filter.DaysInStatusMax != nil && *filter.DaysInStatusMin > *filter.DaysInStatusMax {
+		return nil, fmt.Errorf("days_in_status_min must not exceed days_in_status_max")
+	}
+
+	return filter, nil
+}
+
+// Значения параметра из всех повторов, разделенные запятыми
+func queryValues(query url.Values, key string) []string {
+	var values []string
+	for _, value := range query[key] {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+func queryInt(query url.Values, key string) (*int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return nil, fmt.Errorf("invalid %s", key)
+	}
+	return &number, nil
 }
 
-// func (h *Handler) ExportStatusDurationReport(w http.ResponseWriter, r *http.Request){
-// 	ctx := r.Context()
-
-// 	time_from := time.Time{}
-// 	time_to := time.Time{}
-// 	err:= error(nil)
-// 	if r.URL.Query().Get("start_date") != "" {
-// 		time_from, err = time.Parse(time.RFC3339, r.URL.Query().Get("start_date"))
-// 		if err != nil  {
-// 			h.errorResponse(w, "invalid date_from format", err, http.StatusBadRequest)
-// 			return
-// 		}
-// 	}
-// 	if r.URL.Query().Get("end_date") != "" {
-// 		time_to, err = time.Parse(time.RFC3339, r.URL.Query().Get("end_date"))
-// 		if err != nil {
-// 			h.errorResponse(w, "invalid date_to format", err, http.StatusBadRequest)
-// 			return
-// 		}
-// 	}
-// 	trashold := 0
-// 	if r.URL.Query().Get("over_threshold") != "" {
-// 		trashold, err = strconv.Atoi(r.URL.Query().Get("over_threshold"))
-// 		if err != nil {
-// 			h.errorResponse(w, "invalid over_threshold format", err, http.StatusBadRequest)
-// 			return
-// 		}
-// 	}
-
-// 	filter := &domain.StatusDurationFilter{
-// 		StartDate: &time_from,
-// 		EndDate:   &time_to,
-// 		ThresholdDays: trashold,
-// 	}
-
-// 	filePath, fileName, err := h.audienceService.ExportStatusDurationReport(ctx, filter)
-// 	if err != nil {
-// 		h.errorResponse(w, "failed to export status duration report: "+err.Error(), err, http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-// 	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-// 	http.ServeFile(w, r, filePath)
-// }
+// Список идентификаторов через запятую: "1,2,3"
+func parseIDList(value string) ([]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
 
 func (h *Handler) errorResponse(w http.ResponseWriter, message string, err error, code int) {
 	h.logger.Error(message,
@@ -674,6 +1479,30 @@ func (h *Handler) errorResponse(w http.ResponseWriter, message string, err error
 	}, code)
 }
 
+// Ошибки валидации отдаются как 400 со списком полей, чужие записи как 403, отсутствующие как 404, остальные как 500
+func (h *Handler) serviceErrorResponse(w http.ResponseWriter, message string, err error) {
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		h.logger.Warn(message, zap.Error(err))
+		h.jsonResponse(w, Response{
+			Error:  "validation failed",
+			Errors: validationErr.Errors,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, domain.ErrNotFound) {
+		h.errorResponse(w, message+": "+err.Error(), err, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		h.errorResponse(w, message+": "+err.Error(), err, http.StatusForbidden)
+		return
+	}
+
+	h.errorResponse(w, message+": "+err.Error(), err, http.StatusInternalServerError)
+}
+
 func (h *Handler) jsonResponse(w http.ResponseWriter, data interface{}, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -683,3 +1512,8 @@ func (h *Handler) jsonResponse(w http.ResponseWriter, data interface{}, code int
 			zap.Error(err))
 	}
 }
+
+// Заголовок Content-Disposition для скачивания: имя в кавычках, не-ASCII символы по RFC 2231
+func attachmentDisposition(fileName string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+}
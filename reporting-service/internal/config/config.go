@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"reporting-service/internal/mailer"
+	"reporting-service/internal/storage"
 )
 
 type Config struct {
@@ -15,6 +18,10 @@ type Config struct {
 	RabbitMQ RabbitMQConfig `yaml:"rabbitmq"`
 	Service  ServiceConfig  `yaml:"service"`
 	Logger   LoggerConfig   `yaml:"logger"`
+	// Где хранятся файлы выгрузок: локальный диск или S3-совместимое хранилище
+	Storage storage.Config `yaml:"storage"`
+	// Почтовый сервер для рассылки отчетов по подпискам
+	SMTP mailer.Config `yaml:"smtp"`
 }
 
 type ServerConfig struct {
@@ -51,6 +58,27 @@ type ServiceConfig struct {
 	UpdateTime     string `yaml:"update_time"`
 	BatchSize      int    `yaml:"batch_size"`
 	ExportPath     string `yaml:"export_path"`
+	// Период синхронизации поискового индекса заявок и период его полной пересборки
+	SearchSyncInterval    time.Duration `yaml:"search_sync_interval"`
+	SearchRebuildInterval time.Duration `yaml:"search_rebuild_interval"`
+	// Фоновые выгрузки: период опроса очереди, ключ подписи ссылок, срок жизни ссылки и файла
+	ExportWorkerInterval time.Duration `yaml:"export_worker_interval"`
+	ExportSigningKey     string        `yaml:"export_signing_key" json:"-"`
+	ExportLinkTTL        time.Duration `yaml:"export_link_ttl"`
+	ExportRetention      time.Duration `yaml:"export_retention"`
+	// TrueType шрифты для PDF отчетов, по умолчанию DejaVu Sans
+	PDFFontPath     string `yaml:"pdf_font_path"`
+	PDFBoldFontPath string `yaml:"pdf_bold_font_path"`
+	// Период проверки расписаний подписок на отчеты
+	SubscriptionWorkerInterval time.Duration `yaml:"subscription_worker_interval"`
+	// Поиск аномалий в отчете по колл-центру: проверяемые показатели, порог доли от среднего
+	// по команде, порог z-оценки, порог падения к прошлой неделе (0 - правило выключено)
+	// и минимальный знаменатель доли
+	AnomalyMetrics      []string `yaml:"anomaly_metrics"`
+	AnomalyBelowAverage float64  `yaml:"anomaly_below_average"`
+	AnomalyZScore       float64  `yaml:"anomaly_z_score"`
+	AnomalyWeekDrop     float64  `yaml:"anomaly_week_drop"`
+	AnomalyMinBase      int      `yaml:"anomaly_min_base"`
 }
 
 type LoggerConfig struct {
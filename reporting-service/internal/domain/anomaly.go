@@ -0,0 +1,24 @@
+package domain
+
+// Правила поиска аномалий в отчете по колл-центру
+const (
+	// Значение ниже заданной доли от среднего по команде
+	AnomalyRuleBelowAverage = "below_average"
+	// Отклонение от среднего по команде больше заданного числа стандартных отклонений
+	AnomalyRuleZScore = "z_score"
+	// Падение относительно такого же периода неделей раньше
+	AnomalyRuleWeekOverWeek = "week_over_week"
+)
+
+// Аномальное значение показателя у менеджера. Baseline - с чем сравнивалось значение:
+// среднее по команде или значение неделей раньше
+type Anomaly struct {
+	ManagerID   int      `json:"manager_id"`
+	ManagerName string   `json:"manager_name"`
+	Metric      string   `json:"metric"`
+	Rule        string   `json:"rule"`
+	Value       float64  `json:"value"`
+	Baseline    float64  `json:"baseline"`
+	Score       *float64 `json:"score,omitempty"`
+	Message     string   `json:"message"`
+}
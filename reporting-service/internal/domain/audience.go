@@ -25,9 +25,26 @@ type Application struct {
 	RegionName 	   string    `json:"region" db:"region"`
 }
 
+// Совпадение поискового запроса: поле и позиция в символах
+type SearchHighlight struct {
+	Field  string `json:"field"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// Запись поискового индекса заявок
+type ApplicationSearchEntry struct {
+	ApplicationID int64     `db:"application_id"`
+	ClientName    string    `db:"client_name"`
+	Phone         string    `db:"phone"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
 type Audience struct {
 	ID               int64          `json:"id" db:"id"`
 	Name             string         `json:"name" db:"name"`
+	Folder           string         `json:"folder" db:"folder"`
+	Tags             []string       `json:"tags" db:"tags"`
 	CreatedAt        time.Time      `json:"created_at" db:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at" db:"updated_at"`
 	Application_ids  []int64        `json:"request_ids" db:"request_ids"`
@@ -37,6 +54,12 @@ type Audience struct {
 	Filter           AudienceCreationFilter `json:"filter" db:"filter"`
 }
 
+// Категории причин статуса: отказ и нецелевое обращение
+const (
+	ReasonCategoryRejection = "rejection"
+	ReasonCategoryNonTarget = "non_target"
+)
+
 type Integration struct {
 	ID          int64  `json:"id" db:"id"`
 	AudienceID  int64  `json:"audience_id" db:"audience_id"`
@@ -51,4 +74,39 @@ type StatusDuration struct {
     AverageDays    float64 `json:"average_days" db:"avg_days"`
     TotalRequests  int     `json:"total_requests" db:"total_requests"`
     OverThreshold  int     `json:"over_threshold" db:"over_threshold"`
-}
\ No newline at end of file
+}
+
+// Статистика аудитории за день
+type AudienceDailyStat struct {
+	Date    time.Time `json:"date" db:"stat_date"`
+	Size    int       `json:"size" db:"size"`
+	Added   int       `json:"added" db:"added"`
+	Removed int       `json:"removed" db:"removed"`
+}
+
+// Итоги изменения аудитории за период
+type AudienceStatsSummary struct {
+	AudienceID   int64   `json:"audience_id" db:"audience_id"`
+	AudienceName string  `json:"audience_name" db:"audience_name"`
+	StartSize    int     `json:"start_size" db:"start_size"`
+	EndSize      int     `json:"end_size" db:"end_size"`
+	Added        int     `json:"added" db:"added"`
+	Removed      int     `json:"removed" db:"removed"`
+	NetChange    int     `json:"net_change"`
+	GrowthRate   float64 `json:"growth_rate"`
+	ChurnRate    float64 `json:"churn_rate"`
+}
+
+// Сохраненное представление списка заявок
+type ApplicationView struct {
+	ID             int64                    `json:"id" db:"id"`
+	Name           string                   `json:"name" db:"name"`
+	OwnerID        string                   `json:"owner_id" db:"owner_id"`
+	Shared         bool                     `json:"shared" db:"shared"`
+	Filter         ApplicationFilterRequest `json:"filter" db:"-"`
+	OrderField     string                   `json:"order_field" db:"order_field"`
+	OrderDirection string                   `json:"order_direction" db:"order_direction"`
+	Columns        []string                 `json:"columns" db:"-"`
+	CreatedAt      time.Time                `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time                `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Типы отчетов для фоновой выгрузки
+const (
+	ExportReportApplications = "applications"
+	ExportReportAudience     = "audience"
+	ExportReportRegions      = "regions"
+	ExportReportCallCenter   = "call_center"
+	ExportReportSpeed        = "speed"
+)
+
+// Статусы фоновой выгрузки
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+	ExportStatusExpired = "expired"
+)
+
+// Фоновая выгрузка отчета. Params - фильтр отчета в том виде, в каком его прислал клиент
+type ExportJob struct {
+	ID          int64           `json:"id" db:"id"`
+	OwnerID     string          `json:"owner_id" db:"owner_id"`
+	ReportType  string          `json:"report_type" db:"report_type"`
+	Format      string          `json:"format" db:"format"`
+	Params      json.RawMessage `json:"params" db:"params"`
+	Status      string          `json:"status" db:"status"`
+	FilePath    string          `json:"-" db:"file_path"`
+	FileName    string          `json:"file_name,omitempty" db:"file_name"`
+	Error       string          `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty" db:"started_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty" db:"expires_at"`
+	DownloadURL string          `json:"download_url,omitempty" db:"-"`
+}
+
+type ExportJobRequest struct {
+	ReportType string          `json:"report_type"`
+	Format     string          `json:"format"`
+	Params     json.RawMessage `json:"params"`
+}
+
+// Параметры выгрузки заявок: фильтр списка и набор колонок
+type ApplicationExportParams struct {
+	ApplicationFilterRequest
+	Columns []string `json:"columns"`
+}
+
+type AudienceExportParams struct {
+	AudienceID int64 `json:"audience_id"`
+}
@@ -0,0 +1,40 @@
+package domain
+
+import "time"
+
+// Воронка отчета по колл-центру, которая строится по умолчанию
+const DefaultFunnelCode = "call_center"
+
+// Этапы воронки
+const (
+	FunnelStageNonTarget   = "non_target"
+	FunnelStageAppointment = "appointment"
+	FunnelStageVisit       = "visit"
+	FunnelStageBooking     = "booking"
+)
+
+// Этап воронки: переход заявки в один из статусов. Статус задается id (status_to в логе статусов)
+// или названием (status_custom_to_name). Если указаны FromStatusNames, считаются только переходы из этих статусов
+type FunnelStage struct {
+	StatusIDs       []int64  `json:"status_ids,omitempty"`
+	StatusNames     []string `json:"status_names,omitempty"`
+	FromStatusNames []string `json:"from_status_names,omitempty"`
+}
+
+// Настройки воронки: этапы и отделы, менеджеры которых попадают в отчет
+type FunnelConfig struct {
+	ID            int64                  `json:"id" db:"id"`
+	Code          string                 `json:"code" db:"code"`
+	Name          string                 `json:"name" db:"name"`
+	DepartmentIDs []int64                `json:"department_ids" db:"-"`
+	Stages        map[string]FunnelStage `json:"stages" db:"-"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+type FunnelConfigRequest struct {
+	Code          string                 `json:"code"`
+	Name          string                 `json:"name"`
+	DepartmentIDs []int64                `json:"department_ids"`
+	Stages        map[string]FunnelStage `json:"stages"`
+}
@@ -0,0 +1,150 @@
+package domain
+
+// Единицы показателей отчета
+const (
+	// Количество заявок
+	MetricUnitCount = "count"
+	// Доля от 0 до 1, в отчетах выводится в процентах
+	MetricUnitRatio = "ratio"
+)
+
+// Показатель отчета по колл-центру. Количества считаются запросом к MacroCRM,
+// доли - делением количества Numerator на количество Denominator, одинаково для строк и итога
+type MetricDefinition struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Unit        string `json:"unit"`
+	Formula     string `json:"formula"`
+	Numerator   string `json:"numerator,omitempty"`
+	Denominator string `json:"denominator,omitempty"`
+	// Колонка скрыта по умолчанию
+	IsAdditional bool `json:"is_additional"`
+	// Показатель воронки после визита, считается только при show_optional
+	Optional bool `json:"optional"`
+}
+
+// Значение доли вместе с числителем и знаменателем. Без знаменателя доля не определена и Value пустое
+type MetricRatio struct {
+	Value       *float64 `json:"value"`
+	Numerator   int      `json:"numerator"`
+	Denominator int      `json:"denominator"`
+}
+
+var CallCenterMetrics = []MetricDefinition{
+	{Name: "total_inquiries", Title: "Всего обращений", Unit: MetricUnitCount, IsAdditional: true,
+		Formula: "заявки, статус которых менеджер менял в периоде"},
+	{Name: "target_inquiries", Title: "Целевые", Unit: MetricUnitCount,
+		Formula: "обращения, которые ни разу не переводились в нецелевые"},
+	{Name: "target_conversion", Title: "Конверсия в целевые", Unit: MetricUnitRatio,
+		Formula: "Целевые / Всего обращений", Numerator: "target_inquiries", Denominator: "total_inquiries"},
+	{Name: "appointed_visits", Title: "Назначено визитов", Unit: MetricUnitCount,
+		Formula: "заявки, переведенные менеджером в этап назначенной встречи в периоде"},
+	{Name: "visit_conversion", Title: "Конверсия в визиты", Unit: MetricUnitRatio,
+		Formula: "Назначено визитов / Целевые", Numerator: "appointed_visits", Denominator: "target_inquiries"},
+	{Name: "completed_visits", Title: "Визиты состоялись", Unit: MetricUnitCount,
+		Formula: "заявки с состоявшимся в периоде визитом, по последнему менеджеру колл-центра"},
+	{Name: "visit_success", Title: "Конверсия визитов", Unit: MetricUnitRatio,
+		Formula: "Визиты состоялись / Назначено визитов. Визит относится к последнему менеджеру колл-центра, " +
+			"а назначение - к менеджеру, который назначил встречу, поэтому у менеджера доля может быть больше 100%",
+		Numerator: "completed_visits", Denominator: "appointed_visits"},
+	{Name: "lead_to_visit", Title: "Конверсия лид->визит", Unit: MetricUnitRatio,
+		Formula: "Визиты состоялись / Целевые", Numerator: "completed_visits", Denominator: "target_inquiries"},
+	{Name: "bookings", Title: "Бронирования", Unit: MetricUnitCount, IsAdditional: true, Optional: true,
+		Formula: "заявки, переведенные в бронь в периоде, по последнему менеджеру колл-центра"},
+	{Name: "visit_to_booking", Title: "Конверсия визит->бронь", Unit: MetricUnitRatio, IsAdditional: true, Optional: true,
+		Formula: "Бронирования / Визиты состоялись", Numerator: "bookings", Denominator: "completed_visits"},
+	{Name: "contracts", Title: "ДДУ", Unit: MetricUnitCount, IsAdditional: true, Optional: true,
+		Formula: "сделки с датой в периоде, по последнему менеджеру колл-центра"},
+	{Name: "booking_to_contract", Title: "Конверсия бронь->ДДУ", Unit: MetricUnitRatio, IsAdditional: true, Optional: true,
+		Formula: "ДДУ / Бронирования", Numerator: "contracts", Denominator: "bookings"},
+	{Name: "lead_to_contract", Title: "Конверсия лид->ДДУ", Unit: MetricUnitRatio, IsAdditional: true, Optional: true,
+		Formula: "ДДУ / Целевые", Numerator: "contracts", Denominator: "target_inquiries"},
+}
+
+// Показатели отчета. Показатели воронки после визита - только вместе с дополнительными колонками
+func CallCenterMetricDefinitions(showOptional bool) []MetricDefinition {
+	definitions := make([]MetricDefinition, 0, len(CallCenterMetrics))
+	for _, definition := range CallCenterMetrics {
+		if definition.Optional && !showOptional {
+			continue
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions
+}
+
+// Количество по имени показателя
+func (m *ManagerMetrics) Count(name string) (int, bool) {
+	switch name {
+	case "total_inquiries":
+		return m.TotalInquiries, true
+	case "target_inquiries":
+		return m.TargetInquiries, true
+	case "appointed_visits":
+		return m.AppointedVisits, true
+	case "completed_visits":
+		return m.CompletedVisits, true
+	case "bookings":
+		return optionalCount(m.Bookings)
+	case "contracts":
+		return optionalCount(m.Contracts)
+	}
+	return 0, false
+}
+
+// Показатели воронки после визита посчитаны, то есть отчет построен с show_optional
+func (m *ManagerMetrics) HasOptional() bool {
+	return m.Bookings != nil
+}
+
+// Включает показатели воронки после визита с нулевыми количествами
+func (m *ManagerMetrics) InitOptional() {
+	m.Bookings, m.Contracts = new(int), new(int)
+}
+
+func optionalCount(count *int) (int, bool) {
+	if count == nil {
+		return 0, false
+	}
+	return *count, true
+}
+
+func (m *ManagerMetrics) setRatio(name string, value *float64) {
+	switch name {
+	case "target_conversion":
+		m.TargetConversion = value
+	case "visit_conversion":
+		m.VisitConversion = value
+	case "visit_success":
+		m.VisitSuccess = value
+	case "lead_to_visit":
+		m.LeadToVisit = value
+	case "visit_to_booking":
+		m.VisitToBooking = value
+	case "booking_to_contract":
+		m.BookingToContract = value
+	case "lead_to_contract":
+		m.LeadToContract = value
+	}
+}
+
+// Считает доли по количествам. Если знаменатель равен нулю, доля не определена:
+// пустые и поле доли, и значение в Ratios
+func (m *ManagerMetrics) CalculateRatios(definitions []MetricDefinition) {
+	m.Ratios = make(map[string]MetricRatio)
+	for _, definition := range definitions {
+		if definition.Unit != MetricUnitRatio {
+			continue
+		}
+		numerator, _ := m.Count(definition.Numerator)
+		denominator, _ := m.Count(definition.Denominator)
+
+		ratio := MetricRatio{Numerator: numerator, Denominator: denominator}
+		if denominator > 0 {
+			value := float64(numerator) / float64(denominator)
+			ratio.Value = &value
+		}
+		m.setRatio(definition.Name, ratio.Value)
+		m.Ratios[definition.Name] = ratio
+	}
+}
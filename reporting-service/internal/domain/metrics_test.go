@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"math"
+	"testing"
+)
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+func TestCalculateRatios(t *testing.T) {
+	tests := []struct {
+		name         string
+		row          ManagerMetrics
+		showOptional bool
+		// Ожидаемые значения долей, nil - доля не определена
+		want map[string]*float64
+	}{
+		{
+			name: "zero denominators",
+			row:  ManagerMetrics{},
+			want: map[string]*float64{
+				"target_conversion": nil,
+				"visit_conversion":  nil,
+				"visit_success":     nil,
+				"lead_to_visit":     nil,
+			},
+		},
+		{
+			name: "regular counts",
+			row:  ManagerMetrics{TotalInquiries: 10, TargetInquiries: 5, AppointedVisits: 2, CompletedVisits: 3},
+			want: map[string]*float64{
+				"target_conversion": floatPtr(0.5),
+				"visit_conversion":  floatPtr(0.4),
+				// Визиты и назначения относятся к разным менеджерам, доля может быть больше 1
+				"visit_success": floatPtr(1.5),
+				"lead_to_visit": floatPtr(0.6),
+			},
+		},
+		{
+			name: "zero numerator",
+			row:  ManagerMetrics{TotalInquiries: 10},
+			want: map[string]*float64{
+				"target_conversion": floatPtr(0),
+				"visit_conversion":  nil,
+				"visit_success":     nil,
+				"lead_to_visit":     nil,
+			},
+		},
+		{
+			name: "optional funnel",
+			row: ManagerMetrics{TotalInquiries: 10, TargetInquiries: 4, AppointedVisits: 4, CompletedVisits: 2,
+				Bookings: intPtr(0), Contracts: intPtr(0)},
+			showOptional: true,
+			want: map[string]*float64{
+				"target_conversion":   floatPtr(0.4),
+				"visit_conversion":    floatPtr(1),
+				"visit_success":       floatPtr(0.5),
+				"lead_to_visit":       floatPtr(0.5),
+				"visit_to_booking":    floatPtr(0),
+				"booking_to_contract": nil,
+				"lead_to_contract":    floatPtr(0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := tt.row
+			row.CalculateRatios(CallCenterMetricDefinitions(tt.showOptional))
+
+			if len(row.Ratios) != len(tt.want) {
+				t.Fatalf("ratios = %v, want %d ratios", row.Ratios, len(tt.want))
+			}
+			flat := map[string]*float64{
+				"target_conversion":   row.TargetConversion,
+				"visit_conversion":    row.VisitConversion,
+				"visit_success":       row.VisitSuccess,
+				"lead_to_visit":       row.LeadToVisit,
+				"visit_to_booking":    row.VisitToBooking,
+				"booking_to_contract": row.BookingToContract,
+				"lead_to_contract":    row.LeadToContract,
+			}
+			for name, want := range tt.want {
+				ratio, ok := row.Ratios[name]
+				if !ok {
+					t.Errorf("%s: missing in ratios", name)
+					continue
+				}
+				if !sameRatio(ratio.Value, want) {
+					t.Errorf("%s: ratio value = %v, want %v", name, ratioString(ratio.Value), ratioString(want))
+				}
+				// Поле доли и значение в Ratios совпадают, в том числе пустые
+				if !sameRatio(flat[name], ratio.Value) {
+					t.Errorf("%s: field = %v, ratio value = %v", name, ratioString(flat[name]), ratioString(ratio.Value))
+				}
+			}
+			if !tt.showOptional && (row.VisitToBooking != nil || row.BookingToContract != nil || row.LeadToContract != nil) {
+				t.Errorf("optional ratios are set without show_optional")
+			}
+		})
+	}
+}
+
+func sameRatio(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return math.Abs(*a-*b) < 1e-9
+}
+
+func ratioString(value *float64) interface{} {
+	if value == nil {
+		return "null"
+	}
+	return *value
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type SpeedReport struct {
     LeadID    int    `json:"lead_id"`
@@ -13,6 +16,38 @@ type SpeedReport struct {
 type CallCenterReportFilter struct {
 	StartDate *time.Time  `json:"start_date"`
 	EndDate   *time.Time  `json:"end_date"`
+	// Дополнительные колонки воронки: брони, ДДУ и конверсии в них
+	ShowOptional bool `json:"show_optional,omitempty"`
+	// Сравнение с другим периодом: previous_period, previous_year или custom с датами compare_*
+	Compare          string     `json:"compare,omitempty"`
+	CompareStartDate *time.Time `json:"compare_start_date,omitempty"`
+	CompareEndDate   *time.Time `json:"compare_end_date,omitempty"`
+	// Код настроенной воронки, по умолчанию колл-центр
+	Funnel string `json:"funnel,omitempty"`
+}
+
+// Режимы сравнения периодов
+const (
+	CompareNone           = ""
+	ComparePreviousPeriod = "previous_period"
+	ComparePreviousYear   = "previous_year"
+	CompareCustom         = "custom"
+)
+
+// Суффиксы колонок сравнения: значение за период сравнения, абсолютное и относительное изменение
+const (
+	ComparePrevSuffix     = "_prev"
+	CompareDeltaSuffix    = "_delta"
+	CompareDeltaPctSuffix = "_delta_pct"
+)
+
+// Сравниваемые периоды отчета
+type ComparisonPeriod struct {
+	Mode             string    `json:"mode"`
+	StartDate        time.Time `json:"start_date"`
+	EndDate          time.Time `json:"end_date"`
+	CompareStartDate time.Time `json:"compare_start_date"`
+	CompareEndDate   time.Time `json:"compare_end_date"`
 }
 
 // Строка отчета по колл-центру
@@ -22,23 +57,63 @@ type ManagerMetrics struct {
     ManagerName          string  `json:"manager_name" db:"users_name"`
     TotalInquiries       int     `json:"total_inquiries" db:"total_requests"`
     TargetInquiries      int     `json:"target_inquiries" db:"target_requests"`
-    TargetConversion     float64 `json:"target_conversion"`
+    TargetConversion    *float64 `json:"target_conversion"`
     AppointedVisits      int     `json:"appointed_visits" db:"appointed_visits"`
-    VisitConversion      float64 `json:"visit_conversion"`
+    VisitConversion     *float64 `json:"visit_conversion"`
     CompletedVisits      int     `json:"completed_visits" db:"successful_visits"`
-    VisitSuccess         float64 `json:"visit_success"`
-    LeadToVisit          float64 `json:"lead_to_visit"`
-    // Optional metrics
-    //Bookings            int     `json:"bookings,omitempty" db:"brons"`
-    //VisitToBooking      float64 `json:"visit_to_booking,omitempty"`
-    //Contracts           int     `json:"contracts,omitempty" db:"ddus"`
-    //BookingToContract   float64 `json:"booking_to_contract,omitempty"`
-    //LeadToContract      float64 `json:"lead_to_contract,omitempty"`
+    VisitSuccess        *float64 `json:"visit_success"`
+    LeadToVisit         *float64 `json:"lead_to_visit"`
+    // Optional metrics, считаются только при ShowOptional. Без флага количества пустые,
+    // и поля воронки после визита не выводятся в JSON вовсе, даже нулями
+    Bookings            *int     `json:"bookings,omitempty" db:"brons"`
+    VisitToBooking      *float64 `json:"visit_to_booking"`
+    Contracts           *int     `json:"contracts,omitempty" db:"ddus"`
+    BookingToContract   *float64 `json:"booking_to_contract"`
+    LeadToContract      *float64 `json:"lead_to_contract"`
+    // Числитель и знаменатель каждой доли, по именам показателей. Без знаменателя
+    // пустые (null) и Value, и поле доли выше
+    Ratios map[string]MetricRatio `json:"ratios,omitempty"`
+    // Аномалии по именам показателей, IsAnomaly отмечает строки хотя бы с одной аномалией
+    Anomalies map[string][]Anomaly `json:"anomalies,omitempty"`
+    // Колонки сравнения периодов по именам заголовков, в JSON выводятся рядом с основными полями
+    Comparison map[string]interface{} `json:"-"`
+}
+
+func (m ManagerMetrics) MarshalJSON() ([]byte, error) {
+	type plain ManagerMetrics
+	data, err := json.Marshal(plain(m))
+	if err != nil || (len(m.Comparison) == 0 && m.HasOptional()) {
+		return data, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	// Неопределенная доля выводится null, поэтому доли воронки убираются по флагу, а не по значению
+	if !m.HasOptional() {
+		for _, definition := range CallCenterMetrics {
+			if definition.Optional {
+				delete(fields, definition.Name)
+			}
+		}
+	}
+	for name, value := range m.Comparison {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = encoded
+	}
+	return json.Marshal(fields)
 }
 
 type CallCenterReport struct {
     Headers     []Header         `json:"headers"`
     Data        []ManagerMetrics `json:"data"`
     Footer      ManagerMetrics   `json:"footer"`
-    //Anomalies   []string        `json:"anomalies,omitempty"`
+    Comparison  *ComparisonPeriod `json:"comparison,omitempty"`
+    Funnel      string           `json:"funnel,omitempty"`
+    FunnelName  string           `json:"funnel_name,omitempty"`
+    Anomalies   []Anomaly        `json:"anomalies,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestManagerMetricsOptionalJSON(t *testing.T) {
+	optionalKeys := []string{"bookings", "contracts", "visit_to_booking", "booking_to_contract", "lead_to_contract"}
+
+	tests := []struct {
+		name         string
+		showOptional bool
+		comparison   map[string]interface{}
+	}{
+		{name: "without optional"},
+		{name: "with optional", showOptional: true},
+		{name: "without optional with comparison", comparison: map[string]interface{}{"total_inquiries_prev": 1}},
+		{name: "with optional and comparison", showOptional: true, comparison: map[string]interface{}{"total_inquiries_prev": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Нулевые количества: доли воронки не определены
+			row := ManagerMetrics{ManagerID: 1, TotalInquiries: 10, Comparison: tt.comparison}
+			if tt.showOptional {
+				row.InitOptional()
+			}
+			row.CalculateRatios(CallCenterMetricDefinitions(tt.showOptional))
+
+			data, err := json.Marshal(row)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			fields := map[string]json.RawMessage{}
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+
+			for _, key := range optionalKeys {
+				if _, ok := fields[key]; ok != tt.showOptional {
+					t.Errorf("key %q present = %v, want %v", key, ok, tt.showOptional)
+				}
+			}
+			if tt.showOptional && string(fields["bookings"]) != "0" {
+				t.Errorf("bookings = %s, want 0", fields["bookings"])
+			}
+			if tt.showOptional && string(fields["visit_to_booking"]) != "null" {
+				t.Errorf("visit_to_booking = %s, want null", fields["visit_to_booking"])
+			}
+			if _, ok := fields["total_inquiries_prev"]; ok != (tt.comparison != nil) {
+				t.Errorf("comparison field present = %v", ok)
+			}
+		})
+	}
+}
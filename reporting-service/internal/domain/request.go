@@ -6,19 +6,37 @@ import (
 	//"github.com/google/uuid"
 )
 
+// Фильтры списка заявок. Поля Excluded* исключают перечисленные значения
 type ApplicationFilterRequest struct {
-	OrderField     string     `json:"order_field"`
-	OrderDirection string     `json:"order_direction"`
-	Status         string     `json:"status_name" form:"status_name"`
-	StatusDuration int        `json:"status_duration,omitempty" form:"status_duration"`
-	ProjectName    string     `json:"project_name" form:"project_name"`
-	PropertyType   string     `json:"property_type" form:"property_type"`
-	AudienceName   string     `json:"audience_name" form:"audience_name"`
-	AudienceIDs    []string   `json:"audience_ids" form:"audience_ids"`
-	RegionName     string     `json:"region" form:"region"`
-	StartDate      *time.Time `json:"created_at_from" form:"created_at_from"`
-	EndDate        *time.Time `json:"created_at_to" form:"created_at_to"`
-	DeadlinePassed bool       `json:"deadline_passed" form:"deadline_passed"`
+	OrderField            string     `json:"order_field"`
+	OrderDirection        string     `json:"order_direction"`
+	Statuses              []string   `json:"status_names" form:"status"`
+	ExcludedStatuses      []string   `json:"excluded_status_names" form:"status!"`
+	ProjectNames          []string   `json:"project_names" form:"project_name"`
+	ExcludedProjectNames  []string   `json:"excluded_project_names" form:"project_name!"`
+	PropertyTypes         []string   `json:"property_types" form:"property_type"`
+	ExcludedPropertyTypes []string   `json:"excluded_property_types" form:"property_type!"`
+	RegionNames           []string   `json:"regions" form:"region"`
+	ExcludedRegionNames   []string   `json:"excluded_regions" form:"region!"`
+	ManagerIDs            []int64    `json:"manager_ids" form:"manager_id"`
+	ExcludedManagerIDs    []int64    `json:"excluded_manager_ids" form:"manager_id!"`
+	ManagerNames          []string   `json:"manager_names" form:"manager"`
+	ExcludedManagerNames  []string   `json:"excluded_manager_names" form:"manager!"`
+	DaysInStatusMin       *int       `json:"days_in_status_min,omitempty" form:"days_in_status_min"`
+	DaysInStatusMax       *int       `json:"days_in_status_max,omitempty" form:"days_in_status_max"`
+	AudienceName          string     `json:"audience_name" form:"audience_name"`
+	AudienceIDs           []string   `json:"audience_ids" form:"audience_ids"`
+	Query                 string     `json:"q" form:"q"`
+	// Заявки, найденные по Query в поисковом индексе, и общее число совпадений
+	SearchIDs             []int64    `json:"-"`
+	SearchTotal           int        `json:"-"`
+	RejectionReasonIDs    []int64    `json:"rejection_reason_ids" form:"rejection_reason_ids"`
+	NonTargetReasonIDs    []int64    `json:"non_target_reason_ids" form:"non_target_reason_ids"`
+	StartDate             *time.Time `json:"created_at_from" form:"created_at_from"`
+	EndDate               *time.Time `json:"created_at_to" form:"created_at_to"`
+	DeadlinePassed        bool       `json:"deadline_passed" form:"deadline_passed"`
+	// Колонки ответа в нужном порядке, пусто - набор по умолчанию. В представлениях хранятся отдельно
+	Columns               []string   `json:"-" form:"columns"`
 }
 
 type AudienceMessage struct {
@@ -40,6 +58,9 @@ type AudienceCreationFilter struct {
 	StatusIDs            []int64    `json:"status_ids" db:"status_ids"`
 	RegectionReasonNames []string   `json:"rejection_reasons" db:"rejection_reasons"`
 	NonTargetReasonNames []string   `json:"non_target_reasons" db:"non_target_reasons"`
+	RejectionReasonIDs   []int64    `json:"rejection_reason_ids" db:"rejection_reason_ids"`
+	NonTargetReasonIDs   []int64    `json:"non_target_reason_ids" db:"non_target_reason_ids"`
+	// Причины без учета категории, для фильтров, сохраненных до разделения
 	ReasonIDs            []int64    `json:"reason_ids" db:"reason_ids"`
 }
 
@@ -56,17 +77,60 @@ type RegionFilter struct {
 
 type AudienceCreateRequest struct {
 	Name   string                 `json:"name" validate:"required"`
+	Folder string                 `json:"folder"`
+	Tags   []string               `json:"tags"`
 	Filter AudienceCreationFilter `json:"filter" validate:"required"`
 }
 
+// Изменение аудитории: nil-поля остаются без изменений
+type AudienceUpdateRequest struct {
+	Name   *string                 `json:"name"`
+	Folder *string                 `json:"folder"`
+	Tags   []string                `json:"tags"`
+	Filter *AudienceCreationFilter `json:"filter"`
+}
+
+// Предпросмотр аудитории; AudienceID исключает саму аудиторию из проверки дублей
+type AudiencePreviewRequest struct {
+	AudienceID int64                  `json:"audience_id,omitempty"`
+	Filter     AudienceCreationFilter `json:"filter"`
+}
+
+// Фильтр списка аудиторий
+type AudienceListFilter struct {
+	Search         string `json:"search" form:"search"`
+	Tag            string `json:"tag" form:"tag"`
+	Folder         string `json:"folder" form:"folder"`
+	OrderField     string `json:"order_field" form:"order_field"`
+	OrderDirection string `json:"order_direction" form:"order_direction"`
+}
+
+type AudienceStatsFilter struct {
+	StartDate  *time.Time `json:"start_date"`
+	EndDate    *time.Time `json:"end_date"`
+	CompareIDs []int64    `json:"compare_ids"`
+	CompareAll bool       `json:"compare_all"`
+}
+
 type IntegrationsCreateRequest struct {
 	CabinetName string  `json:"cabinet_name"`
 	AudienceIds []int64 `json:"audience_ids"`
 }
 
+// Режимы подсчета общего количества записей
+const (
+	CountModeExact    = "exact"
+	CountModeEstimate = "estimate"
+	CountModeNone     = "none"
+)
+
 type PaginationRequest struct {
-	Page     int `json:"page" form:"page"`
-	PageSize int `json:"page_size" form:"page_size"`
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"page_size" form:"page_size"`
+	// Курсор из next_cursor предыдущей страницы, при нем page не используется
+	Cursor string `json:"cursor" form:"cursor"`
+	// По умолчанию exact, при курсоре none
+	CountMode string `json:"count" form:"count"`
 }
 
 type StatusDurationFilter struct {
@@ -74,3 +138,12 @@ type StatusDurationFilter struct {
 	EndDate       *time.Time `json:"end_date"`
 	ThresholdDays int        `json:"threshold_days"`
 }
+
+type ApplicationViewRequest struct {
+	Name           string                   `json:"name"`
+	Shared         bool                     `json:"shared"`
+	Filter         ApplicationFilterRequest `json:"filter"`
+	OrderField     string                   `json:"order_field"`
+	OrderDirection string                   `json:"order_direction"`
+	Columns        []string                 `json:"columns"`
+}
@@ -6,9 +6,14 @@ type PaginationResponse struct {
 	Headers    []Header    `json:"header"`
 	Items      interface{} `json:"items"`
 	TotalItems int64       `json:"total_items"`
-	TotalPages int         `json:"total_pages"`
+	TotalPages *int        `json:"total_pages,omitempty"` // нет только при пагинации курсором
 	Page       int         `json:"page"`
 	PageSize   int         `json:"page_size"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	CountMode  string      `json:"count_mode,omitempty"`
+	// Поиск по q нашел больше заявок, чем выводится в списке
+	SearchTotal     int  `json:"search_total,omitempty"`
+	SearchTruncated bool `json:"search_truncated,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -27,20 +32,57 @@ type ApplicationFilterResponce struct {
 	PropertyTypes []string `json:"property_types" form:"property_types"`
 	AudienceNames []string `json:"audience_names" form:"audience_names"`
 	RegionNames   []string `json:"regions" form:"regions"`
+	RejectionReasons []DictionaryItem `json:"rejection_reasons"`
+	NonTargetReasons []DictionaryItem `json:"non_target_reasons"`
 	//CreatedAtFrom  *time.Time `json:"created_at_from" form:"created_at_from"`
 	//CreatedAtTo    *time.Time `json:"created_at_to" form:"created_at_to"`
 	//DeadlinePassed bool       `json:"deadline_passed" form:"deadline_passed"`
 }
 
+// Справочник MacroCRM: идентификатор -> текущее название
+type DictionaryItem struct {
+	ID   int64  `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+}
+
+type FilterDictionaryResponse struct {
+	Statuses         []DictionaryItem `json:"statuses"`
+	Reasons          []DictionaryItem `json:"reasons"`
+	RejectionReasons []DictionaryItem `json:"rejection_reasons"`
+	NonTargetReasons []DictionaryItem `json:"non_target_reasons"`
+}
+
 //audience reports
 type AudienceResponse struct {
 	ID                 int64         `json:"id"`
 	Name               string        `json:"name"`
+	Folder             string        `json:"folder"`
+	Tags               []string      `json:"tags"`
 	Integrations       []Integration `json:"integrations"`
 	//Application_ids    []int64       `json:"application_ids"`
 	Applications_count int       `json:"application_count"`
 	CreatedAt          time.Time `json:"created_at"`
 	UpdatedAt          time.Time `json:"updated_at"`
+	Warnings           []FieldError `json:"warnings,omitempty"`
+}
+
+type AudiencePreviewResponse struct {
+	ApplicationsCount int          `json:"application_count"`
+	Warnings          []FieldError `json:"warnings"`
+}
+
+type AudienceLabelsResponse struct {
+	Folders []string `json:"folders"`
+	Tags    []string `json:"tags"`
+}
+
+type AudienceStatsResponse struct {
+	AudienceID int64                  `json:"audience_id"`
+	StartDate  time.Time              `json:"start_date"`
+	EndDate    time.Time              `json:"end_date"`
+	Series     []AudienceDailyStat    `json:"series"`
+	Summary    AudienceStatsSummary   `json:"summary"`
+	Comparison []AudienceStatsSummary `json:"comparison,omitempty"`
 }
 
 type IntegrationsCreateResponse struct {
@@ -57,6 +99,8 @@ type Header struct {
 	IsAdditional  bool   `json:"is_additional"`
 	IsSortable    bool   `json:"is_sortable"`
 	Format        string `json:"format"`
+	// Как считается показатель
+	Description string `json:"description,omitempty"`
 }
 
 type Data_row struct {
@@ -75,4 +119,67 @@ type StatusDurationResponse struct {
     Headers []Header                 `json:"headers"`
     Data    []map[string]interface{} `json:"data"`
 	Footer  map[string]interface{}   `json:"footer"`
-}
\ No newline at end of file
+}
+// Карточка заявки со связанными данными и историей статусов
+type ApplicationDetailResponse struct {
+	Application  Application           `json:"application"`
+	Contact      ApplicationContact    `json:"contact"`
+	Manager      ApplicationManager    `json:"manager"`
+	Project      ApplicationProject    `json:"project"`
+	Deal         *ApplicationDeal      `json:"deal"`
+	Sell         *ApplicationSell      `json:"sell"`
+	Timeline     []StatusTimelineEntry `json:"timeline"`
+	StatusTotals []StatusTimeTotal     `json:"status_totals"`
+	Audiences    []DictionaryItem      `json:"audiences"`
+}
+
+type ApplicationContact struct {
+	ID              int64  `json:"id"`
+	Name            string `json:"name"`
+	Phone           string `json:"phone"`
+	PassportAddress string `json:"passport_address"`
+	Region          string `json:"region"`
+}
+
+type ApplicationManager struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type ApplicationProject struct {
+	HouseID     int64  `json:"house_id"`
+	ProjectName string `json:"project_name"`
+}
+
+type ApplicationDeal struct {
+	ID   int64      `json:"id"`
+	Date *time.Time `json:"date"`
+	Sum  float64    `json:"sum"`
+}
+
+type ApplicationSell struct {
+	ID         int64  `json:"id"`
+	StatusName string `json:"status_name"`
+}
+
+// Переход заявки в статус и время, проведенное в нем
+type StatusTimelineEntry struct {
+	ID              int64      `json:"id" db:"id"`
+	ChangedAt       time.Time  `json:"changed_at" db:"log_date"`
+	LeftAt          *time.Time `json:"left_at" db:"left_at"`
+	FromStatus      string     `json:"from_status" db:"status_from_name"`
+	ToStatus        string     `json:"to_status" db:"status_to_name"`
+	CustomStatus    string     `json:"custom_status" db:"status_custom_to_name"`
+	UserID          int64      `json:"user_id" db:"users_id"`
+	UserName        string     `json:"user_name" db:"user_name"`
+	DurationSeconds int64      `json:"duration_seconds" db:"duration_seconds"`
+	DurationDays    int64      `json:"duration_days" db:"duration_days"`
+}
+
+// Суммарное время в статусе, если заявка возвращалась в него несколько раз
+type StatusTimeTotal struct {
+	StatusName      string `json:"status_name"`
+	Visits          int    `json:"visits"`
+	DurationSeconds int64  `json:"duration_seconds"`
+	DurationDays    int64  `json:"duration_days"`
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Относительные периоды рассылки. Считаются от времени отправки, даты подставляются в фильтр отчета
+const (
+	ReportPeriodYesterday   = "yesterday"
+	ReportPeriodLastWeek    = "last_week"
+	ReportPeriodLastMonth   = "last_month"
+	ReportPeriodLast7Days   = "last_7_days"
+	ReportPeriodLast30Days  = "last_30_days"
+	ReportPeriodWeekToDate  = "week_to_date"
+	ReportPeriodMonthToDate = "month_to_date"
+)
+
+// Статусы отправки отчета
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSending = "sending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+// Подписка на отчет: отчет с фильтром Params отправляется получателям по расписанию Schedule
+// (cron из пяти полей, можно указать часовой пояс префиксом CRON_TZ=Europe/Moscow)
+type ReportSubscription struct {
+	ID         int64           `json:"id" db:"id"`
+	OwnerID    string          `json:"owner_id" db:"owner_id"`
+	Name       string          `json:"name" db:"name"`
+	ReportType string          `json:"report_type" db:"report_type"`
+	Format     string          `json:"format" db:"format"`
+	Params     json.RawMessage `json:"params" db:"params"`
+	Period     string          `json:"period,omitempty" db:"period"`
+	Recipients []string        `json:"recipients" db:"-"`
+	Schedule   string          `json:"schedule" db:"schedule"`
+	Enabled    bool            `json:"enabled" db:"enabled"`
+	NextRunAt  *time.Time      `json:"next_run_at,omitempty" db:"next_run_at"`
+	LastRunAt  *time.Time      `json:"last_run_at,omitempty" db:"last_run_at"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type ReportSubscriptionRequest struct {
+	Name       string          `json:"name"`
+	ReportType string          `json:"report_type"`
+	Format     string          `json:"format"`
+	Params     json.RawMessage `json:"params"`
+	Period     string          `json:"period"`
+	Recipients []string        `json:"recipients"`
+	Schedule   string          `json:"schedule"`
+	// Без значения подписка создается включенной
+	Enabled *bool `json:"enabled"`
+}
+
+// Одна отправка отчета по подписке
+type ReportDelivery struct {
+	ID             int64           `json:"id" db:"id"`
+	SubscriptionID int64           `json:"subscription_id" db:"subscription_id"`
+	ReportType     string          `json:"report_type" db:"report_type"`
+	Format         string          `json:"format" db:"format"`
+	Params         json.RawMessage `json:"params" db:"params"`
+	Recipients     []string        `json:"recipients" db:"-"`
+	PeriodStart    *time.Time      `json:"period_start,omitempty" db:"period_start"`
+	PeriodEnd      *time.Time      `json:"period_end,omitempty" db:"period_end"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	FileName       string          `json:"file_name,omitempty" db:"file_name"`
+	Error          string          `json:"error,omitempty" db:"error"`
+	ScheduledAt    time.Time       `json:"scheduled_at" db:"scheduled_at"`
+	StartedAt      *time.Time      `json:"started_at,omitempty" db:"started_at"`
+	SentAt         *time.Time      `json:"sent_at,omitempty" db:"sent_at"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// Запрошенная запись не существует, обработчики отдают 404
+	ErrNotFound = errors.New("not found")
+	// Запись принадлежит другому пользователю, обработчики отдают 403
+	ErrForbidden = errors.New("forbidden")
+)
+
+const (
+	ValidationCodeRequired      = "required"
+	ValidationCodeInvalidRange  = "invalid_range"
+	ValidationCodeInvalidValue  = "invalid_value"
+	ValidationCodeEmptyResult   = "empty_result"
+	ValidationCodeDuplicate     = "duplicate_filter"
+	ValidationCodeUnknownStatus = "unknown_status"
+	ValidationCodeUnknownReason = "unknown_reason"
+)
+
+// Ошибка или предупреждение по конкретному полю запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Результат проверки: ошибки блокируют операцию, предупреждения возвращаются клиенту
+type ValidationResult struct {
+	Errors   []FieldError `json:"errors,omitempty"`
+	Warnings []FieldError `json:"warnings,omitempty"`
+}
+
+func (r *ValidationResult) AddError(field, code, message string) {
+	r.Errors = append(r.Errors, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (r *ValidationResult) AddWarning(field, code, message string) {
+	r.Warnings = append(r.Warnings, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (r *ValidationResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: r.Errors}
+}
+
+// Ошибка валидации, которую обработчики отдают как 400 со списком полей
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func NewValidationError(field, code, message string) *ValidationError {
+	return &ValidationError{Errors: []FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		messages = append(messages, fieldErr.Field+": "+fieldErr.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"time"
@@ -63,7 +65,40 @@ func (am *AuthMiddleware) Validate(next http.Handler) http.Handler {
             return
         }
 
+        defer resp.Body.Close()
+
+        // Данные пользователя из токена доступны обработчикам через контекст
+        var validation struct {
+            Claims struct {
+                UserID string `json:"user_id"`
+                Role   string `json:"role"`
+            } `json:"claims"`
+        }
+        if err := json.NewDecoder(resp.Body).Decode(&validation); err == nil {
+            ctx := context.WithValue(r.Context(), userIDKey, validation.Claims.UserID)
+            ctx = context.WithValue(ctx, userRoleKey, validation.Claims.Role)
+            r = r.WithContext(ctx)
+        }
+
         // Token is valid, proceed
         next.ServeHTTP(w, r)
     })
+}
+
+type contextKey string
+
+const (
+    userIDKey   contextKey = "user_id"
+    userRoleKey contextKey = "user_role"
+)
+
+// Идентификатор пользователя из проверенного токена, пусто для публичных маршрутов
+func UserID(ctx context.Context) string {
+    userID, _ := ctx.Value(userIDKey).(string)
+    return userID
+}
+
+func UserRole(ctx context.Context) string {
+    role, _ := ctx.Value(userRoleKey).(string)
+    return role
 }
\ No newline at end of file
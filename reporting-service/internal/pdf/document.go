@@ -0,0 +1,298 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Размеры A4 в пунктах
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Минимальный генератор PDF: страницы с текстом, линиями и заливкой.
+// Координаты задаются в пунктах от левого верхнего угла страницы
+type Document struct {
+	width  float64
+	height float64
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	fonts  []*Font
+	used   map[*Font]map[uint16]rune
+}
+
+func New(width, height float64) *Document {
+	return &Document{
+		width:  width,
+		height: height,
+		used:   map[*Font]map[uint16]rune{},
+	}
+}
+
+func (d *Document) Width() float64  { return d.width }
+func (d *Document) Height() float64 { return d.height }
+
+func (d *Document) AddPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+}
+
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+// Делает текущей уже созданную страницу, например чтобы проставить номера страниц
+func (d *Document) SetPage(n int) {
+	if n >= 1 && n <= len(d.pages) {
+		d.page = d.pages[n-1]
+	}
+}
+
+// Текст с базовой линией на высоте y
+func (d *Document) Text(font *Font, size, x, y float64, text string) {
+	if d.page == nil || text == "" {
+		return
+	}
+
+	used, ok := d.used[font]
+	if !ok {
+		used = map[uint16]rune{}
+		d.used[font] = used
+		d.fonts = append(d.fonts, font)
+	}
+
+	var hex bytes.Buffer
+	for _, r := range text {
+		gid := font.glyphs[r]
+		if gid != 0 {
+			used[gid] = r
+		}
+		fmt.Fprintf(&hex, "%04X", gid)
+	}
+	fmt.Fprintf(d.page, "BT /F%d %s Tf %s %s Td <%s> Tj ET\n",
+		d.fontIndex(font), num(size), num(x), num(d.height-y), hex.String())
+}
+
+// Прямоугольник с заливкой оттенком серого: 0 - черный, 1 - белый
+func (d *Document) FillRect(x, y, width, height, gray float64) {
+	if d.page == nil {
+		return
+	}
+	fmt.Fprintf(d.page, "%s g %s %s %s %s re f 0 g\n",
+		num(gray), num(x), num(d.height-y-height), num(width), num(height))
+}
+
+func (d *Document) Line(x1, y1, x2, y2, lineWidth, gray float64) {
+	if d.page == nil {
+		return
+	}
+	fmt.Fprintf(d.page, "%s w %s G %s %s m %s %s l S 0 G\n",
+		num(lineWidth), num(gray), num(x1), num(d.height-y1), num(x2), num(d.height-y2))
+}
+
+func (d *Document) fontIndex(font *Font) int {
+	for i, f := range d.fonts {
+		if f == font {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Номера объектов: 1 - каталог, 2 - дерево страниц, далее по два на страницу
+// (страница и ее содержимое) и по пять на шрифт
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	out := &objectWriter{w: bufio.NewWriter(w)}
+	fontBase := 3 + 2*len(d.pages)
+	total := fontBase + 5*len(d.fonts) - 1
+	out.offsets = make([]int64, total+1)
+
+	out.printf("%%PDF-1.4\n%%\xE2\xE3\xCF\xD3\n")
+
+	out.begin(1)
+	out.printf("<< /Type /Catalog /Pages 2 0 R >>\n")
+	out.end()
+
+	out.begin(2)
+	out.printf("<< /Type /Pages /Count %d /Kids [", len(d.pages))
+	for i := range d.pages {
+		out.printf(" %d 0 R", 3+2*i)
+	}
+	out.printf(" ] >>\n")
+	out.end()
+
+	var fontResources bytes.Buffer
+	for i := range d.fonts {
+		fmt.Fprintf(&fontResources, " /F%d %d 0 R", i+1, fontBase+5*i)
+	}
+
+	for i, page := range d.pages {
+		out.begin(3 + 2*i)
+		out.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font <<%s >> >> /Contents %d 0 R >>\n",
+			num(d.width), num(d.height), fontResources.String(), 4+2*i)
+		out.end()
+
+		if err := out.stream(4+2*i, page.Bytes(), ""); err != nil {
+			return out.n, err
+		}
+	}
+
+	for i, font := range d.fonts {
+		if err := d.writeFont(out, font, fontBase+5*i); err != nil {
+			return out.n, err
+		}
+	}
+
+	xref := out.n
+	out.printf("xref\n0 %d\n0000000000 65535 f \n", total+1)
+	for id := 1; id <= total; id++ {
+		out.printf("%010d 00000 n \n", out.offsets[id])
+	}
+	out.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", total+1, xref)
+
+	if out.err != nil {
+		return out.n, out.err
+	}
+	return out.n, out.w.Flush()
+}
+
+// Составной шрифт Type0 с кодировкой Identity-H: в тексте записываются номера глифов,
+// ToUnicode позволяет копировать и искать текст в документе
+func (d *Document) writeFont(out *objectWriter, font *Font, id int) error {
+	used := d.used[font]
+	gids := make([]int, 0, len(used))
+	for gid := range used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	fontFile, err := font.subset(used)
+	if err != nil {
+		return fmt.Errorf("subset font %s: %w", font.name, err)
+	}
+	name := subsetTag(id) + "+" + font.name
+
+	out.begin(id)
+	out.printf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>\n",
+		name, id+1, id+4)
+	out.end()
+
+	var widths bytes.Buffer
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, " %d [%s]", gid, num(font.glyphWidth(uint16(gid))))
+	}
+	out.begin(id + 1)
+	out.printf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW %s /W [%s ] /CIDToGIDMap /Identity >>\n",
+		name, id+2, num(font.glyphWidth(0)), widths.String())
+	out.end()
+
+	out.begin(id + 2)
+	out.printf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%s %s %s %s] /ItalicAngle 0 /Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %d 0 R >>\n",
+		name, num(font.bbox[0]), num(font.bbox[1]), num(font.bbox[2]), num(font.bbox[3]),
+		num(font.ascent), num(font.descent), num(font.capHeight), id+3)
+	out.end()
+
+	if err := out.stream(id+3, fontFile, fmt.Sprintf(" /Length1 %d", len(fontFile))); err != nil {
+		return err
+	}
+
+	var cmap bytes.Buffer
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		chunk := gids[start:min(start+100, len(gids))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(chunk))
+		for _, gid := range chunk {
+			fmt.Fprintf(&cmap, "<%04X> <", gid)
+			for _, unit := range utf16.Encode([]rune{used[uint16(gid)]}) {
+				fmt.Fprintf(&cmap, "%04X", unit)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return out.stream(id+4, cmap.Bytes(), "")
+}
+
+// Метка подмножества шрифта из шести заглавных букв, уникальная в пределах документа
+func subsetTag(id int) string {
+	tag := []byte("AAAAAA")
+	for i := len(tag) - 1; i >= 0 && id > 0; i-- {
+		tag[i] = byte('A' + id%26)
+		id /= 26
+	}
+	return string(tag)
+}
+
+// Координаты с точностью до сотой пункта
+func num(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+type objectWriter struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64
+	err     error
+}
+
+func (o *objectWriter) printf(format string, args ...interface{}) {
+	if o.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(o.w, format, args...)
+	o.n += int64(n)
+	o.err = err
+}
+
+func (o *objectWriter) write(p []byte) {
+	if o.err != nil {
+		return
+	}
+	n, err := o.w.Write(p)
+	o.n += int64(n)
+	o.err = err
+}
+
+func (o *objectWriter) begin(id int) {
+	o.offsets[id] = o.n
+	o.printf("%d 0 obj\n", id)
+}
+
+func (o *objectWriter) end() {
+	o.printf("endobj\n")
+}
+
+// Потоки сжимаются zlib (FlateDecode)
+func (o *objectWriter) stream(id int, data []byte, extra string) error {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return fmt.Errorf("compress stream: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress stream: %w", err)
+	}
+
+	o.begin(id)
+	o.printf("<< /Length %d /Filter /FlateDecode%s >>\nstream\n", compressed.Len(), extra)
+	o.write(compressed.Bytes())
+	o.printf("\nendstream\n")
+	o.end()
+	return o.err
+}
@@ -0,0 +1,178 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "перезаписать эталонные файлы в testdata")
+
+// Две страницы с кириллицей, заливкой и линиями, номера страниц проставляются после верстки
+func testDocument(t *testing.T) []byte {
+	t.Helper()
+	font := testFont(t)
+
+	doc := New(A4Width, A4Height)
+	doc.AddPage()
+	doc.Text(font, 14, 36, 50, "Отчет по колл-центру")
+	doc.FillRect(36, 60, 200, 14, 0.85)
+	doc.Text(font, 8, 40, 71, "Менеджер")
+	doc.Line(36, 74, 236, 74, 0.5, 0.6)
+	doc.AddPage()
+	doc.Text(font, 8, 40, 50, "Итого: 42")
+	for page := 1; page <= doc.PageCount(); page++ {
+		doc.SetPage(page)
+		doc.Text(font, 8, 280, A4Height-18, fmt.Sprintf("Страница %d из %d", page, doc.PageCount()))
+	}
+
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+	return buf.Bytes()
+}
+
+// Документ сравнивается с эталоном побайтно. После намеренных изменений формата
+// эталон обновляется: go test ./internal/pdf -run Golden -update
+func TestDocumentGolden(t *testing.T) {
+	got := testDocument(t)
+	golden := filepath.Join("testdata", "cyrillic.golden.pdf")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("document differs from %s (%d bytes, want %d)", golden, len(got), len(want))
+	}
+}
+
+var (
+	startxrefPattern = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	xrefPattern      = regexp.MustCompile(`^xref\n0 (\d+)\n0000000000 65535 f \n`)
+	trailerPattern   = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R >>`)
+)
+
+// Таблица xref указывает на начало каждого объекта, startxref - на саму таблицу
+func TestDocumentXref(t *testing.T) {
+	data := testDocument(t)
+
+	match := startxrefPattern.FindSubmatch(data)
+	if match == nil {
+		t.Fatal("no startxref at the end of document")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	table := xrefPattern.FindSubmatch(data[xref:])
+	if table == nil {
+		t.Fatalf("startxref %d does not point to xref table", xref)
+	}
+	size, _ := strconv.Atoi(string(table[1]))
+	// Каталог, дерево страниц, по два объекта на страницу и пять на шрифт
+	if want := 2 + 2*2 + 5; size != want+1 {
+		t.Errorf("xref size = %d, want %d", size, want+1)
+	}
+	if trailer := trailerPattern.FindSubmatch(data[xref:]); trailer == nil || string(trailer[1]) != strconv.Itoa(size) {
+		t.Errorf("trailer size does not match xref size %d", size)
+	}
+
+	entries := data[xref+len(table[0]):]
+	for id := 1; id < size; id++ {
+		entry := string(entries[20*(id-1) : 20*id])
+		if len(entry) != 20 || entry[10:] != " 00000 n \n" {
+			t.Fatalf("xref entry %d = %q", id, entry)
+		}
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil {
+			t.Fatalf("xref entry %d: %v", id, err)
+		}
+		if header := fmt.Sprintf("%d 0 obj\n", id); !bytes.HasPrefix(data[offset:], []byte(header)) {
+			t.Errorf("object %d: offset %d points to %q", id, offset, data[offset:min(offset+len(header), len(data))])
+		}
+	}
+}
+
+var streamPattern = regexp.MustCompile(`(?s)(\d+) 0 obj\n<< /Length (\d+) /Filter /FlateDecode[^>]*>>\nstream\n`)
+
+// Потоки документа по номерам объектов, распакованные
+func documentStreams(t *testing.T, data []byte) map[int][]byte {
+	t.Helper()
+	streams := map[int][]byte{}
+	for _, match := range streamPattern.FindAllSubmatchIndex(data, -1) {
+		id, _ := strconv.Atoi(string(data[match[2]:match[3]]))
+		length, _ := strconv.Atoi(string(data[match[4]:match[5]]))
+		body := data[match[1] : match[1]+length]
+		if !bytes.HasPrefix(data[match[1]+length:], []byte("\nendstream\n")) {
+			t.Fatalf("stream %d: /Length %d does not end at endstream", id, length)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("stream %d: %v", id, err)
+		}
+		decoded, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("stream %d: %v", id, err)
+		}
+		streams[id] = decoded
+	}
+	return streams
+}
+
+// Текст записан номерами глифов, ToUnicode переводит их обратно в кириллицу,
+// а встроенный шрифт содержит только использованные глифы
+func TestDocumentCyrillicText(t *testing.T) {
+	font := testFont(t)
+	data := testDocument(t)
+	streams := documentStreams(t, data)
+
+	// Объекты шрифта начинаются после страниц: 3 + 2*2
+	const fontID = 7
+	if !bytes.Contains(data, []byte(fmt.Sprintf("%d 0 obj\n<< /Type /Font /Subtype /Type0 /BaseFont /AAAAAH+GoRegular /Encoding /Identity-H", fontID))) {
+		t.Fatal("no Type0 font object with subset tag")
+	}
+
+	var hex bytes.Buffer
+	for _, r := range "Отчет по колл-центру" {
+		fmt.Fprintf(&hex, "%04X", font.glyphs[r])
+	}
+	// Координата y отсчитывается от нижнего края страницы
+	title := "BT /F1 14 Tf 36 791.89 Td <" + hex.String() + "> Tj ET\n"
+	if content := streams[4]; !bytes.Contains(content, []byte(title)) {
+		t.Errorf("page content does not contain title %q:\n%s", title, content)
+	}
+
+	cmap := string(streams[fontID+4])
+	for _, r := range "Отчет по колл-центру Страница Итого" {
+		if r == ' ' {
+			continue
+		}
+		if mapping := fmt.Sprintf("<%04X> <%04X>\n", font.glyphs[r], r); !bytes.Contains([]byte(cmap), []byte(mapping)) {
+			t.Errorf("ToUnicode has no mapping %q for %q", mapping, r)
+		}
+	}
+
+	tables := fontTables(t, streams[fontID+3])
+	for _, r := range "ОтчетМенеджер" {
+		if len(glyphData(tables, int(font.glyphs[r]))) == 0 {
+			t.Errorf("embedded font has no outline for %q", r)
+		}
+	}
+	if unused := font.glyphs['Щ']; len(glyphData(tables, int(unused))) != 0 {
+		t.Error("embedded font keeps unused glyph Щ")
+	}
+}
@@ -0,0 +1,481 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// TrueType шрифт для встраивания в документ. Стандартные шрифты PDF не содержат кириллицы,
+// поэтому текст всегда выводится встроенным шрифтом
+type Font struct {
+	name       string
+	data       []byte
+	tables     map[string][]byte
+	unitsPerEm float64
+	ascent     float64
+	descent    float64
+	capHeight  float64
+	bbox       [4]float64
+	advances   []uint16
+	glyphs     map[rune]uint16
+}
+
+var errInvalidFont = errors.New("invalid truetype font")
+
+func LoadFont(path string) (*Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read font: %w", err)
+	}
+	font, err := ParseFont(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font %s: %w", path, err)
+	}
+	return font, nil
+}
+
+func ParseFont(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, errInvalidFont
+	}
+	if version := binary.BigEndian.Uint32(data); version != 0x00010000 && version != 0x74727565 {
+		return nil, fmt.Errorf("%w: only glyf-based fonts are supported", errInvalidFont)
+	}
+
+	f := &Font{data: data, tables: map[string][]byte{}}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, errInvalidFont
+		}
+		tag := string(data[record : record+4])
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("%w: table %s out of range", errInvalidFont, tag)
+		}
+		f.tables[tag] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "cmap", "loca", "glyf"} {
+		if _, ok := f.tables[tag]; !ok {
+			return nil, fmt.Errorf("%w: missing %s table", errInvalidFont, tag)
+		}
+	}
+
+	head := f.tables["head"]
+	hhea := f.tables["hhea"]
+	if len(head) < 54 || len(hhea) < 36 || len(f.tables["maxp"]) < 6 {
+		return nil, errInvalidFont
+	}
+	f.unitsPerEm = float64(binary.BigEndian.Uint16(head[18:]))
+	if f.unitsPerEm == 0 {
+		return nil, errInvalidFont
+	}
+	for i := range f.bbox {
+		f.bbox[i] = f.scale(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+	f.ascent = f.scale(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = f.scale(int16(binary.BigEndian.Uint16(hhea[6:])))
+	f.capHeight = f.ascent
+
+	if os2 := f.tables["OS/2"]; len(os2) >= 10 {
+		// Шрифты с запретом встраивания использовать нельзя
+		if binary.BigEndian.Uint16(os2[8:])&0x000F == 0x0002 {
+			return nil, fmt.Errorf("%w: font license does not allow embedding", errInvalidFont)
+		}
+		if binary.BigEndian.Uint16(os2) >= 2 && len(os2) >= 90 {
+			f.capHeight = f.scale(int16(binary.BigEndian.Uint16(os2[88:])))
+		}
+	}
+
+	if err := f.parseMetrics(); err != nil {
+		return nil, err
+	}
+	if err := f.parseCmap(); err != nil {
+		return nil, err
+	}
+	f.name = f.parseName()
+	return f, nil
+}
+
+// Ширина текста в пунктах при заданном кегле
+func (f *Font) TextWidth(text string, size float64) float64 {
+	width := 0.0
+	for _, r := range text {
+		width += f.glyphWidth(f.glyphs[r])
+	}
+	return width * size / 1000
+}
+
+// Ширина глифа в единицах PDF (1000 на кегль)
+func (f *Font) glyphWidth(gid uint16) float64 {
+	if len(f.advances) == 0 {
+		return 0
+	}
+	if int(gid) >= len(f.advances) {
+		gid = uint16(len(f.advances) - 1)
+	}
+	return f.scale(int16(f.advances[gid]))
+}
+
+func (f *Font) scale(value int16) float64 {
+	return float64(value) * 1000 / f.unitsPerEm
+}
+
+func (f *Font) numGlyphs() int {
+	return int(binary.BigEndian.Uint16(f.tables["maxp"][4:]))
+}
+
+func (f *Font) parseMetrics() error {
+	hmtx := f.tables["hmtx"]
+	numMetrics := int(binary.BigEndian.Uint16(f.tables["hhea"][34:]))
+	numGlyphs := f.numGlyphs()
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < 4*numMetrics {
+		return fmt.Errorf("%w: broken hmtx table", errInvalidFont)
+	}
+
+	// Глифы после последней записи hmtx наследуют ее ширину
+	f.advances = make([]uint16, numGlyphs)
+	for i := 0; i < numGlyphs; i++ {
+		if i < numMetrics {
+			f.advances[i] = binary.BigEndian.Uint16(hmtx[4*i:])
+		} else {
+			f.advances[i] = f.advances[numMetrics-1]
+		}
+	}
+	return nil
+}
+
+// Юникодная таблица символов: формат 12 для всего диапазона или формат 4 для BMP
+func (f *Font) parseCmap() error {
+	cmap := f.tables["cmap"]
+	if len(cmap) < 4 {
+		return errInvalidFont
+	}
+
+	var format4, format12 []byte
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables; i++ {
+		record := 4 + 8*i
+		if record+8 > len(cmap) {
+			return errInvalidFont
+		}
+		platform := binary.BigEndian.Uint16(cmap[record:])
+		encoding := binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if offset+4 > len(cmap) {
+			continue
+		}
+		if platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+		switch binary.BigEndian.Uint16(cmap[offset:]) {
+		case 4:
+			format4 = cmap[offset:]
+		case 12:
+			format12 = cmap[offset:]
+		}
+	}
+
+	f.glyphs = map[rune]uint16{}
+	switch {
+	case format12 != nil:
+		return f.parseCmap12(format12)
+	case format4 != nil:
+		return f.parseCmap4(format4)
+	default:
+		return fmt.Errorf("%w: no unicode cmap", errInvalidFont)
+	}
+}
+
+func (f *Font) parseCmap4(table []byte) error {
+	if len(table) < 14 {
+		return errInvalidFont
+	}
+	segX2 := int(binary.BigEndian.Uint16(table[6:]))
+	if len(table) < 16+4*segX2 {
+		return errInvalidFont
+	}
+	ends := 14
+	starts := 16 + segX2
+	deltas := 16 + 2*segX2
+	rangeOffsets := 16 + 3*segX2
+
+	for i := 0; i < segX2; i += 2 {
+		end := int(binary.BigEndian.Uint16(table[ends+i:]))
+		start := int(binary.BigEndian.Uint16(table[starts+i:]))
+		delta := int(binary.BigEndian.Uint16(table[deltas+i:]))
+		rangeOffset := int(binary.BigEndian.Uint16(table[rangeOffsets+i:]))
+		for c := start; c <= end && c != 0xFFFF; c++ {
+			gid := 0
+			if rangeOffset == 0 {
+				gid = (c + delta) & 0xFFFF
+			} else {
+				addr := rangeOffsets + i + rangeOffset + 2*(c-start)
+				if addr+2 > len(table) {
+					continue
+				}
+				if gid = int(binary.BigEndian.Uint16(table[addr:])); gid != 0 {
+					gid = (gid + delta) & 0xFFFF
+				}
+			}
+			if gid != 0 {
+				f.glyphs[rune(c)] = uint16(gid)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Font) parseCmap12(table []byte) error {
+	if len(table) < 16 {
+		return errInvalidFont
+	}
+	groups := int(binary.BigEndian.Uint32(table[12:]))
+	if len(table) < 16+12*groups {
+		return errInvalidFont
+	}
+	for i := 0; i < groups; i++ {
+		group := table[16+12*i:]
+		start := binary.BigEndian.Uint32(group)
+		end := binary.BigEndian.Uint32(group[4:])
+		gid := binary.BigEndian.Uint32(group[8:])
+		for c := start; c <= end && c <= 0x10FFFF; c++ {
+			if g := gid + (c - start); g < 0x10000 {
+				f.glyphs[rune(c)] = uint16(g)
+			}
+		}
+	}
+	return nil
+}
+
+// PostScript-имя шрифта из таблицы name, только допустимые в имени PDF символы
+func (f *Font) parseName() string {
+	name := ""
+	table := f.tables["name"]
+	if len(table) >= 6 {
+		count := int(binary.BigEndian.Uint16(table[2:]))
+		storage := int(binary.BigEndian.Uint16(table[4:]))
+		for i := 0; i < count && name == ""; i++ {
+			record := 6 + 12*i
+			if record+12 > len(table) {
+				break
+			}
+			platform := binary.BigEndian.Uint16(table[record:])
+			nameID := binary.BigEndian.Uint16(table[record+6:])
+			length := int(binary.BigEndian.Uint16(table[record+8:]))
+			offset := storage + int(binary.BigEndian.Uint16(table[record+10:]))
+			if nameID != 6 || offset+length > len(table) {
+				continue
+			}
+			raw := table[offset : offset+length]
+			if platform == 3 || platform == 0 {
+				units := make([]uint16, len(raw)/2)
+				for j := range units {
+					units[j] = binary.BigEndian.Uint16(raw[2*j:])
+				}
+				name = string(utf16.Decode(units))
+			} else {
+				name = string(raw)
+			}
+		}
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return "EmbeddedFont"
+	}
+	return name
+}
+
+// Подмножество шрифта: номера глифов сохраняются, контуры неиспользуемых глифов удаляются.
+// Так встроенный шрифт занимает десятки килобайт вместо сотен
+func (f *Font) subset(used map[uint16]rune) ([]byte, error) {
+	head := f.tables["head"]
+	loca := f.tables["loca"]
+	glyf := f.tables["glyf"]
+	numGlyphs := f.numGlyphs()
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+
+	glyphRange := func(gid int) (int, int, error) {
+		var start, end int
+		if longLoca {
+			if 4*gid+8 > len(loca) {
+				return 0, 0, errInvalidFont
+			}
+			start = int(binary.BigEndian.Uint32(loca[4*gid:]))
+			end = int(binary.BigEndian.Uint32(loca[4*gid+4:]))
+		} else {
+			if 2*gid+4 > len(loca) {
+				return 0, 0, errInvalidFont
+			}
+			start = 2 * int(binary.BigEndian.Uint16(loca[2*gid:]))
+			end = 2 * int(binary.BigEndian.Uint16(loca[2*gid+2:]))
+		}
+		if start > end || end > len(glyf) {
+			return 0, 0, errInvalidFont
+		}
+		return start, end, nil
+	}
+
+	// Составные глифы ссылаются на другие глифы, их тоже нужно сохранить
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for gid := range used {
+		if int(gid) < numGlyphs && !keep[int(gid)] {
+			keep[int(gid)] = true
+			queue = append(queue, int(gid))
+		}
+	}
+	for len(queue) > 0 {
+		gid := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		start, end, err := glyphRange(gid)
+		if err != nil {
+			return nil, err
+		}
+		for _, component := range compositeComponents(glyf[start:end]) {
+			if component < numGlyphs && !keep[component] {
+				keep[component] = true
+				queue = append(queue, component)
+			}
+		}
+	}
+
+	newGlyf := make([]byte, 0, len(glyf)/8)
+	newLoca := make([]byte, 4*(numGlyphs+1))
+	for gid := 0; gid < numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(newLoca[4*gid:], uint32(len(newGlyf)))
+		if !keep[gid] {
+			continue
+		}
+		start, end, err := glyphRange(gid)
+		if err != nil {
+			return nil, err
+		}
+		newGlyf = append(newGlyf, glyf[start:end]...)
+		for len(newGlyf)%4 != 0 {
+			newGlyf = append(newGlyf, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*numGlyphs:], uint32(len(newGlyf)))
+
+	// Длинный формат loca и обнуленная контрольная сумма файла
+	newHead := append([]byte(nil), head...)
+	binary.BigEndian.PutUint32(newHead[8:], 0)
+	binary.BigEndian.PutUint16(newHead[50:], 1)
+
+	tables := map[string][]byte{
+		"head": newHead,
+		"hhea": f.tables["hhea"],
+		"maxp": f.tables["maxp"],
+		"hmtx": f.tables["hmtx"],
+		"loca": newLoca,
+		"glyf": newGlyf,
+	}
+	// Инструкции хинтинга нужны глифам, остальные таблицы для PDF не используются
+	for _, tag := range []string{"cvt ", "fpgm", "prep"} {
+		if table, ok := f.tables[tag]; ok {
+			tables[tag] = table
+		}
+	}
+	return buildFontFile(tables), nil
+}
+
+func compositeComponents(glyph []byte) []int {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return nil
+	}
+
+	const (
+		argsAreWords   = 0x0001
+		haveScale      = 0x0008
+		moreComponents = 0x0020
+		haveXYScale    = 0x0040
+		haveTwoByTwo   = 0x0080
+	)
+
+	var components []int
+	pos := 10
+	for pos+4 <= len(glyph) {
+		flags := binary.BigEndian.Uint16(glyph[pos:])
+		components = append(components, int(binary.BigEndian.Uint16(glyph[pos+2:])))
+		pos += 4
+		if flags&argsAreWords != 0 {
+			pos += 4
+		} else {
+			pos += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			pos += 2
+		case flags&haveXYScale != 0:
+			pos += 4
+		case flags&haveTwoByTwo != 0:
+			pos += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return components
+}
+
+func buildFontFile(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	numTables := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= numTables {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	header := make([]byte, 12+16*numTables)
+	binary.BigEndian.PutUint32(header, 0x00010000)
+	binary.BigEndian.PutUint16(header[4:], uint16(numTables))
+	binary.BigEndian.PutUint16(header[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(numTables*16-searchRange))
+
+	body := []byte{}
+	offset := len(header)
+	for i, tag := range tags {
+		table := tables[tag]
+		record := header[12+16*i:]
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[4:], tableChecksum(table))
+		binary.BigEndian.PutUint32(record[8:], uint32(offset+len(body)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table)))
+		body = append(body, table...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return append(header, body...)
+}
+
+func tableChecksum(table []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(table); i += 4 {
+		var word [4]byte
+		copy(word[:], table[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
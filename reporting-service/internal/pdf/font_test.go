@@ -0,0 +1,247 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func testFont(t *testing.T) *Font {
+	t.Helper()
+	font, err := ParseFont(goregular.TTF)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+	return font
+}
+
+// Таблицы файла шрифта с проверкой контрольных сумм
+func fontTables(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	if len(data) < 12 {
+		t.Fatalf("font file is too short: %d bytes", len(data))
+	}
+	tables := map[string][]byte{}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := data[12+16*i:]
+		tag := string(record[:4])
+		offset := int(binary.BigEndian.Uint32(record[8:]))
+		length := int(binary.BigEndian.Uint32(record[12:]))
+		if offset%4 != 0 || offset+length > len(data) {
+			t.Fatalf("table %s: offset %d length %d out of file of %d bytes", tag, offset, length, len(data))
+		}
+		table := data[offset : offset+length]
+		if sum := binary.BigEndian.Uint32(record[4:]); sum != tableChecksum(table) {
+			t.Errorf("table %s: checksum %08X, want %08X", tag, sum, tableChecksum(table))
+		}
+		tables[tag] = table
+	}
+	return tables
+}
+
+// Контур глифа по таблицам loca и glyf
+func glyphData(tables map[string][]byte, gid int) []byte {
+	loca, glyf := tables["loca"], tables["glyf"]
+	var start, end int
+	if binary.BigEndian.Uint16(tables["head"][50:]) == 1 {
+		start, end = int(binary.BigEndian.Uint32(loca[4*gid:])), int(binary.BigEndian.Uint32(loca[4*gid+4:]))
+	} else {
+		start, end = 2*int(binary.BigEndian.Uint16(loca[2*gid:])), 2*int(binary.BigEndian.Uint16(loca[2*gid+2:]))
+	}
+	return glyf[start:end]
+}
+
+func TestParseFontCyrillic(t *testing.T) {
+	font := testFont(t)
+
+	for _, r := range "Отчет по колл-центру: ЁЖЩЪЭЮЯ ёжщъэюя №…" {
+		if r != ' ' && font.glyphs[r] == 0 {
+			t.Errorf("no glyph for %q", r)
+		}
+	}
+	if font.name != "GoRegular" {
+		t.Errorf("name = %q", font.name)
+	}
+
+	one := font.TextWidth("Ж", 10)
+	if one <= 0 {
+		t.Fatalf("TextWidth(Ж) = %v", one)
+	}
+	if three := font.TextWidth("ЖЖЖ", 10); three != 3*one {
+		t.Errorf("TextWidth(ЖЖЖ) = %v, want %v", three, 3*one)
+	}
+	if double := font.TextWidth("Ж", 20); double != 2*one {
+		t.Errorf("TextWidth at size 20 = %v, want %v", double, 2*one)
+	}
+}
+
+func TestParseFontInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": goregular.TTF[:100],
+		"otf":       append([]byte("OTTO"), make([]byte, 20)...),
+	} {
+		if _, err := ParseFont(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// В подмножестве остаются контуры использованных глифов, нулевого глифа и компонентов составных глифов.
+// Номера глифов не меняются, остальные контуры пустые. В Go Regular составных глифов нет, они проверяются ниже
+func TestFontSubsetCyrillic(t *testing.T) {
+	font := testFont(t)
+	used := map[uint16]rune{}
+	for _, r := range "Привет, ёжик! Й…" {
+		used[font.glyphs[r]] = r
+	}
+
+	data, err := font.subset(used)
+	if err != nil {
+		t.Fatalf("subset: %v", err)
+	}
+	if len(data)*4 > len(goregular.TTF) {
+		t.Errorf("subset is %d bytes, original %d", len(data), len(goregular.TTF))
+	}
+
+	tables := fontTables(t, data)
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "loca", "glyf"} {
+		if _, ok := tables[tag]; !ok {
+			t.Fatalf("subset has no %s table", tag)
+		}
+	}
+	if !bytes.Equal(tables["hmtx"], font.tables["hmtx"]) || !bytes.Equal(tables["maxp"], font.tables["maxp"]) {
+		t.Error("subset must keep glyph metrics and count")
+	}
+	if got, want := len(tables["loca"]), 4*(font.numGlyphs()+1); got != want {
+		t.Fatalf("loca length = %d, want %d (long format)", got, want)
+	}
+
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for gid := range used {
+		keep[int(gid)] = true
+		queue = append(queue, int(gid))
+	}
+	for len(queue) > 0 {
+		gid := queue[0]
+		queue = queue[1:]
+		for _, component := range compositeComponents(glyphData(font.tables, gid)) {
+			if !keep[component] {
+				keep[component] = true
+				queue = append(queue, component)
+			}
+		}
+	}
+
+	for gid := 0; gid < font.numGlyphs(); gid++ {
+		got := glyphData(tables, gid)
+		if !keep[gid] {
+			if len(got) != 0 {
+				t.Errorf("glyph %d is not used but kept (%d bytes)", gid, len(got))
+			}
+			continue
+		}
+		want := glyphData(font.tables, gid)
+		if !bytes.Equal(bytes.TrimRight(got, "\x00"), bytes.TrimRight(want, "\x00")) {
+			t.Errorf("glyph %d differs from original", gid)
+		}
+	}
+}
+
+// Составной глиф: первый компонент с аргументами-словами и масштабом, остальные с аргументами-байтами
+func compositeGlyph(components ...uint16) []byte {
+	glyph := make([]byte, 10)
+	binary.BigEndian.PutUint16(glyph, 0xFFFF)
+	for i, gid := range components {
+		var flags uint16
+		if i == 0 {
+			flags = 0x0001 | 0x0008
+		}
+		if i < len(components)-1 {
+			flags |= 0x0020
+		}
+		glyph = binary.BigEndian.AppendUint16(glyph, flags)
+		glyph = binary.BigEndian.AppendUint16(glyph, gid)
+		if i == 0 {
+			glyph = append(glyph, 0, 0, 0, 0, 0x40, 0)
+		} else {
+			glyph = append(glyph, 0, 0)
+		}
+	}
+	return glyph
+}
+
+func TestCompositeComponents(t *testing.T) {
+	if got := compositeComponents(compositeGlyph(3, 5, 7)); len(got) != 3 || got[0] != 3 || got[1] != 5 || got[2] != 7 {
+		t.Errorf("components = %v, want [3 5 7]", got)
+	}
+	simple := make([]byte, 12)
+	binary.BigEndian.PutUint16(simple, 1)
+	if got := compositeComponents(simple); got != nil {
+		t.Errorf("simple glyph components = %v", got)
+	}
+	if got := compositeComponents(nil); got != nil {
+		t.Errorf("empty glyph components = %v", got)
+	}
+}
+
+// Шрифт из пяти глифов: 3 - составной из 1 и 4, 4 - составной из 2
+func TestFontSubsetComposite(t *testing.T) {
+	simple := func(marker byte) []byte {
+		glyph := make([]byte, 12)
+		binary.BigEndian.PutUint16(glyph, 1)
+		glyph[11] = marker
+		return glyph
+	}
+	glyphs := [][]byte{simple(0xA0), simple(0xA1), simple(0xA2), compositeGlyph(1, 4), compositeGlyph(2)}
+
+	var glyf []byte
+	loca := make([]byte, 0, 4*(len(glyphs)+1))
+	for _, glyph := range glyphs {
+		loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyf)))
+		glyf = append(glyf, glyph...)
+		for len(glyf)%4 != 0 {
+			glyf = append(glyf, 0)
+		}
+	}
+	loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyf)))
+
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	maxp := make([]byte, 6)
+	binary.BigEndian.PutUint16(maxp[4:], uint16(len(glyphs)))
+	font := &Font{tables: map[string][]byte{
+		"head": head,
+		"hhea": make([]byte, 36),
+		"maxp": maxp,
+		"hmtx": make([]byte, 4*len(glyphs)),
+		"loca": loca,
+		"glyf": glyf,
+	}}
+
+	data, err := font.subset(map[uint16]rune{3: 'Й'})
+	if err != nil {
+		t.Fatalf("subset: %v", err)
+	}
+	tables := fontTables(t, data)
+	for gid, kept := range []bool{true, true, true, true, true} {
+		if got := len(glyphData(tables, gid)) > 0; got != kept {
+			t.Errorf("glyph %d kept = %v, want %v", gid, got, kept)
+		}
+	}
+
+	data, err = font.subset(map[uint16]rune{1: 'А'})
+	if err != nil {
+		t.Fatalf("subset: %v", err)
+	}
+	tables = fontTables(t, data)
+	for gid, kept := range []bool{true, true, false, false, false} {
+		if got := len(glyphData(tables, gid)) > 0; got != kept {
+			t.Errorf("glyph %d kept = %v, want %v", gid, got, kept)
+		}
+	}
+}
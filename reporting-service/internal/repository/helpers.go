@@ -1,5 +1,10 @@
 package repository
 
+import (
+	"strings"
+	"time"
+)
+
 func SliceConatinsString(slice []string, item string) bool {
 	for _, v := range slice {
         if v == item {
@@ -7,4 +12,26 @@ func SliceConatinsString(slice []string, item string) bool {
         }
     }
     return false
-}
\ No newline at end of file
+}
+
+// Только цифры телефона, чтобы искать независимо от формата записи
+func PhoneDigits(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Отчеты фильтруют по дням, и последний день периода входит в отчет целиком:
+// начало сравнивается через ">=" с ReportDateFrom, конец через "<" с ReportDateUntil
+func ReportDateFrom(start time.Time) string {
+	return start.Format("2006-01-02")
+}
+
+// Следующий за концом периода день
+func ReportDateUntil(end time.Time) string {
+	return end.AddDate(0, 0, 1).Format("2006-01-02")
+}
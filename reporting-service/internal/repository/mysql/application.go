@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"reporting-service/internal/domain"
+)
+
+type applicationDetailRow struct {
+	domain.Application
+	ContactsID      int64           `db:"contact_id"`
+	PassportAddress string          `db:"passport_address"`
+	HouseID         int64           `db:"house_id"`
+	DealID          sql.NullInt64   `db:"deal_id"`
+	DealDate        sql.NullTime    `db:"deal_date"`
+	DealSum         sql.NullFloat64 `db:"deal_sum"`
+	SellID          sql.NullInt64   `db:"sell_id"`
+	SellStatusName  sql.NullString  `db:"sell_status_name"`
+}
+
+func (r *MySQLAudienceRepository) GetApplicationDetail(ctx context.Context, id int64) (*domain.ApplicationDetailResponse, error) {
+	query := `
+		SELECT
+			eb.id AS id,
+			eb.date_added AS date_added,
+			COALESCE(eb.updated_at, eb.date_added) AS updated_at,
+			COALESCE(eb.status, 0) AS status,
+			COALESCE(eb.status_name, '') AS status_name,
+			COALESCE(eb.status_reason_id, -1) AS status_reason_id,
+			COALESCE(ebrs.name, '') AS name,
+			COALESCE(eb.manager_id, 0) AS manager_id,
+			COALESCE(u.users_name, 'Не назначен') AS manager_name,
+			COALESCE(eb.contacts_id, 0) AS contact_id,
+			COALESCE(edc.contacts_buy_name, 'Не указано') AS client_name,
+			COALESCE(edc.contacts_buy_phones, 'Не указано') AS phone,
+			COALESCE(edc.passport_address, '') AS passport_address,
+			` + regionExpression + ` AS region,
+			COALESCE(eb.category, 'Не указано') AS property_type,
+			COALESCE(eb.house_id, 0) AS house_id,
+			COALESCE(h.complex_name, 'Не указано') AS project_name,
+			` + daysInStatusExpression + ` AS days_in_status,
+			ed.id AS deal_id,
+			ed.deal_date AS deal_date,
+			ed.deal_sum AS deal_sum,
+			es.id AS sell_id,
+			es.estate_sell_status_name AS sell_status_name
+		FROM estate_buys eb
+		LEFT JOIN estate_deals_contacts edc ON edc.id = eb.contacts_id
+		LEFT JOIN users u ON u.id = eb.manager_id
+		LEFT JOIN estate_houses h ON h.id = eb.house_id
+		LEFT JOIN estate_sells es ON es.id = eb.estate_sell_id
+		LEFT JOIN estate_deals ed ON eb.deal_id = ed.id
+		LEFT JOIN estate_statuses_reasons ebrs ON ebrs.status_reason_id = eb.status_reason_id
+		WHERE eb.company_id = 528 AND eb.id = ?`
+
+	var row applicationDetailRow
+	if err := r.db.GetContext(ctx, &row, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("application %d: %w", id, domain.ErrNotFound)
+		}
+		return nil, fmt.Errorf("select application: %w", err)
+	}
+
+	detail := &domain.ApplicationDetailResponse{
+		Application: row.Application,
+		Contact: domain.ApplicationContact{
+			ID:              row.ContactsID,
+			Name:            row.ClientName,
+			Phone:           row.Phone,
+			PassportAddress: row.PassportAddress,
+			Region:          row.RegionName,
+		},
+		Manager: domain.ApplicationManager{
+			ID:   row.ManagerID,
+			Name: row.ManagerName,
+		},
+		Project: domain.ApplicationProject{
+			HouseID:     row.HouseID,
+			ProjectName: row.ProjectName,
+		},
+	}
+	if row.DealID.Valid {
+		detail.Deal = &domain.ApplicationDeal{ID: row.DealID.Int64, Sum: row.DealSum.Float64}
+		if row.DealDate.Valid {
+			detail.Deal.Date = &row.DealDate.Time
+		}
+	}
+	if row.SellID.Valid {
+		detail.Sell = &domain.ApplicationSell{ID: row.SellID.Int64, StatusName: row.SellStatusName.String}
+	}
+
+	return detail, nil
+}
+
+// История статусов заявки. Время в статусе - до следующего перехода, для текущего статуса - до сейчас
+func (r *MySQLAudienceRepository) GetStatusTimeline(ctx context.Context, id int64) ([]domain.StatusTimelineEntry, error) {
+	query := `
+		SELECT
+			id,
+			log_date,
+			left_at,
+			status_from_name,
+			status_to_name,
+			status_custom_to_name,
+			users_id,
+			user_name,
+			TIMESTAMPDIFF(SECOND, log_date, COALESCE(left_at, NOW())) AS duration_seconds,
+			TIMESTAMPDIFF(DAY, log_date, COALESCE(left_at, NOW())) AS duration_days
+		FROM (
+			SELECT
+				sl.id,
+				sl.log_date,
+				LEAD(sl.log_date) OVER (ORDER BY sl.log_date, sl.id) AS left_at,
+				COALESCE(sl.status_from_name, '') AS status_from_name,
+				COALESCE(sl.status_to_name, '') AS status_to_name,
+				COALESCE(sl.status_custom_to_name, '') AS status_custom_to_name,
+				COALESCE(sl.users_id, 0) AS users_id,
+				COALESCE(u.users_name, 'Не указано') AS user_name
+			FROM estate_buys_statuses_log sl
+			LEFT JOIN users u ON u.id = sl.users_id
+			WHERE sl.estate_buy_id = ?
+		) timeline
+		ORDER BY log_date, id`
+
+	timeline := []domain.StatusTimelineEntry{}
+	if err := r.db.SelectContext(ctx, &timeline, query, id); err != nil {
+		return nil, fmt.Errorf("select status timeline: %w", err)
+	}
+
+	return timeline, nil
+}
@@ -3,26 +3,26 @@ package mysql
 import (
 	"context"
 	//"database/sql"
+	"encoding/base64"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 	"math"
 	"reporting-service/internal/domain"
-	//"strconv"
+	"reporting-service/internal/repository"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Предел подсчета в режиме count=estimate
+const estimateCountLimit = 10000
+
 type MySQLAudienceRepository struct {
 	db     *sqlx.DB
 	logger *zap.Logger
 }
 
-type ValidationError struct {
-	Field string
-	Error string
-}
-
 func NewMySQLAudienceRepository(db *sqlx.DB, logger *zap.Logger) *MySQLAudienceRepository {
 	return &MySQLAudienceRepository{
 		db:     db,
@@ -30,37 +30,7 @@ func NewMySQLAudienceRepository(db *sqlx.DB, logger *zap.Logger) *MySQLAudienceR
 	}
 }
 
-func validateAudienceFilter(filter domain.AudienceCreationFilter) []ValidationError {
-	var errors []ValidationError
-
-	// Date range validation
-	if filter.StartDate != nil && filter.EndDate != nil {
-		if filter.StartDate.After(*filter.EndDate) {
-			errors = append(errors, ValidationError{
-				Field: "date_range",
-				Error: "start date must be before end date",
-			})
-		}
-		if filter.EndDate.Sub(*filter.StartDate).Hours() > 8784 {
-			errors = append(errors, ValidationError{
-				Field: "date_range",
-				Error: "date range must be less than 366 days (8784 hours)",
-			})
-
-		}
-	}
-
-	if filter.StartDate == nil {
-		errors = append(errors, ValidationError{
-			Field: "filter",
-			Error: "start date field required",
-		})
-	}
-
-	return errors
-}
-
-func (r *MySQLAudienceRepository) GetFilters(ctx context.Context) (domain.ApplicationFilterResponce, error) {
+func (r *MySQLAudienceRepository) GetFilters(ctx context.Context, nonTarget domain.FunnelStage) (domain.ApplicationFilterResponce, error) {
 	var filter domain.ApplicationFilterResponce
 
 	query := `SELECT Distinct status_name FROM macro_bi_cmp_528.estate_buys`
@@ -90,21 +60,14 @@ func (r *MySQLAudienceRepository) GetFilters(ctx context.Context) (domain.Applic
 	if err := r.db.SelectContext(ctx, &filter.RegionNames, query); err != nil {
 		return domain.ApplicationFilterResponce{}, fmt.Errorf("select filters: %w", err)
 	}
+	var err error
+	if filter.RejectionReasons, filter.NonTargetReasons, err = r.ListReasonCategories(ctx, nonTarget); err != nil {
+		return domain.ApplicationFilterResponce{}, fmt.Errorf("select filters: %w", err)
+	}
 	return filter, nil
 }
 
-func (r *MySQLAudienceRepository) GetApplicationsByAudienceFilter(ctx context.Context, filter domain.AudienceCreationFilter) ([]domain.Application, error) {
-	// Validate filter
-	errs := validateAudienceFilter(filter)
-	errs_string := ""
-	for _, err := range errs {
-		errs_string += fmt.Sprintf("%s: %s\n", err.Field, err.Error)
-	}
-
-	if errs != nil {
-		return nil, fmt.Errorf("invalid filters: %v", errs_string)
-	}
-
+func (r *MySQLAudienceRepository) GetApplicationsByAudienceFilter(ctx context.Context, filter domain.AudienceCreationFilter, nonTarget domain.FunnelStage) ([]domain.Application, error) {
 	// Build query
 	query := `
         SELECT 
@@ -124,27 +87,8 @@ func (r *MySQLAudienceRepository) GetApplicationsByAudienceFilter(ctx context.Co
 
 	args := map[string]interface{}{}
 
-	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		query += " AND eb.date_added <= :creation_date_to"
-		args["creation_date_to"] = filter.EndDate
-	}
-
-	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		query += " AND eb.date_added >= :creation_date_from"
-		args["creation_date_from"] = filter.StartDate
-	}
-
-	// Add status filter
-	if len(filter.StatusNames) > 0 {
-		query += " AND eb.status_name IN (:status_names)"
-		args["status_names"] = filter.StatusNames
-	}
-
-	// Add reason filters
-	if len(filter.RegectionReasonNames) > 0 || len(filter.NonTargetReasonNames) > 0 {
-		reasons := append(filter.RegectionReasonNames, filter.NonTargetReasonNames...)
-		query += " AND ebrs.name IN (:reason_names)"
-		args["reason_names"] = reasons
+	if conditions := audienceFilterConditions(&filter, nonTarget, args); len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
 	}
 
 	// Execute query
@@ -169,7 +113,441 @@ func (r *MySQLAudienceRepository) GetApplicationsByAudienceFilter(ctx context.Co
 	return results, nil
 }
 
-func (r *MySQLAudienceRepository) GetNewApplicationsByAudience(ctx context.Context, audience *domain.Audience, apllication_ids []int64) ([]domain.Application, error) {
+func (r *MySQLAudienceRepository) CountApplicationsByAudienceFilter(ctx context.Context, filter domain.AudienceCreationFilter, nonTarget domain.FunnelStage) (int, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM estate_buys eb LEFT JOIN estate_statuses_reasons ebrs
+        ON ebrs.status_reason_id=eb.status_reason_id
+		WHERE 1=1
+		`
+
+	args := map[string]interface{}{}
+
+	if conditions := audienceFilterConditions(&filter, nonTarget, args); len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+
+	query, params, err := sqlx.Named(query, args)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bind named params: %w", err)
+	}
+
+	query, params, err = sqlx.In(query, params...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand IN clause: %w", err)
+	}
+
+	var count int
+	if err := r.db.GetContext(ctx, &count, r.db.Rebind(query), params...); err != nil {
+		return 0, fmt.Errorf("count applications: %w", err)
+	}
+
+	return count, nil
+}
+
+// Условия отбора заявок по фильтру аудитории; запрос должен содержать eb и ebrs.
+// Нецелевые заявки отличаются от отказов по этапу нецелевых воронки колл-центра
+func audienceFilterConditions(filter *domain.AudienceCreationFilter, nonTarget domain.FunnelStage, args map[string]interface{}) []string {
+	conditions := []string{}
+
+	if filter.StartDate != nil && !filter.StartDate.IsZero() {
+		conditions = append(conditions, "eb.date_added >= :creation_date_from")
+		args["creation_date_from"] = filter.StartDate
+	}
+
+	if filter.EndDate != nil && !filter.EndDate.IsZero() {
+		conditions = append(conditions, "eb.date_added <= :creation_date_to")
+		args["creation_date_to"] = filter.EndDate
+	}
+
+	// Add status filter: идентификаторы приоритетнее названий, названия остаются для старых фильтров
+	if len(filter.StatusIDs) > 0 {
+		conditions = append(conditions, "eb.status IN (:status_ids)")
+		args["status_ids"] = filter.StatusIDs
+	} else if len(filter.StatusNames) > 0 {
+		conditions = append(conditions, "eb.status_name IN (:status_names)")
+		args["status_names"] = filter.StatusNames
+	}
+
+	// Add reason filters: причины отказа и нецелевые причины проверяются отдельно и объединяются через OR
+	reasons := reasonCategoryConditions("audience_", filter.RejectionReasonIDs, filter.NonTargetReasonIDs, nonTarget, args)
+	if len(filter.RejectionReasonIDs) == 0 && len(filter.RegectionReasonNames) > 0 {
+		reasons = append(reasons, "(ebrs.name IN (:rejection_reason_names) AND NOT "+currentStageCondition("audience_non_target", nonTarget, args)+")")
+		args["rejection_reason_names"] = filter.RegectionReasonNames
+	}
+	if len(filter.NonTargetReasonIDs) == 0 && len(filter.NonTargetReasonNames) > 0 {
+		reasons = append(reasons, "(ebrs.name IN (:non_target_reason_names) AND "+currentStageCondition("audience_non_target", nonTarget, args)+")")
+		args["non_target_reason_names"] = filter.NonTargetReasonNames
+	}
+	if len(filter.ReasonIDs) > 0 {
+		reasons = append(reasons, "eb.status_reason_id IN (:reason_ids)")
+		args["reason_ids"] = filter.ReasonIDs
+	}
+	if len(reasons) > 0 {
+		conditions = append(conditions, "("+strings.Join(reasons, " OR ")+")")
+	}
+
+	return conditions
+}
+
+// Регион клиента, извлеченный из адреса прописки
+const regionExpression = `coalesce(TRIM(LOWER(REGEXP_REPLACE(REGEXP_SUBSTR(
+                    edc.passport_address,
+                    '((г\\.|город )\\s*([^,\\s\\.]+))|(([^,\\s\\.]+)\\s(shah|shax|Ш(и|а)\\SРИ|ша\\Sар|город,|ш\\.))'
+                ),
+                '(г\\.|город\\s|\\sshah|\\sshax|\\sШ(и|а)\\SРИ|\\sша\\Sар|\\sгород,|\\sш\\.)', ''
+            ))), "Не указано")`
+
+// Дней в текущем статусе: от последнего перехода в статус или от создания заявки
+const daysInStatusExpression = `DATEDIFF(NOW(), COALESCE(
+			(SELECT MAX(log_date) 
+			FROM estate_buys_statuses_log 
+			WHERE estate_buy_id = eb.id 
+			AND status_to = eb.status),
+			eb.date_added
+		))`
+
+// Прежние названия полей сортировки, которые еще присылает фронт
+var applicationSortAliases = map[string]string{
+	"created_date": "created_at",
+	"client_name":  "name",
+	"status":       "status_name",
+	"manager":      "manager_name",
+	"property":     "property_type",
+}
+
+// ORDER BY по списку полей через запятую: order_field=status_name,created_at&order_direction=asc,desc.
+// Если направлений меньше чем полей, для остальных берется последнее указанное.
+// Второе значение - направление сортировки по дате для курсора, пусто если курсор неприменим
+func applicationOrderClause(filter *domain.ApplicationFilterRequest) (string, string, error) {
+	if strings.TrimSpace(filter.OrderField) == "" {
+		return " ORDER BY eb.date_added DESC, eb.id DESC", "DESC", nil
+	}
+
+	fields := strings.Split(filter.OrderField, ",")
+	directions := []string{}
+	if strings.TrimSpace(filter.OrderDirection) != "" {
+		directions = strings.Split(filter.OrderDirection, ",")
+	}
+	if len(directions) > len(fields) {
+		return "", "", domain.NewValidationError("order_direction", domain.ValidationCodeInvalidValue,
+			"more sort directions than sort fields")
+	}
+
+	orderBy := make([]string, 0, len(fields)+1)
+	used := map[string]bool{}
+	direction := "DESC"
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		if alias, ok := applicationSortAliases[field]; ok {
+			field = alias
+		}
+		column, ok := findApplicationColumn(field)
+		if !ok || column.sort == "" {
+			return "", "", domain.NewValidationError("order_field", domain.ValidationCodeInvalidValue,
+				fmt.Sprintf("unknown sort field %q", field))
+		}
+		if used[field] {
+			return "", "", domain.NewValidationError("order_field", domain.ValidationCodeInvalidValue,
+				fmt.Sprintf("sort field %q is repeated", field))
+		}
+		used[field] = true
+
+		if i < len(directions) {
+			switch strings.ToUpper(strings.TrimSpace(directions[i])) {
+			case "ASC":
+				direction = "ASC"
+			case "DESC":
+				direction = "DESC"
+			default:
+				return "", "", domain.NewValidationError("order_direction", domain.ValidationCodeInvalidValue,
+					fmt.Sprintf("unknown sort direction %q", directions[i]))
+			}
+		}
+		orderBy = append(orderBy, column.sort+" "+direction)
+	}
+
+	// id в конце делает порядок однозначным
+	if !used["id"] {
+		orderBy = append(orderBy, "eb.id "+direction)
+	}
+
+	keysetDirection := ""
+	if len(fields) == 1 && used["created_at"] {
+		keysetDirection = direction
+	}
+	return " ORDER BY " + strings.Join(orderBy, ", "), keysetDirection, nil
+}
+
+// Проверка сортировки без построения запроса, например для сохраненных представлений
+func ValidateApplicationOrder(filter *domain.ApplicationFilterRequest) error {
+	_, _, err := applicationOrderClause(filter)
+	return err
+}
+
+// Условия фильтров списка заявок, общие для списка и выгрузки.
+// Нужные соединения подбираются по псевдонимам в условиях, см. applicationJoinClause
+func applicationFilterConditions(filter *domain.ApplicationFilterRequest, nonTarget domain.FunnelStage, args map[string]interface{}) []string {
+	conditions := []string{}
+	conditions = append(conditions, includeExcludeConditions("eb.status_name", "status", filter.Statuses, filter.ExcludedStatuses, args)...)
+	conditions = append(conditions, includeExcludeConditions("h.complex_name", "project_name", filter.ProjectNames, filter.ExcludedProjectNames, args)...)
+	conditions = append(conditions, includeExcludeConditions("eb.category", "property_type", filter.PropertyTypes, filter.ExcludedPropertyTypes, args)...)
+	conditions = append(conditions, includeExcludeConditions(regionExpression, "region_name", filter.RegionNames, filter.ExcludedRegionNames, args)...)
+	conditions = append(conditions, includeExcludeConditions("eb.manager_id", "manager_id", filter.ManagerIDs, filter.ExcludedManagerIDs, args)...)
+	conditions = append(conditions, includeExcludeConditions("u.users_name", "manager_name", filter.ManagerNames, filter.ExcludedManagerNames, args)...)
+
+	if filter.DaysInStatusMin != nil {
+		conditions = append(conditions, daysInStatusExpression+" >= :days_in_status_min")
+		args["days_in_status_min"] = *filter.DaysInStatusMin
+	}
+
+	if filter.DaysInStatusMax != nil {
+		conditions = append(conditions, daysInStatusExpression+" <= :days_in_status_max")
+		args["days_in_status_max"] = *filter.DaysInStatusMax
+	}
+
+	if filter.StartDate != nil && !filter.StartDate.IsZero() {
+		conditions = append(conditions, "eb.date_added >= :created_at_from")
+		args["created_at_from"] = filter.StartDate
+	}
+
+	if filter.EndDate != nil && !filter.EndDate.IsZero() {
+		conditions = append(conditions, "eb.date_added <= :created_at_to")
+		args["created_at_to"] = filter.EndDate
+	}
+
+	if reasons := reasonCategoryConditions("filter_", filter.RejectionReasonIDs, filter.NonTargetReasonIDs, nonTarget, args); len(reasons) > 0 {
+		conditions = append(conditions, "("+strings.Join(reasons, " OR ")+")")
+	}
+
+	// Поиск выполняется заранее по индексу в Postgres, сюда приходят найденные id
+	if filter.Query != "" {
+		if len(filter.SearchIDs) == 0 {
+			conditions = append(conditions, "1 = 0")
+		} else {
+			conditions = append(conditions, "eb.id IN (:search_ids)")
+			args["search_ids"] = filter.SearchIDs
+		}
+	}
+
+	return conditions
+}
+
+// Заявки, измененные после указанной позиции, для поискового индекса. Отбор идет по самим
+// колонкам updated_at и date_added, чтобы работали их индексы, точная позиция проверяется поверх него
+func (r *MySQLAudienceRepository) ListApplicationsForSearch(ctx context.Context, since time.Time, afterID int64, limit int) ([]domain.ApplicationSearchEntry, error) {
+	query := `
+		SELECT
+			eb.id AS application_id,
+			COALESCE(edc.contacts_buy_name, '') AS client_name,
+			COALESCE(edc.contacts_buy_phones, '') AS phone,
+			COALESCE(eb.updated_at, eb.date_added) AS updated_at
+		FROM estate_buys eb
+		LEFT JOIN estate_deals_contacts edc ON edc.id = eb.contacts_id
+		WHERE eb.company_id = 528
+			AND (eb.updated_at >= ? OR (eb.updated_at IS NULL AND eb.date_added >= ?))
+			AND (COALESCE(eb.updated_at, eb.date_added) > ?
+				OR (COALESCE(eb.updated_at, eb.date_added) = ? AND eb.id > ?))
+		ORDER BY updated_at, eb.id
+		LIMIT ?`
+
+	var entries []domain.ApplicationSearchEntry
+	if err := r.db.SelectContext(ctx, &entries, query, since, since, since, since, afterID, limit); err != nil {
+		return nil, fmt.Errorf("select applications for search: %w", err)
+	}
+	return entries, nil
+}
+
+// Включение и исключение значений. Записи с пустым значением исключением не отбрасываются
+func includeExcludeConditions[T any](column, name string, include, exclude []T, args map[string]interface{}) []string {
+	conditions := []string{}
+	if len(include) > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s IN (:%s)", column, name))
+		args[name] = include
+	}
+	if len(exclude) > 0 {
+		conditions = append(conditions, fmt.Sprintf("(%[1]s IS NULL OR %[1]s NOT IN (:%[2]s_excluded))", column, name))
+		args[name+"_excluded"] = exclude
+	}
+	return conditions
+}
+
+// Условия по категориям причин. Префикс разделяет аргументы фильтра аудитории и фильтра списка
+func reasonCategoryConditions(prefix string, rejectionIDs, nonTargetIDs []int64, nonTarget domain.FunnelStage, args map[string]interface{}) []string {
+	conditions := []string{}
+	if len(rejectionIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("(eb.status_reason_id IN (:%srejection_reason_ids) AND NOT %s)",
+			prefix, currentStageCondition(prefix+"non_target", nonTarget, args)))
+		args[prefix+"rejection_reason_ids"] = rejectionIDs
+	}
+	if len(nonTargetIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("(eb.status_reason_id IN (:%snon_target_reason_ids) AND %s)",
+			prefix, currentStageCondition(prefix+"non_target", nonTarget, args)))
+		args[prefix+"non_target_reason_ids"] = nonTargetIDs
+	}
+	return conditions
+}
+
+// Заявка сейчас в одном из статусов этапа воронки. У этапа без статусов условие ложно:
+// например, без этапа нецелевых все причины считаются причинами отказа
+func currentStageCondition(key string, stage domain.FunnelStage, args map[string]interface{}) string {
+	var conditions []string
+	if len(stage.StatusIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("eb.status IN (:%s_status_ids)", key))
+		args[key+"_status_ids"] = stage.StatusIDs
+	}
+	if len(stage.StatusNames) > 0 {
+		conditions = append(conditions, fmt.Sprintf("eb.status_name IN (:%s_status_names)", key))
+		args[key+"_status_names"] = stage.StatusNames
+	}
+	if len(conditions) == 0 {
+		return "(1=0)"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// Текущее название статуса берется из последней заявки с этим статусом
+func (r *MySQLAudienceRepository) ListStatuses(ctx context.Context) ([]domain.DictionaryItem, error) {
+	var statuses []domain.DictionaryItem
+	query := `
+		SELECT 
+			eb.status AS id,
+			eb.status_name AS name
+		FROM estate_buys eb
+		JOIN (
+			SELECT status, MAX(id) AS last_id
+			FROM estate_buys
+			WHERE company_id = 528
+			GROUP BY status
+		) last_eb ON last_eb.last_id = eb.id
+		ORDER BY eb.status`
+	if err := r.db.SelectContext(ctx, &statuses, query); err != nil {
+		return nil, fmt.Errorf("select statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+func (r *MySQLAudienceRepository) ListReasons(ctx context.Context) ([]domain.DictionaryItem, error) {
+	var reasons []domain.DictionaryItem
+	query := `
+		SELECT 
+			status_reason_id AS id,
+			COALESCE(name, '') AS name
+		FROM estate_statuses_reasons
+		ORDER BY name`
+	if err := r.db.SelectContext(ctx, &reasons, query); err != nil {
+		return nil, fmt.Errorf("select reasons: %w", err)
+	}
+	return reasons, nil
+}
+
+// Причины отказа и нецелевые причины одним проходом по заявкам: категорию определяет
+// текущий статус заявки, одна причина может попасть в обе категории
+func (r *MySQLAudienceRepository) ListReasonCategories(ctx context.Context, nonTarget domain.FunnelStage) (rejection, nonTargetReasons []domain.DictionaryItem, err error) {
+	args := map[string]interface{}{}
+	query, params, err := sqlx.Named(`
+		SELECT DISTINCT
+			ebrs.status_reason_id AS id,
+			COALESCE(ebrs.name, '') AS name,
+			`+currentStageCondition("non_target", nonTarget, args)+` AS non_target
+		FROM estate_statuses_reasons ebrs
+		JOIN estate_buys eb ON eb.status_reason_id = ebrs.status_reason_id
+		WHERE eb.company_id = 528
+		ORDER BY name`, args)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to bind named params: %w", err)
+	}
+	query, params, err = sqlx.In(query, params...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to expand IN clause: %w", err)
+	}
+
+	var rows []struct {
+		domain.DictionaryItem
+		NonTarget *bool `db:"non_target"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), params...); err != nil {
+		return nil, nil, fmt.Errorf("select reasons by category: %w", err)
+	}
+
+	rejection = []domain.DictionaryItem{}
+	nonTargetReasons = []domain.DictionaryItem{}
+	for _, row := range rows {
+		// NULL в условии: заявка не попадает ни в одну категорию, как и в фильтре
+		if row.NonTarget == nil {
+			continue
+		}
+		if *row.NonTarget {
+			nonTargetReasons = append(nonTargetReasons, row.DictionaryItem)
+		} else {
+			rejection = append(rejection, row.DictionaryItem)
+		}
+	}
+	return rejection, nonTargetReasons, nil
+}
+
+// Идентификаторы статусов по названиям: из заявок и истории статусов компании, чтобы находились
+// и статусы, в которых сейчас нет ни одной заявки
+func (r *MySQLAudienceRepository) ResolveStatusIDs(ctx context.Context, names []string) ([]domain.DictionaryItem, error) {
+	return r.selectItemsByNames(ctx, `
+		SELECT status AS id, status_name AS name
+		FROM estate_buys
+		WHERE company_id = 528 AND status_name IN (:names)
+		UNION
+		SELECT status_to AS id, status_custom_to_name AS name
+		FROM estate_buys_statuses_log
+		WHERE company_id = 528 AND status_custom_to_name IN (:names)`, names)
+}
+
+// Идентификаторы причин по названиям из справочника причин. Категория здесь не учитывается:
+// фильтр проверяет ее по статусу заявки, см. reasonCategoryConditions
+func (r *MySQLAudienceRepository) ResolveReasonIDs(ctx context.Context, names []string) ([]domain.DictionaryItem, error) {
+	return r.selectItemsByNames(ctx, `
+		SELECT status_reason_id AS id, name
+		FROM estate_statuses_reasons
+		WHERE name IN (:names)`, names)
+}
+
+func (r *MySQLAudienceRepository) selectItemsByNames(ctx context.Context, query string, names []string) ([]domain.DictionaryItem, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	query, params, err := sqlx.Named(query, map[string]interface{}{"names": names})
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind named params: %w", err)
+	}
+	query, params, err = sqlx.In(query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand IN clause: %w", err)
+	}
+
+	var items []domain.DictionaryItem
+	if err := r.db.SelectContext(ctx, &items, r.db.Rebind(query), params...); err != nil {
+		return nil, fmt.Errorf("select ids by names: %w", err)
+	}
+	return items, nil
+}
+
+func (r *MySQLAudienceRepository) ListStatusNames(ctx context.Context) ([]string, error) {
+	var names []string
+	query := `SELECT DISTINCT status_name FROM estate_buys WHERE status_name IS NOT NULL`
+	if err := r.db.SelectContext(ctx, &names, query); err != nil {
+		return nil, fmt.Errorf("select status names: %w", err)
+	}
+	return names, nil
+}
+
+func (r *MySQLAudienceRepository) ListReasonNames(ctx context.Context) ([]string, error) {
+	var names []string
+	query := `SELECT DISTINCT name FROM estate_statuses_reasons WHERE name IS NOT NULL`
+	if err := r.db.SelectContext(ctx, &names, query); err != nil {
+		return nil, fmt.Errorf("select reason names: %w", err)
+	}
+	return names, nil
+}
+
+func (r *MySQLAudienceRepository) GetNewApplicationsByAudience(ctx context.Context, audience *domain.Audience, apllication_ids []int64, nonTarget domain.FunnelStage) ([]domain.Application, error) {
 	// Build query
 	query := `
         SELECT 
@@ -189,37 +567,14 @@ func (r *MySQLAudienceRepository) GetNewApplicationsByAudience(ctx context.Conte
 
 	args := map[string]interface{}{}
 
-	query += " AND eb.id NOT IN (:apllication_ids)"
-	args["apllication_ids"] = apllication_ids
-
-	// Add date filters
-	if audience.Filter.StartDate != nil && !audience.Filter.StartDate.IsZero() {
-		query += " AND eb.date_added >= :creation_date_from"
-		args["creation_date_from"] = audience.Filter.StartDate
-
-	}
-
-	if audience.Filter.EndDate != nil && !audience.Filter.EndDate.IsZero() {
-		query += " AND eb.date_added <= :creation_date_to"
-		args["creation_date_to"] = audience.Filter.EndDate
-	}
-
-	// Add status filter
-	if len(audience.Filter.StatusNames) > 0 {
-		query += " AND eb.status_name IN (:status_names)"
-		args["status_names"] = audience.Filter.StatusNames
-	}
-
-	// Add reason filters
-	if len(audience.Filter.RegectionReasonNames) > 0 || len(audience.Filter.NonTargetReasonNames) > 0 {
-		reasons := append(audience.Filter.RegectionReasonNames, audience.Filter.NonTargetReasonNames...)
-		query += " AND ebrs.name IN (:reason_names)"
-		args["reason_names"] = reasons
+	// В пустой аудитории новыми считаются все подходящие заявки; пустой NOT IN sqlx.In не раскрывает
+	if len(apllication_ids) > 0 {
+		query += " AND eb.id NOT IN (:apllication_ids)"
+		args["apllication_ids"] = apllication_ids
 	}
 
-	// Get the latest creation date of all applications in the audience
-	if len(audience.Applications) == 0 {
-		return nil, fmt.Errorf("audience has no applications")
+	if conditions := audienceFilterConditions(&audience.Filter, nonTarget, args); len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
 	}
 
 	// Execute query
@@ -244,24 +599,29 @@ func (r *MySQLAudienceRepository) GetNewApplicationsByAudience(ctx context.Conte
 	return results, nil
 }
 
-func (r *MySQLAudienceRepository) GetChangedApplicationIds(ctx context.Context, filter *domain.AudienceCreationFilter, application_ids []int64) ([]int64, error) {
-	// Build query
+// Заявки аудитории, которые больше не подходят под ее фильтр: сменили статус или причину
+func (r *MySQLAudienceRepository) GetChangedApplicationIds(ctx context.Context, filter *domain.AudienceCreationFilter, application_ids []int64, nonTarget domain.FunnelStage) ([]int64, error) {
+	if len(application_ids) == 0 {
+		return nil, nil
+	}
+
 	args := map[string]interface{}{}
+	conditions := audienceFilterConditions(filter, nonTarget, args)
+	if len(conditions) == 0 {
+		// Под пустой фильтр подходят все заявки, из аудитории ничего не выпадает
+		return nil, nil
+	}
 
+	// Заявка с пустой причиной или статусом дает NULL в условии и тоже считается выпавшей
 	query := `
 		SELECT 
 			eb.id
 		FROM estate_buys eb LEFT JOIN estate_statuses_reasons ebrs
 		ON ebrs.status_reason_id=eb.status_reason_id
-		WHERE eb.id IN (:apllication_ids) AND NOT eb.status_name IN (:status_names)
+		WHERE eb.id IN (:apllication_ids)
+			AND NOT COALESCE((` + strings.Join(conditions, " AND ") + `), FALSE)
 		`
 	args["apllication_ids"] = application_ids
-	args["status_names"] = filter.StatusNames
-	r.logger.Info("args", zap.Any("statuses:", filter.StatusNames))
-
-	if application_ids == nil {
-		return nil, fmt.Errorf("application_ids is nil")
-	}
 
 	// Execute query
 	query, params, err := sqlx.Named(query, args)
@@ -327,105 +687,18 @@ func (r *MySQLAudienceRepository) ListApplicationsByIds(ctx context.Context, app
 	return results, nil
 }
 
-func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Context, pagination *domain.PaginationRequest, filter *domain.ApplicationFilterRequest, audience_filter *domain.AudienceCreationFilter) (*domain.PaginationResponse, error) {
-	// Build base query for counting
-	countQuery := `
-        SELECT COUNT(*) 
-        FROM estate_buys eb
-        LEFT JOIN estate_houses h ON h.id = eb.house_id
-		LEFT JOIN estate_deals_contacts edc ON edc.id = eb.contacts_id
-        LEFT JOIN users u ON u.id = eb.manager_id
-		LEFT JOIN estate_sells es ON es.id = eb.estate_sell_id
-		LEFT JOIN estate_deals ed ON eb.deal_id = ed.id
-        WHERE eb.company_id = 528
-    `
+func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Context, pagination *domain.PaginationRequest, filter *domain.ApplicationFilterRequest, audience_filter *domain.AudienceCreationFilter, nonTarget domain.FunnelStage) (*domain.PaginationResponse, error) {
+	columns, err := selectApplicationColumns(filter.Columns, defaultListColumns())
+	if err != nil {
+		return nil, err
+	}
+
 	// Build where conditions and args map
 	args := map[string]interface{}{}
 	// Get total count
 	var totalItems int64
 
-	// Prepare main query
-	query := `
-    SELECT 
-            eb.id AS id,
-            eb.date_added AS date_added,
-            COALESCE(edc.contacts_buy_name, 'Не указано') AS client_name,
-            eb.status_name AS status_name,
-            COALESCE(edc.contacts_buy_phones, 'Не указано') AS phone,
-            COALESCE(u.users_name, 'Не назначен') AS manager_name,
-            COALESCE(eb.category, "Не указано") AS property_type,
-			coalesce(TRIM(LOWER(REGEXP_REPLACE(REGEXP_SUBSTR(
-                    passport_address,
-                    '((г\\.|город )\\s*([^,\\s\\.]+))|(([^,\\s\\.]+)\\s(shah|shax|Ш(и|а)\\SРИ|ша\\Sар|город,|ш\\.))'
-                ),
-                '(г\\.|город\\s|\\sshah|\\sshax|\\sШ(и|а)\\SРИ|\\sша\\Sар|\\sгород,|\\sш\\.)', ''
-            ))), "Не указано")
-    	    AS region,
-            DATEDIFF(NOW(), COALESCE(
-                (SELECT MAX(log_date) 
-                FROM estate_buys_statuses_log 
-                WHERE estate_buy_id = eb.id 
-                AND status_to = eb.status),
-                eb.date_added
-            )) AS days_in_status,
-            COALESCE(h.complex_name, 'Не указано') AS project_name
-        FROM estate_buys eb
-        LEFT JOIN estate_deals_contacts edc ON edc.id = eb.contacts_id
-        LEFT JOIN users u ON u.id = eb.manager_id
-        LEFT JOIN estate_houses h ON h.id = eb.house_id
-		LEFT JOIN estate_sells es ON es.id = eb.estate_sell_id
-		LEFT JOIN estate_deals ed ON eb.deal_id = ed.id
-        WHERE eb.company_id = 528
-    `
-	whereConditions := []string{}
-	if filter.Status != "" {
-		whereConditions = append(whereConditions, "eb.status_name = :status")
-		args["status"] = filter.Status
-	}
-
-	if filter.PropertyType != "" {
-		whereConditions = append(whereConditions, "eb.category = :property_type")
-		args["property_type"] = filter.PropertyType
-	}
-
-	if filter.ProjectName != "" {
-		whereConditions = append(whereConditions, "h.complex_name = :project_name")
-		args["project_name"] = filter.ProjectName
-	}
-
-	if filter.StatusDuration > 0 {
-		whereConditions = append(whereConditions, `
-		DATEDIFF(NOW(), COALESCE(
-			(SELECT MAX(log_date) 
-			FROM estate_buys_statuses_log 
-			WHERE estate_buy_id = eb.id 
-			AND status_to = eb.status),
-			eb.date_added
-		)) >= :days_in_status
-	`)
-		args["days_in_status"] = filter.StatusDuration
-	}
-
-	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		whereConditions = append(whereConditions, "eb.date_added <= :created_at_to")
-		args["created_at_to"] = filter.EndDate
-	}
-
-	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		whereConditions = append(whereConditions, "eb.date_added >= :created_at_from")
-		args["created_at_from"] = filter.StartDate
-	}
-
-	if filter.RegionName != "" {
-		whereConditions = append(whereConditions,
-			`coalesce(TRIM(LOWER(REGEXP_REPLACE(REGEXP_SUBSTR(
-                    edc.passport_address,
-                    '((г\\.|город )\\s*([^,\\s\\.]+))|(([^,\\s\\.]+)\\s(shah|shax|Ш(и|а)\\SРИ|ша\\Sар|город,|ш\\.))'
-                ),
-                '(г\\.|город\\s|\\sshah|\\sshax|\\sШ(и|а)\\SРИ|\\sша\\Sар|\\sгород,|\\sш\\.)', ''
-            ))), "Не указано") = :region_name`)
-		args["region_name"] = filter.RegionName
-	}
+	whereConditions := applicationFilterConditions(filter, nonTarget, args)
 
 	// OMAGAD this is crap code
 	// ids:=strings.Join(filter.AudienceIDs, ", ")
@@ -436,38 +709,17 @@ func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Contex
 	//args["audience_ids"] = ids
 
 	//AUDIENCE filters
-	if audience_filter.StatusNames != nil {
-		whereConditions = append(whereConditions, "eb.status_name IN (:status_names)")
-		args["status_names"] = audience_filter.StatusNames
-	}
-	if audience_filter.StartDate != nil && !audience_filter.StartDate.IsZero() {
-		whereConditions = append(whereConditions, "eb.date_added >= :creation_date_from")
-		args["creation_date_from"] = audience_filter.StartDate
-	}
-	if audience_filter.EndDate != nil && !audience_filter.StartDate.IsZero() {
-		whereConditions = append(whereConditions, "eb.date_added <= :creation_date_to")
-		args["creation_date_to"] = audience_filter.EndDate
-	}
-	if audience_filter.StatusNames != nil {
-		whereConditions = append(whereConditions, "eb.status_name IN (:status_names)")
-		args["status_names"] = audience_filter.StatusNames
-	}
-	if audience_filter.RegectionReasonNames != nil {
-		whereConditions = append(whereConditions, "ebrs.name IN (:reason_names)")
-		args["reason_names"] = audience_filter.RegectionReasonNames
-	}
-	if audience_filter.NonTargetReasonNames != nil {
-		whereConditions = append(whereConditions, "ebrs.name IN (:reason_names)")
-		args["reason_names"] = audience_filter.NonTargetReasonNames
-	}
-
-	if len(whereConditions) > 0 {
-		countQuery += " AND " + strings.Join(whereConditions, " AND ")
-	}
+	whereConditions = append(whereConditions, audienceFilterConditions(audience_filter, nonTarget, args)...)
 
+	countWhere := ""
 	if len(whereConditions) > 0 {
-		query += " AND " + strings.Join(whereConditions, " AND ")
+		countWhere = " AND " + strings.Join(whereConditions, " AND ")
 	}
+	// Для подсчета соединяем только то, что нужно условиям
+	countFrom := `
+        FROM estate_buys eb
+        ` + applicationJoinClause(countWhere) + `
+        WHERE eb.company_id = 528` + countWhere
 
 	// Add pagination
 	if pagination.PageSize <= 0 {
@@ -479,57 +731,81 @@ func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Contex
 
 	offset := (pagination.Page - 1) * pagination.PageSize
 
-	// Define allowed sort fields mapping
-	sortableFields := map[string]string{
-		"id":           "eb.id",
-		"created_date": "eb.date_added",
-		"client_name":  "edc.contacts_buy_name",
-		"status":       "eb.status_name",
-		"phone":        "edc.contacts_buy_phones",
-		"manager":      "u.users_name",
-		"property":     "eb.category",
-	}
-
-	// Build ORDER BY clause
-	orderClause := " ORDER BY eb.date_added DESC" // Default sorting
-
-	if filter.OrderField != "" {
-		dbField, exists := sortableFields[filter.OrderField]
-		if !exists {
-			r.logger.Warn("invalid sort field requested, using default",
-				zap.String("field", filter.OrderField))
-		} else {
-			direction := ""
-			if strings.ToUpper(filter.OrderDirection) == "DESC" {
-				direction = "DESC"
-			} else if strings.ToUpper(filter.OrderDirection) == "ASC" {
-				direction = "ASC"
-			} else {
-				direction = "DESC"
-			}
-			orderClause = fmt.Sprintf(" ORDER BY %s %s", dbField, direction)
-		}
-	}
-
-	countQuery, params, err := sqlx.Named(countQuery, args)
+	orderClause, keysetDirection, err := applicationOrderClause(filter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to bind named params: %w", err)
+		return nil, err
 	}
 
-	countQuery, params, err = sqlx.In(countQuery, params...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to expand IN clause: %w", err)
+	// Курсор заменяет OFFSET: следующая страница начинается сразу после последней записи предыдущей
+	if pagination.Cursor != "" {
+		if keysetDirection == "" {
+			return nil, domain.NewValidationError("cursor", domain.ValidationCodeInvalidValue,
+				"cursor pagination is only supported when sorting by created_at alone")
+		}
+		if pagination.Page > 1 {
+			return nil, domain.NewValidationError("page", domain.ValidationCodeInvalidValue,
+				"page cannot be combined with cursor")
+		}
+		cursorDate, cursorID, err := decodeApplicationCursor(pagination.Cursor)
+		if err != nil {
+			return nil, domain.NewValidationError("cursor", domain.ValidationCodeInvalidValue, err.Error())
+		}
+		operator := "<"
+		if keysetDirection == "ASC" {
+			operator = ">"
+		}
+		whereConditions = append(whereConditions, fmt.Sprintf(
+			"(eb.date_added %[1]s :cursor_date OR (eb.date_added = :cursor_date AND eb.id %[1]s :cursor_id))", operator))
+		args["cursor_date"] = cursorDate
+		args["cursor_id"] = cursorID
+		offset = 0
 	}
 
-	countQuery = r.db.Rebind(countQuery)
+	where := ""
+	if len(whereConditions) > 0 {
+		where = " AND " + strings.Join(whereConditions, " AND ")
+	}
 
-	if err := r.db.GetContext(ctx, &totalItems, countQuery, params...); err != nil {
-		return nil, fmt.Errorf("count applications: %w", err)
+	// Дата создания нужна для курсора, даже если колонка не запрошена
+	selectList := applicationSelectList(columns) + ",\n\t\t\teb.date_added AS cursor_date"
+	query := `
+        SELECT
+            ` + selectList + `
+        FROM estate_buys eb
+        ` + applicationJoinClause(selectList, where, orderClause) + `
+        WHERE eb.company_id = 528` + where
+
+	countMode := pagination.CountMode
+	// Курсор нужен для глубоких списков, где точный подсчет на каждой странице дорог
+	if countMode == "" && pagination.Cursor != "" {
+		countMode = domain.CountModeNone
+	}
+	switch countMode {
+	case domain.CountModeNone:
+	case domain.CountModeEstimate:
+		// Считаем не дальше лимита: на глубоких выборках точное число не нужно, достаточно знать что записей много
+		limitedQuery := "SELECT COUNT(*) FROM (SELECT 1" + countFrom +
+			fmt.Sprintf(" LIMIT %d) limited", estimateCountLimit)
+		count, err := r.countByQuery(ctx, limitedQuery, args)
+		if err != nil {
+			return nil, err
+		}
+		totalItems = count
+		if count < estimateCountLimit {
+			countMode = domain.CountModeExact
+		}
+	default:
+		countMode = domain.CountModeExact
+		count, err := r.countByQuery(ctx, "SELECT COUNT(*)"+countFrom, args)
+		if err != nil {
+			return nil, err
+		}
+		totalItems = count
 	}
 
-	// Apply pagination after sorting
+	// Apply pagination after sorting, лишняя запись показывает, есть ли следующая страница
 	fullQuery := query + orderClause + " LIMIT :limit OFFSET :offset"
-	args["limit"] = pagination.PageSize
+	args["limit"] = pagination.PageSize + 1
 	args["offset"] = offset
 
 	// Debug log
@@ -550,10 +826,37 @@ func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Contex
 
 	query = r.db.Rebind(query)
 
-	var items []domain.Application
-	if err := r.db.SelectContext(ctx, &items, query, queryArgs...); err != nil {
+	rows, err := r.db.QueryxContext(ctx, query, queryArgs...)
+	if err != nil {
 		return nil, fmt.Errorf("select applications: %w", err)
 	}
+	defer rows.Close()
+
+	items := []map[string]interface{}{}
+	for rows.Next() {
+		item, err := scanApplicationRow(rows, columns)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate applications: %w", err)
+	}
+
+	nextCursor := ""
+	if len(items) > pagination.PageSize {
+		items = items[:pagination.PageSize]
+		if keysetDirection != "" {
+			last := items[len(items)-1]
+			cursorDate, _ := last["cursor_date"].(time.Time)
+			cursorID, _ := last["id"].(int64)
+			nextCursor = encodeApplicationCursor(cursorDate, cursorID)
+		}
+	}
+	for _, item := range items {
+		delete(item, "cursor_date")
+	}
 
 	// appls := []domain.Application{}
 
@@ -584,120 +887,14 @@ func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Contex
 	r.logger.Info("audience_ids_count", zap.Any("audience_ids_count", len(filter.AudienceIDs)))
 	r.logger.Info("query", zap.Any("query", query))
 
-	headers := []domain.Header{
-		{
-			Name:          "id",
-			IsID:          true,
-			IsAsideHeader: true,
-			Title:         "ID",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "number",
-			IsSortable:    true,
-		},
-		{
-			Name:          "name",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "ФИО",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "string",
-			IsSortable:    true,
-		},
-		{
-			Name:         "created_at",
-			IsID:         false,
-			Title:        "Дата",
-			IsVisible:    true,
-			IsAdditional: false,
-			Format:       "date",
-			IsSortable:   true,
-		},
-		{
-			Name:          "status_name",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Этап",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "enum",
-			IsSortable:    true,
-		},
-		{
-			Name:          "phone",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Номер телефона",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "string",
-			IsSortable:    true,
-		},
-		{
-			Name:          "manager_name",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Посредник",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "string",
-			IsSortable:    true,
-		},
-		{
-			Name:          "property_type",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Тип недвижимости",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "enum",
-			IsSortable:    true,
-		},
-		{
-			Name:          "project_name",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Проект",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "string",
-		},
-		{
-			Name:          "region",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Регион",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "string",
-		},
-		{
-			Name:          "reason_name",
-			IsID:          false,
-			IsAsideHeader: false,
-			Title:         "Причина статуса",
-			IsVisible:     true,
-			IsAdditional:  false,
-			Format:        "string",
-		},
-	}
+	headers := applicationHeaders(columns)
 
-	//TODO Later: implement method for auto headers generation
-	// t := reflect.TypeOf(items[0])
-
-	// for i := 0; i < t.NumField(); i++ {
-	// 	headers = append(headers, domain.Header{
-	// 		Name:         t.Field(i).Name,
-	// 		IsID:         t.Field(i).Name == "ID",
-	// 		Title:        t.Field(i).Name,
-	// 		IsVisible:    true,
-	// 		IsAdditional: false,
-	// 		Format:       t.Field(i).Type.String(),
-	// 	})
-	// }
-
-	totalPages := int(math.Ceil(float64(totalItems) / float64(pagination.PageSize)))
+	// Число страниц - только для постраничного режима, при курсоре его нет в ответе
+	var totalPages *int
+	if pagination.Cursor == "" {
+		pages := int(math.Ceil(float64(totalItems) / float64(pagination.PageSize)))
+		totalPages = &pages
+	}
 
 	return &domain.PaginationResponse{
 		Headers:    headers,
@@ -706,126 +903,158 @@ func (r *MySQLAudienceRepository) ListApplicationsWithFilters(ctx context.Contex
 		TotalPages: totalPages,
 		Page:       pagination.Page,
 		PageSize:   pagination.PageSize,
+		NextCursor: nextCursor,
+		CountMode:  countMode,
 	}, nil
 }
 
-func (r *MySQLAudienceRepository) ExportApplicationsWithFilters(ctx context.Context, filter *domain.ApplicationFilterRequest) ([]domain.Application, error) {
-	baseQuery := `
-        SELECT 
-            eb.id AS id,
-            eb.date_added AS date_added,
-            COALESCE(edc.contacts_buy_name, 'Не указано') AS client_name,
-            COALESCE(eb.status_name, "Не указано") AS status_name,
-            COALESCE(edc.contacts_buy_phones, 'Не указано') AS phone,
-            COALESCE(u.users_name, 'Не назначен') AS manager_name,
-            COALESCE(eb.category, "Не указано") AS property_type,
-            DATEDIFF(NOW(), COALESCE(
-                (SELECT MAX(log_date) 
-                FROM estate_buys_statuses_log 
-                WHERE estate_buy_id = eb.id 
-                AND status_to = eb.status),
-                eb.date_added
-            )) AS days_in_status,
-            COALESCE(h.complex_name, 'Не указано') AS project_name
-        FROM estate_buys eb
-        LEFT JOIN estate_deals_contacts edc ON edc.id = eb.contacts_id
-        LEFT JOIN users u ON u.id = eb.manager_id
-        LEFT JOIN estate_houses h ON h.id = eb.house_id
-        WHERE eb.company_id = 528`
-
-	whereConditions := []string{}
-	var args []interface{}
-
-	if filter.Status != "" {
-		whereConditions = append(whereConditions, "eb.status_name = ?")
-		args = append(args, filter.Status)
+func (r *MySQLAudienceRepository) countByQuery(ctx context.Context, query string, args map[string]interface{}) (int64, error) {
+	query, params, err := sqlx.Named(query, args)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bind named params: %w", err)
 	}
 
-	if filter.PropertyType != "" {
-		whereConditions = append(whereConditions, "eb.category = ?")
-		args = append(args, filter.PropertyType)
+	query, params, err = sqlx.In(query, params...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand IN clause: %w", err)
 	}
 
-	if filter.ProjectName != "" {
-		whereConditions = append(whereConditions, "h.complex_name LIKE ?")
-		args = append(args, "%"+filter.ProjectName+"%")
+	var count int64
+	if err := r.db.GetContext(ctx, &count, r.db.Rebind(query), params...); err != nil {
+		return 0, fmt.Errorf("count applications: %w", err)
 	}
+	return count, nil
+}
+
+// Курсор - дата создания и id последней заявки страницы
+func encodeApplicationCursor(createdAt time.Time, id int64) string {
+	raw := createdAt.Format(time.RFC3339Nano) + "|" + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
 
-	if filter.StatusDuration > 0 {
-		whereConditions = append(whereConditions, `
-            DATEDIFF(NOW(), COALESCE(
-                (SELECT MAX(log_date) 
-                FROM estate_buys_statuses_log 
-                WHERE estate_buy_id = eb.id 
-                AND status_to = eb.status),
-                eb.date_added
-            )) >= ?
-        `)
-		args = append(args, filter.StatusDuration)
+func decodeApplicationCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor date")
 	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor id")
+	}
+	return createdAt, id, nil
+}
 
-	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		whereConditions = append(whereConditions, "eb.date_added <= ?")
-		args = append(args, filter.EndDate)
+// Выгрузка заявок по фильтру: заголовки выбранных колонок в порядке запроса и строки по ним
+func (r *MySQLAudienceRepository) ExportApplicationsWithFilters(ctx context.Context, filter *domain.ApplicationFilterRequest, nonTarget domain.FunnelStage) ([]domain.Header, []map[string]interface{}, error) {
+	var headers []domain.Header
+	applications := []map[string]interface{}{}
+	err := r.StreamApplicationsWithFilters(ctx, filter, nonTarget,
+		func(exportHeaders []domain.Header) error {
+			headers = exportHeaders
+			return nil
+		},
+		func(row map[string]interface{}) error {
+			applications = append(applications, row)
+			return nil
+		})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		whereConditions = append(whereConditions, "eb.date_added >= ?")
-		args = append(args, filter.StartDate)
+	r.logger.Info("applications exported successfully",
+		zap.Int("count", len(applications)))
+
+	return headers, applications, nil
+}
+
+// Построчная выгрузка заявок по курсору MySQL без накопления в памяти.
+// writeHeaders вызывается один раз после успешного выполнения запроса, до первой строки
+func (r *MySQLAudienceRepository) StreamApplicationsWithFilters(ctx context.Context, filter *domain.ApplicationFilterRequest, nonTarget domain.FunnelStage, writeHeaders func([]domain.Header) error, writeRow func(map[string]interface{}) error) error {
+	columns, err := selectApplicationColumns(filter.Columns, defaultExportColumns)
+	if err != nil {
+		return err
 	}
 
+	args := map[string]interface{}{}
+	where := ""
+	whereConditions := applicationFilterConditions(filter, nonTarget, args)
 	if len(whereConditions) > 0 {
-		baseQuery += " AND " + strings.Join(whereConditions, " AND ")
-	}
-
-	orderClause := " ORDER BY eb.date_added DESC"
-	if filter.OrderField != "" {
-		sortableFields := map[string]string{
-			"id":           "eb.id",
-			"created_date": "eb.date_added",
-			"client_name":  "edc.contacts_buy_name",
-			"status":       "eb.status_name",
-			"phone":        "edc.contacts_buy_phones",
-			"manager":      "u.users_name",
-			"property":     "eb.category",
-		}
+		where = " AND " + strings.Join(whereConditions, " AND ")
+	}
 
-		if dbField, exists := sortableFields[filter.OrderField]; exists {
-			direction := "ASC"
-			if strings.ToUpper(filter.OrderDirection) == "DESC" {
-				direction = "DESC"
-			}
-			orderClause = fmt.Sprintf(" ORDER BY %s %s", dbField, direction)
-		} else {
-			r.logger.Warn("invalid sort field requested, using default",
-				zap.String("field", filter.OrderField))
-		}
+	orderClause, _, err := applicationOrderClause(filter)
+	if err != nil {
+		return err
 	}
 
-	fullQuery := baseQuery + orderClause
+	selectList := applicationSelectList(columns)
+	fullQuery := `
+        SELECT
+            ` + selectList + `
+        FROM estate_buys eb
+        ` + applicationJoinClause(selectList, where, orderClause) + `
+        WHERE eb.company_id = 528` + where + orderClause
 
 	r.logger.Debug("executing export query",
 		zap.String("query", fullQuery),
 		zap.Any("args", args))
 
-	var applications []domain.Application
-	if err := r.db.SelectContext(ctx, &applications, fullQuery, args...); err != nil {
-		return nil, fmt.Errorf("select applications for export: %w", err)
+	query, queryArgs, err := sqlx.Named(fullQuery, args)
+	if err != nil {
+		return fmt.Errorf("prepare query: %w", err)
 	}
 
-	r.logger.Info("applications exported successfully",
-		zap.Int("count", len(applications)))
+	query, queryArgs, err = sqlx.In(query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to expand IN clause: %w", err)
+	}
+
+	rows, err := r.db.QueryxContext(ctx, r.db.Rebind(query), queryArgs...)
+	if err != nil {
+		return fmt.Errorf("select applications for export: %w", err)
+	}
+	defer rows.Close()
+
+	headers := make([]domain.Header, 0, len(columns))
+	for _, column := range columns {
+		header := column.header
+		header.IsVisible = true
+		headers = append(headers, header)
+	}
+	if err := writeHeaders(headers); err != nil {
+		return err
+	}
 
-	return applications, nil
+	for rows.Next() {
+		row, err := scanApplicationRow(rows, columns)
+		if err != nil {
+			return err
+		}
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate applications for export: %w", err)
+	}
+	return nil
 }
 
 func (r *MySQLAudienceRepository) GetRegionsData(ctx context.Context, filter *domain.RegionFilter) (*domain.RegionsResponse, error) {
 	try_date_query := `SELECT * FROM macro_bi_cmp_528.estate_buys eb WHERE 1=1`
 	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		try_date_query = try_date_query + ` AND eb.date_added >= '` + filter.StartDate.Format("2006-01-02") + `'`
+		try_date_query = try_date_query + ` AND eb.date_added >= '` + repository.ReportDateFrom(*filter.StartDate) + `'`
 	}
 	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		try_date_query = try_date_query + ` AND eb.date_added <= '` + filter.EndDate.Format("2006-01-02") + `'`
+		try_date_query = try_date_query + ` AND eb.date_added < '` + repository.ReportDateUntil(*filter.EndDate) + `'`
 	}
 	try_date_query = try_date_query + ` LIMIT 1`
 	if response, err := r.db.QueryContext(ctx, try_date_query); err != nil || !response.Next() {
@@ -927,14 +1156,14 @@ func (r *MySQLAudienceRepository) GetRegionsData(ctx context.Context, filter *do
 	}
 
 	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		data_query = data_query + ` AND eb.date_added >= '` + filter.StartDate.Format("2006-01-02") + `'`
+		data_query = data_query + ` AND eb.date_added >= '` + repository.ReportDateFrom(*filter.StartDate) + `'`
 		//totals_query = totals_query + ` AND eb.date_added >= '` + filter.StartDate.Format("2006-01-02") + `'`
 		//columns_query = columns_query + ` AND eb.date_added >= '` + filter.StartDate.Format("2006-01-02") + `'`
 
 	}
 
 	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		data_query = data_query + ` AND eb.date_added <= '` + filter.EndDate.Format("2006-01-02") + `'`
+		data_query = data_query + ` AND eb.date_added < '` + repository.ReportDateUntil(*filter.EndDate) + `'`
 		//totals_query = totals_query + ` AND eb.date_added <= '` + filter.EndDate.Format("2006-01-02") + `'`
 		// columns_query = columns_query + ` AND eb.date_added <= '` + filter.EndDate.Format("2006-01-02") + `'`
 
@@ -1034,27 +1263,48 @@ func (r *MySQLAudienceRepository) GetRegionsData(ctx context.Context, filter *do
 	}, nil
 }
 
-func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, filter *domain.CallCenterReportFilter) (*domain.CallCenterReport, error) {
+// Отчет по менеджерам колл-центра. Этапы воронки и отделы менеджеров берутся из настроек воронки
+func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, filter *domain.CallCenterReportFilter, funnel *domain.FunnelConfig) (*domain.CallCenterReport, error) {
 
 	StartDateCondition := ""
 	EndDateCondition := ""
 
 	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		StartDateCondition = " AND ebsl.log_date >= '"+filter.StartDate.Format("2006-01-02")+"'"
+		StartDateCondition = " AND ebsl.log_date >= '"+repository.ReportDateFrom(*filter.StartDate)+"'"
 	}
 
+	// Перевод в нецелевые после конца периода не влияет на отчет за период
+	NonTargetDateCondition := ""
+
 	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		EndDateCondition =  " AND ebsl.log_date <= '"+filter.EndDate.Format("2006-01-02")+"'"
+		EndDateCondition =  " AND ebsl.log_date < '"+repository.ReportDateUntil(*filter.EndDate)+"'"
+		NonTargetDateCondition = " AND nt.log_date < '"+repository.ReportDateUntil(*filter.EndDate)+"'"
 	}
 
-	query := `
+	args := map[string]interface{}{
+		"department_ids": funnel.DepartmentIDs,
+	}
+	nonTargetCondition := funnelStageCondition("nt", domain.FunnelStageNonTarget, funnel.Stages[domain.FunnelStageNonTarget], args)
+	appointmentCondition := funnelStageCondition("ebsl", domain.FunnelStageAppointment, funnel.Stages[domain.FunnelStageAppointment], args)
+	visitCondition := funnelStageCondition("ebsl", domain.FunnelStageVisit, funnel.Stages[domain.FunnelStageVisit], args)
+
+	optionalWith, optionalColumns, optionalJoins := "", "", ""
+	if filter.ShowOptional {
+		optionalColumns = `,
+        COALESCE(brons, 0) AS brons,
+        COALESCE(ddus, 0) AS ddus`
+		bookingCondition := funnelStageCondition("ebsl", domain.FunnelStageBooking, funnel.Stages[domain.FunnelStageBooking], args)
+		optionalWith, optionalJoins = callCenterFunnelJoins(filter, bookingCondition, StartDateCondition+EndDateCondition)
+	}
+
+	query := optionalWith + `
 	SELECT 
 	    t1.users_id,
         u.users_name,
 	    COALESCE(total_requests, 0) AS total_requests,
 	    COALESCE(target_requests, 0) AS target_requests,
 	    COALESCE(appointed_visits, 0) AS appointed_visits,
-        COALESCE(successful_visits, 0) AS successful_visits
+        COALESCE(successful_visits, 0) AS successful_visits`+ optionalColumns +`
 	FROM (
 	    SELECT 
 	        ebsl.users_id,
@@ -1066,18 +1316,20 @@ func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, f
 	LEFT JOIN (
 	    SELECT 
 	        ebsl.users_id,
-	        COUNT(*) AS target_requests
+	        COUNT(DISTINCT ebsl.estate_buy_id) AS target_requests
 	    FROM estate_buys_statuses_log ebsl
-	    WHERE status_custom_to_name != 'Нецелевой'`+ StartDateCondition + EndDateCondition +`
+	    WHERE NOT EXISTS (
+	        SELECT 1 FROM estate_buys_statuses_log nt
+	        WHERE nt.estate_buy_id = ebsl.estate_buy_id AND `+ nonTargetCondition + NonTargetDateCondition +`
+	    )`+ StartDateCondition + EndDateCondition +`
 	    GROUP BY ebsl.users_id
 	) t2 ON t1.users_id = t2.users_id
 	LEFT JOIN (
 	    SELECT 
 	        ebsl.users_id,
-	        COUNT(*) AS appointed_visits
+	        COUNT(DISTINCT ebsl.estate_buy_id) AS appointed_visits
 	    FROM estate_buys_statuses_log ebsl
-	    WHERE (status_from_name IN ('Проверка', 'Подбор', 'Неразобранное')) 
-	          AND status_custom_to_name = 'Назначенная встреча' `+ StartDateCondition + EndDateCondition +`
+	    WHERE `+ appointmentCondition + StartDateCondition + EndDateCondition +`
 	    GROUP BY ebsl.users_id
 	) t3 ON t1.users_id = t3.users_id 
     LEFT JOIN (
@@ -1087,7 +1339,7 @@ func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, f
 			MAX(ebsl.id) AS last_cc_log_id
 		FROM estate_buys_statuses_log ebsl
 		JOIN users u ON u.id = ebsl.users_id
-		WHERE u.departments_id = 1903`+ StartDateCondition + EndDateCondition +`
+		WHERE u.departments_id IN (:department_ids)`+ StartDateCondition + EndDateCondition +`
 		GROUP BY ebsl.estate_buy_id
 	),
 	FinalVisits AS (
@@ -1095,7 +1347,7 @@ func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, f
 			estate_buy_id,
 			users_id AS final_manager
 		FROM estate_buys_statuses_log ebsl
-		WHERE status_custom_to_name = 'Визит состоялся'`+ StartDateCondition + EndDateCondition +`
+		WHERE `+ visitCondition + StartDateCondition + EndDateCondition +`
 	)
 	SELECT 
 		users_id,
@@ -1107,96 +1359,60 @@ func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, f
 	WHERE 1=1`+ StartDateCondition + EndDateCondition +`
 	GROUP BY u.id
 	ORDER BY successful_visits DESC
-    ) t4 ON t1.users_id = t4.users_id 
+    ) t4 ON t1.users_id = t4.users_id `+ optionalJoins +`
     LEFT JOIN
 		users u on t1.users_id = u.id
-		WHERE u.departments_id=1903
+		WHERE u.departments_id IN (:department_ids)
 		ORDER BY users_id;
 	`
 
+	query, params, err := sqlx.Named(query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind named params: %w", err)
+	}
+
+	query, params, err = sqlx.In(query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand IN clause: %w", err)
+	}
+
 	var metrics []domain.ManagerMetrics
 
-	if err := r.db.SelectContext(ctx, &metrics, query); err != nil {
+	if err := r.db.SelectContext(ctx, &metrics, r.db.Rebind(query), params...); err != nil {
 		return nil, fmt.Errorf("get sales metrics: %w", err)
 	}
 
-	// Calculate conversions and footer
+	// Доли считаются по одним и тем же определениям для строк и итога
+	definitions := domain.CallCenterMetricDefinitions(filter.ShowOptional)
 	footer := domain.ManagerMetrics{ManagerName: "Итого"}
+	if filter.ShowOptional {
+		footer.InitOptional()
+	}
 	for i := range metrics {
 		m := &metrics[i]
+		m.CalculateRatios(definitions)
 
-		// Calculate basic conversions
-		if m.TotalInquiries > 0 {
-			m.TargetConversion = float64(m.TargetInquiries) / float64(m.TotalInquiries+m.TargetInquiries) // * 100
-		}
-		if m.TargetInquiries > 0 {
-			m.VisitConversion = float64(m.AppointedVisits) / float64(m.TargetInquiries+m.AppointedVisits) // * 100
-			m.LeadToVisit = float64(m.CompletedVisits) / float64(m.TargetInquiries+m.CompletedVisits)     // * 100
-		}
-		if m.AppointedVisits > 0 {
-			m.VisitSuccess = float64(m.CompletedVisits) / float64(m.AppointedVisits+m.CompletedVisits) // * 100
-		}
-
-		//if m.CompletedVisits > 0 {
-		//    m.VisitToBooking = float64(m.Bookings) / float64(m.CompletedVisits) * 100
-		//}
-		//if m.Bookings > 0 {
-		//    m.BookingToContract = float64(m.Contracts) / float64(m.Bookings) * 100
-		//}
-		//if m.TargetInquiries > 0 {
-		//    m.LeadToContract = float64(m.Contracts) / float64(m.TargetInquiries) * 100
-		//}
-
-		// Update totals
 		footer.TotalInquiries += m.TotalInquiries
 		footer.TargetInquiries += m.TargetInquiries
 		footer.AppointedVisits += m.AppointedVisits
 		footer.CompletedVisits += m.CompletedVisits
-		//totals.Bookings += m.Bookings
-		//totals.Contracts += m.Contracts
-	}
-
-	// Calculate total conversions
-	if footer.TotalInquiries > 0 {
-		footer.TargetConversion = float64(footer.TargetInquiries) / float64(footer.TotalInquiries+footer.TargetInquiries) // * 100
-	}
-	if footer.TargetInquiries > 0 {
-		footer.VisitConversion = float64(footer.AppointedVisits) / float64(footer.TargetInquiries+footer.AppointedVisits) // * 100
-		footer.LeadToVisit = float64(footer.CompletedVisits) / float64(footer.TargetInquiries+footer.CompletedVisits)     // * 100
-	}
-	if footer.AppointedVisits > 0 {
-		footer.VisitSuccess = float64(footer.CompletedVisits) / float64(footer.AppointedVisits+footer.CompletedVisits) // * 100
+		if m.HasOptional() && footer.HasOptional() {
+			*footer.Bookings += *m.Bookings
+			*footer.Contracts += *m.Contracts
+		}
 	}
+	footer.CalculateRatios(definitions)
 
-	// Create headers
 	headers := []domain.Header{
 		{Name: "manager_name", IsAsideHeader: true, Title: "ФИО менеджера", IsVisible: true, IsAdditional: false, Format: "string"},
-		{Name: "total_inquiries", IsAsideHeader: false, Title: "Всего обращений", IsVisible: true, IsAdditional: true, Format: "number"},
-		{Name: "target_inquiries", IsAsideHeader: false, Title: "Целевые", IsVisible: true, IsAdditional: false, Format: "number"},
-		{Name: "target_conversion", IsAsideHeader: false, Title: "Конверсия в целевые", IsVisible: true, IsAdditional: false, Format: "percent"},
-		{Name: "appointed_visits", IsAsideHeader: false, Title: "Назначено визитов", IsVisible: true, IsAdditional: false, Format: "number"},
-		{Name: "visit_conversion", IsAsideHeader: false, Title: "Конверсия в визиты", IsVisible: true, IsAdditional: false, Format: "percent"},
-		{Name: "completed_visits", IsAsideHeader: false, Title: "Визиты состоялись", IsVisible: true, IsAdditional: false, Format: "number"},
-		{Name: "visit_success", IsAsideHeader: false, Title: "Конверсия визитов", IsVisible: true, IsAdditional: false, Format: "percent"},
-		{Name: "lead_to_visit", IsAsideHeader: false, Title: "Конверсия лид->визит", IsVisible: true, IsAdditional: false, Format: "percent"},
-
-		//{Name: "bookings", Title: "Бронирования", IsVisible: true, IsAdditional: true, Format: "number"},
-		//{Name: "visit_to_booking", Title: "Конверсия визит->бронь", IsVisible: true, IsAdditional: true, Format: "percent"},
-		//{Name: "contracts", Title: "ДДУ", IsVisible: true, IsAdditional: true, Format: "number"},
-		//{Name: "booking_to_contract", Title: "Конверсия бронь->ДДУ", IsVisible: true, IsAdditional: true, Format: "percent"},
-		//{Name: "lead_to_contract", Title: "Конверсия лид->ДДУ", IsVisible: true, IsAdditional: true, Format: "percent"},
-	}
-
-	// if showOptional {
-	//     optionalHeaders := []domain.Header{
-	//         {Name: "bookings", Title: "Бронирования", IsVisible: true, Format: "number"},
-	//         {Name: "visit_to_booking", Title: "Конверсия визит->бронь", IsVisible: true, Format: "percent"},
-	//         {Name: "contracts", Title: "ДДУ", IsVisible: true, Format: "number"},
-	//         {Name: "booking_to_contract", Title: "Конверсия бронь->ДДУ", IsVisible: true, Format: "percent"},
-	//         {Name: "lead_to_contract", Title: "Конверсия лид->ДДУ", IsVisible: true, Format: "percent"},
-	//     }
-	//     headers = append(headers, optionalHeaders...)
-	// }
+	}
+	for _, definition := range definitions {
+		header := domain.Header{Name: definition.Name, Title: definition.Title, IsVisible: true, IsAdditional: definition.IsAdditional, Format: "number", Description: definition.Formula}
+		if definition.Unit == domain.MetricUnitRatio {
+			header.Format = "percent"
+		}
+		headers = append(headers, header)
+	}
 
 	return &domain.CallCenterReport{
 		Headers: headers,
@@ -1205,7 +1421,77 @@ func (r *MySQLAudienceRepository) GetCallCenterReportData(ctx context.Context, f
 	}, nil
 }
 
-func (r *MySQLAudienceRepository) GetStatusDurationReport(ctx context.Context, filter *domain.StatusDurationFilter) (*domain.StatusDurationResponse, error) {
+// Брони и ДДУ по менеджерам колл-центра. Заявка относится к последнему до конца периода
+// менеджеру колл-центра, который с ней работал, а бронь и сделка считаются по дате события,
+// а не по дате обращения. Последний менеджер считается один раз в WITH для обоих подзапросов
+func callCenterFunnelJoins(filter *domain.CallCenterReportFilter, bookingCondition, logDateConditions string) (string, string) {
+	dealDateConditions, lastLogDateCondition := "", ""
+	if filter.StartDate != nil && !filter.StartDate.IsZero() {
+		dealDateConditions += " AND ed.deal_date >= '" + repository.ReportDateFrom(*filter.StartDate) + "'"
+	}
+	if filter.EndDate != nil && !filter.EndDate.IsZero() {
+		dealDateConditions += " AND ed.deal_date < '" + repository.ReportDateUntil(*filter.EndDate) + "'"
+		lastLogDateCondition = " AND l.log_date < '" + repository.ReportDateUntil(*filter.EndDate) + "'"
+	}
+
+	with := `
+	WITH CallCenterLastLog AS (
+		SELECT l.estate_buy_id, MAX(l.id) AS last_cc_log_id
+		FROM estate_buys_statuses_log l
+		JOIN users cu ON cu.id = l.users_id
+		WHERE cu.departments_id IN (:department_ids)` + lastLogDateCondition + `
+		GROUP BY l.estate_buy_id
+	)`
+
+	return with, `
+    LEFT JOIN (
+		SELECT 
+			lcc.users_id,
+			COUNT(DISTINCT ebsl.estate_buy_id) AS brons
+		FROM estate_buys_statuses_log ebsl
+		JOIN CallCenterLastLog cc ON cc.estate_buy_id = ebsl.estate_buy_id
+		JOIN estate_buys_statuses_log lcc ON lcc.id = cc.last_cc_log_id
+		WHERE ` + bookingCondition + logDateConditions + `
+		GROUP BY lcc.users_id
+    ) t5 ON t1.users_id = t5.users_id
+    LEFT JOIN (
+		SELECT 
+			lcc.users_id,
+			COUNT(DISTINCT ed.id) AS ddus
+		FROM estate_deals ed
+		JOIN estate_buys eb ON eb.deal_id = ed.id
+		JOIN CallCenterLastLog cc ON cc.estate_buy_id = eb.id
+		JOIN estate_buys_statuses_log lcc ON lcc.id = cc.last_cc_log_id
+		WHERE eb.company_id = 528` + dealDateConditions + `
+		GROUP BY lcc.users_id
+    ) t6 ON t1.users_id = t6.users_id `
+}
+
+// Условие перехода заявки в этап воронки. Этап без статусов не совпадает ни с одной записью лога
+func funnelStageCondition(alias, key string, stage domain.FunnelStage, args map[string]interface{}) string {
+	var conditions []string
+	if len(stage.StatusIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s.status_to IN (:%s_status_ids)", alias, key))
+		args[key+"_status_ids"] = stage.StatusIDs
+	}
+	if len(stage.StatusNames) > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s.status_custom_to_name IN (:%s_status_names)", alias, key))
+		args[key+"_status_names"] = stage.StatusNames
+	}
+	if len(conditions) == 0 {
+		return "(1=0)"
+	}
+
+	condition := "(" + strings.Join(conditions, " OR ") + ")"
+	if len(stage.FromStatusNames) > 0 {
+		condition = fmt.Sprintf("(%s AND %s.status_from_name IN (:%s_from_status_names))", condition, alias, key)
+		args[key+"_from_status_names"] = stage.FromStatusNames
+	}
+	return condition
+}
+
+// Время в статусах по каждому статусу, от самых долгих к самым быстрым
+func (r *MySQLAudienceRepository) GetStatusDurations(ctx context.Context, filter *domain.StatusDurationFilter) ([]domain.StatusDuration, error) {
 	query := `
         WITH StatusDurations AS (
             SELECT 
@@ -1220,29 +1506,29 @@ func (r *MySQLAudienceRepository) GetStatusDurationReport(ctx context.Context, f
                 ) as days_in_status
             FROM estate_buys_statuses_log sl
             WHERE sl.company_id = 528
-            AND sl.log_date BETWEEN ? AND ?
+            AND sl.log_date >= ? AND sl.log_date < ?
         )
         SELECT 
             status_name,
             AVG(days_in_status) as avg_days,
             COUNT(DISTINCT estate_buy_id) as total_requests,
-            SUM(CASE WHEN days_in_status > ? THEN 1 ELSE 0 END) as over_threshold
+            COUNT(DISTINCT CASE WHEN days_in_status > ? THEN estate_buy_id END) as over_threshold
         FROM StatusDurations
         GROUP BY status_name
         ORDER BY avg_days DESC
     `
-	// Подготовка аргументов для SQL-запроса
+	// Подготовка аргументов для SQL-запроса: начало и конец периода, по умолчанию последний год
 	args := []interface{}{}
-	if filter.EndDate != nil && !filter.EndDate.IsZero() {
-		args = append(args, filter.EndDate.Format("2006-01-02"))
+	if filter.StartDate != nil && !filter.StartDate.IsZero() {
+		args = append(args, repository.ReportDateFrom(*filter.StartDate))
 	} else {
-		args = append(args, time.Now().AddDate(-1, 0, 0).Format("2006-01-02"))
+		args = append(args, repository.ReportDateFrom(time.Now().AddDate(-1, 0, 0)))
 	}
 
-	if filter.StartDate != nil && !filter.StartDate.IsZero() {
-		args = append(args, filter.StartDate.Format("2006-01-02"))
+	if filter.EndDate != nil && !filter.EndDate.IsZero() {
+		args = append(args, repository.ReportDateUntil(*filter.EndDate))
 	} else {
-		args = append(args, time.Now().Format("2006-01-02"))
+		args = append(args, repository.ReportDateUntil(time.Now()))
 	}
 
 	if filter.ThresholdDays <= 0 {
@@ -1257,23 +1543,33 @@ func (r *MySQLAudienceRepository) GetStatusDurationReport(ctx context.Context, f
 	}
 	defer rows.Close()
 
-	// Переменные для хранения результатов
-	statusMap := make(map[string]domain.StatusDuration)
-	var totalRequests int
-	avgDaysMap := make(map[string]float64)
-
+	var statuses []domain.StatusDuration
 	for rows.Next() {
 		var status domain.StatusDuration
 		if err := rows.Scan(&status.StatusName, &status.AverageDays, &status.TotalRequests, &status.OverThreshold); err != nil {
 			return nil, fmt.Errorf("scan result: %w", err)
 		}
-		statusMap[status.StatusName] = status
-		totalRequests += status.TotalRequests
-		avgDaysMap[status.StatusName] = status.AverageDays
+		statuses = append(statuses, status)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %w", err)
 	}
+	return statuses, nil
+}
+
+func (r *MySQLAudienceRepository) GetStatusDurationReport(ctx context.Context, filter *domain.StatusDurationFilter) (*domain.StatusDurationResponse, error) {
+	statuses, err := r.GetStatusDurations(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Переменные для хранения результатов
+	statusMap := make(map[string]domain.StatusDuration)
+	avgDaysMap := make(map[string]float64)
+	for _, status := range statuses {
+		statusMap[status.StatusName] = status
+		avgDaysMap[status.StatusName] = status.AverageDays
+	}
 
 	// Формируем заголовки
 	headers := []domain.Header{
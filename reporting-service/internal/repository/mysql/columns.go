@@ -0,0 +1,217 @@
+package mysql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"reporting-service/internal/domain"
+)
+
+// Колонка списка заявок: заголовок для клиента, выражение для SELECT и выражение для сортировки
+type applicationColumn struct {
+	header domain.Header
+	expr   string
+	sort   string
+}
+
+// Реестр колонок в порядке по умолчанию. Дополнительные колонки отдаются только по запросу
+var applicationColumns = []applicationColumn{
+	{header: domain.Header{Name: "id", IsID: true, IsAsideHeader: true, Title: "ID", Format: "number"},
+		expr: "eb.id", sort: "eb.id"},
+	{header: domain.Header{Name: "name", Title: "ФИО", Format: "string"},
+		expr: "COALESCE(edc.contacts_buy_name, 'Не указано')", sort: "edc.contacts_buy_name"},
+	{header: domain.Header{Name: "created_at", Title: "Дата", Format: "date"},
+		expr: "eb.date_added", sort: "eb.date_added"},
+	{header: domain.Header{Name: "status_name", Title: "Этап", Format: "enum"},
+		expr: "eb.status_name", sort: "eb.status_name"},
+	{header: domain.Header{Name: "phone", Title: "Номер телефона", Format: "string"},
+		expr: "COALESCE(edc.contacts_buy_phones, 'Не указано')", sort: "edc.contacts_buy_phones"},
+	{header: domain.Header{Name: "manager_name", Title: "Посредник", Format: "string"},
+		expr: "COALESCE(u.users_name, 'Не назначен')", sort: "u.users_name"},
+	{header: domain.Header{Name: "property_type", Title: "Тип недвижимости", Format: "enum"},
+		expr: "COALESCE(eb.category, 'Не указано')", sort: "eb.category"},
+	{header: domain.Header{Name: "days_in_status", Title: "Дней в статусе", Format: "number"},
+		expr: daysInStatusExpression, sort: daysInStatusExpression},
+	{header: domain.Header{Name: "project_name", Title: "Проект", Format: "string"},
+		expr: "COALESCE(h.complex_name, 'Не указано')", sort: "h.complex_name"},
+	{header: domain.Header{Name: "region", Title: "Регион", Format: "string"},
+		expr: regionExpression, sort: regionExpression},
+	{header: domain.Header{Name: "reason_name", Title: "Причина статуса", Format: "string"},
+		expr: "COALESCE(ebrs.name, '')", sort: "ebrs.name"},
+
+	{header: domain.Header{Name: "manager_id", Title: "ID посредника", Format: "number", IsAdditional: true},
+		expr: "eb.manager_id", sort: "eb.manager_id"},
+	{header: domain.Header{Name: "house_id", Title: "ID дома", Format: "number", IsAdditional: true},
+		expr: "eb.house_id", sort: "eb.house_id"},
+	{header: domain.Header{Name: "house_name", Title: "Дом", Format: "string", IsAdditional: true},
+		expr: "COALESCE(h.name, 'Не указано')", sort: "h.name"},
+	{header: domain.Header{Name: "birth_place", Title: "Место рождения", Format: "string", IsAdditional: true},
+		expr: "COALESCE(edc.passport_birth_place, 'Не указано')", sort: "edc.passport_birth_place"},
+	{header: domain.Header{Name: "deal_id", Title: "ID сделки", Format: "number", IsAdditional: true},
+		expr: "ed.id", sort: "ed.id"},
+	{header: domain.Header{Name: "deal_sum", Title: "Сумма сделки", Format: "number", IsAdditional: true},
+		expr: "ed.deal_sum", sort: "ed.deal_sum"},
+	{header: domain.Header{Name: "deal_date", Title: "Дата сделки", Format: "date", IsAdditional: true},
+		expr: "ed.deal_date", sort: "ed.deal_date"},
+	{header: domain.Header{Name: "sell_status_name", Title: "Статус объекта", Format: "enum", IsAdditional: true},
+		expr: "es.estate_sell_status_name", sort: "es.estate_sell_status_name"},
+	{header: domain.Header{Name: "updated_at", Title: "Дата изменения", Format: "date", IsAdditional: true},
+		expr: "eb.updated_at", sort: "eb.updated_at"},
+}
+
+// Колонки выгрузки по умолчанию, как в прежнем файле
+var defaultExportColumns = []string{
+	"id", "created_at", "name", "status_name", "phone", "manager_name", "property_type", "days_in_status", "project_name",
+}
+
+func findApplicationColumn(name string) (applicationColumn, bool) {
+	for _, column := range applicationColumns {
+		if column.header.Name == name {
+			return column, true
+		}
+	}
+	return applicationColumn{}, false
+}
+
+// Выбранные колонки в запрошенном порядке. id добавляется всегда, без запроса берутся колонки по умолчанию
+func selectApplicationColumns(names []string, defaults []string) ([]applicationColumn, error) {
+	if len(names) == 0 {
+		names = defaults
+	}
+
+	selected := make([]applicationColumn, 0, len(names)+1)
+	used := map[string]bool{}
+	for _, name := range names {
+		column, ok := findApplicationColumn(name)
+		if !ok {
+			return nil, domain.NewValidationError("columns", domain.ValidationCodeInvalidValue,
+				fmt.Sprintf("unknown column %q", name))
+		}
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		selected = append(selected, column)
+	}
+
+	if !used["id"] {
+		id, _ := findApplicationColumn("id")
+		selected = append([]applicationColumn{id}, selected...)
+	}
+	return selected, nil
+}
+
+func defaultListColumns() []string {
+	names := []string{}
+	for _, column := range applicationColumns {
+		if !column.header.IsAdditional {
+			names = append(names, column.header.Name)
+		}
+	}
+	return names
+}
+
+func ValidateApplicationColumns(names []string) error {
+	_, err := selectApplicationColumns(names, nil)
+	return err
+}
+
+// Заголовки выбранных колонок, за ними остальные доступные колонки скрытыми
+func applicationHeaders(selected []applicationColumn) []domain.Header {
+	headers := make([]domain.Header, 0, len(applicationColumns))
+	used := map[string]bool{}
+	for _, column := range selected {
+		header := column.header
+		header.IsVisible = true
+		header.IsSortable = column.sort != ""
+		headers = append(headers, header)
+		used[header.Name] = true
+	}
+	for _, column := range applicationColumns {
+		if used[column.header.Name] {
+			continue
+		}
+		header := column.header
+		header.IsVisible = false
+		header.IsSortable = column.sort != ""
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+func applicationSelectList(selected []applicationColumn) string {
+	fields := make([]string, 0, len(selected))
+	for _, column := range selected {
+		fields = append(fields, column.expr+" AS "+column.header.Name)
+	}
+	return strings.Join(fields, ",\n\t\t\t")
+}
+
+// Соединения в порядке зависимости и псевдонимы, по которым они нужны
+var applicationJoins = []struct {
+	alias string
+	join  string
+}{
+	{"edc", "LEFT JOIN estate_deals_contacts edc ON edc.id = eb.contacts_id"},
+	{"u", "LEFT JOIN users u ON u.id = eb.manager_id"},
+	{"h", "LEFT JOIN estate_houses h ON h.id = eb.house_id"},
+	{"es", "LEFT JOIN estate_sells es ON es.id = eb.estate_sell_id"},
+	{"ed", "LEFT JOIN estate_deals ed ON eb.deal_id = ed.id"},
+	{"ebrs", "LEFT JOIN estate_statuses_reasons ebrs ON ebrs.status_reason_id = eb.status_reason_id"},
+}
+
+var joinAliasPattern = regexp.MustCompile(`\b(edc|u|h|es|ed|ebrs)\.`)
+
+// Только те соединения, псевдонимы которых встречаются в частях запроса
+func applicationJoinClause(parts ...string) string {
+	used := map[string]bool{}
+	for _, part := range parts {
+		for _, match := range joinAliasPattern.FindAllStringSubmatch(part, -1) {
+			used[match[1]] = true
+		}
+	}
+
+	joins := []string{}
+	for _, join := range applicationJoins {
+		if used[join.alias] {
+			joins = append(joins, join.join)
+		}
+	}
+	return strings.Join(joins, "\n\t\t")
+}
+
+// Значения строки по колонкам: драйвер отдает текст и DECIMAL байтами
+func scanApplicationRow(rows *sqlx.Rows, selected []applicationColumn) (map[string]interface{}, error) {
+	row := map[string]interface{}{}
+	if err := rows.MapScan(row); err != nil {
+		return nil, fmt.Errorf("scan application row: %w", err)
+	}
+
+	formats := map[string]string{}
+	for _, column := range selected {
+		formats[column.header.Name] = column.header.Format
+	}
+	for key, value := range row {
+		raw, ok := value.([]byte)
+		if !ok {
+			continue
+		}
+		text := string(raw)
+		if formats[key] == "number" {
+			if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+				row[key] = number
+				continue
+			}
+			if number, err := strconv.ParseFloat(text, 64); err == nil {
+				row[key] = number
+				continue
+			}
+		}
+		row[key] = text
+	}
+	return row, nil
+}
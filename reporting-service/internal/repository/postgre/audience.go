@@ -2,11 +2,16 @@ package postgre
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/lib/pq"
 	"reporting-service/internal/domain"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
+	"reporting-service/internal/repository"
+	"strings"
+	"time"
 )
 
 type PostgresAudienceRepository struct {
@@ -41,12 +46,14 @@ func (r *PostgresAudienceRepository) Create(ctx context.Context, audience *domai
 
 	// Insert audience
 	query := `
-        INSERT INTO audiences (name)
-        VALUES ($1)
+        INSERT INTO audiences (name, folder, tags)
+        VALUES ($1, NULLIF($2, ''), $3)
         RETURNING id`
 
 	err = tx.QueryRowxContext(ctx, query,
 		audience.Name,
+		audience.Folder,
+		pq.Array(normalizeTags(audience.Tags)),
 	).Scan(&audience.ID)
 	if err != nil {
 		return fmt.Errorf("insert audience: %w", err)
@@ -60,10 +67,12 @@ func (r *PostgresAudienceRepository) Create(ctx context.Context, audience *domai
 		status_names,
 		status_ids,
 		reason_ids,
+		rejection_reason_ids,
+		non_target_reason_ids,
 		rejection_reasons,
 		non_target_reasons
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
 	`
 	err = tx.QueryRowxContext(ctx, query,
@@ -73,6 +82,8 @@ func (r *PostgresAudienceRepository) Create(ctx context.Context, audience *domai
 		pq.Array(audience.Filter.StatusNames),
 		pq.Array(audience.Filter.StatusIDs),
 		pq.Array(audience.Filter.ReasonIDs),
+		pq.Array(audience.Filter.RejectionReasonIDs),
+		pq.Array(audience.Filter.NonTargetReasonIDs),
 		pq.Array(audience.Filter.RegectionReasonNames),
 		pq.Array(audience.Filter.NonTargetReasonNames),
 	).Scan(&audience.Filter.ID)
@@ -108,14 +119,26 @@ func (r *PostgresAudienceRepository) GetByID(ctx context.Context, id int64) (*do
         SELECT 
             a.id,
             a.name,
+            COALESCE(a.folder, '') AS folder,
+            a.tags,
             a.created_at,
             a.updated_at
         FROM audiences a
         WHERE a.id = $1
         `
 
-	err := r.db.GetContext(ctx, audience, query, id)
+	err := r.db.QueryRowxContext(ctx, query, id).Scan(
+		&audience.ID,
+		&audience.Name,
+		&audience.Folder,
+		pq.Array(&audience.Tags),
+		&audience.CreatedAt,
+		&audience.UpdatedAt,
+	)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("audience %d: %w", id, domain.ErrNotFound)
+		}
 		return nil, fmt.Errorf("select audience: %w", err)
 	}
 
@@ -137,6 +160,24 @@ func (r *PostgresAudienceRepository) GetByID(ctx context.Context, id int64) (*do
 	return audience, nil
 }
 
+// Названия аудиторий по идентификаторам, без заявок и интеграций
+func (r *PostgresAudienceRepository) GetNamesByIDs(ctx context.Context, ids []int64) (map[int64]string, error) {
+	var rows []struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	query := `SELECT id, name FROM audiences WHERE id = ANY($1)`
+	if err := r.db.SelectContext(ctx, &rows, query, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("select audience names: %w", err)
+	}
+
+	names := make(map[int64]string, len(rows))
+	for _, row := range rows {
+		names[row.ID] = row.Name
+	}
+	return names, nil
+}
+
 func (r *PostgresAudienceRepository) GetByName(ctx context.Context, name string) (*domain.Audience, error) {
 	audience := &domain.Audience{}
 
@@ -184,9 +225,15 @@ func (r *PostgresAudienceRepository) GetFilterByAudienceId(ctx context.Context,
 
 	query := `
     SELECT 
+        id,
+        audience_id,
         creation_date_from,
         creation_date_to,
         status_names,
+        status_ids,
+        reason_ids,
+        rejection_reason_ids,
+        non_target_reason_ids,
         rejection_reasons,
         non_target_reasons
     FROM audience_filters 
@@ -194,9 +241,15 @@ func (r *PostgresAudienceRepository) GetFilterByAudienceId(ctx context.Context,
 
 	rows := r.db.QueryRowContext(ctx, query, audience_id)
 	err = rows.Scan(
+		&filter.ID,
+		&filter.AudienceId,
 		&filter.StartDate,
 		&filter.EndDate,
 		pq.Array(&filter.StatusNames),
+		pq.Array(&filter.StatusIDs),
+		pq.Array(&filter.ReasonIDs),
+		pq.Array(&filter.RejectionReasonIDs),
+		pq.Array(&filter.NonTargetReasonIDs),
 		pq.Array(&filter.RegectionReasonNames),
 		pq.Array(&filter.NonTargetReasonNames),
 	)
@@ -320,6 +373,203 @@ func (r *PostgresAudienceRepository) List(ctx context.Context) ([]domain.Audienc
 	return audiences, nil
 }
 
+// Сортировка списка аудиторий: ключ запроса -> выражение SQL
+var audienceSortFields = map[string]string{
+	"name":       "a.name",
+	"size":       "applications_count",
+	"created_at": "a.created_at",
+	"updated_at": "a.updated_at",
+}
+
+type audienceListRow struct {
+	ID                int64          `db:"id"`
+	Name              string         `db:"name"`
+	Folder            string         `db:"folder"`
+	Tags              pq.StringArray `db:"tags"`
+	ApplicationsCount int            `db:"applications_count"`
+	CreatedAt         time.Time      `db:"created_at"`
+	UpdatedAt         time.Time      `db:"updated_at"`
+}
+
+func (r *PostgresAudienceRepository) ListPage(ctx context.Context, pagination *domain.PaginationRequest, filter *domain.AudienceListFilter) ([]domain.AudienceResponse, int64, error) {
+	whereConditions := []string{}
+	args := map[string]interface{}{}
+
+	if filter.Search != "" {
+		whereConditions = append(whereConditions, `(a.name ILIKE :search OR EXISTS (
+			SELECT 1 FROM unnest(a.tags) t WHERE t ILIKE :search))`)
+		args["search"] = "%" + escapeLike(filter.Search) + "%"
+	}
+	if filter.Tag != "" {
+		whereConditions = append(whereConditions, ":tag = ANY(a.tags)")
+		args["tag"] = strings.ToLower(strings.TrimSpace(filter.Tag))
+	}
+	if filter.Folder != "" {
+		whereConditions = append(whereConditions, "a.folder = :folder")
+		args["folder"] = filter.Folder
+	}
+
+	where := ""
+	if len(whereConditions) > 0 {
+		where = " WHERE " + strings.Join(whereConditions, " AND ")
+	}
+
+	countQuery, params, err := sqlx.Named(`SELECT COUNT(*) FROM audiences a`+where, args)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to bind named params: %w", err)
+	}
+	var totalItems int64
+	if err := r.db.GetContext(ctx, &totalItems, r.db.Rebind(countQuery), params...); err != nil {
+		return nil, 0, fmt.Errorf("count audiences: %w", err)
+	}
+
+	orderClause := " ORDER BY a.updated_at DESC"
+	if filter.OrderField != "" {
+		if dbField, exists := audienceSortFields[filter.OrderField]; exists {
+			direction := "DESC"
+			if strings.ToUpper(filter.OrderDirection) == "ASC" {
+				direction = "ASC"
+			}
+			orderClause = fmt.Sprintf(" ORDER BY %s %s, a.id", dbField, direction)
+		} else {
+			r.logger.Warn("invalid sort field requested, using default",
+				zap.String("field", filter.OrderField))
+		}
+	}
+
+	query := `
+        SELECT 
+            a.id,
+            a.name,
+            COALESCE(a.folder, '') AS folder,
+            a.tags,
+            (SELECT COUNT(*) FROM audience_requests ar WHERE ar.audience_id = a.id) AS applications_count,
+            a.created_at,
+            a.updated_at
+        FROM audiences a` + where + orderClause + " LIMIT :limit OFFSET :offset"
+	args["limit"] = pagination.PageSize
+	args["offset"] = (pagination.Page - 1) * pagination.PageSize
+
+	query, params, err = sqlx.Named(query, args)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to bind named params: %w", err)
+	}
+
+	var rows []audienceListRow
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), params...); err != nil {
+		return nil, 0, fmt.Errorf("select audiences: %w", err)
+	}
+
+	audiences := make([]domain.AudienceResponse, 0, len(rows))
+	for _, row := range rows {
+		integrations, err := r.listIntegrations(ctx, row.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		audiences = append(audiences, domain.AudienceResponse{
+			ID:                 row.ID,
+			Name:               row.Name,
+			Folder:             row.Folder,
+			Tags:               row.Tags,
+			Integrations:       integrations,
+			Applications_count: row.ApplicationsCount,
+			CreatedAt:          row.CreatedAt,
+			UpdatedAt:          row.UpdatedAt,
+		})
+	}
+
+	return audiences, totalItems, nil
+}
+
+func (r *PostgresAudienceRepository) listIntegrations(ctx context.Context, audienceID int64) ([]domain.Integration, error) {
+	integrations := []domain.Integration{}
+	query := `
+        SELECT 
+            i.id,
+            i.audience_id,
+            i.cabinet_name,
+            COALESCE(i.external_id, -1) as external_id,
+            i.created_at,
+            i.updated_at
+        FROM integrations i
+        WHERE i.audience_id = $1`
+
+	if err := r.db.SelectContext(ctx, &integrations, query, audienceID); err != nil {
+		return nil, fmt.Errorf("select integrations: %w", err)
+	}
+	return integrations, nil
+}
+
+func (r *PostgresAudienceRepository) UpdateLabels(ctx context.Context, id int64, req *domain.AudienceUpdateRequest) error {
+	return updateAudienceLabels(ctx, r.db, id, req)
+}
+
+// Обновляет название, папку и теги аудитории и время изменения; db - соединение или транзакция
+func updateAudienceLabels(ctx context.Context, db sqlx.ExtContext, id int64, req *domain.AudienceUpdateRequest) error {
+	setClauses := []string{"updated_at = NOW()"}
+	args := map[string]interface{}{"id": id}
+
+	if req.Name != nil {
+		setClauses = append(setClauses, "name = :name")
+		args["name"] = *req.Name
+	}
+	if req.Folder != nil {
+		setClauses = append(setClauses, "folder = NULLIF(:folder, '')")
+		args["folder"] = strings.TrimSpace(*req.Folder)
+	}
+	if req.Tags != nil {
+		setClauses = append(setClauses, "tags = :tags")
+		args["tags"] = pq.Array(normalizeTags(req.Tags))
+	}
+
+	query, params, err := sqlx.Named(`UPDATE audiences SET `+strings.Join(setClauses, ", ")+` WHERE id = :id`, args)
+	if err != nil {
+		return fmt.Errorf("failed to bind named params: %w", err)
+	}
+
+	result, err := db.ExecContext(ctx, db.Rebind(query), params...)
+	if err != nil {
+		return fmt.Errorf("update audience: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("audience %d: %w", id, domain.ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) ListLabels(ctx context.Context) (*domain.AudienceLabelsResponse, error) {
+	labels := &domain.AudienceLabelsResponse{Folders: []string{}, Tags: []string{}}
+
+	query := `SELECT DISTINCT folder FROM audiences WHERE folder IS NOT NULL ORDER BY folder`
+	if err := r.db.SelectContext(ctx, &labels.Folders, query); err != nil {
+		return nil, fmt.Errorf("select folders: %w", err)
+	}
+
+	query = `SELECT DISTINCT unnest(tags) AS tag FROM audiences ORDER BY tag`
+	if err := r.db.SelectContext(ctx, &labels.Tags, query); err != nil {
+		return nil, fmt.Errorf("select tags: %w", err)
+	}
+	return labels, nil
+}
+
+// Теги хранятся в нижнем регистре без повторов
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || repository.SliceConatinsString(normalized, tag) {
+			continue
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
 func (r *PostgresAudienceRepository) UpdateApplicationsForAudience(ctx context.Context, audienceID int64, requests []domain.Application) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -350,15 +600,6 @@ func (r *PostgresAudienceRepository) UpdateApplicationsForAudience(ctx context.C
 	}
 
 	// Update audience updated_at timestamp
-	_, err = tx.ExecContext(ctx, `
-        UPDATE audiences 
-        SET updated_at = NOW() 
-        WHERE id = $1`,
-		audienceID)
-	if err != nil {
-		return fmt.Errorf("update audience timestamp: %w", err)
-	}
-
 	return tx.Commit()
 }
 
@@ -396,7 +637,7 @@ func (r *PostgresAudienceRepository) Delete(ctx context.Context, id int64) error
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("audience not found")
+		return fmt.Errorf("audience %d: %w", id, domain.ErrNotFound)
 	}
 
 	return nil
@@ -461,3 +702,257 @@ func (r *PostgresAudienceRepository) DeleteApplications(ctx context.Context, aud
 	}
 	return nil
 }
+
+// Повторные запуски в течение дня суммируют изменения и перезаписывают размер
+func (r *PostgresAudienceRepository) RecordDailyStats(ctx context.Context, audienceID int64, size, added, removed int) error {
+	query := `
+		INSERT INTO audience_daily_stats (audience_id, stat_date, size, added, removed)
+		VALUES ($1, CURRENT_DATE, $2, $3, $4)
+		ON CONFLICT (audience_id, stat_date) DO UPDATE SET
+			size = EXCLUDED.size,
+			added = audience_daily_stats.added + EXCLUDED.added,
+			removed = audience_daily_stats.removed + EXCLUDED.removed,
+			updated_at = NOW()`
+
+	if _, err := r.db.ExecContext(ctx, query, audienceID, size, added, removed); err != nil {
+		return fmt.Errorf("upsert audience stats: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) GetDailyStats(ctx context.Context, audienceID int64, from, to time.Time) ([]domain.AudienceDailyStat, error) {
+	stats := []domain.AudienceDailyStat{}
+	query := `
+		SELECT 
+			stat_date,
+			size,
+			added,
+			removed
+		FROM audience_daily_stats
+		WHERE audience_id = $1 AND stat_date BETWEEN $2 AND $3
+		ORDER BY stat_date`
+
+	if err := r.db.SelectContext(ctx, &stats, query, audienceID, from, to); err != nil {
+		return nil, fmt.Errorf("select audience stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Размер аудитории на последнюю дату до начала периода
+func (r *PostgresAudienceRepository) GetSizeBefore(ctx context.Context, audienceID int64, date time.Time) (int, error) {
+	var size int
+	query := `
+		SELECT COALESCE((
+			SELECT size
+			FROM audience_daily_stats
+			WHERE audience_id = $1 AND stat_date < $2
+			ORDER BY stat_date DESC
+			LIMIT 1
+		), 0)`
+
+	if err := r.db.GetContext(ctx, &size, query, audienceID, date); err != nil {
+		return 0, fmt.Errorf("select audience size: %w", err)
+	}
+	return size, nil
+}
+
+// Итоги аудиторий за период одним запросом: размер на начало и конец, добавленные и удаленные.
+// Без ids - по всем аудиториям. Несуществующие id в результат не попадают
+func (r *PostgresAudienceRepository) GetStatsSummaries(ctx context.Context, ids []int64, from, to time.Time) ([]domain.AudienceStatsSummary, error) {
+	args := []interface{}{from, to}
+	condition := ""
+	if ids != nil {
+		condition = "WHERE a.id = ANY($3)"
+		args = append(args, pq.Array(ids))
+	}
+
+	query := `
+		SELECT
+			a.id AS audience_id,
+			a.name AS audience_name,
+			COALESCE(b.size, 0) AS start_size,
+			COALESCE(e.size, 0) AS end_size,
+			COALESCE(p.added, 0) AS added,
+			COALESCE(p.removed, 0) AS removed
+		FROM audiences a
+		LEFT JOIN LATERAL (
+			SELECT size FROM audience_daily_stats
+			WHERE audience_id = a.id AND stat_date < $1
+			ORDER BY stat_date DESC
+			LIMIT 1
+		) b ON true
+		LEFT JOIN LATERAL (
+			SELECT size FROM audience_daily_stats
+			WHERE audience_id = a.id AND stat_date <= $2
+			ORDER BY stat_date DESC
+			LIMIT 1
+		) e ON true
+		LEFT JOIN LATERAL (
+			SELECT SUM(added) AS added, SUM(removed) AS removed
+			FROM audience_daily_stats
+			WHERE audience_id = a.id AND stat_date BETWEEN $1 AND $2
+		) p ON true
+		` + condition + `
+		ORDER BY a.id`
+
+	summaries := []domain.AudienceStatsSummary{}
+	if err := r.db.SelectContext(ctx, &summaries, query, args...); err != nil {
+		return nil, fmt.Errorf("select audience stats summaries: %w", err)
+	}
+	return summaries, nil
+}
+
+func (r *PostgresAudienceRepository) ListFilters(ctx context.Context) ([]domain.AudienceCreationFilter, error) {
+	query := `
+    SELECT 
+        id,
+        audience_id,
+        creation_date_from,
+        creation_date_to,
+        status_names,
+        status_ids,
+        reason_ids,
+        rejection_reason_ids,
+        non_target_reason_ids,
+        rejection_reasons,
+        non_target_reasons
+    FROM audience_filters`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("select filters: %w", err)
+	}
+	defer rows.Close()
+
+	var filters []domain.AudienceCreationFilter
+	for rows.Next() {
+		var filter domain.AudienceCreationFilter
+		if err := rows.Scan(
+			&filter.ID,
+			&filter.AudienceId,
+			&filter.StartDate,
+			&filter.EndDate,
+			pq.Array(&filter.StatusNames),
+			pq.Array(&filter.StatusIDs),
+			pq.Array(&filter.ReasonIDs),
+			pq.Array(&filter.RejectionReasonIDs),
+			pq.Array(&filter.NonTargetReasonIDs),
+			pq.Array(&filter.RegectionReasonNames),
+			pq.Array(&filter.NonTargetReasonNames),
+		); err != nil {
+			return nil, fmt.Errorf("scan filter: %w", err)
+		}
+		filters = append(filters, filter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return filters, nil
+}
+
+// Замена названия, папки, тегов, фильтра и состава аудитории одной транзакцией
+func (r *PostgresAudienceRepository) ReplaceFilter(ctx context.Context, audienceID int64, req *domain.AudienceUpdateRequest, requests []domain.Application) error {
+	filter := req.Filter
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateAudienceLabels(ctx, tx, audienceID, req); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE audience_filters SET
+			creation_date_from = $2,
+			creation_date_to = $3,
+			status_names = $4,
+			status_ids = $5,
+			reason_ids = $6,
+			rejection_reason_ids = $7,
+			non_target_reason_ids = $8,
+			rejection_reasons = $9,
+			non_target_reasons = $10
+		WHERE audience_id = $1`,
+		audienceID,
+		filter.StartDate,
+		filter.EndDate,
+		pq.Array(filter.StatusNames),
+		pq.Array(filter.StatusIDs),
+		pq.Array(filter.ReasonIDs),
+		pq.Array(filter.RejectionReasonIDs),
+		pq.Array(filter.NonTargetReasonIDs),
+		pq.Array(filter.RegectionReasonNames),
+		pq.Array(filter.NonTargetReasonNames),
+	)
+	if err != nil {
+		return fmt.Errorf("update filter: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("audience %d filter: %w", audienceID, domain.ErrNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM audience_requests WHERE audience_id = $1`, audienceID); err != nil {
+		return fmt.Errorf("delete requests: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO audience_requests (
+            audience_id,
+            request_id
+        ) VALUES ($1, $2)`)
+	if err != nil {
+		return fmt.Errorf("prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, req := range requests {
+		if _, err := stmt.ExecContext(ctx, audienceID, req.ID); err != nil {
+			return fmt.Errorf("insert request %d: %w", req.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresAudienceRepository) UpdateFilterIDs(ctx context.Context, filter *domain.AudienceCreationFilter) error {
+	query := `
+		UPDATE audience_filters SET
+			status_ids = $2,
+			reason_ids = $3,
+			rejection_reason_ids = $4,
+			non_target_reason_ids = $5
+		WHERE id = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, filter.ID,
+		pq.Array(filter.StatusIDs),
+		pq.Array(filter.ReasonIDs),
+		pq.Array(filter.RejectionReasonIDs),
+		pq.Array(filter.NonTargetReasonIDs),
+	); err != nil {
+		return fmt.Errorf("update filter ids: %w", err)
+	}
+	return nil
+}
+
+// Аудитории, в которые сейчас входит заявка
+func (r *PostgresAudienceRepository) ListAudiencesByApplication(ctx context.Context, applicationID int64) ([]domain.DictionaryItem, error) {
+	query := `
+		SELECT DISTINCT a.id, a.name
+		FROM audiences a
+		JOIN audience_requests ar ON ar.audience_id = a.id
+		WHERE ar.request_id = $1
+		ORDER BY a.name`
+
+	audiences := []domain.DictionaryItem{}
+	if err := r.db.SelectContext(ctx, &audiences, query, applicationID); err != nil {
+		return nil, fmt.Errorf("select audiences by application: %w", err)
+	}
+	return audiences, nil
+}
@@ -0,0 +1,142 @@
+package postgre
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"reporting-service/internal/domain"
+)
+
+const exportJobColumns = `id, owner_id, report_type, format, params, status, file_path, file_name, error, created_at, started_at, finished_at, expires_at`
+
+func (r *PostgresAudienceRepository) CreateExportJob(ctx context.Context, job *domain.ExportJob) error {
+	query := `
+		INSERT INTO export_jobs (owner_id, report_type, format, params, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		job.OwnerID,
+		job.ReportType,
+		job.Format,
+		string(job.Params),
+		domain.ExportStatusPending,
+	).Scan(&job.ID, &job.CreatedAt); err != nil {
+		return fmt.Errorf("insert export job: %w", err)
+	}
+	job.Status = domain.ExportStatusPending
+	return nil
+}
+
+func (r *PostgresAudienceRepository) GetExportJob(ctx context.Context, id int64) (*domain.ExportJob, error) {
+	var job domain.ExportJob
+	query := `SELECT ` + exportJobColumns + ` FROM export_jobs WHERE id = $1`
+	if err := r.db.GetContext(ctx, &job, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("export job %d: %w", id, domain.ErrNotFound)
+		}
+		return nil, fmt.Errorf("select export job: %w", err)
+	}
+	return &job, nil
+}
+
+// Забирает самую старую ожидающую выгрузку. SKIP LOCKED не дает двум экземплярам сервиса взять одну задачу
+func (r *PostgresAudienceRepository) ClaimExportJob(ctx context.Context) (*domain.ExportJob, error) {
+	var job domain.ExportJob
+	query := `
+		UPDATE export_jobs SET status = $1, started_at = NOW(), heartbeat_at = NOW()
+		WHERE id = (
+			SELECT id FROM export_jobs
+			WHERE status = $2
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + exportJobColumns
+	if err := r.db.GetContext(ctx, &job, query, domain.ExportStatusRunning, domain.ExportStatusPending); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("claim export job: %w", err)
+	}
+	return &job, nil
+}
+
+// Продлевает выгрузку в работе. false - выгрузку уже вернули в очередь или завершили
+func (r *PostgresAudienceRepository) TouchExportJob(ctx context.Context, id int64) (bool, error) {
+	query := `UPDATE export_jobs SET heartbeat_at = NOW() WHERE id = $1 AND status = $2`
+	result, err := r.db.ExecContext(ctx, query, id, domain.ExportStatusRunning)
+	if err != nil {
+		return false, fmt.Errorf("touch export job: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// Завершает выгрузку в работе. false - выгрузку уже вернули в очередь или завершил другой воркер
+func (r *PostgresAudienceRepository) CompleteExportJob(ctx context.Context, id int64, filePath, fileName string, expiresAt time.Time) (bool, error) {
+	query := `
+		UPDATE export_jobs SET status = $2, file_path = $3, file_name = $4, finished_at = NOW(), expires_at = $5
+		WHERE id = $1 AND status = $6`
+	result, err := r.db.ExecContext(ctx, query, id, domain.ExportStatusDone, filePath, fileName, expiresAt, domain.ExportStatusRunning)
+	if err != nil {
+		return false, fmt.Errorf("complete export job: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// Отмечает ошибку выгрузки в работе. false - выгрузку уже вернули в очередь или завершили
+func (r *PostgresAudienceRepository) FailExportJob(ctx context.Context, id int64, message string) (bool, error) {
+	query := `UPDATE export_jobs SET status = $2, error = $3, finished_at = NOW() WHERE id = $1 AND status = $4`
+	result, err := r.db.ExecContext(ctx, query, id, domain.ExportStatusFailed, message, domain.ExportStatusRunning)
+	if err != nil {
+		return false, fmt.Errorf("fail export job: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// Возвращает в очередь выгрузки, воркер которых давно не отмечался, например после перезапуска сервиса
+func (r *PostgresAudienceRepository) RequeueStaleExportJobs(ctx context.Context, heartbeatBefore time.Time) (int64, error) {
+	query := `
+		UPDATE export_jobs SET status = $1, started_at = NULL, heartbeat_at = NULL
+		WHERE status = $2 AND COALESCE(heartbeat_at, started_at) < $3`
+	result, err := r.db.ExecContext(ctx, query, domain.ExportStatusPending, domain.ExportStatusRunning, heartbeatBefore)
+	if err != nil {
+		return 0, fmt.Errorf("requeue export jobs: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows, nil
+}
+
+// Готовые выгрузки с истекшим сроком хранения и старые записи о неудачных
+func (r *PostgresAudienceRepository) ListExpiredExportJobs(ctx context.Context, now time.Time, failedBefore time.Time) ([]domain.ExportJob, error) {
+	var jobs []domain.ExportJob
+	query := `
+		SELECT ` + exportJobColumns + `
+		FROM export_jobs
+		WHERE (status = $1 AND expires_at < $2) OR (status = $3 AND finished_at < $4)
+		ORDER BY id`
+	if err := r.db.SelectContext(ctx, &jobs, query,
+		domain.ExportStatusDone, now, domain.ExportStatusFailed, failedBefore); err != nil {
+		return nil, fmt.Errorf("select expired export jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (r *PostgresAudienceRepository) ExpireExportJob(ctx context.Context, id int64) error {
+	query := `UPDATE export_jobs SET status = $2, file_path = '' WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, domain.ExportStatusExpired); err != nil {
+		return fmt.Errorf("expire export job: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package postgre
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"reporting-service/internal/domain"
+)
+
+type reportFunnelRow struct {
+	domain.FunnelConfig
+	DepartmentList pq.Int64Array `db:"department_ids"`
+	StagesJSON     []byte        `db:"stages"`
+}
+
+func (row *reportFunnelRow) toFunnel() (domain.FunnelConfig, error) {
+	funnel := row.FunnelConfig
+	funnel.DepartmentIDs = []int64(row.DepartmentList)
+	if err := json.Unmarshal(row.StagesJSON, &funnel.Stages); err != nil {
+		return funnel, fmt.Errorf("decode stages of funnel %q: %w", funnel.Code, err)
+	}
+	return funnel, nil
+}
+
+const reportFunnelColumns = `id, code, name, department_ids, stages, created_at, updated_at`
+
+func (r *PostgresAudienceRepository) ListReportFunnels(ctx context.Context) ([]domain.FunnelConfig, error) {
+	var rows []reportFunnelRow
+	query := `SELECT ` + reportFunnelColumns + ` FROM report_funnels ORDER BY name, id`
+	if err := r.db.SelectContext(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("select report funnels: %w", err)
+	}
+
+	funnels := make([]domain.FunnelConfig, 0, len(rows))
+	for i := range rows {
+		funnel, err := rows[i].toFunnel()
+		if err != nil {
+			return nil, err
+		}
+		funnels = append(funnels, funnel)
+	}
+	return funnels, nil
+}
+
+func (r *PostgresAudienceRepository) GetReportFunnel(ctx context.Context, code string) (*domain.FunnelConfig, error) {
+	var row reportFunnelRow
+	query := `SELECT ` + reportFunnelColumns + ` FROM report_funnels WHERE code = $1`
+	if err := r.db.GetContext(ctx, &row, query, code); err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isUndefinedTable(err) {
+			return nil, fmt.Errorf("report funnel %q: %w", code, domain.ErrNotFound)
+		}
+		return nil, fmt.Errorf("select report funnel: %w", err)
+	}
+	funnel, err := row.toFunnel()
+	if err != nil {
+		return nil, err
+	}
+	return &funnel, nil
+}
+
+func (r *PostgresAudienceRepository) CreateReportFunnel(ctx context.Context, funnel *domain.FunnelConfig) error {
+	stages, err := json.Marshal(funnel.Stages)
+	if err != nil {
+		return fmt.Errorf("encode funnel stages: %w", err)
+	}
+
+	query := `
+		INSERT INTO report_funnels (code, name, department_ids, stages)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		funnel.Code,
+		funnel.Name,
+		pq.Array(funnel.DepartmentIDs),
+		string(stages),
+	).Scan(&funnel.ID, &funnel.CreatedAt, &funnel.UpdatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return domain.NewValidationError("code", domain.ValidationCodeDuplicate,
+				fmt.Sprintf("funnel %q already exists", funnel.Code))
+		}
+		return fmt.Errorf("insert report funnel: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) UpdateReportFunnel(ctx context.Context, funnel *domain.FunnelConfig) error {
+	stages, err := json.Marshal(funnel.Stages)
+	if err != nil {
+		return fmt.Errorf("encode funnel stages: %w", err)
+	}
+
+	query := `
+		UPDATE report_funnels SET
+			name = $2,
+			department_ids = $3,
+			stages = $4,
+			updated_at = NOW()
+		WHERE code = $1
+		RETURNING id, created_at, updated_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		funnel.Code,
+		funnel.Name,
+		pq.Array(funnel.DepartmentIDs),
+		string(stages),
+	).Scan(&funnel.ID, &funnel.CreatedAt, &funnel.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("report funnel %q: %w", funnel.Code, domain.ErrNotFound)
+		}
+		return fmt.Errorf("update report funnel: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) DeleteReportFunnel(ctx context.Context, code string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM report_funnels WHERE code = $1`, code)
+	if err != nil {
+		return fmt.Errorf("delete report funnel: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("report funnel %q: %w", code, domain.ErrNotFound)
+	}
+	return nil
+}
+
+// Подписки и незавершенные выгрузки, в параметрах которых указана воронка
+func (r *PostgresAudienceRepository) CountReportFunnelReferences(ctx context.Context, code string) (int, int, error) {
+	var subscriptions, jobs int
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM report_subscriptions WHERE params->>'funnel' = $1),
+			(SELECT COUNT(*) FROM export_jobs WHERE params->>'funnel' = $1 AND status IN ($2, $3))`
+	if err := r.db.QueryRowxContext(ctx, query, code, domain.ExportStatusPending, domain.ExportStatusRunning).Scan(&subscriptions, &jobs); err != nil {
+		return 0, 0, fmt.Errorf("count report funnel references: %w", err)
+	}
+	return subscriptions, jobs, nil
+}
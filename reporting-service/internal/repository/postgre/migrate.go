@@ -0,0 +1,100 @@
+package postgre
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Миграции схемы сервиса. Применяются при запуске, примененные версии хранятся в schema_migrations
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Ключ блокировки, чтобы несколько экземпляров сервиса не применяли миграции одновременно
+const migrationLockKey = 7290341
+
+// Версия миграции - имя файла без .sql, например 001_audience_labels
+func migrationVersions() ([]string, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("list migrations: %w", err)
+	}
+	versions := make([]string, 0, len(names))
+	for _, name := range names {
+		versions = append(versions, strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql"))
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// Применяет новые миграции, каждую в своей транзакции вместе с отметкой в schema_migrations.
+// Миграции до появления schema_migrations идемпотентны, поэтому на существующей базе
+// они безопасно применяются повторно
+func Migrate(ctx context.Context, db *sqlx.DB, logger *zap.Logger) error {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var applied []string
+	if err := conn.SelectContext(ctx, &applied, `SELECT version FROM schema_migrations`); err != nil {
+		return fmt.Errorf("select applied migrations: %w", err)
+	}
+	done := make(map[string]bool, len(applied))
+	for _, version := range applied {
+		done[version] = true
+	}
+
+	versions, err := migrationVersions()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if done[version] {
+			continue
+		}
+		script, err := migrationFiles.ReadFile("migrations/" + version + ".sql")
+		if err != nil {
+			return fmt.Errorf("read migration %s: %w", version, err)
+		}
+
+		tx, err := conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("apply migration %s: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %s: %w", version, err)
+		}
+		logger.Info("Applied migration", zap.String("version", version))
+	}
+	return nil
+}
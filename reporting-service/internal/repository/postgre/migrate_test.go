@@ -0,0 +1,28 @@
+package postgre
+
+import (
+	"strings"
+	"testing"
+)
+
+// Номер версии задает порядок применения и не должен повторяться
+func TestMigrationVersions(t *testing.T) {
+	versions, err := migrationVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) == 0 || versions[0] != "001_audience_labels" {
+		t.Fatalf("versions = %v", versions)
+	}
+	seen := map[string]bool{}
+	for _, version := range versions {
+		number, _, ok := strings.Cut(version, "_")
+		if !ok || len(number) != 3 {
+			t.Errorf("migration %q has no three-digit number", version)
+		}
+		if seen[number] {
+			t.Errorf("migration number %s is repeated", number)
+		}
+		seen[number] = true
+	}
+}
@@ -0,0 +1,6 @@
+-- Папки и теги аудиторий
+ALTER TABLE audiences ADD COLUMN IF NOT EXISTS folder VARCHAR(255);
+ALTER TABLE audiences ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_audiences_folder ON audiences(folder);
+CREATE INDEX IF NOT EXISTS idx_audiences_tags ON audiences USING GIN (tags);
@@ -0,0 +1,10 @@
+-- Ежедневная статистика аудиторий: размер, добавленные и удаленные заявки
+CREATE TABLE IF NOT EXISTS audience_daily_stats (
+    audience_id INTEGER NOT NULL REFERENCES audiences(id) ON DELETE CASCADE,
+    stat_date DATE NOT NULL,
+    size INTEGER NOT NULL DEFAULT 0,
+    added INTEGER NOT NULL DEFAULT 0,
+    removed INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (audience_id, stat_date)
+);
@@ -0,0 +1,3 @@
+-- Причины отказа и нецелевые причины хранятся раздельно
+ALTER TABLE audience_filters ADD COLUMN IF NOT EXISTS rejection_reason_ids INTEGER[];
+ALTER TABLE audience_filters ADD COLUMN IF NOT EXISTS non_target_reason_ids INTEGER[];
@@ -0,0 +1,16 @@
+-- Поисковый индекс заявок MacroCRM: имя клиента, телефон и номер заявки
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS application_search_index (
+    application_id BIGINT PRIMARY KEY,
+    client_name TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    phone_digits TEXT NOT NULL DEFAULT '',
+    source_updated_at TIMESTAMP NOT NULL,
+    indexed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_application_search_name ON application_search_index USING GIN (lower(client_name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_application_search_phone ON application_search_index USING GIN (phone_digits gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_application_search_id ON application_search_index ((application_id::text) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_application_search_sync ON application_search_index (source_updated_at, application_id);
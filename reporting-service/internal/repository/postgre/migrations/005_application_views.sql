@@ -0,0 +1,16 @@
+-- Сохраненные представления списка заявок: фильтры, сортировка и колонки
+CREATE TABLE IF NOT EXISTS application_views (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    owner_id VARCHAR(64) NOT NULL,
+    shared BOOLEAN NOT NULL DEFAULT FALSE,
+    filter JSONB NOT NULL DEFAULT '{}',
+    order_field VARCHAR(255) NOT NULL DEFAULT '',
+    order_direction VARCHAR(64) NOT NULL DEFAULT '',
+    columns TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_application_views_owner_name ON application_views (owner_id, name);
+CREATE INDEX IF NOT EXISTS idx_application_views_shared ON application_views (shared) WHERE shared;
@@ -0,0 +1,20 @@
+-- Фоновые выгрузки отчетов: параметры, статус и готовый файл до истечения срока хранения
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id VARCHAR(64) NOT NULL,
+    report_type VARCHAR(64) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    file_path TEXT NOT NULL DEFAULT '',
+    file_name TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_export_jobs_pending ON export_jobs (id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_export_jobs_owner ON export_jobs (owner_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_expires ON export_jobs (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,45 @@
+-- Подписки на отчеты: что и в каком формате отправлять, кому и по какому расписанию
+CREATE TABLE IF NOT EXISTS report_subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    report_type VARCHAR(64) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    period VARCHAR(32) NOT NULL DEFAULT '',
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    schedule VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_subscriptions_owner ON report_subscriptions (owner_id, name);
+CREATE INDEX IF NOT EXISTS idx_report_subscriptions_due ON report_subscriptions (next_run_at) WHERE enabled;
+
+-- История отправок. Параметры отчета с подставленным периодом сохраняются в отправке,
+-- чтобы повторная попытка построила тот же отчет
+CREATE TABLE IF NOT EXISTS report_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES report_subscriptions (id) ON DELETE CASCADE,
+    report_type VARCHAR(64) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    period_start TIMESTAMPTZ,
+    period_end TIMESTAMPTZ,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    file_name TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    scheduled_at TIMESTAMPTZ NOT NULL,
+    started_at TIMESTAMPTZ,
+    sent_at TIMESTAMPTZ,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_deliveries_pending ON report_deliveries (next_attempt_at) WHERE status IN ('pending', 'sending');
+CREATE INDEX IF NOT EXISTS idx_report_deliveries_subscription ON report_deliveries (subscription_id, id DESC);
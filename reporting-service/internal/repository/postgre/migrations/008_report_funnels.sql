@@ -0,0 +1,27 @@
+-- Воронки отчета по колл-центру: какие статусы MacroCRM считаются этапами воронки
+-- и менеджеры каких отделов попадают в отчет. Этап задается id статусов (status_to в логе статусов)
+-- и/или названиями (status_custom_to_name), для этапа можно ограничить статусы, из которых в него переходят
+CREATE TABLE IF NOT EXISTS report_funnels (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    department_ids BIGINT[] NOT NULL DEFAULT '{}',
+    stages JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Воронка колл-центра с прежними значениями из запроса отчета
+INSERT INTO report_funnels (code, name, department_ids, stages)
+VALUES (
+    'call_center',
+    'Колл-центр',
+    '{1903}',
+    '{
+        "non_target": {"status_names": ["Нецелевой"]},
+        "appointment": {"status_names": ["Назначенная встреча"], "from_status_names": ["Проверка", "Подбор", "Неразобранное"]},
+        "visit": {"status_names": ["Визит состоялся"]},
+        "booking": {"status_names": ["Бронь"]}
+    }'
+)
+ON CONFLICT (code) DO NOTHING;
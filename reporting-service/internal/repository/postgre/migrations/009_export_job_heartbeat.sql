@@ -0,0 +1,5 @@
+-- Отметка живой выгрузки: воркер обновляет ее, пока формирует файл. Выгрузка без свежей отметки
+-- считается брошенной и возвращается в очередь, даже если формируется дольше часа
+ALTER TABLE export_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_export_jobs_running ON export_jobs (heartbeat_at) WHERE status = 'running';
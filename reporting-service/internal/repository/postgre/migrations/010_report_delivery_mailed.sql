@@ -0,0 +1,3 @@
+-- Момент передачи письма почтовому серверу. Отправка, зависшая после этой отметки, повторно
+-- не забирается: письмо могло уйти, и повтор разослал бы отчет дважды
+ALTER TABLE report_deliveries ADD COLUMN IF NOT EXISTS mailed_at TIMESTAMPTZ;
@@ -0,0 +1,116 @@
+package postgre
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/repository"
+)
+
+// Позиция последней проиндексированной заявки, с нее продолжается синхронизация
+func (r *PostgresAudienceRepository) SearchIndexPosition(ctx context.Context) (time.Time, int64, error) {
+	var position struct {
+		UpdatedAt     time.Time `db:"source_updated_at"`
+		ApplicationID int64     `db:"application_id"`
+	}
+	query := `
+		SELECT source_updated_at, application_id
+		FROM application_search_index
+		ORDER BY source_updated_at DESC, application_id DESC
+		LIMIT 1`
+	if err := r.db.GetContext(ctx, &position, query); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, 0, nil
+		}
+		return time.Time{}, 0, fmt.Errorf("get search index position: %w", err)
+	}
+	return position.UpdatedAt, position.ApplicationID, nil
+}
+
+func (r *PostgresAudienceRepository) UpsertSearchEntries(ctx context.Context, entries []domain.ApplicationSearchEntry) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO application_search_index (application_id, client_name, phone, phone_digits, source_updated_at, indexed_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (application_id) DO UPDATE SET
+			client_name = EXCLUDED.client_name,
+			phone = EXCLUDED.phone,
+			phone_digits = EXCLUDED.phone_digits,
+			source_updated_at = EXCLUDED.source_updated_at,
+			indexed_at = NOW()`
+	for _, entry := range entries {
+		if _, err := tx.ExecContext(ctx, query,
+			entry.ApplicationID,
+			entry.ClientName,
+			entry.Phone,
+			repository.PhoneDigits(entry.Phone),
+			entry.UpdatedAt,
+		); err != nil {
+			return fmt.Errorf("upsert search entry %d: %w", entry.ApplicationID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Поиск по вхождению в имя и цифры телефона и по префиксу номера заявки.
+// Точное совпадение номера идет первым, затем более похожие имена.
+// Возвращает не больше limit заявок и общее число совпадений
+func (r *PostgresAudienceRepository) SearchApplicationIDs(ctx context.Context, q string, limit int) ([]int64, int, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	digits := repository.PhoneDigits(q)
+
+	args := []interface{}{digits, q, "%" + escapeLike(q) + "%"}
+	conditions := []string{"lower(client_name) LIKE $3"}
+	// Короткие цифровые запросы по телефону совпадают почти со всеми заявками
+	if len(digits) >= 3 {
+		args = append(args, "%"+escapeLike(digits)+"%")
+		conditions = append(conditions, fmt.Sprintf("phone_digits LIKE $%d", len(args)))
+	}
+	if digits != "" && digits == q {
+		args = append(args, escapeLike(digits)+"%")
+		conditions = append(conditions, fmt.Sprintf("application_id::text LIKE $%d", len(args)))
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT application_id, COUNT(*) OVER () AS total
+		FROM application_search_index
+		WHERE %s
+		ORDER BY application_id::text = $1 DESC, similarity(lower(client_name), $2) DESC, application_id DESC
+		LIMIT $%d`, strings.Join(conditions, " OR "), len(args))
+
+	var rows []struct {
+		ApplicationID int64 `db:"application_id"`
+		Total         int   `db:"total"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, 0, fmt.Errorf("search applications: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, 0, nil
+	}
+
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ApplicationID)
+	}
+	return ids, rows[0].Total, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
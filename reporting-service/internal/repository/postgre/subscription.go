@@ -0,0 +1,311 @@
+package postgre
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"reporting-service/internal/domain"
+)
+
+type reportSubscriptionRow struct {
+	domain.ReportSubscription
+	RecipientList pq.StringArray `db:"recipients"`
+}
+
+func (row *reportSubscriptionRow) toSubscription() domain.ReportSubscription {
+	subscription := row.ReportSubscription
+	subscription.Recipients = []string(row.RecipientList)
+	return subscription
+}
+
+type reportDeliveryRow struct {
+	domain.ReportDelivery
+	RecipientList pq.StringArray `db:"recipients"`
+}
+
+func (row *reportDeliveryRow) toDelivery() domain.ReportDelivery {
+	delivery := row.ReportDelivery
+	delivery.Recipients = []string(row.RecipientList)
+	return delivery
+}
+
+const reportSubscriptionColumns = `id, owner_id, name, report_type, format, params, period, recipients, schedule, enabled, next_run_at, last_run_at, created_at, updated_at`
+
+const reportDeliveryColumns = `id, subscription_id, report_type, format, params, recipients, period_start, period_end, status, attempts, next_attempt_at, file_name, error, scheduled_at, started_at, sent_at, created_at`
+
+func (r *PostgresAudienceRepository) CreateReportSubscription(ctx context.Context, subscription *domain.ReportSubscription) error {
+	query := `
+		INSERT INTO report_subscriptions (owner_id, name, report_type, format, params, period, recipients, schedule, enabled, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, updated_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		subscription.OwnerID,
+		subscription.Name,
+		subscription.ReportType,
+		subscription.Format,
+		string(subscription.Params),
+		subscription.Period,
+		pq.Array(subscription.Recipients),
+		subscription.Schedule,
+		subscription.Enabled,
+		subscription.NextRunAt,
+	).Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt); err != nil {
+		return fmt.Errorf("insert report subscription: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) UpdateReportSubscription(ctx context.Context, subscription *domain.ReportSubscription) error {
+	query := `
+		UPDATE report_subscriptions SET
+			name = $2,
+			report_type = $3,
+			format = $4,
+			params = $5,
+			period = $6,
+			recipients = $7,
+			schedule = $8,
+			enabled = $9,
+			next_run_at = $10,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		subscription.ID,
+		subscription.Name,
+		subscription.ReportType,
+		subscription.Format,
+		string(subscription.Params),
+		subscription.Period,
+		pq.Array(subscription.Recipients),
+		subscription.Schedule,
+		subscription.Enabled,
+		subscription.NextRunAt,
+	).Scan(&subscription.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("report subscription %d: %w", subscription.ID, domain.ErrNotFound)
+		}
+		return fmt.Errorf("update report subscription: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) GetReportSubscription(ctx context.Context, id int64) (*domain.ReportSubscription, error) {
+	var row reportSubscriptionRow
+	query := `SELECT ` + reportSubscriptionColumns + ` FROM report_subscriptions WHERE id = $1`
+	if err := r.db.GetContext(ctx, &row, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("report subscription %d: %w", id, domain.ErrNotFound)
+		}
+		return nil, fmt.Errorf("select report subscription: %w", err)
+	}
+	subscription := row.toSubscription()
+	return &subscription, nil
+}
+
+func (r *PostgresAudienceRepository) ListReportSubscriptions(ctx context.Context, ownerID string) ([]domain.ReportSubscription, error) {
+	var rows []reportSubscriptionRow
+	query := `
+		SELECT ` + reportSubscriptionColumns + `
+		FROM report_subscriptions
+		WHERE owner_id = $1
+		ORDER BY name, id`
+	if err := r.db.SelectContext(ctx, &rows, query, ownerID); err != nil {
+		return nil, fmt.Errorf("select report subscriptions: %w", err)
+	}
+	return toSubscriptions(rows), nil
+}
+
+func (r *PostgresAudienceRepository) DeleteReportSubscription(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM report_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete report subscription: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("report subscription %d: %w", id, domain.ErrNotFound)
+	}
+	return nil
+}
+
+// Включенные подписки, время отправки которых уже наступило
+func (r *PostgresAudienceRepository) ListDueReportSubscriptions(ctx context.Context, now time.Time) ([]domain.ReportSubscription, error) {
+	var rows []reportSubscriptionRow
+	query := `
+		SELECT ` + reportSubscriptionColumns + `
+		FROM report_subscriptions
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at`
+	if err := r.db.SelectContext(ctx, &rows, query, now); err != nil {
+		return nil, fmt.Errorf("select due report subscriptions: %w", err)
+	}
+	return toSubscriptions(rows), nil
+}
+
+// Переносит подписку на следующий запуск и ставит отправку в очередь одной транзакцией.
+// Если другой экземпляр сервиса уже перенес подписку, возвращает false и ничего не создает
+func (r *PostgresAudienceRepository) ScheduleReportDelivery(ctx context.Context, delivery *domain.ReportDelivery, dueAt time.Time, nextRunAt *time.Time) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE report_subscriptions SET next_run_at = $2, last_run_at = $3
+		WHERE id = $1 AND enabled AND next_run_at = $4`
+	result, err := tx.ExecContext(ctx, query, delivery.SubscriptionID, nextRunAt, delivery.ScheduledAt, dueAt)
+	if err != nil {
+		return false, fmt.Errorf("advance report subscription: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, nil
+	}
+
+	if err := insertReportDelivery(ctx, tx, delivery); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// Внеочередная отправка, расписание подписки не меняется
+func (r *PostgresAudienceRepository) CreateReportDelivery(ctx context.Context, delivery *domain.ReportDelivery) error {
+	return insertReportDelivery(ctx, r.db, delivery)
+}
+
+func insertReportDelivery(ctx context.Context, db sqlx.QueryerContext, delivery *domain.ReportDelivery) error {
+	query := `
+		INSERT INTO report_deliveries (subscription_id, report_type, format, params, recipients, period_start, period_end, status, scheduled_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING id, next_attempt_at, created_at`
+	if err := db.QueryRowxContext(ctx, query,
+		delivery.SubscriptionID,
+		delivery.ReportType,
+		delivery.Format,
+		string(delivery.Params),
+		pq.Array(delivery.Recipients),
+		delivery.PeriodStart,
+		delivery.PeriodEnd,
+		domain.DeliveryStatusPending,
+		delivery.ScheduledAt,
+	).Scan(&delivery.ID, &delivery.NextAttemptAt, &delivery.CreatedAt); err != nil {
+		return fmt.Errorf("insert report delivery: %w", err)
+	}
+	delivery.Status = domain.DeliveryStatusPending
+	return nil
+}
+
+// Забирает отправку, время попытки которой наступило. Отправки, зависшие в работе дольше
+// startedBefore до передачи письма, например после перезапуска сервиса, забираются повторно
+func (r *PostgresAudienceRepository) ClaimReportDelivery(ctx context.Context, startedBefore time.Time) (*domain.ReportDelivery, error) {
+	var row reportDeliveryRow
+	query := `
+		UPDATE report_deliveries SET status = $1, attempts = attempts + 1, started_at = NOW(), mailed_at = NULL
+		WHERE id = (
+			SELECT id FROM report_deliveries
+			WHERE (status = $2 AND next_attempt_at <= NOW())
+				OR (status = $1 AND started_at < $3 AND mailed_at IS NULL)
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + reportDeliveryColumns
+	if err := r.db.GetContext(ctx, &row, query,
+		domain.DeliveryStatusSending, domain.DeliveryStatusPending, startedBefore); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("claim report delivery: %w", err)
+	}
+	delivery := row.toDelivery()
+	return &delivery, nil
+}
+
+// Отмечает, что письмо передается почтовому серверу. false - отправку уже забрал другой воркер
+// как зависшую, и письмо отправлять нельзя
+func (r *PostgresAudienceRepository) MarkReportDeliveryMailed(ctx context.Context, id int64, startedAt time.Time) (bool, error) {
+	query := `
+		UPDATE report_deliveries SET mailed_at = NOW()
+		WHERE id = $1 AND status = $2 AND started_at = $3 AND mailed_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, id, domain.DeliveryStatusSending, startedAt)
+	if err != nil {
+		return false, fmt.Errorf("mark report delivery mailed: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// Отправки, прерванные после передачи письма: дошло ли письмо, неизвестно, поэтому они
+// не повторяются, а завершаются ошибкой
+func (r *PostgresAudienceRepository) FailInterruptedReportDeliveries(ctx context.Context, startedBefore time.Time, message string) (int64, error) {
+	query := `
+		UPDATE report_deliveries SET status = $1, error = $2
+		WHERE status = $3 AND started_at < $4 AND mailed_at IS NOT NULL`
+	result, err := r.db.ExecContext(ctx, query, domain.DeliveryStatusFailed, message, domain.DeliveryStatusSending, startedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("fail interrupted report deliveries: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows, nil
+}
+
+func (r *PostgresAudienceRepository) CompleteReportDelivery(ctx context.Context, id int64, fileName string) error {
+	query := `UPDATE report_deliveries SET status = $2, file_name = $3, error = '', sent_at = NOW() WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, domain.DeliveryStatusSent, fileName); err != nil {
+		return fmt.Errorf("complete report delivery: %w", err)
+	}
+	return nil
+}
+
+// Возвращает отправку в очередь с новым временем попытки
+func (r *PostgresAudienceRepository) RetryReportDelivery(ctx context.Context, id int64, message string, nextAttemptAt time.Time) error {
+	query := `UPDATE report_deliveries SET status = $2, error = $3, next_attempt_at = $4, started_at = NULL, mailed_at = NULL WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, domain.DeliveryStatusPending, message, nextAttemptAt); err != nil {
+		return fmt.Errorf("retry report delivery: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) FailReportDelivery(ctx context.Context, id int64, message string) error {
+	query := `UPDATE report_deliveries SET status = $2, error = $3 WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, domain.DeliveryStatusFailed, message); err != nil {
+		return fmt.Errorf("fail report delivery: %w", err)
+	}
+	return nil
+}
+
+// Последние отправки по подписке, новые первыми
+func (r *PostgresAudienceRepository) ListReportDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]domain.ReportDelivery, error) {
+	var rows []reportDeliveryRow
+	query := `
+		SELECT ` + reportDeliveryColumns + `
+		FROM report_deliveries
+		WHERE subscription_id = $1
+		ORDER BY id DESC
+		LIMIT $2`
+	if err := r.db.SelectContext(ctx, &rows, query, subscriptionID, limit); err != nil {
+		return nil, fmt.Errorf("select report deliveries: %w", err)
+	}
+
+	deliveries := make([]domain.ReportDelivery, 0, len(rows))
+	for i := range rows {
+		deliveries = append(deliveries, rows[i].toDelivery())
+	}
+	return deliveries, nil
+}
+
+func toSubscriptions(rows []reportSubscriptionRow) []domain.ReportSubscription {
+	subscriptions := make([]domain.ReportSubscription, 0, len(rows))
+	for i := range rows {
+		subscriptions = append(subscriptions, rows[i].toSubscription())
+	}
+	return subscriptions
+}
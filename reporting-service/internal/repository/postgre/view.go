@@ -0,0 +1,161 @@
+package postgre
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"reporting-service/internal/domain"
+)
+
+type applicationViewRow struct {
+	domain.ApplicationView
+	FilterJSON []byte         `db:"filter"`
+	ColumnList pq.StringArray `db:"columns"`
+}
+
+func (row *applicationViewRow) toView() (domain.ApplicationView, error) {
+	view := row.ApplicationView
+	if err := json.Unmarshal(row.FilterJSON, &view.Filter); err != nil {
+		return domain.ApplicationView{}, fmt.Errorf("decode view %d filter: %w", view.ID, err)
+	}
+	view.Columns = []string(row.ColumnList)
+	return view, nil
+}
+
+const applicationViewColumns = `id, name, owner_id, shared, filter, order_field, order_direction, columns, created_at, updated_at`
+
+func (r *PostgresAudienceRepository) CreateApplicationView(ctx context.Context, view *domain.ApplicationView) error {
+	filter, err := json.Marshal(view.Filter)
+	if err != nil {
+		return fmt.Errorf("encode view filter: %w", err)
+	}
+
+	query := `
+		INSERT INTO application_views (name, owner_id, shared, filter, order_field, order_direction, columns)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		view.Name,
+		view.OwnerID,
+		view.Shared,
+		filter,
+		view.OrderField,
+		view.OrderDirection,
+		pq.Array(view.Columns),
+	).Scan(&view.ID, &view.CreatedAt, &view.UpdatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return duplicateViewNameError(view.Name)
+		}
+		return fmt.Errorf("insert application view: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) UpdateApplicationView(ctx context.Context, view *domain.ApplicationView) error {
+	filter, err := json.Marshal(view.Filter)
+	if err != nil {
+		return fmt.Errorf("encode view filter: %w", err)
+	}
+
+	query := `
+		UPDATE application_views SET
+			name = $2,
+			shared = $3,
+			filter = $4,
+			order_field = $5,
+			order_direction = $6,
+			columns = $7,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at`
+	if err := r.db.QueryRowxContext(ctx, query,
+		view.ID,
+		view.Name,
+		view.Shared,
+		filter,
+		view.OrderField,
+		view.OrderDirection,
+		pq.Array(view.Columns),
+	).Scan(&view.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("application view %d: %w", view.ID, domain.ErrNotFound)
+		}
+		if isUniqueViolation(err) {
+			return duplicateViewNameError(view.Name)
+		}
+		return fmt.Errorf("update application view: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAudienceRepository) GetApplicationView(ctx context.Context, id int64) (*domain.ApplicationView, error) {
+	var row applicationViewRow
+	query := `SELECT ` + applicationViewColumns + ` FROM application_views WHERE id = $1`
+	if err := r.db.GetContext(ctx, &row, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("application view %d: %w", id, domain.ErrNotFound)
+		}
+		return nil, fmt.Errorf("select application view: %w", err)
+	}
+
+	view, err := row.toView()
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// Представления пользователя и общие представления остальных
+func (r *PostgresAudienceRepository) ListApplicationViews(ctx context.Context, ownerID string) ([]domain.ApplicationView, error) {
+	var rows []applicationViewRow
+	query := `
+		SELECT ` + applicationViewColumns + `
+		FROM application_views
+		WHERE owner_id = $1 OR shared
+		ORDER BY owner_id = $1 DESC, name`
+	if err := r.db.SelectContext(ctx, &rows, query, ownerID); err != nil {
+		return nil, fmt.Errorf("select application views: %w", err)
+	}
+
+	views := make([]domain.ApplicationView, 0, len(rows))
+	for i := range rows {
+		view, err := rows[i].toView()
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+func (r *PostgresAudienceRepository) DeleteApplicationView(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM application_views WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete application view: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("application view %d: %w", id, domain.ErrNotFound)
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// Таблица еще не создана: миграция не применена
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
+
+func duplicateViewNameError(name string) error {
+	return domain.NewValidationError("name", domain.ValidationCodeDuplicate,
+		fmt.Sprintf("view %q already exists", name))
+}
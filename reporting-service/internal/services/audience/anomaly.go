@@ -0,0 +1,248 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"reporting-service/internal/domain"
+)
+
+// Минимальное число менеджеров, при котором имеет смысл сравнивать с командой
+const (
+	minAnomalyTeamSize  = 2
+	minZScoreSampleSize = 3
+)
+
+// Правила поиска аномалий. Нулевое значение отключает правило
+type AnomalyConfig struct {
+	// Показатели для проверки, по умолчанию все доли отчета
+	Metrics []string `yaml:"metrics"`
+	// Доля от среднего по команде, ниже которой значение считается аномальным, например 0.5
+	BelowAverage float64 `yaml:"below_average"`
+	// Порог отклонения от среднего по команде в стандартных отклонениях, например 2
+	ZScore float64 `yaml:"z_score"`
+	// Относительное падение последних 7 дней периода к 7 дням перед ними, например 0.3.
+	// По умолчанию выключено: правило делает два дополнительных запроса к MacroCRM
+	WeekDrop float64 `yaml:"week_drop"`
+	// Доли с меньшим знаменателем не проверяются: на паре заявок любое значение случайно
+	MinBase int `yaml:"min_base"`
+}
+
+var anomalyRules = map[string]string{
+	domain.AnomalyRuleBelowAverage: "ниже среднего по команде",
+	domain.AnomalyRuleZScore:       "отклонение от команды",
+	domain.AnomalyRuleWeekOverWeek: "падение к прошлой неделе",
+}
+
+// Отмечает аномальные значения показателей в строках отчета и собирает их общим списком
+func (s *Service) detectAnomalies(ctx context.Context, filter *domain.CallCenterReportFilter, funnel *domain.FunnelConfig, report *domain.CallCenterReport) error {
+	cfg := s.config.Anomalies
+	definitions := anomalyMetrics(cfg, filter.ShowOptional)
+	if len(report.Data) == 0 || len(definitions) == 0 {
+		return nil
+	}
+
+	// Неделя к неделе сравнивается по дням: последние 7 дней периода с 7 днями перед ними
+	var current, previous map[int]domain.ManagerMetrics
+	weekLabel := ""
+	if cfg.WeekDrop > 0 && !isZeroDate(filter.StartDate) && !isZeroDate(filter.EndDate) {
+		weekStart, whole := lastWeekStart(*filter.StartDate, *filter.EndDate)
+		if whole {
+			current = managerMetricsByID(report.Data)
+		} else {
+			weekLabel = " за последние 7 дней"
+			currentWeek, err := s.mysqlRepo.GetCallCenterReportData(ctx, &domain.CallCenterReportFilter{
+				StartDate:    &weekStart,
+				EndDate:      filter.EndDate,
+				ShowOptional: filter.ShowOptional,
+			}, funnel)
+			if err != nil {
+				return fmt.Errorf("get last week for anomalies: %w", err)
+			}
+			current = managerMetricsByID(currentWeek.Data)
+		}
+
+		start, end := weekStart.AddDate(0, 0, -7), filter.EndDate.AddDate(0, 0, -7)
+		previousWeek, err := s.mysqlRepo.GetCallCenterReportData(ctx, &domain.CallCenterReportFilter{
+			StartDate:    &start,
+			EndDate:      &end,
+			ShowOptional: filter.ShowOptional,
+		}, funnel)
+		if err != nil {
+			return fmt.Errorf("get previous week for anomalies: %w", err)
+		}
+		previous = managerMetricsByID(previousWeek.Data)
+	}
+
+	for _, definition := range definitions {
+		var values []float64
+		for _, row := range report.Data {
+			if value, ok := anomalyMetricValue(row, definition, cfg.MinBase); ok {
+				values = append(values, value)
+			}
+		}
+		mean, std := meanStd(values)
+
+		for i := range report.Data {
+			row := &report.Data[i]
+			value, ok := anomalyMetricValue(*row, definition, cfg.MinBase)
+			if !ok {
+				continue
+			}
+
+			if cfg.BelowAverage > 0 && len(values) >= minAnomalyTeamSize && mean > 0 && value < mean*cfg.BelowAverage {
+				addAnomaly(row, definition, domain.Anomaly{
+					Rule:     domain.AnomalyRuleBelowAverage,
+					Value:    value,
+					Baseline: mean,
+					Message: fmt.Sprintf("%s %s ниже %s среднего по команде (%s)", definition.Title,
+						formatMetricValue(definition, value), formatShare(cfg.BelowAverage), formatMetricValue(definition, mean)),
+				})
+			}
+
+			if cfg.ZScore > 0 && len(values) >= minZScoreSampleSize && std > 0 {
+				score := (value - mean) / std
+				if math.Abs(score) >= cfg.ZScore {
+					direction := "выше"
+					if score < 0 {
+						direction = "ниже"
+					}
+					addAnomaly(row, definition, domain.Anomaly{
+						Rule:     domain.AnomalyRuleZScore,
+						Value:    value,
+						Baseline: mean,
+						Score:    &score,
+						Message: fmt.Sprintf("%s %s %s среднего по команде (%s) на %.1f стандартных отклонения", definition.Title,
+							formatMetricValue(definition, value), direction, formatMetricValue(definition, mean), math.Abs(score)),
+					})
+				}
+			}
+		}
+
+		if previous == nil {
+			continue
+		}
+		for i := range report.Data {
+			row := &report.Data[i]
+			value, ok := anomalyMetricValue(current[row.ManagerID], definition, cfg.MinBase)
+			if !ok {
+				continue
+			}
+			prev, ok := anomalyMetricValue(previous[row.ManagerID], definition, cfg.MinBase)
+			if !ok || prev <= 0 {
+				continue
+			}
+			if drop := (prev - value) / prev; drop >= cfg.WeekDrop {
+				addAnomaly(row, definition, domain.Anomaly{
+					Rule:     domain.AnomalyRuleWeekOverWeek,
+					Value:    value,
+					Baseline: prev,
+					Message: fmt.Sprintf("%s%s %s, неделей раньше %s: падение на %s", definition.Title, weekLabel,
+						formatMetricValue(definition, value), formatMetricValue(definition, prev), formatShare(drop)),
+				})
+			}
+		}
+	}
+
+	// Общий список по менеджерам в порядке строк и показателей отчета
+	report.Anomalies = nil
+	for _, row := range report.Data {
+		for _, definition := range definitions {
+			report.Anomalies = append(report.Anomalies, row.Anomalies[definition.Name]...)
+		}
+	}
+	return nil
+}
+
+// Начало последних 7 дней периода. Период не длиннее недели сравнивается целиком (whole)
+func lastWeekStart(start, end time.Time) (time.Time, bool) {
+	weekStart := truncateDay(end).AddDate(0, 0, -6)
+	if !weekStart.After(truncateDay(start)) {
+		return start, true
+	}
+	return weekStart, false
+}
+
+func managerMetricsByID(rows []domain.ManagerMetrics) map[int]domain.ManagerMetrics {
+	byID := make(map[int]domain.ManagerMetrics, len(rows))
+	for _, row := range rows {
+		byID[row.ManagerID] = row
+	}
+	return byID
+}
+
+// Показатели для проверки: заданные в настройках или все доли отчета
+func anomalyMetrics(cfg AnomalyConfig, showOptional bool) []domain.MetricDefinition {
+	selected := make(map[string]bool, len(cfg.Metrics))
+	for _, name := range cfg.Metrics {
+		selected[name] = true
+	}
+
+	var definitions []domain.MetricDefinition
+	for _, definition := range domain.CallCenterMetricDefinitions(showOptional) {
+		if len(selected) > 0 && !selected[definition.Name] {
+			continue
+		}
+		if len(selected) == 0 && definition.Unit != domain.MetricUnitRatio {
+			continue
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions
+}
+
+// Значение показателя, если его можно проверять: доля определена и знаменатель не меньше минимального
+func anomalyMetricValue(row domain.ManagerMetrics, definition domain.MetricDefinition, minBase int) (float64, bool) {
+	if definition.Unit != domain.MetricUnitRatio {
+		count, ok := row.Count(definition.Name)
+		return float64(count), ok
+	}
+	ratio, ok := row.Ratios[definition.Name]
+	if !ok || ratio.Value == nil || ratio.Denominator < minBase {
+		return 0, false
+	}
+	return *ratio.Value, true
+}
+
+func addAnomaly(row *domain.ManagerMetrics, definition domain.MetricDefinition, anomaly domain.Anomaly) {
+	anomaly.ManagerID = row.ManagerID
+	anomaly.ManagerName = row.ManagerName
+	anomaly.Metric = definition.Name
+	if row.Anomalies == nil {
+		row.Anomalies = make(map[string][]domain.Anomaly)
+	}
+	row.Anomalies[definition.Name] = append(row.Anomalies[definition.Name], anomaly)
+	row.IsAnomaly = true
+}
+
+// Среднее и стандартное отклонение по генеральной совокупности
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+func formatMetricValue(definition domain.MetricDefinition, value float64) string {
+	if definition.Unit == domain.MetricUnitRatio {
+		return formatShare(value)
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64)
+}
+
+func formatShare(value float64) string {
+	return strconv.FormatFloat(value*100, 'f', 1, 64) + "%"
+}
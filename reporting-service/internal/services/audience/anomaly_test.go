@@ -0,0 +1,132 @@
+package audience
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"reporting-service/internal/domain"
+)
+
+// Строка отчета с долей целевых target/total
+func anomalyRow(id, target, total int) domain.ManagerMetrics {
+	row := domain.ManagerMetrics{ManagerID: id, TotalInquiries: total, TargetInquiries: target}
+	row.CalculateRatios(domain.CallCenterMetricDefinitions(false))
+	return row
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	cfg := AnomalyConfig{
+		Metrics:      []string{"target_conversion"},
+		BelowAverage: 0.5,
+		ZScore:       2,
+		MinBase:      10,
+	}
+
+	tests := []struct {
+		name string
+		rows []domain.ManagerMetrics
+		// Правила аномалий по менеджерам
+		want map[int][]string
+	}{
+		{name: "empty report"},
+		{
+			name: "below average and z-score",
+			rows: []domain.ManagerMetrics{
+				anomalyRow(1, 5, 10), anomalyRow(2, 5, 10), anomalyRow(3, 5, 10),
+				anomalyRow(4, 5, 10), anomalyRow(5, 5, 10), anomalyRow(6, 1, 10),
+			},
+			want: map[int][]string{6: {domain.AnomalyRuleBelowAverage, domain.AnomalyRuleZScore}},
+		},
+		{
+			// Среднее нулевое: сравнивать с ним нечего
+			name: "zero mean",
+			rows: []domain.ManagerMetrics{anomalyRow(1, 0, 10), anomalyRow(2, 0, 20), anomalyRow(3, 0, 30)},
+		},
+		{
+			// Все значения одинаковые: стандартное отклонение нулевое
+			name: "zero std",
+			rows: []domain.ManagerMetrics{anomalyRow(1, 3, 10), anomalyRow(2, 6, 20), anomalyRow(3, 9, 30)},
+		},
+		{
+			// Доли с маленьким знаменателем не проверяются и не входят в среднее
+			name: "min base",
+			rows: []domain.ManagerMetrics{
+				anomalyRow(1, 5, 10), anomalyRow(2, 5, 10), anomalyRow(3, 5, 10), anomalyRow(4, 0, 9),
+			},
+		},
+		{
+			// Без знаменателя доля не определена
+			name: "undefined ratio",
+			rows: []domain.ManagerMetrics{anomalyRow(1, 5, 10), anomalyRow(2, 5, 10), anomalyRow(3, 0, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{config: Config{Anomalies: cfg}}
+			report := &domain.CallCenterReport{Data: tt.rows}
+			filter := &domain.CallCenterReportFilter{}
+
+			if err := s.detectAnomalies(context.Background(), filter, nil, report); err != nil {
+				t.Fatalf("detectAnomalies: %v", err)
+			}
+
+			got := map[int][]string{}
+			for _, row := range report.Data {
+				var rules []string
+				for _, anomaly := range row.Anomalies["target_conversion"] {
+					rules = append(rules, anomaly.Rule)
+				}
+				if row.IsAnomaly != (len(rules) > 0) {
+					t.Errorf("manager %d: IsAnomaly = %v with anomalies %v", row.ManagerID, row.IsAnomaly, rules)
+				}
+				if len(rules) > 0 {
+					got[row.ManagerID] = rules
+				}
+			}
+			want := tt.want
+			if want == nil {
+				want = map[int][]string{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("anomalies = %v, want %v", got, want)
+			}
+
+			count := 0
+			for _, rules := range want {
+				count += len(rules)
+			}
+			if len(report.Anomalies) != count {
+				t.Errorf("report has %d anomalies, want %d", len(report.Anomalies), count)
+			}
+		})
+	}
+}
+
+func TestLastWeekStart(t *testing.T) {
+	date := func(day, hour int) time.Time {
+		return time.Date(2025, time.January, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       time.Time
+		whole      bool
+	}{
+		{"one day", date(15, 0), date(15, 23), date(15, 0), true},
+		{"week", date(6, 0), date(12, 23), date(6, 0), true},
+		{"eight days", date(5, 0), date(12, 23), date(6, 0), false},
+		{"month", date(1, 0), date(31, 23), date(25, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, whole := lastWeekStart(tt.start, tt.end)
+			if !got.Equal(tt.want) || whole != tt.whole {
+				t.Errorf("lastWeekStart = %v, %v, want %v, %v", got, whole, tt.want, tt.whole)
+			}
+		})
+	}
+}
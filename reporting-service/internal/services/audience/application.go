@@ -0,0 +1,48 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+
+	"reporting-service/internal/domain"
+)
+
+func (s *Service) GetApplicationDetail(ctx context.Context, id int64) (*domain.ApplicationDetailResponse, error) {
+	detail, err := s.mysqlRepo.GetApplicationDetail(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get application: %w", err)
+	}
+
+	timeline, err := s.mysqlRepo.GetStatusTimeline(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get status timeline: %w", err)
+	}
+	detail.Timeline = timeline
+	detail.StatusTotals = statusTotals(timeline)
+
+	audiences, err := s.audienceRepo.ListAudiencesByApplication(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get application audiences: %w", err)
+	}
+	detail.Audiences = audiences
+
+	return detail, nil
+}
+
+// Суммы по статусам в порядке первого попадания заявки в статус
+func statusTotals(timeline []domain.StatusTimelineEntry) []domain.StatusTimeTotal {
+	totals := []domain.StatusTimeTotal{}
+	index := map[string]int{}
+	for _, entry := range timeline {
+		i, ok := index[entry.ToStatus]
+		if !ok {
+			i = len(totals)
+			index[entry.ToStatus] = i
+			totals = append(totals, domain.StatusTimeTotal{StatusName: entry.ToStatus})
+		}
+		totals[i].Visits++
+		totals[i].DurationSeconds += entry.DurationSeconds
+		totals[i].DurationDays += entry.DurationDays
+	}
+	return totals
+}
@@ -0,0 +1,263 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"reporting-service/internal/domain"
+)
+
+var comparisonModes = map[string]string{
+	domain.ComparePreviousPeriod: "предыдущий период",
+	domain.ComparePreviousYear:   "тот же период прошлого года",
+	domain.CompareCustom:         "произвольный период",
+}
+
+// Отчет по колл-центру с отмеченными аномалиями, при заданном режиме сравнения - с колонками второго периода и изменений
+func (s *Service) callCenterReport(ctx context.Context, filter *domain.CallCenterReportFilter) (*domain.CallCenterReport, error) {
+	funnel, err := s.reportFunnel(ctx, filter.Funnel)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := s.mysqlRepo.GetCallCenterReportData(ctx, filter, funnel)
+	if err != nil {
+		return nil, err
+	}
+	report.Funnel, report.FunnelName = funnel.Code, funnel.Name
+
+	if err := s.detectAnomalies(ctx, filter, funnel, report); err != nil {
+		return nil, err
+	}
+	if filter.Compare == domain.CompareNone {
+		return report, nil
+	}
+
+	period, err := comparisonPeriod(filter)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.mysqlRepo.GetCallCenterReportData(ctx, &domain.CallCenterReportFilter{
+		StartDate:    &period.CompareStartDate,
+		EndDate:      &period.CompareEndDate,
+		ShowOptional: filter.ShowOptional,
+	}, funnel)
+	if err != nil {
+		return nil, fmt.Errorf("get comparison period: %w", err)
+	}
+
+	mergeCallCenterComparison(report, previous)
+	report.Comparison = period
+	return report, nil
+}
+
+// Проверяет режим сравнения. Основной период здесь не нужен: в фоновой выгрузке и рассылке
+// его даты могут появиться позже
+func validateComparison(filter *domain.CallCenterReportFilter) error {
+	if filter.Compare == domain.CompareNone {
+		return nil
+	}
+	if _, ok := comparisonModes[filter.Compare]; !ok {
+		return domain.NewValidationError("compare", domain.ValidationCodeInvalidValue,
+			fmt.Sprintf("unknown comparison mode %q", filter.Compare))
+	}
+	if filter.Compare != domain.CompareCustom {
+		return nil
+	}
+
+	result := &domain.ValidationResult{}
+	if isZeroDate(filter.CompareStartDate) {
+		result.AddError("compare_start_date", domain.ValidationCodeRequired, "compare_start_date is required for custom comparison")
+	}
+	if isZeroDate(filter.CompareEndDate) {
+		result.AddError("compare_end_date", domain.ValidationCodeRequired, "compare_end_date is required for custom comparison")
+	}
+	if !isZeroDate(filter.CompareStartDate) && !isZeroDate(filter.CompareEndDate) && filter.CompareStartDate.After(*filter.CompareEndDate) {
+		result.AddError("compare_start_date", domain.ValidationCodeInvalidRange, "compare_start_date must be before compare_end_date")
+	}
+	return result.Err()
+}
+
+// Период сравнения: предыдущий период той же длины сразу перед основным,
+// те же даты годом раньше или явно заданные даты
+func comparisonPeriod(filter *domain.CallCenterReportFilter) (*domain.ComparisonPeriod, error) {
+	if err := validateComparison(filter); err != nil {
+		return nil, err
+	}
+
+	result := &domain.ValidationResult{}
+	if isZeroDate(filter.StartDate) {
+		result.AddError("start_date", domain.ValidationCodeRequired, "start_date is required for comparison")
+	}
+	if isZeroDate(filter.EndDate) {
+		result.AddError("end_date", domain.ValidationCodeRequired, "end_date is required for comparison")
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	start, end := truncateDay(*filter.StartDate), truncateDay(*filter.EndDate)
+	if start.After(end) {
+		return nil, domain.NewValidationError("start_date", domain.ValidationCodeInvalidRange, "start_date must be before end_date")
+	}
+
+	period := &domain.ComparisonPeriod{
+		Mode:      filter.Compare,
+		StartDate: start,
+		EndDate:   end,
+	}
+	switch filter.Compare {
+	case domain.ComparePreviousPeriod:
+		days := int(math.Round(end.Sub(start).Hours()/24)) + 1
+		period.CompareStartDate = start.AddDate(0, 0, -days)
+		period.CompareEndDate = start.AddDate(0, 0, -1)
+	case domain.ComparePreviousYear:
+		period.CompareStartDate = start.AddDate(-1, 0, 0)
+		period.CompareEndDate = end.AddDate(-1, 0, 0)
+	case domain.CompareCustom:
+		period.CompareStartDate = truncateDay(*filter.CompareStartDate)
+		period.CompareEndDate = truncateDay(*filter.CompareEndDate)
+	}
+	return period, nil
+}
+
+// Дополняет строки и итог значениями периода сравнения. Менеджеры, у которых были заявки
+// только в периоде сравнения, добавляются с нулями в основном периоде
+func mergeCallCenterComparison(report *domain.CallCenterReport, previous *domain.CallCenterReport) {
+	metrics := comparisonMetricHeaders(report.Headers)
+
+	previousRows := make(map[int]domain.ManagerMetrics, len(previous.Data))
+	for _, row := range previous.Data {
+		previousRows[row.ManagerID] = row
+	}
+
+	for i := range report.Data {
+		row := &report.Data[i]
+		prev := previousRows[row.ManagerID]
+		delete(previousRows, row.ManagerID)
+		row.Comparison = comparisonValues(managerMetricsRow(*row), managerMetricsRow(prev), metrics)
+	}
+	for _, prev := range previousRows {
+		row := domain.ManagerMetrics{ManagerID: prev.ManagerID, ManagerName: prev.ManagerName}
+		if prev.HasOptional() {
+			row.InitOptional()
+		}
+		row.Comparison = comparisonValues(managerMetricsRow(row), managerMetricsRow(prev), metrics)
+		report.Data = append(report.Data, row)
+	}
+	sort.SliceStable(report.Data, func(i, j int) bool {
+		return report.Data[i].ManagerID < report.Data[j].ManagerID
+	})
+
+	report.Footer.Comparison = comparisonValues(managerMetricsRow(report.Footer), managerMetricsRow(previous.Footer), metrics)
+	report.Headers = comparisonHeaders(report.Headers)
+}
+
+// Сравниваются только числовые колонки
+func comparisonMetricHeaders(headers []domain.Header) []domain.Header {
+	var metrics []domain.Header
+	for _, header := range headers {
+		if header.Format == "number" || header.Format == "percent" {
+			metrics = append(metrics, header)
+		}
+	}
+	return metrics
+}
+
+// За каждой числовой колонкой идут значение за период сравнения, изменение и изменение в процентах.
+// Изменение долей (конверсий) выводится в процентных пунктах
+func comparisonHeaders(headers []domain.Header) []domain.Header {
+	result := make([]domain.Header, 0, len(headers)*4)
+	for _, header := range headers {
+		result = append(result, header)
+		if header.Format != "number" && header.Format != "percent" {
+			continue
+		}
+
+		prev := header
+		prev.Name += domain.ComparePrevSuffix
+		prev.Title += " (период сравнения)"
+		prev.IsSortable = false
+
+		delta := header
+		delta.Name += domain.CompareDeltaSuffix
+		delta.Title = "Изменение: " + header.Title
+		delta.IsSortable = false
+
+		deltaPct := header
+		deltaPct.Name += domain.CompareDeltaPctSuffix
+		deltaPct.Title = "Изменение, %: " + header.Title
+		deltaPct.Format = "percent"
+		deltaPct.IsSortable = false
+
+		result = append(result, prev, delta, deltaPct)
+	}
+	return result
+}
+
+// Относительное изменение не считается, если в периоде сравнения был ноль.
+// Изменение неопределенной доли пустое
+func comparisonValues(current, previous map[string]interface{}, metrics []domain.Header) map[string]interface{} {
+	values := make(map[string]interface{}, len(metrics)*3)
+	for _, header := range metrics {
+		// Неопределенная доля не сравнивается
+		if previous[header.Name] == nil {
+			values[header.Name+domain.ComparePrevSuffix] = nil
+			values[header.Name+domain.CompareDeltaSuffix] = nil
+			values[header.Name+domain.CompareDeltaPctSuffix] = nil
+			continue
+		}
+
+		cur, curInt := comparisonNumber(current[header.Name])
+		prev, prevInt := comparisonNumber(previous[header.Name])
+
+		if prevInt {
+			values[header.Name+domain.ComparePrevSuffix] = int(prev)
+		} else {
+			values[header.Name+domain.ComparePrevSuffix] = prev
+		}
+		if current[header.Name] == nil {
+			values[header.Name+domain.CompareDeltaSuffix] = nil
+			values[header.Name+domain.CompareDeltaPctSuffix] = nil
+			continue
+		}
+		if curInt && prevInt {
+			values[header.Name+domain.CompareDeltaSuffix] = int(cur - prev)
+		} else {
+			values[header.Name+domain.CompareDeltaSuffix] = cur - prev
+		}
+		if prev != 0 {
+			values[header.Name+domain.CompareDeltaPctSuffix] = (cur - prev) / math.Abs(prev)
+		} else {
+			values[header.Name+domain.CompareDeltaPctSuffix] = nil
+		}
+	}
+	return values
+}
+
+func comparisonNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, false
+	}
+	return 0, true
+}
+
+func comparisonPeriodLabel(period *domain.ComparisonPeriod) string {
+	return fmt.Sprintf("%s – %s", period.CompareStartDate.Format("02.01.2006"), period.CompareEndDate.Format("02.01.2006"))
+}
+
+func isZeroDate(value *time.Time) bool {
+	return value == nil || value.IsZero()
+}
+
+func truncateDay(value time.Time) time.Time {
+	return time.Date(value.Year(), value.Month(), value.Day(), 0, 0, 0, 0, value.Location())
+}
@@ -0,0 +1,205 @@
+package audience
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"reporting-service/internal/domain"
+)
+
+func TestComparisonPeriod(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	date := func(loc *time.Location, year int, month time.Month, day int) *time.Time {
+		value := time.Date(year, month, day, 0, 0, 0, 0, loc)
+		return &value
+	}
+	utc := func(year int, month time.Month, day int) *time.Time {
+		return date(time.UTC, year, month, day)
+	}
+
+	tests := []struct {
+		name      string
+		filter    domain.CallCenterReportFilter
+		wantStart *time.Time
+		wantEnd   *time.Time
+		wantErr   bool
+	}{
+		{
+			name:      "previous day",
+			filter:    domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod, StartDate: utc(2024, 3, 10), EndDate: utc(2024, 3, 10)},
+			wantStart: utc(2024, 3, 9),
+			wantEnd:   utc(2024, 3, 9),
+		},
+		{
+			name:      "previous period of a month crosses february",
+			filter:    domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod, StartDate: utc(2024, 3, 1), EndDate: utc(2024, 3, 31)},
+			wantStart: utc(2024, 1, 30),
+			wantEnd:   utc(2024, 2, 29),
+		},
+		{
+			name: "time of day is dropped",
+			filter: domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod,
+				StartDate: func() *time.Time { v := time.Date(2024, 5, 6, 15, 30, 0, 0, time.UTC); return &v }(),
+				EndDate:   func() *time.Time { v := time.Date(2024, 5, 12, 23, 59, 59, 0, time.UTC); return &v }()},
+			wantStart: utc(2024, 4, 29),
+			wantEnd:   utc(2024, 5, 5),
+		},
+		{
+			// Период с переходом на летнее время на час короче целого числа суток
+			name:      "spring dst",
+			filter:    domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod, StartDate: date(berlin, 2024, 3, 25), EndDate: date(berlin, 2024, 4, 7)},
+			wantStart: date(berlin, 2024, 3, 11),
+			wantEnd:   date(berlin, 2024, 3, 24),
+		},
+		{
+			name:      "autumn dst",
+			filter:    domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod, StartDate: date(berlin, 2024, 10, 21), EndDate: date(berlin, 2024, 11, 3)},
+			wantStart: date(berlin, 2024, 10, 7),
+			wantEnd:   date(berlin, 2024, 10, 20),
+		},
+		{
+			name:      "previous year",
+			filter:    domain.CallCenterReportFilter{Compare: domain.ComparePreviousYear, StartDate: utc(2024, 1, 1), EndDate: utc(2024, 1, 31)},
+			wantStart: utc(2023, 1, 1),
+			wantEnd:   utc(2023, 1, 31),
+		},
+		{
+			// 29 февраля в невисокосном году переносится на 1 марта
+			name:      "previous year from leap day",
+			filter:    domain.CallCenterReportFilter{Compare: domain.ComparePreviousYear, StartDate: utc(2024, 2, 29), EndDate: utc(2024, 3, 31)},
+			wantStart: utc(2023, 3, 1),
+			wantEnd:   utc(2023, 3, 31),
+		},
+		{
+			name: "custom",
+			filter: domain.CallCenterReportFilter{Compare: domain.CompareCustom, StartDate: utc(2024, 3, 1), EndDate: utc(2024, 3, 31),
+				CompareStartDate: utc(2023, 12, 1), CompareEndDate: utc(2023, 12, 15)},
+			wantStart: utc(2023, 12, 1),
+			wantEnd:   utc(2023, 12, 15),
+		},
+		{
+			name:    "missing period",
+			filter:  domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod, StartDate: utc(2024, 3, 1)},
+			wantErr: true,
+		},
+		{
+			name:    "start after end",
+			filter:  domain.CallCenterReportFilter{Compare: domain.ComparePreviousPeriod, StartDate: utc(2024, 3, 2), EndDate: utc(2024, 3, 1)},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			filter:  domain.CallCenterReportFilter{Compare: "week", StartDate: utc(2024, 3, 1), EndDate: utc(2024, 3, 7)},
+			wantErr: true,
+		},
+		{
+			name:    "custom without dates",
+			filter:  domain.CallCenterReportFilter{Compare: domain.CompareCustom, StartDate: utc(2024, 3, 1), EndDate: utc(2024, 3, 7)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period, err := comparisonPeriod(&tt.filter)
+			if tt.wantErr {
+				var validationErr *domain.ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("err = %v, want validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("comparisonPeriod: %v", err)
+			}
+			if !period.CompareStartDate.Equal(*tt.wantStart) || !period.CompareEndDate.Equal(*tt.wantEnd) {
+				t.Errorf("compare period = %s - %s, want %s - %s", period.CompareStartDate, period.CompareEndDate, *tt.wantStart, *tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestComparisonValues(t *testing.T) {
+	metrics := []domain.Header{{Name: "total_inquiries"}, {Name: "target_conversion"}}
+
+	tests := []struct {
+		name     string
+		current  map[string]interface{}
+		previous map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{
+			name:     "counts and ratios",
+			current:  map[string]interface{}{"total_inquiries": 12, "target_conversion": 0.6},
+			previous: map[string]interface{}{"total_inquiries": 10, "target_conversion": 0.4},
+			want: map[string]interface{}{
+				"total_inquiries_prev": 10, "total_inquiries_delta": 2, "total_inquiries_delta_pct": 0.2,
+				"target_conversion_prev": 0.4, "target_conversion_delta": 0.2, "target_conversion_delta_pct": 0.5,
+			},
+		},
+		{
+			name:     "nil previous ratio",
+			current:  map[string]interface{}{"total_inquiries": 5, "target_conversion": 0.6},
+			previous: map[string]interface{}{"total_inquiries": 0, "target_conversion": nil},
+			want: map[string]interface{}{
+				// Относительное изменение от нуля не считается
+				"total_inquiries_prev": 0, "total_inquiries_delta": 5, "total_inquiries_delta_pct": nil,
+				"target_conversion_prev": nil, "target_conversion_delta": nil, "target_conversion_delta_pct": nil,
+			},
+		},
+		{
+			name:     "nil current ratio",
+			current:  map[string]interface{}{"total_inquiries": 0, "target_conversion": nil},
+			previous: map[string]interface{}{"total_inquiries": 4, "target_conversion": 0.25},
+			want: map[string]interface{}{
+				"total_inquiries_prev": 4, "total_inquiries_delta": -4, "total_inquiries_delta_pct": -1.0,
+				"target_conversion_prev": 0.25, "target_conversion_delta": nil, "target_conversion_delta_pct": nil,
+			},
+		},
+		{
+			name:     "zero base ratio",
+			current:  map[string]interface{}{"total_inquiries": 3, "target_conversion": 0.5},
+			previous: map[string]interface{}{"total_inquiries": 3, "target_conversion": 0.0},
+			want: map[string]interface{}{
+				"total_inquiries_prev": 3, "total_inquiries_delta": 0, "total_inquiries_delta_pct": 0.0,
+				"target_conversion_prev": 0.0, "target_conversion_delta": 0.5, "target_conversion_delta_pct": nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := comparisonValues(tt.current, tt.previous, metrics)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d values, want %d: %v", len(got), len(tt.want), got)
+			}
+			for name, want := range tt.want {
+				value, ok := got[name]
+				if !ok {
+					t.Errorf("%s: missing", name)
+					continue
+				}
+				if !sameComparisonValue(value, want) {
+					t.Errorf("%s = %#v, want %#v", name, value, want)
+				}
+			}
+		})
+	}
+}
+
+// Доли сравниваются с допуском, количества и пустые значения - точно, вместе с типом
+func sameComparisonValue(got, want interface{}) bool {
+	gotFloat, gotOK := got.(float64)
+	wantFloat, wantOK := want.(float64)
+	if gotOK && wantOK {
+		return math.Abs(gotFloat-wantFloat) < 1e-9
+	}
+	return reflect.DeepEqual(got, want)
+}
@@ -0,0 +1,133 @@
+package audience
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"reporting-service/internal/domain"
+)
+
+const (
+	ExportFormatXLSX = "xlsx"
+	ExportFormatCSV  = "csv"
+	ExportFormatTSV  = "tsv"
+)
+
+// BOM нужен, чтобы Excel открывал UTF-8 файл без искажения кириллицы
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Через сколько строк буфер отправляется клиенту
+const csvFlushRows = 1000
+
+// Пишет заявки в CSV или TSV прямо в w по мере чтения из базы, не держа выгрузку в памяти
+func (e *ExcelExporter) StreamApplications(ctx context.Context, filter *domain.ApplicationFilterRequest, format string, w io.Writer, nonTarget domain.FunnelStage) error {
+	writer := csv.NewWriter(w)
+	if format == ExportFormatTSV {
+		writer.Comma = '\t'
+	}
+	// Excel по умолчанию ждет CRLF
+	writer.UseCRLF = true
+
+	var headers []domain.Header
+	record := []string{}
+	count := 0
+
+	err := e.mysqlRepo.StreamApplicationsWithFilters(ctx, filter, nonTarget,
+		func(exportHeaders []domain.Header) error {
+			headers = exportHeaders
+			if _, err := w.Write(utf8BOM); err != nil {
+				return fmt.Errorf("write bom: %w", err)
+			}
+			record = record[:0]
+			for _, header := range headers {
+				record = append(record, header.Title)
+			}
+			return writer.Write(record)
+		},
+		func(row map[string]interface{}) error {
+			record = record[:0]
+			for _, header := range headers {
+				record = append(record, csvValue(row[header.Name]))
+			}
+			if err := writer.Write(record); err != nil {
+				return fmt.Errorf("write row: %w", err)
+			}
+
+			count++
+			if count%csvFlushRows == 0 {
+				writer.Flush()
+				if flusher, ok := w.(interface{ Flush() }); ok {
+					flusher.Flush()
+				}
+			}
+			return writer.Error()
+		})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("flush csv: %w", err)
+	}
+
+	e.logger.Info("applications streamed successfully",
+		zap.String("format", format),
+		zap.Int("count", count))
+	return nil
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case time.Time:
+		return v.Format("02.01.2006 15:04")
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Текст из CRM, начинающийся с =, +, - или @, Excel считает формулой: выполняет ее
+// или портит телефоны вида +998... Апостроф в начале оставляет значение текстом
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// CSV или TSV выгрузка заявок в хранилище, для фоновых выгрузок
+func (e *ExcelExporter) ExportApplicationsCSV(ctx context.Context, filter *domain.ApplicationFilterRequest, format string, nonTarget domain.FunnelStage) (string, string, error) {
+	tmp, err := os.CreateTemp("", "export-*."+format)
+	if err != nil {
+		return "", "", fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := e.StreamApplications(ctx, filter, format, tmp, nonTarget); err != nil {
+		return "", "", err
+	}
+
+	fileName := exportFileName("applications_export", format)
+	key, err := e.saveFile(ctx, tmp, "APPLICATIONS_EXPORTS", fileName)
+	if err != nil {
+		return "", "", err
+	}
+	return key, fileName, nil
+}
@@ -0,0 +1,31 @@
+package audience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCSVValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"Иванов", "Иванов"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+998901234567", "'+998901234567"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+		{"a=b", "a=b"},
+		// Числа остаются числами, экранируется только текст
+		{int64(-5), "-5"},
+		{-0.5, "-0.5"},
+		{time.Date(2025, time.January, 2, 15, 4, 0, 0, time.UTC), "02.01.2025 15:04"},
+	}
+	for _, tt := range tests {
+		if got := csvValue(tt.value); got != tt.want {
+			t.Errorf("csvValue(%#v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
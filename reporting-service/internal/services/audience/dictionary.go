@@ -0,0 +1,151 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"reporting-service/internal/domain"
+)
+
+func (s *Service) GetFilterDictionary(ctx context.Context) (*domain.FilterDictionaryResponse, error) {
+	statuses, err := s.mysqlRepo.ListStatuses(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get statuses: %w", err)
+	}
+
+	reasons, err := s.mysqlRepo.ListReasons(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get reasons: %w", err)
+	}
+
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rejectionReasons, nonTargetReasons, err := s.mysqlRepo.ListReasonCategories(ctx, nonTarget)
+	if err != nil {
+		return nil, fmt.Errorf("get reasons by category: %w", err)
+	}
+
+	return &domain.FilterDictionaryResponse{
+		Statuses:         statuses,
+		Reasons:          reasons,
+		RejectionReasons: rejectionReasons,
+		NonTargetReasons: nonTargetReasons,
+	}, nil
+}
+
+// Дополняет фильтр идентификаторами по названиям, если клиент прислал только названия.
+// Идентификаторы приоритетнее названий, поэтому если хотя бы одно название не нашлось,
+// список не заполняется и фильтр работает по названиям
+func (s *Service) resolveFilterIDs(ctx context.Context, filter *domain.AudienceCreationFilter) error {
+	if len(filter.StatusIDs) == 0 && len(filter.StatusNames) > 0 {
+		items, err := s.mysqlRepo.ResolveStatusIDs(ctx, filter.StatusNames)
+		if err != nil {
+			return fmt.Errorf("resolve status ids: %w", err)
+		}
+		filter.StatusIDs = idsForAllNames(filter.StatusNames, items)
+	}
+
+	if len(filter.RejectionReasonIDs) == 0 && len(filter.RegectionReasonNames) > 0 {
+		items, err := s.mysqlRepo.ResolveReasonIDs(ctx, filter.RegectionReasonNames)
+		if err != nil {
+			return fmt.Errorf("resolve rejection reason ids: %w", err)
+		}
+		filter.RejectionReasonIDs = idsForAllNames(filter.RegectionReasonNames, items)
+	}
+
+	if len(filter.NonTargetReasonIDs) == 0 && len(filter.NonTargetReasonNames) > 0 {
+		items, err := s.mysqlRepo.ResolveReasonIDs(ctx, filter.NonTargetReasonNames)
+		if err != nil {
+			return fmt.Errorf("resolve non target reason ids: %w", err)
+		}
+		filter.NonTargetReasonIDs = idsForAllNames(filter.NonTargetReasonNames, items)
+	}
+
+	return nil
+}
+
+// Идентификаторы найденных записей, если нашлось каждое название, иначе nil.
+// Одному названию может соответствовать несколько идентификаторов; регистр, как и в MySQL, не важен
+func idsForAllNames(names []string, items []domain.DictionaryItem) []int64 {
+	found := make(map[string]bool, len(items))
+	ids := make([]int64, 0, len(items))
+	seen := make(map[int64]bool, len(items))
+	for _, item := range items {
+		found[strings.ToLower(item.Name)] = true
+		if !seen[item.ID] {
+			seen[item.ID] = true
+			ids = append(ids, item.ID)
+		}
+	}
+	for _, name := range names {
+		if !found[strings.ToLower(strings.TrimSpace(name))] {
+			return nil
+		}
+	}
+	return ids
+}
+
+// Переводит сохраненные фильтры, заданные только названиями, на идентификаторы.
+// Безопасно запускать повторно: фильтры с идентификаторами не меняются.
+func (s *Service) MigrateFilterIDs(ctx context.Context) error {
+	filters, err := s.audienceRepo.ListFilters(ctx)
+	if err != nil {
+		return fmt.Errorf("list filters: %w", err)
+	}
+
+	migrated := 0
+	for i := range filters {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("migrate filters: %w", err)
+		}
+		filter := &filters[i]
+		needStatuses := len(filter.StatusIDs) == 0 && len(filter.StatusNames) > 0
+		needRejection := len(filter.RejectionReasonIDs) == 0 && len(filter.RegectionReasonNames) > 0
+		needNonTarget := len(filter.NonTargetReasonIDs) == 0 && len(filter.NonTargetReasonNames) > 0
+		if !needStatuses && !needRejection && !needNonTarget {
+			continue
+		}
+
+		if err := s.resolveFilterIDs(ctx, filter); err != nil {
+			s.logger.Error("resolve filter ids failed",
+				zap.Int64("audience_id", filter.AudienceId),
+				zap.Error(err))
+			continue
+		}
+
+		unresolved := (needStatuses && len(filter.StatusIDs) == 0) ||
+			(needRejection && len(filter.RejectionReasonIDs) == 0) ||
+			(needNonTarget && len(filter.NonTargetReasonIDs) == 0)
+
+		// Общий список причин без категории был получен из тех же названий, после разделения он не нужен
+		if (needRejection || needNonTarget) && !unresolved {
+			filter.ReasonIDs = nil
+		}
+
+		// Названия, которых уже нет в MacroCRM, оставляем как есть: фильтр продолжит работать по названиям
+		if unresolved {
+			s.logger.Warn("filter names could not be resolved to ids",
+				zap.Int64("audience_id", filter.AudienceId),
+				zap.Strings("statuses", filter.StatusNames),
+				zap.Strings("rejection_reasons", filter.RegectionReasonNames),
+				zap.Strings("non_target_reasons", filter.NonTargetReasonNames))
+		}
+
+		if err := s.audienceRepo.UpdateFilterIDs(ctx, filter); err != nil {
+			s.logger.Error("update filter ids failed",
+				zap.Int64("audience_id", filter.AudienceId),
+				zap.Error(err))
+			continue
+		}
+		migrated++
+	}
+
+	s.logger.Info("audience filters migrated to ids", zap.Int("migrated", migrated))
+	return nil
+}
@@ -0,0 +1,36 @@
+package audience
+
+import (
+	"reflect"
+	"testing"
+
+	"reporting-service/internal/domain"
+)
+
+func TestIDsForAllNames(t *testing.T) {
+	items := []domain.DictionaryItem{
+		{ID: 3, Name: "Бронь"},
+		{ID: 7, Name: "Нецелевой"},
+		{ID: 9, Name: "Нецелевой"},
+		{ID: 3, Name: "Бронь"},
+	}
+	tests := []struct {
+		name  string
+		names []string
+		items []domain.DictionaryItem
+		want  []int64
+	}{
+		{"all resolved", []string{"Бронь", "Нецелевой"}, items, []int64{3, 7, 9}},
+		{"case and spaces", []string{" бронь", "НЕЦЕЛЕВОЙ"}, items, []int64{3, 7, 9}},
+		// Частичный список сузил бы фильтр: оставляем фильтр по названиям
+		{"one missing", []string{"Бронь", "Отказ"}, items, nil},
+		{"nothing found", []string{"Отказ"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idsForAllNames(tt.names, tt.items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
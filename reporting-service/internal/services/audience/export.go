@@ -2,10 +2,15 @@ package audience
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xuri/excelize/v2"
@@ -14,20 +19,69 @@ import (
 	"reporting-service/internal/domain"
 	MysqlRepo "reporting-service/internal/repository/mysql"
 	PostgreRepo "reporting-service/internal/repository/postgre"
+	"reporting-service/internal/storage"
 )
 
 type ExcelExporter struct {
 	audienceRepo PostgreRepo.PostgresAudienceRepository
 	mysqlRepo    MysqlRepo.MySQLAudienceRepository
+	storage      storage.Storage
 	logger       *zap.Logger
+
+	pdfFontConfig PDFFontConfig
+	pdfOnce       sync.Once
+	pdfFonts      *pdfFonts
+	pdfErr        error
 }
 
-func NewExcelExporter(repo PostgreRepo.PostgresAudienceRepository, mysqlrepo MysqlRepo.MySQLAudienceRepository, logger *zap.Logger) *ExcelExporter {
+func NewExcelExporter(repo PostgreRepo.PostgresAudienceRepository, mysqlrepo MysqlRepo.MySQLAudienceRepository, store storage.Storage, pdfFonts PDFFontConfig, logger *zap.Logger) *ExcelExporter {
 	return &ExcelExporter{
-		audienceRepo: repo,
-		mysqlRepo:    mysqlrepo,
-		logger:       logger,
+		audienceRepo:  repo,
+		mysqlRepo:     mysqlrepo,
+		storage:       store,
+		logger:        logger,
+		pdfFontConfig: pdfFonts,
+	}
+}
+
+// Книга пишется во временный файл и уже из него уходит в хранилище: размер нужен заранее
+func (e *ExcelExporter) saveWorkbook(ctx context.Context, f *excelize.File, dir, fileName string) (string, error) {
+	tmp, err := os.CreateTemp("", "export-*.xlsx")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := f.Write(tmp); err != nil {
+		return "", fmt.Errorf("write workbook: %w", err)
+	}
+	return e.saveFile(ctx, tmp, dir, fileName)
+}
+
+// Имя файла выгрузки со временем и случайным суффиксом: выгрузки, начатые в одну секунду,
+// не перезаписывают друг друга в хранилище
+func exportFileName(prefix, ext string) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s_%s_%s.%s", prefix, time.Now().Format("20060102_150405"), hex.EncodeToString(suffix), ext)
+}
+
+// Сохраняет файл в хранилище выгрузок, ключ - каталог отчета и имя файла
+func (e *ExcelExporter) saveFile(ctx context.Context, file *os.File, dir, fileName string) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat export file: %w", err)
 	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("rewind export file: %w", err)
+	}
+
+	key := path.Join(dir, fileName)
+	if err := e.storage.Save(ctx, key, file, info.Size()); err != nil {
+		return "", fmt.Errorf("save export: %w", err)
+	}
+	return key, nil
 }
 
 func (e *ExcelExporter) ExportAudience(ctx context.Context, audienceID int64) (string, string, error) {
@@ -44,31 +98,6 @@ func (e *ExcelExporter) ExportAudience(ctx context.Context, audienceID int64) (s
 		return "", "", fmt.Errorf("get filter: %w", err)
 	}
 
-	f := excelize.NewFile()
-	defer f.Close()
-
-	// Create Applications sheet
-	mainSheet := "Applications"
-	f.SetSheetName("Sheet1", mainSheet)
-
-	// Set headers for applications
-	headers := []string{
-		"ID", "Status", "Status Name", "Reason",
-		"Manager ID", "Client ID", "Created At", "Updated At",
-	}
-
-	// Style headers
-	for i, header := range headers {
-		cell := fmt.Sprintf("%s1", string(rune('A'+i)))
-		f.SetCellValue(mainSheet, cell, header)
-	}
-
-	style, _ := f.NewStyle(&excelize.Style{
-		Font: &excelize.Font{Bold: true},
-		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#CCCCCC"}},
-	})
-	f.SetRowStyle(mainSheet, 1, 1, style)
-
 	application_ids, err := e.audienceRepo.GetApplicationIdsByAdienceId(ctx, audienceID)
 
 	if err != nil {
@@ -85,385 +114,355 @@ func (e *ExcelExporter) ExportAudience(ctx context.Context, audienceID int64) (s
 		return "", "", fmt.Errorf("EXPORTER get applications: %w", err)
 	}
 
-	audience.Applications = applications
-	// Add application data
-	for i, app := range audience.Applications {
-		row := i + 2
-		f.SetCellValue(mainSheet, fmt.Sprintf("A%d", row), app.ID)
-		f.SetCellValue(mainSheet, fmt.Sprintf("B%d", row), app.StatusID)
-		f.SetCellValue(mainSheet, fmt.Sprintf("C%d", row), app.StatusName)
-		f.SetCellValue(mainSheet, fmt.Sprintf("D%d", row), app.ReasonName)
-		f.SetCellValue(mainSheet, fmt.Sprintf("E%d", row), app.ManagerID)
-		f.SetCellValue(mainSheet, fmt.Sprintf("F%d", row), app.ClientID)
-		f.SetCellValue(mainSheet, fmt.Sprintf("G%d", row), app.CreatedAt.Format(time.RFC3339))
-		f.SetCellValue(mainSheet, fmt.Sprintf("H%d", row), app.UpdatedAt.Format(time.RFC3339))
-	}
-
-	// Create Audience Info sheet
-	infoSheet := "Audience Info"
-	f.NewSheet(infoSheet)
-
-	// Add audience info
-	f.SetCellValue(infoSheet, "A1", "Parameter")
-	f.SetCellValue(infoSheet, "B1", "Value")
-
-	f.SetCellValue(infoSheet, "A2", "Audience ID")
-	f.SetCellValue(infoSheet, "B2", audience.ID)
-
-	f.SetCellValue(infoSheet, "A3", "Name")
-	f.SetCellValue(infoSheet, "B3", audience.Name)
-
-	f.SetCellValue(infoSheet, "A4", "Created At")
-	f.SetCellValue(infoSheet, "B4", audience.CreatedAt.Format(time.RFC3339))
-
-	f.SetCellValue(infoSheet, "A5", "Updated At")
-	f.SetCellValue(infoSheet, "B5", audience.UpdatedAt.Format(time.RFC3339))
-
-	// Add filter info
-	f.SetCellValue(infoSheet, "A7", "Filter Settings")
-	f.SetCellValue(infoSheet, "A8", "Date From")
-	if filter.StartDate != nil {
-		f.SetCellValue(infoSheet, "B8", filter.StartDate.Format(time.RFC3339))
-	}
-
-	f.SetCellValue(infoSheet, "A9", "Date To")
-	if filter.EndDate != nil {
-		f.SetCellValue(infoSheet, "B9", filter.EndDate.Format(time.RFC3339))
-	}
-
-	f.SetCellValue(infoSheet, "A10", "Status Names")
-	f.SetCellValue(infoSheet, "B10", strings.Join(filter.StatusNames, ", "))
-
-	f.SetCellValue(infoSheet, "A11", "Rejection Reasons")
-	f.SetCellValue(infoSheet, "B11", strings.Join(filter.RegectionReasonNames, ", "))
-
-	f.SetCellValue(infoSheet, "A12", "Non-Target Reasons")
-	f.SetCellValue(infoSheet, "B12", strings.Join(filter.NonTargetReasonNames, ", "))
-
-	// Set column widths
-	f.SetColWidth(mainSheet, "A", "H", 15)
-	f.SetColWidth(infoSheet, "A", "B", 30)
-
-	// Create unique directory
-	dirName := fmt.Sprintf("AUDIENCE_%s_EXPORTS", audience.Name)
-	exportPath := filepath.Join("export", dirName)
-
-	// Ensure exports directory exists
-	if err := os.MkdirAll(exportPath, 0755); err != nil {
-		return "", "", fmt.Errorf("create export directory: %w", err)
-	}
-
-	// Create filename with timestamp
-	fileName := fmt.Sprintf("audience_export_%s.xlsx",
-		time.Now().Format("20060102_150405"))
-	filePath := filepath.Join(exportPath, fileName)
-
-	// Ensure exports directory exists
-	if err := os.MkdirAll("export", 0755); err != nil {
-		return "", "", fmt.Errorf("create exports directory: %w", err)
-	}
-
-	// Save file
-	if err := f.SaveAs(filePath); err != nil {
-		return "", "", fmt.Errorf("save file: %w", err)
-	}
-
-	return filePath, fileName, nil
-}
-
-func (e *ExcelExporter) ExportApplications(ctx context.Context, filter *domain.ApplicationFilterRequest) (string, string, error) {
-	applications, err := e.mysqlRepo.ExportApplicationsWithFilters(ctx, filter)
+	stats, err := e.audienceRepo.GetDailyStats(ctx, audienceID, audience.CreatedAt, time.Now())
 	if err != nil {
-		return "", "", fmt.Errorf("get applications: %w", err)
+		return "", "", fmt.Errorf("EXPORTER get audience stats: %w", err)
 	}
 
 	f := excelize.NewFile()
 	defer f.Close()
 
-	// Create sheet
-	sheetName := "Applications"
-	f.SetSheetName("Sheet1", sheetName)
-
-	// Set headers
-	headers := []string{
-		"ID", "Дата создания", "ФИО клиента", "Статус",
-		"Телефон", "Менеджер", "Тип недвижимости",
-		"Дней в статусе", "Проект",
+	styles, err := newXLSXStyles(f)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Applications sheet
+	mainSheet, err := newXLSXSheet(f, "Applications", []xlsxColumn{
+		{Title: "ID", Format: cellNumber},
+		{Title: "Status", Format: cellNumber},
+		{Title: "Status Name", Format: cellString},
+		{Title: "Reason", Format: cellString},
+		{Title: "Manager ID", Format: cellNumber},
+		{Title: "Client ID", Format: cellNumber},
+		{Title: "Created At", Format: cellDateTime},
+		{Title: "Updated At", Format: cellDateTime},
+	}, styles)
+	if err != nil {
+		return "", "", err
 	}
-
-	for i, header := range headers {
-		cell := fmt.Sprintf("%s1", string(rune('A'+i)))
-		f.SetCellValue(sheetName, cell, header)
+	audience.Applications = applications
+	for _, app := range audience.Applications {
+		if err := mainSheet.WriteRow(
+			app.ID, app.StatusID, app.StatusName, app.ReasonName,
+			app.ManagerID, app.ClientID, app.CreatedAt, app.UpdatedAt,
+		); err != nil {
+			return "", "", err
+		}
+	}
+	if err := mainSheet.Close(); err != nil {
+		return "", "", err
 	}
 
-	// Style headers
-	style, err := f.NewStyle(&excelize.Style{
-		Font:      &excelize.Font{Bold: true},
-		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#CCCCCC"}},
-		Alignment: &excelize.Alignment{Horizontal: "center"},
-	})
+	// Audience Info sheet
+	infoSheet, err := newXLSXSheet(f, "Audience Info", []xlsxColumn{
+		{Title: "Parameter", Format: cellString},
+		{Title: "Value", Format: cellString},
+	}, styles)
 	if err != nil {
-		return "", "", fmt.Errorf("create style: %w", err)
+		return "", "", err
+	}
+	info := [][]interface{}{
+		{"Audience ID", audience.ID},
+		{"Name", audience.Name},
+		{"Created At", audience.CreatedAt.Format(time.RFC3339)},
+		{"Updated At", audience.UpdatedAt.Format(time.RFC3339)},
+		{"", ""},
+		{"Filter Settings", ""},
+		{"Date From", formatOptionalDate(filter.StartDate)},
+		{"Date To", formatOptionalDate(filter.EndDate)},
+		{"Status Names", strings.Join(filter.StatusNames, ", ")},
+		{"Rejection Reasons", strings.Join(filter.RegectionReasonNames, ", ")},
+		{"Non-Target Reasons", strings.Join(filter.NonTargetReasonNames, ", ")},
+	}
+	for _, row := range info {
+		if err := infoSheet.WriteRow(row...); err != nil {
+			return "", "", err
+		}
 	}
-	f.SetRowStyle(sheetName, 1, 1, style)
-
-	// Add data
-	for i, app := range applications {
-		row := i + 2
-		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), app.ID)
-		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), app.CreatedAt.Format("02.01.2006 15:04"))
-		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), app.ClientName)
-		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), app.StatusName)
-		f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), app.Phone)
-		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), app.ManagerName)
-		f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), app.PropertyType)
-		f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), app.StatusDuration)
-		f.SetCellValue(sheetName, fmt.Sprintf("I%d", row), app.ProjectName)
+	if err := infoSheet.Close(); err != nil {
+		return "", "", err
 	}
 
-	// Set column widths
-	columnWidths := map[string]float64{
-		"A": 10, "B": 20, "C": 30, "D": 20,
-		"E": 15, "F": 25, "G": 20, "H": 15,
-		"I": 30,
+	// Audience Stats sheet
+	statsSheet, err := newXLSXSheet(f, "Audience Stats", []xlsxColumn{
+		{Title: "Date", Format: cellDate},
+		{Title: "Size", Format: cellNumber},
+		{Title: "Added", Format: cellNumber},
+		{Title: "Removed", Format: cellNumber},
+		{Title: "Net Change", Format: cellNumber},
+	}, styles)
+	if err != nil {
+		return "", "", err
 	}
-	for col, width := range columnWidths {
-		f.SetColWidth(sheetName, col, col, width)
+	for _, stat := range stats {
+		if err := statsSheet.WriteRow(stat.Date, stat.Size, stat.Added, stat.Removed, stat.Added-stat.Removed); err != nil {
+			return "", "", err
+		}
 	}
-
-	// Create unique directory
-	dirName := "APPLICATIONS_EXPORTS"
-	exportPath := filepath.Join("export", dirName)
-
-	// Ensure exports directory exists
-	if err := os.MkdirAll(exportPath, 0755); err != nil {
-		return "", "", fmt.Errorf("create export directory: %w", err)
+	if err := statsSheet.Close(); err != nil {
+		return "", "", err
 	}
 
-	// Create filename with timestamp
-	fileName := fmt.Sprintf("applications_export_%s.xlsx",
-		time.Now().Format("20060102_150405"))
-	filePath := filepath.Join(exportPath, fileName)
+	// Create unique directory
+	dirName := fmt.Sprintf("AUDIENCE_%s_EXPORTS", audience.Name)
 
-	// Ensure exports directory exists
-	if err := os.MkdirAll("export", 0755); err != nil {
-		return "", "", fmt.Errorf("create exports directory: %w", err)
-	}
+	// Create filename with timestamp and random suffix
+	fileName := exportFileName("audience_export", "xlsx")
 
-	// Save file
-	if err := f.SaveAs(filePath); err != nil {
-		return "", "", fmt.Errorf("save file: %w", err)
+	key, err := e.saveWorkbook(ctx, f, dirName, fileName)
+	if err != nil {
+		return "", "", err
 	}
 
-	return filePath, fileName, nil
+	return key, fileName, nil
 }
 
-func (e *ExcelExporter) ExportCallCenterReport(report *domain.CallCenterReport) (string, string, error) {
+// Заявки пишутся в лист по мере чтения из базы, без загрузки всей выборки в память
+func (e *ExcelExporter) ExportApplications(ctx context.Context, filter *domain.ApplicationFilterRequest, nonTarget domain.FunnelStage) (string, string, error) {
 	f := excelize.NewFile()
 	defer f.Close()
 
-	sheetName := "Sales Report"
-	f.SetSheetName("Sheet1", sheetName)
-
-	// Set headers style
-	headerStyle, err := f.NewStyle(&excelize.Style{
-		Font:      &excelize.Font{Bold: true},
-		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#CCCCCC"}},
-		Alignment: &excelize.Alignment{Horizontal: "center"},
-	})
+	styles, err := newXLSXStyles(f)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Колонки и их порядок задает запрос
+	var sheet *xlsxSheet
+	var headers []domain.Header
+	count := 0
+	err = e.mysqlRepo.StreamApplicationsWithFilters(ctx, filter, nonTarget,
+		func(exportHeaders []domain.Header) error {
+			headers = exportHeaders
+			sheet, err = newXLSXSheet(f, "Applications", headerColumns(headers), styles)
+			return err
+		},
+		func(row map[string]interface{}) error {
+			count++
+			return sheet.WriteRow(rowValues(row, headers)...)
+		})
 	if err != nil {
-		return "", "", fmt.Errorf("create header style: %w", err)
+		return "", "", fmt.Errorf("get applications: %w", err)
+	}
+	if err := sheet.Close(); err != nil {
+		return "", "", err
 	}
 
-	// Set percent style
-	percentStyle, err := f.NewStyle(&excelize.Style{
-		NumFmt:    10,
-		Alignment: &excelize.Alignment{Horizontal: "right"},
-	})
-	if err != nil {
-		return "", "", fmt.Errorf("create percent style: %w", err)
-	}
-
-	// Write headers
-	for i, header := range report.Headers {
-		col := string(rune('A' + i))
-		cell := fmt.Sprintf("%s1", col)
-		f.SetCellValue(sheetName, cell, header.Title)
-		f.SetColWidth(sheetName, col, col, 15)
-	}
-	f.SetRowStyle(sheetName, 1, 1, headerStyle)
-
-	// Write data
-	for i, data := range report.Data {
-		row := i + 2
-		metrics := []interface{}{
-			data.ManagerName,
-			data.TotalInquiries,
-			data.TargetInquiries,
-			data.TargetConversion / 1,
-			data.AppointedVisits,
-			data.VisitConversion / 1,
-			data.CompletedVisits,
-			data.VisitSuccess / 1,
-			data.LeadToVisit / 1,
-		}
+	// Create filename with timestamp and random suffix
+	fileName := exportFileName("applications_export", "xlsx")
 
-		//if len(report.Headers) > 9 { // If optional columns are included
-		//    metrics = append(metrics,
-		//        data.Bookings,
-		//        data.VisitToBooking / 100,
-		//        data.Contracts,
-		//        data.BookingToContract / 100,
-		//        data.LeadToContract / 100,
-		//    )
-		//}
-
-		for j, value := range metrics {
-			cell := fmt.Sprintf("%s%d", string(rune('A'+j)), row)
-			f.SetCellValue(sheetName, cell, value)
-
-			// Apply percent style to conversion columns
-			if j == 3 || j == 5 || j == 7 || j == 8 || j == 10 || j == 12 || j == 13 {
-				f.SetCellStyle(sheetName, cell, cell, percentStyle)
-			}
-		}
+	key, err := e.saveWorkbook(ctx, f, "APPLICATIONS_EXPORTS", fileName)
+	if err != nil {
+		return "", "", err
 	}
 
-	// Write totals
-	totalRow := len(report.Data) + 2
-	totalStyle, _ := f.NewStyle(&excelize.Style{
-		Font: &excelize.Font{Bold: true},
-		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#E0E0E0"}},
-	})
-
-	totals := []interface{}{
-		"ИТОГО",
-		report.Footer.TotalInquiries,
-		report.Footer.TargetInquiries,
-		report.Footer.TargetConversion / 1,
-		report.Footer.AppointedVisits,
-		report.Footer.VisitConversion / 1,
-		report.Footer.CompletedVisits,
-		report.Footer.VisitSuccess / 1,
-		report.Footer.LeadToVisit / 1,
-	}
-
-	//if len(report.Headers) > 9 {
-	//    totals = append(totals,
-	//        report.Totals.Bookings,
-	//        report.Totals.VisitToBooking / 100,
-	//        report.Totals.Contracts,
-	//        report.Totals.BookingToContract / 100,
-	//        report.Totals.LeadToContract / 100,
-	//    )
-	//}
-
-	for j, value := range totals {
-		cell := fmt.Sprintf("%s%d", string(rune('A'+j)), totalRow)
-		f.SetCellValue(sheetName, cell, value)
-		f.SetCellStyle(sheetName, cell, cell, totalStyle)
-
-		if j == 3 || j == 5 || j == 7 || j == 8 || j == 10 || j == 12 || j == 13 {
-			f.SetCellStyle(sheetName, cell, cell, percentStyle)
-		}
+	e.logger.Info("applications exported to xlsx", zap.Int("count", count))
+	return key, fileName, nil
+}
+
+func (e *ExcelExporter) ExportCallCenterReport(ctx context.Context, report *domain.CallCenterReport, filter *domain.CallCenterReportFilter, format string) (string, string, error) {
+	rows := make([]map[string]interface{}, 0, len(report.Data))
+	for _, data := range report.Data {
+		rows = append(rows, managerMetricsRow(data))
 	}
 
-	// Add anomalies sheet if present
-	//if len(report.Anomalies) > 0 {
-	//    f.NewSheet("Anomalies")
-	//    f.SetCellValue("Anomalies", "A1", "Обнаруженные аномалии")
-	//    f.SetCellStyle("Anomalies", "A1", "A1", headerStyle)
-	//
-	//    for i, anomaly := range report.Anomalies {
-	//        f.SetCellValue("Anomalies", fmt.Sprintf("A%d", i+2), anomaly)
-	//    }
-	//
-	//    f.SetColWidth("Anomalies", "A", "A", 50)
-	//}
+	params := []reportParam{
+		{Name: "Начало периода", Value: formatFilterDate(filter.StartDate)},
+		{Name: "Конец периода", Value: formatFilterDate(filter.EndDate)},
+	}
+	if report.FunnelName != "" {
+		params = append(params, reportParam{Name: "Воронка", Value: report.FunnelName})
+	}
+	if report.Comparison != nil {
+		params = append(params,
+			reportParam{Name: "Сравнение", Value: comparisonModes[report.Comparison.Mode]},
+			reportParam{Name: "Период сравнения", Value: comparisonPeriodLabel(report.Comparison)})
+	}
+	params = append(params, reportParam{Name: "Сформирован", Value: time.Now().Format("02.01.2006 15:04")})
 
-	// Save file
-	fileName := fmt.Sprintf("sales_report_%s.xlsx",
-		time.Now().Format("20060102_150405"))
-	filePath := filepath.Join("export/CALL_CENTER_REPORTS", fileName)
+	return e.exportReport(ctx, &tableReport{
+		Title:   "Отчет по колл-центру",
+		Sheet:   "Sales Report",
+		Headers: report.Headers,
+		Rows:    rows,
+		Footer:  managerMetricsRow(report.Footer),
+		Params:  params,
+		Sheets:  anomalySheets(report.Anomalies),
+	}, format, "CALL_CENTER_REPORTS", "sales_report")
+}
 
-	if err := os.MkdirAll("export/CALL_CENTER_REPORTS", 0755); err != nil {
-		return "", "", fmt.Errorf("create exports directory: %w", err)
+// Лист найденных аномалий, если они есть
+func anomalySheets(anomalies []domain.Anomaly) []reportSheet {
+	if len(anomalies) == 0 {
+		return nil
 	}
 
-	if err := f.SaveAs(filePath); err != nil {
-		return "", "", fmt.Errorf("save excel file: %w", err)
+	titles := make(map[string]string, len(domain.CallCenterMetrics))
+	for _, definition := range domain.CallCenterMetrics {
+		titles[definition.Name] = definition.Title
 	}
 
-	return filePath, fileName, nil
+	rows := make([][]interface{}, 0, len(anomalies))
+	for _, anomaly := range anomalies {
+		rows = append(rows, []interface{}{anomaly.ManagerName, titles[anomaly.Metric], anomalyRules[anomaly.Rule], anomaly.Message})
+	}
+	return []reportSheet{{
+		Name: "Аномалии",
+		Columns: []xlsxColumn{
+			{Title: "ФИО менеджера", Format: cellString},
+			{Title: "Показатель", Format: cellString},
+			{Title: "Правило", Format: cellString},
+			{Title: "Описание", Format: cellString},
+		},
+		Rows: rows,
+	}}
 }
 
-func (e *ExcelExporter) ExportRegionsData(report *domain.RegionsResponse) (string, string, error) {
-	f := excelize.NewFile()
-	defer f.Close()
-
-	sheetName := "Regions Report"
-	f.SetSheetName("Sheet1", sheetName)
+// Метрики менеджера по именам заголовков отчета. Конверсии хранятся долями
+func managerMetricsRow(data domain.ManagerMetrics) map[string]interface{} {
+	row := map[string]interface{}{
+		"manager_name":      data.ManagerName,
+		"total_inquiries":   data.TotalInquiries,
+		"target_inquiries":  data.TargetInquiries,
+		"target_conversion": ratioValue(data.TargetConversion),
+		"appointed_visits":  data.AppointedVisits,
+		"visit_conversion":  ratioValue(data.VisitConversion),
+		"completed_visits":  data.CompletedVisits,
+		"visit_success":     ratioValue(data.VisitSuccess),
+		"lead_to_visit":     ratioValue(data.LeadToVisit),
+		// Колонки воронки попадают в выгрузку, только если они есть в заголовках отчета
+		"bookings":            countValue(data.Bookings),
+		"visit_to_booking":    ratioValue(data.VisitToBooking),
+		"contracts":           countValue(data.Contracts),
+		"booking_to_contract": ratioValue(data.BookingToContract),
+		"lead_to_contract":    ratioValue(data.LeadToContract),
+	}
+	for name, value := range data.Comparison {
+		row[name] = value
+	}
+	return row
+}
 
-	// Set headers style
-	headerStyle, err := f.NewStyle(&excelize.Style{
-		Font:      &excelize.Font{Bold: true},
-		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#CCCCCC"}},
-		Alignment: &excelize.Alignment{Horizontal: "center"},
-	})
-	if err != nil {
-		return "", "", fmt.Errorf("create header style: %w", err)
+// Менеджер без строки в одном из периодов сравнения считается с нулем
+func countValue(value *int) int {
+	if value == nil {
+		return 0
 	}
+	return *value
+}
 
-	// Write headers
-	for i := 1; i <= len(report.Headers); i++ {
-		//div := i / 26 + 1
-		col := getColumnName(i)
-		cell := fmt.Sprintf("%s1", col)
-		f.SetCellValue(sheetName, cell, report.Headers[i-1].Title)
-		f.SetColWidth(sheetName, col, col, 15)
+// Неопределенная доля выводится пустой ячейкой
+func ratioValue(value *float64) interface{} {
+	if value == nil {
+		return nil
 	}
+	return *value
+}
 
-	f.SetRowStyle(sheetName, 1, 1, headerStyle)
-
-	// Write data
-	for i := 2; i <= len(report.Data); i++ {
-		row := i
-		for j := 1; j <= len(report.Headers); j++ {
-			cell := fmt.Sprintf("%s%d", getColumnName(j), row)
-			f.SetCellValue(sheetName, cell, report.Data[i-1][report.Headers[j-1].Name])
+func (e *ExcelExporter) ExportRegionsData(ctx context.Context, report *domain.RegionsResponse, filter *domain.RegionFilter, format string) (string, string, error) {
+	params := []reportParam{
+		{Name: "Начало периода", Value: formatFilterDate(filter.StartDate)},
+		{Name: "Конец периода", Value: formatFilterDate(filter.EndDate)},
+	}
+	for _, param := range []reportParam{
+		{Name: "Проект", Value: filter.Project},
+		{Name: "Статус", Value: filter.Status},
+		{Name: "Поиск", Value: filter.Search},
+	} {
+		if param.Value != "" {
+			params = append(params, param)
 		}
 	}
+	params = append(params, reportParam{Name: "Сформирован", Value: time.Now().Format("02.01.2006 15:04")})
 
-	// Write footer
-	footerRow := len(report.Data) + 1
-	for j := 1; j <= len(report.Headers); j++ {
-		cell := fmt.Sprintf("%s%d", getColumnName(j), footerRow)
-		f.SetCellValue(sheetName, cell, report.Footer[report.Headers[j-1].Title])
-	}
-	f.SetCellValue(sheetName, fmt.Sprintf("%s%d","A",footerRow), "ИТОГО")
+	return e.exportReport(ctx, &tableReport{
+		Title:   "Заявки по проектам и регионам",
+		Sheet:   "Regions Report",
+		Headers: report.Headers,
+		Rows:    report.Data,
+		Footer:  report.Footer,
+		Params:  params,
+	}, format, "REGIONS_REPORTS", "regions_report")
+}
 
-	// Save file
-	fileName := fmt.Sprintf("regions_report_%s.xlsx",
-		time.Now().Format("20060102_150405"))
-	filePath := filepath.Join("export/REGIONS_REPORTS", fileName)
+// Скорость обработки: по строке на статус с количеством заявок, средним временем и превышениями порога
+func (e *ExcelExporter) ExportStatusDurationReport(ctx context.Context, statuses []domain.StatusDuration, filter *domain.StatusDurationFilter, format string) (string, string, error) {
+	rows := make([]map[string]interface{}, 0, len(statuses))
+	totalRequests, overThreshold := 0, 0
+	weightedDays := 0.0
+	for _, status := range statuses {
+		rows = append(rows, map[string]interface{}{
+			"status_name":          status.StatusName,
+			"total_requests":       status.TotalRequests,
+			"average_days":         math.Round(status.AverageDays*10) / 10,
+			"over_threshold":       status.OverThreshold,
+			"over_threshold_share": shareOf(status.OverThreshold, status.TotalRequests),
+		})
+		totalRequests += status.TotalRequests
+		overThreshold += status.OverThreshold
+		weightedDays += status.AverageDays * float64(status.TotalRequests)
+	}
+
+	// Среднее по всем статусам взвешено количеством заявок
+	averageDays := 0.0
+	if totalRequests > 0 {
+		averageDays = math.Round(weightedDays/float64(totalRequests)*10) / 10
+	}
+
+	return e.exportReport(ctx, &tableReport{
+		Title: "Скорость обработки заявок",
+		Sheet: "Speed Report",
+		Headers: []domain.Header{
+			{Name: "status_name", Title: "Наименование статуса", IsAsideHeader: true, IsVisible: true, Format: "string"},
+			{Name: "total_requests", Title: "Количество заявок", IsVisible: true, Format: "number"},
+			{Name: "average_days", Title: "Среднее время, дней", IsVisible: true, Format: "number"},
+			{Name: "over_threshold", Title: "Дольше порога", IsVisible: true, Format: "number"},
+			{Name: "over_threshold_share", Title: "Доля дольше порога", IsVisible: true, Format: "percent"},
+		},
+		Rows: rows,
+		Footer: map[string]interface{}{
+			"total_requests":       totalRequests,
+			"average_days":         averageDays,
+			"over_threshold":       overThreshold,
+			"over_threshold_share": shareOf(overThreshold, totalRequests),
+		},
+		Params: []reportParam{
+			{Name: "Начало периода", Value: formatReportDate(filter.StartDate, time.Now().AddDate(-1, 0, 0))},
+			{Name: "Конец периода", Value: formatReportDate(filter.EndDate, time.Now())},
+			{Name: "Порог, дней", Value: filter.ThresholdDays},
+			{Name: "Сформирован", Value: time.Now().Format("02.01.2006 15:04")},
+		},
+	}, format, "SPEED_REPORTS", "speed_report")
+}
 
-	if err := os.MkdirAll("export/REGIONS_REPORTS", 0755); err != nil {
-		return "", "", fmt.Errorf("create exports directory: %w", err)
+// Доля без деления на ноль
+func shareOf(part, total int) float64 {
+	if total == 0 {
+		return 0
 	}
+	return float64(part) / float64(total)
+}
 
-	if err := f.SaveAs(filePath); err != nil {
-		return "", "", fmt.Errorf("save excel file: %w", err)
+// Пустая дата в фильтре заменяется той, что подставляет запрос к базе
+func formatReportDate(date *time.Time, fallback time.Time) string {
+	if date == nil || date.IsZero() {
+		return fallback.Format("02.01.2006")
 	}
+	return date.Format("02.01.2006")
+}
 
-	return filePath, fileName, nil
+// Пустая дата фильтра означает, что период не ограничен
+func formatFilterDate(date *time.Time) string {
+	if date == nil || date.IsZero() {
+		return "не задано"
+	}
+	return date.Format("02.01.2006")
 }
 
-func getColumnName(n int) string{
-	var result string
-	for n > 0 {
-	  n-- // Декремент, так как индексация в Excel начинается с 1.
-	  result = string(rune(n%26+'A')) + result
-	  n /= 26
+func formatOptionalDate(date *time.Time) string {
+	if date == nil {
+		return ""
 	}
-	return result
-}
\ No newline at end of file
+	return date.Format(time.RFC3339)
+}
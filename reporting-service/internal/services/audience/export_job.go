@@ -0,0 +1,402 @@
+package audience
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"reporting-service/internal/domain"
+	MysqlRepo "reporting-service/internal/repository/mysql"
+	"reporting-service/internal/storage"
+)
+
+const (
+	defaultExportLinkTTL   = time.Hour
+	defaultExportRetention = 72 * time.Hour
+	// Пока файл формируется, воркер отмечает выгрузку с этим интервалом
+	exportJobHeartbeat = time.Minute
+	// Выгрузка без отметки дольше этого срока считается брошенной и возвращается в очередь
+	exportJobStaleAfter = 5 * time.Minute
+)
+
+func (s *Service) CreateExportJob(ctx context.Context, ownerID string, req domain.ExportJobRequest) (*domain.ExportJob, error) {
+	if len(req.Params) == 0 {
+		req.Params = json.RawMessage(`{}`)
+	}
+	if req.Format == "" {
+		req.Format = ExportFormatXLSX
+	}
+
+	if err := validateReportFormat(req.ReportType, req.Format); err != nil {
+		return nil, err
+	}
+
+	// Параметры проверяются сразу, чтобы ошибка пришла в ответ, а не в статус выгрузки
+	job := &domain.ExportJob{
+		OwnerID:    ownerID,
+		ReportType: req.ReportType,
+		Format:     req.Format,
+		Params:     req.Params,
+	}
+	if err := s.validateExportParams(job); err != nil {
+		return nil, err
+	}
+
+	if err := s.audienceRepo.CreateExportJob(ctx, job); err != nil {
+		return nil, err
+	}
+	s.logger.Info("export job created",
+		zap.Int64("job_id", job.ID),
+		zap.String("report_type", job.ReportType),
+		zap.String("format", job.Format))
+	return job, nil
+}
+
+// Выгрузку видит только тот, кто ее запросил
+func (s *Service) GetExportJob(ctx context.Context, ownerID string, id int64) (*domain.ExportJob, error) {
+	job, err := s.audienceRepo.GetExportJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.OwnerID != ownerID {
+		return nil, fmt.Errorf("export job %d: %w", id, domain.ErrNotFound)
+	}
+	s.attachDownloadURL(job)
+	return job, nil
+}
+
+// Готовая выгрузка по подписанной ссылке. Подпись заменяет авторизацию, поэтому ссылку можно открыть в браузере
+func (s *Service) GetExportDownload(ctx context.Context, id int64, expires string, signature string) (*domain.ExportJob, error) {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(s.signExportLink(id, expiresAt))) {
+		return nil, fmt.Errorf("export job %d: invalid signature: %w", id, domain.ErrForbidden)
+	}
+	if time.Now().Unix() > expiresAt {
+		return nil, fmt.Errorf("export job %d: link expired: %w", id, domain.ErrForbidden)
+	}
+
+	job, err := s.audienceRepo.GetExportJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != domain.ExportStatusDone || job.FilePath == "" {
+		return nil, fmt.Errorf("export job %d is %s: %w", id, job.Status, domain.ErrNotFound)
+	}
+	return job, nil
+}
+
+// Обрабатывает все ожидающие выгрузки по очереди
+func (s *Service) ProcessExportJobs(ctx context.Context) error {
+	if requeued, err := s.audienceRepo.RequeueStaleExportJobs(ctx, time.Now().Add(-exportJobStaleAfter)); err != nil {
+		return err
+	} else if requeued > 0 {
+		s.logger.Warn("stale export jobs requeued", zap.Int64("count", requeued))
+	}
+
+	for {
+		job, err := s.audienceRepo.ClaimExportJob(ctx)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+		s.runExportJob(ctx, job)
+	}
+}
+
+func (s *Service) runExportJob(ctx context.Context, job *domain.ExportJob) {
+	started := time.Now()
+	stopHeartbeat := s.exportJobHeartbeat(ctx, job.ID)
+	filePath, fileName, err := s.renderExport(ctx, job)
+	stopHeartbeat()
+	if err != nil {
+		// При остановке сервиса выгрузка остается в работе и без отметок вернется в очередь
+		if ctx.Err() != nil {
+			s.logger.Warn("export job interrupted", zap.Int64("job_id", job.ID), zap.Error(err))
+			return
+		}
+		s.logger.Error("export job failed", zap.Int64("job_id", job.ID), zap.Error(err))
+		if ok, err := s.audienceRepo.FailExportJob(ctx, job.ID, err.Error()); err != nil {
+			s.logger.Error("failed to mark export job as failed", zap.Int64("job_id", job.ID), zap.Error(err))
+		} else if !ok {
+			s.logger.Warn("export job is no longer running", zap.Int64("job_id", job.ID))
+		}
+		return
+	}
+
+	// Выгрузку могли вернуть в очередь и отдать другому воркеру: тогда ее файл не нужен
+	expiresAt := time.Now().Add(s.exportRetention())
+	ok, err := s.audienceRepo.CompleteExportJob(ctx, job.ID, filePath, fileName, expiresAt)
+	if err != nil {
+		s.logger.Error("failed to complete export job", zap.Int64("job_id", job.ID), zap.Error(err))
+		return
+	}
+	if !ok {
+		s.logger.Warn("export job is no longer running, file dropped", zap.Int64("job_id", job.ID))
+		if err := s.storage.Delete(ctx, filePath); err != nil {
+			s.logger.Error("failed to remove export file", zap.Int64("job_id", job.ID), zap.String("path", filePath), zap.Error(err))
+		}
+		return
+	}
+	s.logger.Info("export job finished",
+		zap.Int64("job_id", job.ID),
+		zap.String("file", fileName),
+		zap.Duration("duration", time.Since(started)))
+}
+
+// Отмечает выгрузку в работе, пока не будет вызвана возвращенная функция
+func (s *Service) exportJobHeartbeat(ctx context.Context, id int64) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(exportJobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if alive, err := s.audienceRepo.TouchExportJob(ctx, id); err != nil && ctx.Err() == nil {
+					s.logger.Error("failed to touch export job", zap.Int64("job_id", id), zap.Error(err))
+				} else if err == nil && !alive {
+					s.logger.Warn("export job is no longer running", zap.Int64("job_id", id))
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Типы отчетов и форматы, в которых их можно выгрузить
+func validateReportFormat(reportType, format string) error {
+	result := &domain.ValidationResult{}
+	switch reportType {
+	case domain.ExportReportApplications:
+		if format != ExportFormatXLSX && format != ExportFormatCSV && format != ExportFormatTSV {
+			result.AddError("format", domain.ValidationCodeInvalidValue, "applications can be exported as xlsx, csv or tsv")
+		}
+	case domain.ExportReportRegions, domain.ExportReportCallCenter, domain.ExportReportSpeed:
+		if !IsReportFormat(format) {
+			result.AddError("format", domain.ValidationCodeInvalidValue, "report can be exported as xlsx, csv, tsv or pdf")
+		}
+	case domain.ExportReportAudience:
+		if format != ExportFormatXLSX {
+			result.AddError("format", domain.ValidationCodeInvalidValue, "report can only be exported as xlsx")
+		}
+	default:
+		result.AddError("report_type", domain.ValidationCodeInvalidValue,
+			fmt.Sprintf("unknown report type %q", reportType))
+	}
+	return result.Err()
+}
+
+func (s *Service) validateExportParams(job *domain.ExportJob) error {
+	switch job.ReportType {
+	case domain.ExportReportApplications:
+		params, err := decodeExportParams[domain.ApplicationExportParams](job)
+		if err != nil {
+			return err
+		}
+		if err := MysqlRepo.ValidateApplicationColumns(params.Columns); err != nil {
+			return err
+		}
+		return MysqlRepo.ValidateApplicationOrder(&params.ApplicationFilterRequest)
+	case domain.ExportReportAudience:
+		params, err := decodeExportParams[domain.AudienceExportParams](job)
+		if err != nil {
+			return err
+		}
+		if params.AudienceID <= 0 {
+			return domain.NewValidationError("params.audience_id", domain.ValidationCodeRequired, "audience_id is required")
+		}
+	case domain.ExportReportRegions:
+		_, err := decodeExportParams[domain.RegionFilter](job)
+		return err
+	case domain.ExportReportCallCenter:
+		filter, err := decodeExportParams[domain.CallCenterReportFilter](job)
+		if err != nil {
+			return err
+		}
+		return validateComparison(&filter)
+	case domain.ExportReportSpeed:
+		_, err := decodeExportParams[domain.StatusDurationFilter](job)
+		return err
+	}
+	return nil
+}
+
+// Формирует файл тем же экспортером, что и синхронная выгрузка
+func (s *Service) renderExport(ctx context.Context, job *domain.ExportJob) (string, string, error) {
+	switch job.ReportType {
+	case domain.ExportReportApplications:
+		params, err := decodeExportParams[domain.ApplicationExportParams](job)
+		if err != nil {
+			return "", "", err
+		}
+		filter := params.ApplicationFilterRequest
+		filter.Columns = params.Columns
+		if err := s.applyExportSearch(ctx, &filter); err != nil {
+			return "", "", err
+		}
+		nonTarget, err := s.nonTargetStage(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		if job.Format == ExportFormatXLSX {
+			return s.exporter.ExportApplications(ctx, &filter, nonTarget)
+		}
+		return s.exporter.ExportApplicationsCSV(ctx, &filter, job.Format, nonTarget)
+	case domain.ExportReportAudience:
+		params, err := decodeExportParams[domain.AudienceExportParams](job)
+		if err != nil {
+			return "", "", err
+		}
+		return s.ExportAudience(ctx, params.AudienceID)
+	case domain.ExportReportRegions:
+		filter, err := decodeExportParams[domain.RegionFilter](job)
+		if err != nil {
+			return "", "", err
+		}
+		return s.ExportRegions(ctx, &filter, job.Format)
+	case domain.ExportReportCallCenter:
+		filter, err := decodeExportParams[domain.CallCenterReportFilter](job)
+		if err != nil {
+			return "", "", err
+		}
+		// Без дат отчет строится как в синхронной выгрузке
+		if filter.StartDate == nil {
+			filter.StartDate = &time.Time{}
+		}
+		if filter.EndDate == nil {
+			filter.EndDate = &time.Time{}
+		}
+		return s.ExportCallCenterReport(ctx, &filter, job.Format)
+	case domain.ExportReportSpeed:
+		filter, err := decodeExportParams[domain.StatusDurationFilter](job)
+		if err != nil {
+			return "", "", err
+		}
+		return s.ExportStatusDurationReport(ctx, &filter, job.Format)
+	}
+	return "", "", fmt.Errorf("unknown report type %q", job.ReportType)
+}
+
+func decodeExportParams[T any](job *domain.ExportJob) (T, error) {
+	var params T
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return params, domain.NewValidationError("params", domain.ValidationCodeInvalidValue,
+			fmt.Sprintf("invalid %s params: %v", job.ReportType, err))
+	}
+	return params, nil
+}
+
+// Удаляет файлы выгрузок с истекшим сроком хранения
+func (s *Service) CleanupExports(ctx context.Context) error {
+	now := time.Now()
+	jobs, err := s.audienceRepo.ListExpiredExportJobs(ctx, now, now.Add(-s.exportRetention()))
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			if err := s.storage.Delete(ctx, job.FilePath); err != nil {
+				s.logger.Error("failed to remove export file",
+					zap.Int64("job_id", job.ID),
+					zap.String("path", job.FilePath),
+					zap.Error(err))
+				continue
+			}
+		}
+		if err := s.audienceRepo.ExpireExportJob(ctx, job.ID); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		s.logger.Info("expired exports removed", zap.Int("count", removed))
+	}
+	return nil
+}
+
+var exportContentTypes = map[string]string{
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".csv":  "text/csv; charset=utf-8",
+	".tsv":  "text/tab-separated-values; charset=utf-8",
+	".pdf":  "application/pdf",
+}
+
+// MIME-тип файла выгрузки по расширению, пустая строка для неизвестных
+func ExportContentType(fileName string) string {
+	return exportContentTypes[path.Ext(fileName)]
+}
+
+// Открывает готовый файл выгрузки в хранилище
+func (s *Service) OpenExport(ctx context.Context, key string) (*storage.Object, error) {
+	file, err := s.storage.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("export file %s: %w", key, domain.ErrNotFound)
+		}
+		return nil, fmt.Errorf("open export file: %w", err)
+	}
+	return file, nil
+}
+
+// Удаляет файл разовой выгрузки после отдачи: такие файлы не попадают в export_jobs
+// и не удаляются по сроку хранения
+func (s *Service) RemoveExport(ctx context.Context, key string) error {
+	if err := s.storage.Delete(ctx, key); err != nil {
+		return fmt.Errorf("remove export file: %w", err)
+	}
+	return nil
+}
+
+// Ссылка действует не дольше, чем хранится файл
+func (s *Service) attachDownloadURL(job *domain.ExportJob) {
+	if job.Status != domain.ExportStatusDone || job.ExpiresAt == nil {
+		return
+	}
+
+	ttl := s.config.ExportLinkTTL
+	if ttl <= 0 {
+		ttl = defaultExportLinkTTL
+	}
+	expiresAt := time.Now().Add(ttl)
+	if job.ExpiresAt.Before(expiresAt) {
+		expiresAt = *job.ExpiresAt
+	}
+
+	expires := expiresAt.Unix()
+	job.DownloadURL = fmt.Sprintf("/api/exports/%d/download?expires=%d&signature=%s",
+		job.ID, expires, s.signExportLink(job.ID, expires))
+}
+
+func (s *Service) signExportLink(id int64, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(s.config.ExportSigningKey))
+	fmt.Fprintf(mac, "%d:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) exportRetention() time.Duration {
+	if s.config.ExportRetention <= 0 {
+		return defaultExportRetention
+	}
+	return s.config.ExportRetention
+}
@@ -0,0 +1,183 @@
+package audience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"reporting-service/internal/domain"
+)
+
+var funnelCodePattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+var funnelStages = map[string]bool{
+	domain.FunnelStageNonTarget:   true,
+	domain.FunnelStageAppointment: true,
+	domain.FunnelStageVisit:       true,
+	domain.FunnelStageBooking:     true,
+}
+
+func (s *Service) ListReportFunnels(ctx context.Context) ([]domain.FunnelConfig, error) {
+	funnels, err := s.audienceRepo.ListReportFunnels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list report funnels: %w", err)
+	}
+	return funnels, nil
+}
+
+func (s *Service) GetReportFunnel(ctx context.Context, code string) (*domain.FunnelConfig, error) {
+	return s.audienceRepo.GetReportFunnel(ctx, code)
+}
+
+func (s *Service) CreateReportFunnel(ctx context.Context, req domain.FunnelConfigRequest) (*domain.FunnelConfig, error) {
+	req.Code = strings.TrimSpace(req.Code)
+	result := &domain.ValidationResult{}
+	if !funnelCodePattern.MatchString(req.Code) {
+		result.AddError("code", domain.ValidationCodeInvalidValue, "code must consist of 1-64 lowercase latin letters, digits or underscores")
+	}
+	validateFunnelRequest(&req, result)
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	funnel := funnelFromRequest(req.Code, req)
+	if err := s.audienceRepo.CreateReportFunnel(ctx, funnel); err != nil {
+		return nil, err
+	}
+	s.logger.Info("report funnel created", zap.String("code", funnel.Code))
+	return funnel, nil
+}
+
+// Код воронки не меняется: по нему на воронку ссылаются выгрузки и подписки
+func (s *Service) UpdateReportFunnel(ctx context.Context, code string, req domain.FunnelConfigRequest) (*domain.FunnelConfig, error) {
+	result := &domain.ValidationResult{}
+	validateFunnelRequest(&req, result)
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	funnel := funnelFromRequest(code, req)
+	if err := s.audienceRepo.UpdateReportFunnel(ctx, funnel); err != nil {
+		return nil, err
+	}
+	s.logger.Info("report funnel updated", zap.String("code", funnel.Code))
+	return funnel, nil
+}
+
+// Воронку колл-центра и воронки, на которые ссылаются подписки или незавершенные выгрузки,
+// удалить нельзя: отчеты по ним перестанут строиться
+func (s *Service) DeleteReportFunnel(ctx context.Context, code string) error {
+	if code == domain.DefaultFunnelCode {
+		return domain.NewValidationError("code", domain.ValidationCodeInvalidValue, "default funnel cannot be deleted")
+	}
+	subscriptions, jobs, err := s.audienceRepo.CountReportFunnelReferences(ctx, code)
+	if err != nil {
+		return err
+	}
+	if subscriptions > 0 || jobs > 0 {
+		return domain.NewValidationError("code", domain.ValidationCodeInvalidValue,
+			fmt.Sprintf("funnel is used by %d report subscriptions and %d unfinished export jobs", subscriptions, jobs))
+	}
+
+	if err := s.audienceRepo.DeleteReportFunnel(ctx, code); err != nil {
+		return err
+	}
+	s.logger.Info("report funnel deleted", zap.String("code", code))
+	return nil
+}
+
+// Воронка для отчета, без явного кода - воронка колл-центра. Она заводится миграцией;
+// пока ее нет в базе, отчеты и фильтры строятся по прежним этапам колл-центра
+func (s *Service) reportFunnel(ctx context.Context, code string) (*domain.FunnelConfig, error) {
+	if code == "" {
+		funnel, err := s.audienceRepo.GetReportFunnel(ctx, domain.DefaultFunnelCode)
+		if errors.Is(err, domain.ErrNotFound) {
+			s.logger.Warn("default report funnel is missing, using built-in call center stages",
+				zap.String("code", domain.DefaultFunnelCode))
+			return builtinCallCenterFunnel(), nil
+		}
+		return funnel, err
+	}
+	return s.audienceRepo.GetReportFunnel(ctx, code)
+}
+
+// Этапы колл-центра, которые были в запросе отчета до настраиваемых воронок.
+// Совпадают с воронкой из миграции 008_report_funnels
+func builtinCallCenterFunnel() *domain.FunnelConfig {
+	return &domain.FunnelConfig{
+		Code:          domain.DefaultFunnelCode,
+		Name:          "Колл-центр",
+		DepartmentIDs: []int64{1903},
+		Stages: map[string]domain.FunnelStage{
+			domain.FunnelStageNonTarget: {StatusNames: []string{"Нецелевой"}},
+			domain.FunnelStageAppointment: {
+				StatusNames:     []string{"Назначенная встреча"},
+				FromStatusNames: []string{"Проверка", "Подбор", "Неразобранное"},
+			},
+			domain.FunnelStageVisit:   {StatusNames: []string{"Визит состоялся"}},
+			domain.FunnelStageBooking: {StatusNames: []string{"Бронь"}},
+		},
+	}
+}
+
+// Этап нецелевых из воронки колл-центра: по нему причины в фильтрах заявок делятся
+// на нецелевые и причины отказа
+func (s *Service) nonTargetStage(ctx context.Context) (domain.FunnelStage, error) {
+	funnel, err := s.reportFunnel(ctx, "")
+	if err != nil {
+		return domain.FunnelStage{}, err
+	}
+	return funnel.Stages[domain.FunnelStageNonTarget], nil
+}
+
+func validateFunnelRequest(req *domain.FunnelConfigRequest, result *domain.ValidationResult) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		result.AddError("name", domain.ValidationCodeRequired, "name is required")
+	}
+	if len(req.DepartmentIDs) == 0 {
+		result.AddError("department_ids", domain.ValidationCodeRequired, "at least one department is required")
+	}
+	for key, stage := range req.Stages {
+		stage.StatusNames = trimNames(stage.StatusNames)
+		stage.FromStatusNames = trimNames(stage.FromStatusNames)
+		req.Stages[key] = stage
+
+		field := "stages." + key
+		if !funnelStages[key] {
+			result.AddError(field, domain.ValidationCodeInvalidValue, fmt.Sprintf("unknown funnel stage %q", key))
+			continue
+		}
+		if len(stage.StatusIDs) == 0 && len(stage.StatusNames) == 0 {
+			result.AddError(field, domain.ValidationCodeRequired, "stage must have status_ids or status_names")
+		}
+	}
+	for _, key := range []string{domain.FunnelStageAppointment, domain.FunnelStageVisit} {
+		if _, ok := req.Stages[key]; !ok {
+			result.AddError("stages."+key, domain.ValidationCodeRequired, fmt.Sprintf("stage %q is required", key))
+		}
+	}
+}
+
+func funnelFromRequest(code string, req domain.FunnelConfigRequest) *domain.FunnelConfig {
+	return &domain.FunnelConfig{
+		Code:          code,
+		Name:          req.Name,
+		DepartmentIDs: req.DepartmentIDs,
+		Stages:        req.Stages,
+	}
+}
+
+func trimNames(names []string) []string {
+	var result []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
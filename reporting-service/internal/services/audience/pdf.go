@@ -0,0 +1,357 @@
+package audience
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/pdf"
+)
+
+const ExportFormatPDF = "pdf"
+
+// Форматы, в которых выгружаются табличные отчеты
+func IsReportFormat(format string) bool {
+	switch format {
+	case ExportFormatXLSX, ExportFormatCSV, ExportFormatTSV, ExportFormatPDF:
+		return true
+	}
+	return false
+}
+
+// Пути к TrueType шрифтам для PDF. Пустые значения - DejaVu Sans из системного каталога шрифтов
+type PDFFontConfig struct {
+	Regular string `yaml:"regular"`
+	Bold    string `yaml:"bold"`
+}
+
+var (
+	defaultPDFFonts = []string{
+		"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+		"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	}
+	defaultPDFBoldFonts = []string{
+		"/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf",
+		"/usr/share/fonts/truetype/dejavu/DejaVuSans-Bold.ttf",
+	}
+)
+
+// Размеры в пунктах
+const (
+	pdfMargin       = 36.0
+	pdfTitleSize    = 14.0
+	pdfParamSize    = 9.0
+	pdfCellSize     = 8.0
+	pdfCellPadding  = 4.0
+	pdfRowHeight    = 14.0
+	pdfHeaderLine   = 10.0
+	pdfHeaderLines  = 3
+	pdfMinColumn    = 30.0
+	pdfMaxColumn    = 180.0
+	pdfFooterHeight = 20.0
+)
+
+type pdfFonts struct {
+	regular *pdf.Font
+	bold    *pdf.Font
+}
+
+// Шрифты читаются один раз на экспортер, без жирного начертания заголовки пишутся обычным
+func (e *ExcelExporter) loadPDFFonts() (*pdfFonts, error) {
+	e.pdfOnce.Do(func() {
+		regular, err := pdf.LoadFont(findPDFFont(e.pdfFontConfig.Regular, defaultPDFFonts))
+		if err != nil {
+			e.pdfErr = fmt.Errorf("load pdf font: %w", err)
+			return
+		}
+		fonts := &pdfFonts{regular: regular, bold: regular}
+		if path := findPDFFont(e.pdfFontConfig.Bold, defaultPDFBoldFonts); path != "" {
+			if fonts.bold, err = pdf.LoadFont(path); err != nil {
+				e.pdfErr = fmt.Errorf("load pdf bold font: %w", err)
+				return
+			}
+		}
+		e.pdfFonts = fonts
+	})
+	return e.pdfFonts, e.pdfErr
+}
+
+func findPDFFont(configured string, defaults []string) string {
+	if configured != "" {
+		return configured
+	}
+	for _, path := range defaults {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Колонки таблицы PDF. Если все колонки не помещаются по ширине, таблица печатается
+// несколькими частями, а первая колонка с названиями строк повторяется в каждой
+type pdfColumn struct {
+	header domain.Header
+	format string
+	width  float64
+	index  int
+}
+
+type pdfReport struct {
+	doc     *pdf.Document
+	fonts   *pdfFonts
+	report  *tableReport
+	headers []domain.Header
+	y       float64
+}
+
+func writeReportPDF(w io.Writer, report *tableReport, fonts *pdfFonts) error {
+	headers := report.visibleHeaders()
+	columns := pdfColumns(report, headers, fonts)
+
+	// Широкие таблицы, например сводка по регионам, печатаются на альбомных листах
+	natural := 0.0
+	for _, column := range columns {
+		natural += column.width
+	}
+	width, height := pdf.A4Width, pdf.A4Height
+	if natural > width-2*pdfMargin {
+		width, height = height, width
+	}
+
+	r := &pdfReport{
+		doc:     pdf.New(width, height),
+		fonts:   fonts,
+		report:  report,
+		headers: headers,
+	}
+	r.doc.AddPage()
+	r.writeTitle()
+
+	for i, group := range pdfColumnGroups(columns, width-2*pdfMargin) {
+		if i > 0 {
+			r.newPage()
+		}
+		r.writeTable(group)
+	}
+
+	// Номера страниц проставляются, когда известно их общее количество
+	total := r.doc.PageCount()
+	for page := 1; page <= total; page++ {
+		r.doc.SetPage(page)
+		label := fmt.Sprintf("Страница %d из %d", page, total)
+		x := (width - fonts.regular.TextWidth(label, pdfCellSize)) / 2
+		r.doc.Text(fonts.regular, pdfCellSize, x, height-pdfMargin/2, label)
+	}
+
+	if _, err := r.doc.WriteTo(w); err != nil {
+		return fmt.Errorf("write pdf: %w", err)
+	}
+	return nil
+}
+
+// Заголовок отчета и параметры, с которыми он построен
+func (r *pdfReport) writeTitle() {
+	r.y = pdfMargin + pdfTitleSize
+	r.doc.Text(r.fonts.bold, pdfTitleSize, pdfMargin, r.y, r.report.Title)
+	r.y += pdfTitleSize / 2
+
+	for _, param := range r.report.Params {
+		r.y += pdfParamSize + 3
+		r.doc.Text(r.fonts.regular, pdfParamSize, pdfMargin, r.y,
+			fmt.Sprintf("%s: %s", param.Name, displayValue(param.Value, cellString)))
+	}
+	r.y += pdfParamSize
+}
+
+func (r *pdfReport) newPage() {
+	r.doc.AddPage()
+	r.y = pdfMargin
+}
+
+func (r *pdfReport) writeTable(columns []pdfColumn) {
+	r.writeHeader(columns)
+	for _, row := range r.report.Rows {
+		r.writeRow(columns, rowValues(row, r.headers), false)
+	}
+	if footer := r.report.footerValues(r.headers); footer != nil {
+		r.writeRow(columns, footer, true)
+	}
+}
+
+// Шапка таблицы: длинные заголовки переносятся по словам
+func (r *pdfReport) writeHeader(columns []pdfColumn) {
+	lines := make([][]string, len(columns))
+	height := pdfRowHeight
+	for i, column := range columns {
+		lines[i] = wrapText(r.fonts.bold, column.header.Title, column.width-2*pdfCellPadding, pdfHeaderLines)
+		height = max(height, float64(len(lines[i]))*pdfHeaderLine+pdfRowHeight-pdfHeaderLine)
+	}
+
+	if r.y+height+pdfRowHeight > r.doc.Height()-pdfMargin-pdfFooterHeight {
+		r.newPage()
+	}
+
+	x := pdfMargin
+	r.doc.FillRect(x, r.y, tableWidth(columns), height, 0.85)
+	for i, column := range columns {
+		for j, line := range lines[i] {
+			r.doc.Text(r.fonts.bold, pdfCellSize, x+pdfCellPadding, r.y+pdfCellSize+3+float64(j)*pdfHeaderLine, line)
+		}
+		x += column.width
+	}
+	r.drawGrid(columns, height)
+	r.y += height
+}
+
+func (r *pdfReport) writeRow(columns []pdfColumn, values []interface{}, total bool) {
+	if r.y+pdfRowHeight > r.doc.Height()-pdfMargin-pdfFooterHeight {
+		r.newPage()
+		r.writeHeader(columns)
+	}
+
+	font := r.fonts.regular
+	if total {
+		font = r.fonts.bold
+		r.doc.FillRect(pdfMargin, r.y, tableWidth(columns), pdfRowHeight, 0.92)
+	}
+
+	x := pdfMargin
+	for _, column := range columns {
+		text := fitText(font, displayValue(values[column.index], column.format), column.width-2*pdfCellPadding)
+		textX := x + pdfCellPadding
+		// Числа выравниваются по правому краю
+		if column.format != cellString && column.format != cellDate && column.format != cellDateTime {
+			textX = x + column.width - pdfCellPadding - font.TextWidth(text, pdfCellSize)
+		}
+		r.doc.Text(font, pdfCellSize, textX, r.y+pdfCellSize+3, text)
+		x += column.width
+	}
+	r.drawGrid(columns, pdfRowHeight)
+	r.y += pdfRowHeight
+}
+
+func (r *pdfReport) drawGrid(columns []pdfColumn, height float64) {
+	right := pdfMargin + tableWidth(columns)
+	r.doc.Line(pdfMargin, r.y, right, r.y, 0.5, 0.6)
+	r.doc.Line(pdfMargin, r.y+height, right, r.y+height, 0.5, 0.6)
+
+	x := pdfMargin
+	r.doc.Line(x, r.y, x, r.y+height, 0.5, 0.6)
+	for _, column := range columns {
+		x += column.width
+		r.doc.Line(x, r.y, x, r.y+height, 0.5, 0.6)
+	}
+}
+
+// Ширина колонки по самому длинному значению и самому длинному слову заголовка
+func pdfColumns(report *tableReport, headers []domain.Header, fonts *pdfFonts) []pdfColumn {
+	columns := make([]pdfColumn, len(headers))
+	for i, header := range headers {
+		column := pdfColumn{header: header, format: headerCellFormat(header.Format), index: i}
+		width := 0.0
+		if i == 0 && report.Footer != nil {
+			width = fonts.bold.TextWidth(reportTotalLabel, pdfCellSize)
+		}
+		for _, word := range strings.Fields(header.Title) {
+			width = max(width, fonts.bold.TextWidth(word, pdfCellSize))
+		}
+		for _, row := range report.Rows {
+			width = max(width, fonts.regular.TextWidth(displayValue(row[header.Name], column.format), pdfCellSize))
+		}
+		if report.Footer != nil {
+			width = max(width, fonts.bold.TextWidth(displayValue(report.Footer[header.Name], column.format), pdfCellSize))
+		}
+		column.width = min(max(width+2*pdfCellPadding, pdfMinColumn), pdfMaxColumn)
+		columns[i] = column
+	}
+	return columns
+}
+
+// Делит колонки на части по ширине страницы и растягивает каждую часть на всю ширину
+func pdfColumnGroups(columns []pdfColumn, pageWidth float64) [][]pdfColumn {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	var aside []pdfColumn
+	rest := columns
+	if columns[0].header.IsAsideHeader && len(columns) > 1 {
+		aside = columns[:1]
+		rest = columns[1:]
+	}
+
+	var groups [][]pdfColumn
+	group := append([]pdfColumn{}, aside...)
+	for _, column := range rest {
+		if len(group) > len(aside) && tableWidth(group)+column.width > pageWidth {
+			groups = append(groups, group)
+			group = append([]pdfColumn{}, aside...)
+		}
+		group = append(group, column)
+	}
+	groups = append(groups, group)
+
+	for _, group := range groups {
+		scale := pageWidth / tableWidth(group)
+		for i := range group {
+			group[i].width *= scale
+		}
+	}
+	return groups
+}
+
+func tableWidth(columns []pdfColumn) float64 {
+	width := 0.0
+	for _, column := range columns {
+		width += column.width
+	}
+	return width
+}
+
+// Перенос по словам не больше чем в maxLines строк, остаток обрезается
+func wrapText(font *pdf.Font, text string, width float64, maxLines int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line == "" || font.TextWidth(candidate, pdfCellSize) <= width {
+			line = candidate
+			continue
+		}
+		lines = append(lines, line)
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += "…"
+	}
+	for i, line := range lines {
+		lines[i] = fitText(font, line, width)
+	}
+	return lines
+}
+
+// Обрезает текст с многоточием, если он не помещается в ячейку
+func fitText(font *pdf.Font, text string, width float64) string {
+	if font.TextWidth(text, pdfCellSize) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if candidate := string(runes) + "…"; font.TextWidth(candidate, pdfCellSize) <= width {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,265 @@
+package audience
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+
+	"reporting-service/internal/domain"
+)
+
+// Подпись итоговой строки, если в футере отчета первая колонка пустая
+const reportTotalLabel = "ИТОГО"
+
+// Табличный отчет в том виде, в каком его отдает API: колонки описываются заголовками,
+// значения строк и итоговой строки берутся по Header.Name
+type tableReport struct {
+	Title   string
+	Sheet   string
+	Headers []domain.Header
+	Rows    []map[string]interface{}
+	Footer  map[string]interface{}
+	// Параметры построения отчета: в XLSX выводятся отдельным листом, в PDF под заголовком
+	Params []reportParam
+	// Дополнительные листы, выводятся только в XLSX
+	Sheets []reportSheet
+}
+
+type reportSheet struct {
+	Name    string
+	Columns []xlsxColumn
+	Rows    [][]interface{}
+}
+
+type reportParam struct {
+	Name  string
+	Value interface{}
+}
+
+// Скрытые колонки, например идентификаторы, в файл не попадают
+func (r *tableReport) visibleHeaders() []domain.Header {
+	headers := make([]domain.Header, 0, len(r.Headers))
+	for _, header := range r.Headers {
+		if header.IsVisible {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+func (r *tableReport) footerValues(headers []domain.Header) []interface{} {
+	if r.Footer == nil || len(headers) == 0 {
+		return nil
+	}
+	values := rowValues(r.Footer, headers)
+	if values[0] == nil || values[0] == "" {
+		values[0] = reportTotalLabel
+	}
+	return values
+}
+
+func rowValues(row map[string]interface{}, headers []domain.Header) []interface{} {
+	values := make([]interface{}, len(headers))
+	for i, header := range headers {
+		values[i] = row[header.Name]
+	}
+	return values
+}
+
+func headerColumns(headers []domain.Header) []xlsxColumn {
+	columns := make([]xlsxColumn, 0, len(headers))
+	for _, header := range headers {
+		columns = append(columns, xlsxColumn{Title: header.Title, Format: headerCellFormat(header.Format)})
+	}
+	return columns
+}
+
+// Формирует файл отчета в нужном формате и сохраняет его в хранилище
+func (e *ExcelExporter) exportReport(ctx context.Context, report *tableReport, format, dir, prefix string) (string, string, error) {
+	fileName := exportFileName(prefix, format)
+
+	switch format {
+	case ExportFormatXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+
+		styles, err := newXLSXStyles(f)
+		if err != nil {
+			return "", "", err
+		}
+		if err := writeReportSheet(f, styles, report); err != nil {
+			return "", "", err
+		}
+		for _, extra := range report.Sheets {
+			if err := writeExtraSheet(f, styles, extra); err != nil {
+				return "", "", err
+			}
+		}
+		if err := writeReportParams(f, styles, report.Params); err != nil {
+			return "", "", err
+		}
+
+		key, err := e.saveWorkbook(ctx, f, dir, fileName)
+		if err != nil {
+			return "", "", err
+		}
+		return key, fileName, nil
+	case ExportFormatPDF:
+		fonts, err := e.loadPDFFonts()
+		if err != nil {
+			return "", "", err
+		}
+
+		tmp, err := os.CreateTemp("", "export-*.pdf")
+		if err != nil {
+			return "", "", fmt.Errorf("create temp file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if err := writeReportPDF(tmp, report, fonts); err != nil {
+			return "", "", err
+		}
+
+		key, err := e.saveFile(ctx, tmp, dir, fileName)
+		if err != nil {
+			return "", "", err
+		}
+		return key, fileName, nil
+	case ExportFormatCSV, ExportFormatTSV:
+		tmp, err := os.CreateTemp("", "export-*."+format)
+		if err != nil {
+			return "", "", fmt.Errorf("create temp file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if err := writeReportCSV(tmp, report, format); err != nil {
+			return "", "", err
+		}
+
+		key, err := e.saveFile(ctx, tmp, dir, fileName)
+		if err != nil {
+			return "", "", err
+		}
+		return key, fileName, nil
+	default:
+		return "", "", fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// Лист отчета: шапка из заголовков, строки данных и выделенная итоговая строка
+func writeReportSheet(f *excelize.File, styles *xlsxStyles, report *tableReport) error {
+	headers := report.visibleHeaders()
+
+	sheet, err := newXLSXSheet(f, report.Sheet, headerColumns(headers), styles)
+	if err != nil {
+		return err
+	}
+	for _, row := range report.Rows {
+		if err := sheet.WriteRow(rowValues(row, headers)...); err != nil {
+			return err
+		}
+	}
+	if footer := report.footerValues(headers); footer != nil {
+		if err := sheet.WriteTotal(footer...); err != nil {
+			return err
+		}
+	}
+	return sheet.Close()
+}
+
+func writeExtraSheet(f *excelize.File, styles *xlsxStyles, extra reportSheet) error {
+	sheet, err := newXLSXSheet(f, extra.Name, extra.Columns, styles)
+	if err != nil {
+		return err
+	}
+	for _, row := range extra.Rows {
+		if err := sheet.WriteRow(row...); err != nil {
+			return err
+		}
+	}
+	return sheet.Close()
+}
+
+func writeReportParams(f *excelize.File, styles *xlsxStyles, params []reportParam) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	sheet, err := newXLSXSheet(f, "Параметры", []xlsxColumn{
+		{Title: "Параметр", Format: cellString},
+		{Title: "Значение", Format: cellString},
+	}, styles)
+	if err != nil {
+		return err
+	}
+	for _, param := range params {
+		if err := sheet.WriteRow(param.Name, param.Value); err != nil {
+			return err
+		}
+	}
+	return sheet.Close()
+}
+
+// В CSV попадает только таблица отчета, параметры построения в нем не выводятся
+func writeReportCSV(w io.Writer, report *tableReport, format string) error {
+	headers := report.visibleHeaders()
+
+	if _, err := w.Write(utf8BOM); err != nil {
+		return fmt.Errorf("write bom: %w", err)
+	}
+	writer := csv.NewWriter(w)
+	if format == ExportFormatTSV {
+		writer.Comma = '\t'
+	}
+	writer.UseCRLF = true
+
+	record := make([]string, len(headers))
+	for i, header := range headers {
+		record[i] = header.Title
+	}
+	if err := writer.Write(record); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	writeValues := func(values []interface{}) error {
+		for i, value := range values {
+			record[i] = reportCSVValue(value, headerCellFormat(headers[i].Format))
+		}
+		return writer.Write(record)
+	}
+	for _, row := range report.Rows {
+		if err := writeValues(rowValues(row, headers)); err != nil {
+			return fmt.Errorf("write row: %w", err)
+		}
+	}
+	if footer := report.footerValues(headers); footer != nil {
+		if err := writeValues(footer); err != nil {
+			return fmt.Errorf("write footer: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("flush csv: %w", err)
+	}
+	return nil
+}
+
+// Доли в процентных колонках выводятся процентами, как в Excel
+func reportCSVValue(value interface{}, format string) string {
+	if v, ok := value.(float64); ok && format == cellPercent {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(v*100, 'f', 2, 64) + "%"
+	}
+	return csvValue(cellValue(value))
+}
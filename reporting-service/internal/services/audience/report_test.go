@@ -0,0 +1,221 @@
+package audience
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/storage"
+)
+
+// Отчет с итоговой строкой, скрытой колонкой, параметрами и дополнительным листом
+func testTableReport() *tableReport {
+	return &tableReport{
+		Title: "Отчет по колл-центру",
+		Sheet: "Колл-центр",
+		Headers: []domain.Header{
+			{Name: "manager_id", Title: "ID", IsID: true},
+			{Name: "manager", Title: "Менеджер", IsVisible: true},
+			{Name: "calls", Title: "Звонки", IsVisible: true, Format: "number"},
+			{Name: "conversion", Title: "Конверсия", IsVisible: true, Format: "percent"},
+		},
+		Rows: []map[string]interface{}{
+			{"manager_id": int64(11), "manager": "Иванов", "calls": int64(10), "conversion": 0.25},
+			{"manager_id": int64(12), "manager": "Петрова, А.", "calls": int64(30), "conversion": 1.0 / 3},
+		},
+		Footer: map[string]interface{}{"calls": int64(40), "conversion": 0.3},
+		Params: []reportParam{{Name: "Период", Value: "01.01.2025 – 31.01.2025"}},
+		Sheets: []reportSheet{{
+			Name:    "Аномалии",
+			Columns: []xlsxColumn{{Title: "Менеджер", Format: cellString}, {Title: "Описание", Format: cellString}},
+			Rows:    [][]interface{}{{"Петрова, А.", "Конверсия ниже средней"}},
+		}},
+	}
+}
+
+// Экспортер с локальным хранилищем и шрифтом Go вместо системного
+func newTestExporter(t *testing.T) (*ExcelExporter, *storage.LocalStorage) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.NewLocalStorage(filepath.Join(dir, "export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fontPath := filepath.Join(dir, "goregular.ttf")
+	if err := os.WriteFile(fontPath, goregular.TTF, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &ExcelExporter{
+		storage:       store,
+		pdfFontConfig: PDFFontConfig{Regular: fontPath, Bold: fontPath},
+	}, store
+}
+
+// Один и тот же отчет во всех форматах: набор колонок, итоговая строка и параметры
+func TestExportReportFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		check  func(t *testing.T, data []byte)
+	}{
+		{ExportFormatXLSX, checkReportXLSX},
+		{ExportFormatCSV, func(t *testing.T, data []byte) {
+			want := "\ufeff" +
+				"Менеджер,Звонки,Конверсия\r\n" +
+				"Иванов,10,25.00%\r\n" +
+				"\"Петрова, А.\",30,33.33%\r\n" +
+				"ИТОГО,40,30.00%\r\n"
+			if string(data) != want {
+				t.Errorf("csv = %q, want %q", data, want)
+			}
+		}},
+		{ExportFormatTSV, func(t *testing.T, data []byte) {
+			want := "\ufeff" +
+				"Менеджер\tЗвонки\tКонверсия\r\n" +
+				"Иванов\t10\t25.00%\r\n" +
+				"Петрова, А.\t30\t33.33%\r\n" +
+				"ИТОГО\t40\t30.00%\r\n"
+			if string(data) != want {
+				t.Errorf("tsv = %q, want %q", data, want)
+			}
+		}},
+		{ExportFormatPDF, checkReportPDF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			e, store := newTestExporter(t)
+			ctx := context.Background()
+
+			key, fileName, err := e.exportReport(ctx, testTableReport(), tt.format, "CALL_CENTER_REPORTS", "call_center_report")
+			if err != nil {
+				t.Fatalf("exportReport: %v", err)
+			}
+			if !strings.HasPrefix(fileName, "call_center_report_") || !strings.HasSuffix(fileName, "."+tt.format) {
+				t.Errorf("file name = %q", fileName)
+			}
+			if key != "CALL_CENTER_REPORTS/"+fileName {
+				t.Errorf("key = %q", key)
+			}
+
+			object, err := store.Open(ctx, key)
+			if err != nil {
+				t.Fatalf("open export: %v", err)
+			}
+			defer object.Close()
+			data, err := io.ReadAll(object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(data)) != object.Size {
+				t.Errorf("read %d bytes, size %d", len(data), object.Size)
+			}
+			tt.check(t, data)
+		})
+	}
+
+	e, _ := newTestExporter(t)
+	if _, _, err := e.exportReport(context.Background(), testTableReport(), "docx", "CALL_CENTER_REPORTS", "report"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func checkReportXLSX(t *testing.T, data []byte) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("open xlsx: %v", err)
+	}
+	defer f.Close()
+
+	if sheets := f.GetSheetList(); !reflect.DeepEqual(sheets, []string{"Колл-центр", "Аномалии", "Параметры"}) {
+		t.Errorf("sheets = %v", sheets)
+	}
+
+	sheets := map[string][][]string{
+		"Колл-центр": {
+			{"Менеджер", "Звонки", "Конверсия"},
+			{"Иванов", "10", "0.25"},
+			{"Петрова, А.", "30", "0.3333333333333333"},
+			{"ИТОГО", "40", "0.3"},
+		},
+		"Аномалии": {
+			{"Менеджер", "Описание"},
+			{"Петрова, А.", "Конверсия ниже средней"},
+		},
+		"Параметры": {
+			{"Параметр", "Значение"},
+			{"Период", "01.01.2025 – 31.01.2025"},
+		},
+	}
+	for sheet, want := range sheets {
+		rows, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+		if err != nil {
+			t.Fatalf("read sheet %s: %v", sheet, err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("sheet %s = %q, want %q", sheet, rows, want)
+		}
+	}
+
+	// Проценты хранятся долями и показываются форматом ячейки
+	formatted, err := f.GetCellValue("Колл-центр", "C2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != "25.00%" {
+		t.Errorf("C2 = %q, want 25.00%%", formatted)
+	}
+}
+
+var pdfStreamPattern = regexp.MustCompile(`(?s)<< /Length (\d+) /Filter /FlateDecode[^>]*>>\nstream\n`)
+
+// Текст в PDF записан номерами глифов, поэтому строки отчета проверяются по ToUnicode
+func checkReportPDF(t *testing.T, data []byte) {
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("not a pdf document")
+	}
+	if !bytes.Contains(data, []byte("/Type /Pages /Count 1 ")) {
+		t.Error("report should fit one page")
+	}
+
+	var text bytes.Buffer
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		var length int
+		fmt.Sscan(string(data[match[2]:match[3]]), &length)
+		zr, err := zlib.NewReader(bytes.NewReader(data[match[1] : match[1]+length]))
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		decoded, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		text.Write(decoded)
+	}
+
+	for _, r := range "Отчет по колл-центру Период Менеджер Звонки Конверсия Иванов Петрова ИТОГО 25.00% Страница 1 из 1" {
+		if r == ' ' {
+			continue
+		}
+		if mapping := regexp.MustCompile(fmt.Sprintf(`<[0-9A-F]{4}> <%04X>\n`, r)); !mapping.Match(text.Bytes()) {
+			t.Errorf("pdf has no text %q", r)
+		}
+	}
+	// Скрытая колонка и дополнительные листы в PDF не выводятся
+	for _, r := range "IDй" {
+		if mapping := regexp.MustCompile(fmt.Sprintf(`<[0-9A-F]{4}> <%04X>\n`, r)); mapping.Match(text.Bytes()) {
+			t.Errorf("pdf has unexpected text %q", r)
+		}
+	}
+}
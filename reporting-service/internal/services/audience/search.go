@@ -0,0 +1,165 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/repository"
+)
+
+// Сколько найденных заявок передается в фильтр списка и выгрузки. Список показывает лучшие
+// совпадения и отмечает, что найдено больше; выгрузка с неполным результатом не строится
+const (
+	searchResultLimit       = 1000
+	searchExportResultLimit = 20000
+)
+
+// Переносит в поисковый индекс заявки, измененные с прошлой синхронизации
+func (s *Service) SyncSearchIndex(ctx context.Context) error {
+	since, afterID, err := s.audienceRepo.SearchIndexPosition(ctx)
+	if err != nil {
+		return err
+	}
+	return s.syncSearchIndexFrom(ctx, since, afterID, "search index synced")
+}
+
+// Перечитывает в индекс все заявки. Имя и телефон клиента меняются в estate_deals_contacts,
+// а updated_at заявки при этом не меняется, и синхронизация изменений их не видит
+func (s *Service) RebuildSearchIndex(ctx context.Context) error {
+	return s.syncSearchIndexFrom(ctx, time.Time{}, 0, "search index rebuilt")
+}
+
+func (s *Service) syncSearchIndexFrom(ctx context.Context, since time.Time, afterID int64, message string) error {
+	batchSize := s.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	synced := 0
+	for {
+		entries, err := s.mysqlRepo.ListApplicationsForSearch(ctx, since, afterID, batchSize)
+		if err != nil {
+			return fmt.Errorf("list applications for search: %w", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		if err := s.audienceRepo.UpsertSearchEntries(ctx, entries); err != nil {
+			return fmt.Errorf("upsert search entries: %w", err)
+		}
+		synced += len(entries)
+
+		last := entries[len(entries)-1]
+		since, afterID = last.UpdatedAt, last.ApplicationID
+		if len(entries) < batchSize {
+			break
+		}
+	}
+
+	if synced > 0 {
+		s.logger.Info(message, zap.Int("applications", synced))
+	}
+	return nil
+}
+
+// Заполняет SearchIDs и SearchTotal по поисковому запросу фильтра
+func (s *Service) applySearch(ctx context.Context, filter *domain.ApplicationFilterRequest, limit int) error {
+	if filter.Query == "" {
+		return nil
+	}
+
+	ids, total, err := s.audienceRepo.SearchApplicationIDs(ctx, filter.Query, limit)
+	if err != nil {
+		return fmt.Errorf("search applications: %w", err)
+	}
+	filter.SearchIDs, filter.SearchTotal = ids, total
+	return nil
+}
+
+// Поиск для выгрузки: все совпадения или ошибка, если их слишком много
+func (s *Service) applyExportSearch(ctx context.Context, filter *domain.ApplicationFilterRequest) error {
+	if err := s.applySearch(ctx, filter, searchExportResultLimit); err != nil {
+		return err
+	}
+	if filter.SearchTotal > len(filter.SearchIDs) {
+		return domain.NewValidationError("q", domain.ValidationCodeInvalidRange,
+			fmt.Sprintf("search matches %d applications, export is limited to %d: refine the query", filter.SearchTotal, searchExportResultLimit))
+	}
+	return nil
+}
+
+func highlightResponse(response *domain.PaginationResponse, filter *domain.ApplicationFilterRequest) {
+	if filter.Query == "" {
+		return
+	}
+	response.SearchTotal = filter.SearchTotal
+	response.SearchTruncated = filter.SearchTotal > len(filter.SearchIDs)
+	if items, ok := response.Items.([]map[string]interface{}); ok {
+		highlightApplications(items, filter.Query)
+	}
+}
+
+// Позиции совпадений запроса в имени, телефоне и номере заявки, если эти колонки выбраны
+func highlightApplications(items []map[string]interface{}, q string) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	digits := repository.PhoneDigits(q)
+
+	for _, item := range items {
+		highlights := []domain.SearchHighlight{}
+		if name, ok := item["name"].(string); ok {
+			if offset, length, ok := matchText(name, q); ok {
+				highlights = append(highlights, domain.SearchHighlight{Field: "name", Offset: offset, Length: length})
+			}
+		}
+		if phone, ok := item["phone"].(string); ok && len(digits) >= 3 {
+			if offset, length, ok := matchDigits(phone, digits); ok {
+				highlights = append(highlights, domain.SearchHighlight{Field: "phone", Offset: offset, Length: length})
+			}
+		}
+		if id, ok := item["id"].(int64); ok && digits != "" && digits == q && strings.HasPrefix(strconv.FormatInt(id, 10), digits) {
+			highlights = append(highlights, domain.SearchHighlight{Field: "id", Offset: 0, Length: len(digits)})
+		}
+		if len(highlights) > 0 {
+			item["highlights"] = highlights
+		}
+	}
+}
+
+func matchText(value, q string) (int, int, bool) {
+	lower := strings.ToLower(value)
+	index := strings.Index(lower, q)
+	if q == "" || index < 0 {
+		return 0, 0, false
+	}
+	return utf8.RuneCountInString(lower[:index]), utf8.RuneCountInString(q), true
+}
+
+// Совпадение по цифрам телефона с учетом пробелов, скобок и дефисов между ними
+func matchDigits(phone, digits string) (int, int, bool) {
+	positions := []int{}
+	var phoneDigits strings.Builder
+	position := 0
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			phoneDigits.WriteRune(r)
+			positions = append(positions, position)
+		}
+		position++
+	}
+
+	index := strings.Index(phoneDigits.String(), digits)
+	if index < 0 {
+		return 0, 0, false
+	}
+	start := positions[index]
+	end := positions[index+len(digits)-1]
+	return start, end - start + 1, true
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -13,8 +17,10 @@ import (
 	//"golang.org/x/text/message"
 
 	"reporting-service/internal/domain"
+	"reporting-service/internal/mailer"
 	MysqlRepo "reporting-service/internal/repository/mysql"
 	PostgreRepo "reporting-service/internal/repository/postgre"
+	"reporting-service/internal/storage"
 )
 
 type Service struct {
@@ -24,18 +30,30 @@ type Service struct {
 	amqpChan     *amqp.Channel
 	config       Config
 	exporter     *ExcelExporter
+	storage      storage.Storage
+	mailer       mailer.Mailer
 }
 
 type Config struct {
 	UpdateTime string `yaml:"update_time"`
 	BatchSize  int    `yaml:"batch_size"`
 	ExportPath string `yaml:"export_path"`
+	// Ключ подписи ссылок на скачивание, срок жизни ссылки и срок хранения готовых файлов
+	ExportSigningKey string        `yaml:"export_signing_key"`
+	ExportLinkTTL    time.Duration `yaml:"export_link_ttl"`
+	ExportRetention  time.Duration `yaml:"export_retention"`
+	// Шрифты для PDF отчетов
+	PDFFonts PDFFontConfig `yaml:"pdf_fonts"`
+	// Правила поиска аномалий в отчете по колл-центру
+	Anomalies AnomalyConfig `yaml:"anomalies"`
 }
 
 func NewService(
 	cfg Config,
 	mysqlRepo *MysqlRepo.MySQLAudienceRepository,
 	audienceRepo *PostgreRepo.PostgresAudienceRepository,
+	store storage.Storage,
+	mail mailer.Mailer,
 	amqpChan *amqp.Channel,
 	logger *zap.Logger) *Service {
 	s := &Service{
@@ -44,7 +62,9 @@ func NewService(
 		amqpChan:     amqpChan,
 		logger:       logger,
 		config:       cfg,
-		exporter:     NewExcelExporter(*audienceRepo, *mysqlRepo, logger),
+		exporter:     NewExcelExporter(*audienceRepo, *mysqlRepo, store, cfg.PDFFonts, logger),
+		storage:      store,
+		mailer:       mail,
 	}
 
 	if err := s.setupRabbitMQ(); err != nil {
@@ -90,9 +110,12 @@ func (s *Service) setupRabbitMQ() error {
 }
 
 func (s *Service) GetFilters(ctx context.Context) (domain.ApplicationFilterResponce, error) {
-	filter := domain.ApplicationFilterResponce{}
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return domain.ApplicationFilterResponce{}, err
+	}
 
-	filter, err := s.mysqlRepo.GetFilters(ctx)
+	filter, err := s.mysqlRepo.GetFilters(ctx, nonTarget)
 
 	if err != nil {
 		return domain.ApplicationFilterResponce{}, fmt.Errorf("get filters: %w", err)
@@ -115,6 +138,8 @@ func (s *Service) GetById(ctx context.Context, id int64) (*domain.AudienceRespon
 	var response = domain.AudienceResponse{
 		ID:           audience.ID,
 		Name:         audience.Name,
+		Folder:       audience.Folder,
+		Tags:         audience.Tags,
 		Integrations: audience.Integrations,
 		CreatedAt:    audience.CreatedAt,
 		UpdatedAt:    audience.UpdatedAt,
@@ -123,26 +148,132 @@ func (s *Service) GetById(ctx context.Context, id int64) (*domain.AudienceRespon
 	return &response, nil
 }
 
-func (s *Service) AudienceList(ctx context.Context) ([]domain.AudienceResponse, error) {
-	audiences, err := s.audienceRepo.List(ctx)
+func (s *Service) AudienceList(ctx context.Context, pagination *domain.PaginationRequest, filter *domain.AudienceListFilter) (*domain.PaginationResponse, error) {
+	if pagination.PageSize <= 0 {
+		pagination.PageSize = 10
+	}
+	if pagination.Page <= 0 {
+		pagination.Page = 1
+	}
+
+	audiences, totalItems, err := s.audienceRepo.ListPage(ctx, pagination, filter)
 	if err != nil {
 		return nil, fmt.Errorf("get audiences: %w", err)
 	}
 
-	var response []domain.AudienceResponse
-	for _, a := range audiences {
-		response = append(response, domain.AudienceResponse{
-			ID:                 a.ID,
-			Name:               a.Name,
-			Integrations:       a.Integrations,
-			Applications_count: len(a.Application_ids),
-			CreatedAt:          a.CreatedAt,
-			UpdatedAt:          a.UpdatedAt,
-		})
+	headers := []domain.Header{
+		{Name: "id", IsID: true, IsAsideHeader: true, Title: "ID", IsVisible: false, Format: "number"},
+		{Name: "name", Title: "Название", IsVisible: true, Format: "string", IsSortable: true},
+		{Name: "folder", Title: "Папка", IsVisible: true, Format: "string"},
+		{Name: "tags", Title: "Теги", IsVisible: true, Format: "enum"},
+		{Name: "application_count", Title: "Размер", IsVisible: true, Format: "number", IsSortable: true},
+		{Name: "integrations", Title: "Интеграции", IsVisible: true, Format: "enum"},
+		{Name: "created_at", Title: "Создана", IsVisible: true, IsAdditional: true, Format: "date", IsSortable: true},
+		{Name: "updated_at", Title: "Обновлена", IsVisible: true, Format: "date", IsSortable: true},
+	}
+
+	totalPages := int(math.Ceil(float64(totalItems) / float64(pagination.PageSize)))
+	return &domain.PaginationResponse{
+		Headers:    headers,
+		Items:      audiences,
+		TotalItems: totalItems,
+		TotalPages: &totalPages,
+		Page:       pagination.Page,
+		PageSize:   pagination.PageSize,
+	}, nil
+}
+
+func (s *Service) Update(ctx context.Context, id int64, req domain.AudienceUpdateRequest) (*domain.AudienceResponse, error) {
+	result := &domain.ValidationResult{}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		result.AddError("name", domain.ValidationCodeRequired, "audience name must not be empty")
+	}
+
+	if req.Filter != nil && len(result.Errors) == 0 {
+		if err := s.resolveFilterIDs(ctx, req.Filter); err != nil {
+			return nil, err
+		}
+		filterResult, err := s.validateAudienceFilter(ctx, req.Filter, id)
+		if err != nil {
+			return nil, fmt.Errorf("validate filter: %w", err)
+		}
+		result.Errors = append(result.Errors, filterResult.Errors...)
+		result.Warnings = append(result.Warnings, filterResult.Warnings...)
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	if req.Filter == nil {
+		if err := s.audienceRepo.UpdateLabels(ctx, id, &req); err != nil {
+			return nil, fmt.Errorf("update audience: %w", err)
+		}
+	} else {
+		count, err := s.replaceAudienceFilter(ctx, id, &req)
+		if err != nil {
+			return nil, err
+		}
+		addEmptyResultWarning(result, count)
+	}
+
+	response, err := s.GetById(ctx, id)
+	if err != nil {
+		return nil, err
 	}
+	response.Warnings = result.Warnings
 	return response, nil
 }
 
+// Пересобирает состав аудитории по новому фильтру вместе с названием, папкой и тегами
+// и учитывает разницу в статистике. Возвращает число заявок в аудитории.
+func (s *Service) replaceAudienceFilter(ctx context.Context, id int64, req *domain.AudienceUpdateRequest) (int, error) {
+	currentIDs, err := s.audienceRepo.GetApplicationIdsByAdienceId(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("get current applications: %w", err)
+	}
+
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return 0, err
+	}
+	applications, err := s.mysqlRepo.GetApplicationsByAudienceFilter(ctx, *req.Filter, nonTarget)
+	if err != nil {
+		return 0, fmt.Errorf("get applications: %w", err)
+	}
+
+	if err := s.audienceRepo.ReplaceFilter(ctx, id, req, applications); err != nil {
+		return 0, fmt.Errorf("replace filter: %w", err)
+	}
+
+	current := make(map[int64]bool, len(currentIDs))
+	for _, applicationID := range currentIDs {
+		current[applicationID] = true
+	}
+	added := 0
+	for _, application := range applications {
+		if current[application.ID] {
+			delete(current, application.ID)
+		} else {
+			added++
+		}
+	}
+
+	if err := s.audienceRepo.RecordDailyStats(ctx, id, len(applications), added, len(current)); err != nil {
+		s.logger.Error("record audience stats failed",
+			zap.Int64("audience_id", id),
+			zap.Error(err))
+	}
+	return len(applications), nil
+}
+
+func (s *Service) AudienceLabels(ctx context.Context) (*domain.AudienceLabelsResponse, error) {
+	labels, err := s.audienceRepo.ListLabels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get audience labels: %w", err)
+	}
+	return labels, nil
+}
+
 func (s *Service) CreateIntegrations(ctx context.Context, req domain.IntegrationsCreateRequest) (*domain.IntegrationsCreateResponse, error) {
 	integrations := make([]domain.Integration, 0, len(req.AudienceIds))
 	for _, id := range req.AudienceIds {
@@ -159,30 +290,92 @@ func (s *Service) CreateIntegrations(ctx context.Context, req domain.Integration
 }
 
 func (s *Service) Create(ctx context.Context, req domain.AudienceCreateRequest) (*domain.AudienceResponse, error) {
+	if err := s.resolveFilterIDs(ctx, &req.Filter); err != nil {
+		return nil, err
+	}
+
+	validation, err := s.validateAudienceFilter(ctx, &req.Filter, 0)
+	if err != nil {
+		return nil, fmt.Errorf("validate filter: %w", err)
+	}
+	if err := validation.Err(); err != nil {
+		return nil, err
+	}
+
 	audience := &domain.Audience{
 		Name:      req.Name,
+		Folder:    strings.TrimSpace(req.Folder),
+		Tags:      req.Tags,
 		Filter:    req.Filter,
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
 	}
-	applications, err := s.mysqlRepo.GetApplicationsByAudienceFilter(ctx, req.Filter)
-
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applications, err := s.mysqlRepo.GetApplicationsByAudienceFilter(ctx, req.Filter, nonTarget)
 	if err != nil {
 		return nil, fmt.Errorf("get applications: %w", err)
 	}
 
 	audience.Applications = applications
+	addEmptyResultWarning(validation, len(applications))
 
 	if err := s.audienceRepo.Create(ctx, audience); err != nil {
 		return nil, fmt.Errorf("create audience: %w", err)
 	}
 
+	if err := s.audienceRepo.RecordDailyStats(ctx, audience.ID, len(applications), len(applications), 0); err != nil {
+		s.logger.Error("record audience stats failed",
+			zap.Int64("audience_id", audience.ID),
+			zap.Error(err))
+	}
+
 	return &domain.AudienceResponse{
-		ID:           audience.ID,
-		Name:         audience.Name,
-		Integrations: audience.Integrations,
-		CreatedAt:    audience.CreatedAt,
-		UpdatedAt:    audience.UpdatedAt,
+		ID:                 audience.ID,
+		Name:               audience.Name,
+		Folder:             audience.Folder,
+		Tags:               audience.Tags,
+		Integrations:       audience.Integrations,
+		Applications_count: len(applications),
+		CreatedAt:          audience.CreatedAt,
+		UpdatedAt:          audience.UpdatedAt,
+		Warnings:           validation.Warnings,
+	}, nil
+}
+
+func (s *Service) Preview(ctx context.Context, req domain.AudiencePreviewRequest) (*domain.AudiencePreviewResponse, error) {
+	if err := s.resolveFilterIDs(ctx, &req.Filter); err != nil {
+		return nil, err
+	}
+
+	validation, err := s.validateAudienceFilter(ctx, &req.Filter, req.AudienceID)
+	if err != nil {
+		return nil, fmt.Errorf("validate filter: %w", err)
+	}
+	if err := validation.Err(); err != nil {
+		return nil, err
+	}
+
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.mysqlRepo.CountApplicationsByAudienceFilter(ctx, req.Filter, nonTarget)
+	if err != nil {
+		return nil, fmt.Errorf("count applications: %w", err)
+	}
+
+	addEmptyResultWarning(validation, count)
+
+	warnings := validation.Warnings
+	if warnings == nil {
+		warnings = []domain.FieldError{}
+	}
+	return &domain.AudiencePreviewResponse{
+		ApplicationsCount: count,
+		Warnings:          warnings,
 	}, nil
 }
 
@@ -210,7 +403,11 @@ func (s *Service) UpdateAudience(ctx context.Context, id int64, application_ids
 		return fmt.Errorf("get audience: %w", err)
 	}
 
-	requests, err := s.mysqlRepo.GetNewApplicationsByAudience(ctx, audience, application_ids)
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return err
+	}
+	requests, err := s.mysqlRepo.GetNewApplicationsByAudience(ctx, audience, application_ids, nonTarget)
 	if err != nil {
 		return fmt.Errorf("get requests: %w", err)
 	}
@@ -230,6 +427,15 @@ func (s *Service) ListApplications(ctx context.Context, pagination *domain.Pagin
 	// }
 	//s.logger.Info("audienceApplicationIDs", zap.Any("audienceApplicationIDs", audienceApplicationIDs))
 
+	if err := s.applySearch(ctx, filter, searchResultLimit); err != nil {
+		return nil, err
+	}
+
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if filter.AudienceName != "" {
 		audienceId, err := s.audienceRepo.GetByName(ctx, filter.AudienceName)
 		if err != nil {
@@ -241,20 +447,22 @@ func (s *Service) ListApplications(ctx context.Context, pagination *domain.Pagin
 			return nil, fmt.Errorf("get filter by audience id: %w", err)
 		}
 
-		filter.AudienceIDs = append(filter.AudienceIDs, string(audienceId.ID))
-		response, err := s.mysqlRepo.ListApplicationsWithFilters(ctx, pagination, filter, audience_filter)
+		filter.AudienceIDs = append(filter.AudienceIDs, strconv.FormatInt(audienceId.ID, 10))
+		response, err := s.mysqlRepo.ListApplicationsWithFilters(ctx, pagination, filter, audience_filter, nonTarget)
 		s.logger.Info("list applications", zap.Any("response", filter))
 		if err != nil {
 			return nil, fmt.Errorf("get applications: %w", err)
 		}
+		highlightResponse(response, filter)
 		return response, nil
 
 	} else {
-		response, err := s.mysqlRepo.ListApplicationsWithFilters(ctx, pagination, filter, &domain.AudienceCreationFilter{})
+		response, err := s.mysqlRepo.ListApplicationsWithFilters(ctx, pagination, filter, &domain.AudienceCreationFilter{}, nonTarget)
 		s.logger.Info("list applications", zap.Any("response", filter))
 		if err != nil {
 			return nil, fmt.Errorf("get applications: %w", err)
 		}
+		highlightResponse(response, filter)
 		return response, nil
 	}
 }
@@ -264,6 +472,10 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("list audiences: %w", err)
 	}
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return err
+	}
 	for _, audience := range audiences {
 		s.logger.Info("processing all audiences", zap.Int64("audience:", audience.ID))
 
@@ -271,7 +483,7 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 		filter, err := s.audienceRepo.GetFilterByAudienceId(ctx, audience.ID)
 		if err != nil {
 			s.logger.Error("get filter by audience id failed",
-				zap.String("audience_id", string(audience.ID)),
+				zap.Int64("audience_id", audience.ID),
 				zap.Error(err))
 			continue
 		}
@@ -281,25 +493,29 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 			StartDate:            filter.StartDate,
 			EndDate:              filter.EndDate,
 			StatusNames:          filter.StatusNames,
+			StatusIDs:            filter.StatusIDs,
 			RegectionReasonNames: filter.RegectionReasonNames,
 			NonTargetReasonNames: filter.NonTargetReasonNames,
+			RejectionReasonIDs:   filter.RejectionReasonIDs,
+			NonTargetReasonIDs:   filter.NonTargetReasonIDs,
+			ReasonIDs:            filter.ReasonIDs,
 		}
 
 		//Получаем текущие заявки по аудитории
 		current_applications, err := s.audienceRepo.GetApplicationIdsByAdienceId(ctx, audience.ID)
 		if err != nil {
 			s.logger.Error("get applications by audience filter failed",
-				zap.String("audience_id", string(audience.ID)),
+				zap.Int64("audience_id", audience.ID),
 				zap.Error(err))
 			continue
 		}
 		//s.logger.Info("current applications", zap.Any("current_applications", current_applications))
 
 		//Получаем заявки, которые изменили статус
-		changed_applications, err := s.mysqlRepo.GetChangedApplicationIds(ctx, &audience.Filter, current_applications)
+		changed_applications, err := s.mysqlRepo.GetChangedApplicationIds(ctx, &audience.Filter, current_applications, nonTarget)
 		if err != nil {
 			s.logger.Error("get changed applications failed",
-				zap.String("audience_id", string(audience.ID)),
+				zap.Int64("audience_id", audience.ID),
 				zap.Error(err))
 			continue
 		}
@@ -309,7 +525,7 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 		//Удаляем заявки с измененными статусами
 		if err := s.audienceRepo.DeleteApplications(ctx, audience.ID, changed_applications); err != nil {
 			s.logger.Error("delete applications with changed statuses failed",
-				zap.String("audience_id", string(audience.ID)),
+				zap.Int64("audience_id", audience.ID),
 				zap.Error(err))
 			continue
 		}
@@ -319,27 +535,35 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 		current_applications, err = s.audienceRepo.GetApplicationIdsByAdienceId(ctx, audience.ID)
 		if err != nil {
 			s.logger.Error("get changed applications failed",
-				zap.String("audience_id", string(audience.ID)),
+				zap.Int64("audience_id", audience.ID),
 				zap.Error(err))
 			continue
 		}
 		//s.logger.Info("current applications", zap.Any("current_applications", current_applications))
 
 		//Получаем обновленные заявки которые ещё не в аудитории
-		requests, err := s.mysqlRepo.GetNewApplicationsByAudience(ctx, &audience, current_applications)
+		requests, err := s.mysqlRepo.GetNewApplicationsByAudience(ctx, &audience, current_applications, nonTarget)
 		if err != nil {
 			s.logger.Error("get requests: ", zap.Error(err))
-			continue
+			requests = nil
 		}
 
 		//А эти в "новые"
-		if requests != nil {
+		if len(requests) > 0 {
 			if err := s.audienceRepo.UpdateApplicationsForAudience(ctx, audience.ID, requests); err != nil {
 				s.logger.Error("update requests: ", zap.Error(err))
-				continue
+				requests = nil
 			}
 		}
 
+		//Статистика роста и оттока за день: удаления уже применены, поэтому пишется при любом исходе поиска новых
+		if err := s.audienceRepo.RecordDailyStats(ctx, audience.ID,
+			len(current_applications)+len(requests), len(requests), len(changed_applications)); err != nil {
+			s.logger.Error("record audience stats failed",
+				zap.Int64("audience_id", audience.ID),
+				zap.Error(err))
+		}
+
 		//TODO: change on production
 		//if requests == nil && changed_applications == nil {
 		//	s.logger.Info("no changed or new requests found so nothing pushed to rabbit", zap.Any("audience_id", audience.ID))
@@ -378,7 +602,7 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 
 		if err := s.pushAudienceToRabbit(ctx, &audience, new_ids, changed_applications); err != nil {
 			s.logger.Error("process audience failed",
-				zap.String("audience_id", string(audience.ID)),
+				zap.Int64("audience_id", audience.ID),
 				zap.Error(err))
 			continue
 		}
@@ -388,7 +612,26 @@ func (s *Service) ProcessAllAudiences(ctx context.Context) error {
 }
 
 func (s *Service) ExportApplications(ctx context.Context, filter domain.ApplicationFilterRequest) (string, string, error) {
-	return s.exporter.ExportApplications(ctx, &filter)
+	if err := s.applyExportSearch(ctx, &filter); err != nil {
+		return "", "", err
+	}
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return s.exporter.ExportApplications(ctx, &filter, nonTarget)
+}
+
+// Потоковая выгрузка заявок в CSV или TSV с теми же фильтрами, что и XLSX
+func (s *Service) StreamApplications(ctx context.Context, filter domain.ApplicationFilterRequest, format string, w io.Writer) error {
+	if err := s.applyExportSearch(ctx, &filter); err != nil {
+		return err
+	}
+	nonTarget, err := s.nonTargetStage(ctx)
+	if err != nil {
+		return err
+	}
+	return s.exporter.StreamApplications(ctx, &filter, format, w, nonTarget)
 }
 
 func (s *Service) pushAudienceToRabbit(ctx context.Context, audience *domain.Audience, new_ids []int64, delete_ids []int64) error {
@@ -480,7 +723,7 @@ func (s *Service) GetRegions(ctx context.Context, filter *domain.RegionFilter) (
 	return response, nil
 }
 
-func (s *Service) ExportRegions(ctx context.Context, filter *domain.RegionFilter) (string, string, error) {
+func (s *Service) ExportRegions(ctx context.Context, filter *domain.RegionFilter, format string) (string, string, error) {
 	s.logger.Info("exporting regions data")
 
 	response, err := s.mysqlRepo.GetRegionsData(ctx, filter)
@@ -488,7 +731,7 @@ func (s *Service) ExportRegions(ctx context.Context, filter *domain.RegionFilter
 		return "", "", fmt.Errorf("get regions data: %w", err)
 	}
 
-	filePath, fileName, err := s.exporter.ExportRegionsData(response)
+	filePath, fileName, err := s.exporter.ExportRegionsData(ctx, response, filter, format)
 	if err != nil {
 		return "", "", fmt.Errorf("export regions data: %w", err)
 	}
@@ -499,26 +742,23 @@ func (s *Service) ExportRegions(ctx context.Context, filter *domain.RegionFilter
 func (s *Service) GetCallCenterReport(ctx context.Context, filter *domain.CallCenterReportFilter) (*domain.CallCenterReport, error) {
 	s.logger.Info("getting call center report")
 
-	report, err := s.mysqlRepo.GetCallCenterReportData(ctx, filter)
+	report, err := s.callCenterReport(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("get call center report: %w", err)
 	}
 
-	// Process anomalies
-	//report.Anomalies = s.detectAnomalies(report.Data)
-
 	return report, nil
 }
 
-func (s *Service) ExportCallCenterReport(ctx context.Context, filter *domain.CallCenterReportFilter) (string, string, error) {
+func (s *Service) ExportCallCenterReport(ctx context.Context, filter *domain.CallCenterReportFilter, format string) (string, string, error) {
 	s.logger.Info("exporting call center report")
 
-	report, err := s.mysqlRepo.GetCallCenterReportData(ctx, filter)
+	report, err := s.callCenterReport(ctx, filter)
 	if err != nil {
 		return "", "", fmt.Errorf("get call center report: %w", err)
 	}
 
-	filePath, fileName, err := s.exporter.ExportCallCenterReport(report)
+	filePath, fileName, err := s.exporter.ExportCallCenterReport(ctx, report, filter, format)
 	if err != nil {
 		return "", "", fmt.Errorf("export to excel: %w", err)
 	}
@@ -526,6 +766,11 @@ func (s *Service) ExportCallCenterReport(ctx context.Context, filter *domain.Cal
 	return filePath, fileName, nil
 }
 
+// Определения показателей отчета по колл-центру: формулы, числители и знаменатели долей
+func (s *Service) GetCallCenterMetrics() []domain.MetricDefinition {
+	return domain.CallCenterMetrics
+}
+
 func (s *Service) GetSpeedReport(ctx context.Context, filter *domain.StatusDurationFilter) (*domain.StatusDurationResponse, error) {
 	s.logger.Info("getting speed report")
 
@@ -537,51 +782,20 @@ func (s *Service) GetSpeedReport(ctx context.Context, filter *domain.StatusDurat
 	return report, nil
 }
 
-// func (s *Service) ExportStatusDurationReport(ctx context.Context, filter *domain.StatusDurationFilter) (string, string, error) {
-// 	s.logger.Info("exporting speed report")
-
-// 	report, err := s.mysqlRepo.GetStatusDurationReport(ctx, filter)
-// 	if err != nil {
-// 		return "", "", fmt.Errorf("get speed report: %w", err)
-// 	}
-
-// 	filePath, fileName, err := s.exporter.ExportStatusDurationReport(report)
-// 	if err != nil {
-// 		return "", "", fmt.Errorf("export to excel: %w", err)
-// 	}
-
-// 	return filePath, fileName, nil
-// }
-
-func (s *Service) detectAnomalies(data []domain.ManagerMetrics) []string {
-	var anomalies []string
-
-	// Calculate averages
-	var avgTargetConv, avgVisitConv, avgVisitSuccess float64
-	for _, m := range data {
-		avgTargetConv += m.TargetConversion
-		avgVisitConv += m.VisitConversion
-		avgVisitSuccess += m.VisitSuccess
-	}
-	count := float64(len(data))
-	avgTargetConv /= count
-	avgVisitConv /= count
-	avgVisitSuccess /= count
-
-	// Check for anomalies
-	for _, m := range data {
-		if m.TargetConversion < avgTargetConv*0.5 {
-			anomalies = append(anomalies, fmt.Sprintf("Низкая конверсия в целевые у %s: %.1f%%", m.ManagerName, m.TargetConversion))
-		}
-		if m.VisitConversion < avgVisitConv*0.5 {
-			anomalies = append(anomalies, fmt.Sprintf("Низкая конверсия в визиты у %s: %.1f%%", m.ManagerName, m.VisitConversion))
-		}
-		if m.VisitSuccess < avgVisitSuccess*0.5 {
-			anomalies = append(anomalies, fmt.Sprintf("Низкая успешность визитов у %s: %.1f%%", m.ManagerName, m.VisitSuccess))
-		}
+func (s *Service) ExportStatusDurationReport(ctx context.Context, filter *domain.StatusDurationFilter, format string) (string, string, error) {
+	s.logger.Info("exporting speed report", zap.String("format", format))
+
+	statuses, err := s.mysqlRepo.GetStatusDurations(ctx, filter)
+	if err != nil {
+		return "", "", fmt.Errorf("get speed report: %w", err)
 	}
 
-	return anomalies
+	filePath, fileName, err := s.exporter.ExportStatusDurationReport(ctx, statuses, filter, format)
+	if err != nil {
+		return "", "", fmt.Errorf("export speed report: %w", err)
+	}
+
+	return filePath, fileName, nil
 }
 
 func splitIntoChunks(ids []int64, chunkSize int) [][]int64 {
@@ -0,0 +1,171 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"reporting-service/internal/domain"
+)
+
+const (
+	defaultStatsPeriodDays = 30
+	// Ряд строится по дням, более длинный период не запрашивается
+	maxStatsPeriodDays = 731
+)
+
+func (s *Service) GetAudienceStats(ctx context.Context, id int64, filter *domain.AudienceStatsFilter) (*domain.AudienceStatsResponse, error) {
+	from, to := statsPeriod(filter)
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxStatsPeriodDays {
+		return nil, domain.NewValidationError("start_date", domain.ValidationCodeInvalidRange,
+			fmt.Sprintf("stats period is %d days, at most %d days are allowed", days, maxStatsPeriodDays))
+	}
+
+	audience, err := s.audienceRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get audience: %w", err)
+	}
+
+	series, summary, err := s.audienceStats(ctx, audience, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &domain.AudienceStatsResponse{
+		AudienceID: id,
+		StartDate:  from,
+		EndDate:    to,
+		Series:     series,
+		Summary:    summary,
+	}
+
+	if !filter.CompareAll && len(filter.CompareIDs) == 0 {
+		return response, nil
+	}
+	others, err := s.comparisonSummaries(ctx, id, filter, from, to)
+	if err != nil {
+		return nil, err
+	}
+	response.Comparison = append([]domain.AudienceStatsSummary{summary}, others...)
+
+	return response, nil
+}
+
+// Итоги аудиторий для сравнения, кроме самой аудитории, в порядке из запроса.
+// Неизвестный id в списке сравнения - ошибка ErrNotFound
+func (s *Service) comparisonSummaries(ctx context.Context, id int64, filter *domain.AudienceStatsFilter, from, to time.Time) ([]domain.AudienceStatsSummary, error) {
+	var ids []int64
+	if !filter.CompareAll {
+		ids = make([]int64, 0, len(filter.CompareIDs))
+		seen := map[int64]bool{id: true}
+		for _, compareID := range filter.CompareIDs {
+			if !seen[compareID] {
+				seen[compareID] = true
+				ids = append(ids, compareID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+	}
+
+	summaries, err := s.audienceRepo.GetStatsSummaries(ctx, ids, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("get audience stats: %w", err)
+	}
+
+	byID := make(map[int64]domain.AudienceStatsSummary, len(summaries))
+	for i := range summaries {
+		calculateStatsRates(&summaries[i])
+		byID[summaries[i].AudienceID] = summaries[i]
+	}
+
+	if filter.CompareAll {
+		others := make([]domain.AudienceStatsSummary, 0, len(summaries))
+		for _, summary := range summaries {
+			if summary.AudienceID != id {
+				others = append(others, summary)
+			}
+		}
+		return others, nil
+	}
+
+	others := make([]domain.AudienceStatsSummary, 0, len(ids))
+	for _, compareID := range ids {
+		summary, ok := byID[compareID]
+		if !ok {
+			return nil, fmt.Errorf("audience %d: %w", compareID, domain.ErrNotFound)
+		}
+		others = append(others, summary)
+	}
+	return others, nil
+}
+
+// Ряд по дням без пропусков: в дни без запуска обработки размер переносится с предыдущего дня
+func (s *Service) audienceStats(ctx context.Context, audience *domain.Audience, from, to time.Time) ([]domain.AudienceDailyStat, domain.AudienceStatsSummary, error) {
+	summary := domain.AudienceStatsSummary{
+		AudienceID:   audience.ID,
+		AudienceName: audience.Name,
+	}
+
+	stats, err := s.audienceRepo.GetDailyStats(ctx, audience.ID, from, to)
+	if err != nil {
+		return nil, summary, fmt.Errorf("get audience stats: %w", err)
+	}
+
+	startSize, err := s.audienceRepo.GetSizeBefore(ctx, audience.ID, from)
+	if err != nil {
+		return nil, summary, fmt.Errorf("get audience stats: %w", err)
+	}
+
+	byDate := make(map[string]domain.AudienceDailyStat, len(stats))
+	for _, stat := range stats {
+		byDate[stat.Date.Format("2006-01-02")] = stat
+	}
+
+	series := make([]domain.AudienceDailyStat, 0, int(to.Sub(from).Hours()/24)+1)
+	size := startSize
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		stat, ok := byDate[day.Format("2006-01-02")]
+		if !ok {
+			stat = domain.AudienceDailyStat{Date: day, Size: size}
+		}
+		size = stat.Size
+		summary.Added += stat.Added
+		summary.Removed += stat.Removed
+		series = append(series, stat)
+	}
+
+	summary.StartSize = startSize
+	summary.EndSize = size
+	calculateStatsRates(&summary)
+
+	return series, summary, nil
+}
+
+// Изменение размера и доли роста и оттока от размера на начало периода
+func calculateStatsRates(summary *domain.AudienceStatsSummary) {
+	summary.NetChange = summary.EndSize - summary.StartSize
+	if summary.StartSize > 0 {
+		summary.GrowthRate = float64(summary.NetChange) / float64(summary.StartSize)
+		summary.ChurnRate = float64(summary.Removed) / float64(summary.StartSize)
+	}
+}
+
+func statsPeriod(filter *domain.AudienceStatsFilter) (time.Time, time.Time) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if filter.EndDate != nil && !filter.EndDate.IsZero() {
+		to = time.Date(filter.EndDate.Year(), filter.EndDate.Month(), filter.EndDate.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	from := to.AddDate(0, 0, -defaultStatsPeriodDays)
+	if filter.StartDate != nil && !filter.StartDate.IsZero() {
+		from = time.Date(filter.StartDate.Year(), filter.StartDate.Month(), filter.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	if from.After(to) {
+		from, to = to, from
+	}
+	return from, to
+}
@@ -0,0 +1,551 @@
+package audience
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/mailer"
+)
+
+const (
+	maxSubscriptionRecipients = 50
+	// Попытки отправки: после неудачи следующая через 5, затем через 10 минут
+	maxDeliveryAttempts = 3
+	deliveryRetryDelay  = 5 * time.Minute
+	// Отправка в работе дольше этого срока считается брошенной и забирается повторно
+	deliveryStaleAfter = 30 * time.Minute
+	// Большие файлы почтовые серверы все равно не пропустят
+	maxDeliveryAttachmentSize = 20 << 20
+	defaultDeliveryListLimit  = 50
+	maxDeliveryListLimit      = 200
+)
+
+var (
+	errAttachmentTooLarge = errors.New("report is too large to be sent by e-mail")
+	// Отправку, пока строился отчет, забрал как зависшую другой воркер
+	errDeliveryReclaimed = errors.New("report delivery was claimed by another worker")
+)
+
+const interruptedDeliveryMessage = "отправка прервана после передачи письма почтовому серверу, повторно не выполняется"
+
+var reportPeriods = map[string]string{
+	domain.ReportPeriodYesterday:   "вчера",
+	domain.ReportPeriodLastWeek:    "прошлая неделя",
+	domain.ReportPeriodLastMonth:   "прошлый месяц",
+	domain.ReportPeriodLast7Days:   "последние 7 дней",
+	domain.ReportPeriodLast30Days:  "последние 30 дней",
+	domain.ReportPeriodWeekToDate:  "текущая неделя",
+	domain.ReportPeriodMonthToDate: "текущий месяц",
+}
+
+// Поля фильтра, в которые подставляются даты периода
+var reportPeriodFields = map[string][2]string{
+	domain.ExportReportApplications: {"created_at_from", "created_at_to"},
+	domain.ExportReportRegions:      {"start_date", "end_date"},
+	domain.ExportReportCallCenter:   {"start_date", "end_date"},
+	domain.ExportReportSpeed:        {"start_date", "end_date"},
+}
+
+func (s *Service) ListReportSubscriptions(ctx context.Context, ownerID string) ([]domain.ReportSubscription, error) {
+	subscriptions, err := s.audienceRepo.ListReportSubscriptions(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("list report subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// Подписки видит и меняет только владелец
+func (s *Service) GetReportSubscription(ctx context.Context, ownerID string, id int64) (*domain.ReportSubscription, error) {
+	subscription, err := s.audienceRepo.GetReportSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.OwnerID != ownerID {
+		return nil, fmt.Errorf("report subscription %d: %w", id, domain.ErrNotFound)
+	}
+	return subscription, nil
+}
+
+func (s *Service) CreateReportSubscription(ctx context.Context, ownerID string, req domain.ReportSubscriptionRequest) (*domain.ReportSubscription, error) {
+	subscription := &domain.ReportSubscription{OwnerID: ownerID, Enabled: true}
+	if err := s.applySubscriptionRequest(subscription, req, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := s.audienceRepo.CreateReportSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	s.logger.Info("report subscription created",
+		zap.Int64("subscription_id", subscription.ID),
+		zap.String("report_type", subscription.ReportType),
+		zap.String("schedule", subscription.Schedule))
+	return subscription, nil
+}
+
+func (s *Service) UpdateReportSubscription(ctx context.Context, ownerID string, id int64, req domain.ReportSubscriptionRequest) (*domain.ReportSubscription, error) {
+	subscription, err := s.GetReportSubscription(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applySubscriptionRequest(subscription, req, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := s.audienceRepo.UpdateReportSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (s *Service) DeleteReportSubscription(ctx context.Context, ownerID string, id int64) error {
+	if _, err := s.GetReportSubscription(ctx, ownerID, id); err != nil {
+		return err
+	}
+	return s.audienceRepo.DeleteReportSubscription(ctx, id)
+}
+
+func (s *Service) ListReportDeliveries(ctx context.Context, ownerID string, id int64, limit int) ([]domain.ReportDelivery, error) {
+	if _, err := s.GetReportSubscription(ctx, ownerID, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultDeliveryListLimit
+	}
+	deliveries, err := s.audienceRepo.ListReportDeliveries(ctx, id, min(limit, maxDeliveryListLimit))
+	if err != nil {
+		return nil, fmt.Errorf("list report deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Внеочередная отправка отчета, период считается от текущего момента
+func (s *Service) SendReportSubscription(ctx context.Context, ownerID string, id int64) (*domain.ReportDelivery, error) {
+	subscription, err := s.GetReportSubscription(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := newReportDelivery(subscription, time.Now(), scheduleLocation(subscription.Schedule))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.audienceRepo.CreateReportDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Ставит в очередь отправки по наступившим расписаниям и отправляет все, что ждет в очереди
+func (s *Service) ProcessReportSubscriptions(ctx context.Context) error {
+	if s.mailer == nil {
+		return errors.New("mailer is not configured")
+	}
+	if err := s.scheduleReportDeliveries(ctx, time.Now()); err != nil {
+		return err
+	}
+
+	staleBefore := time.Now().Add(-deliveryStaleAfter)
+	if failed, err := s.audienceRepo.FailInterruptedReportDeliveries(ctx, staleBefore, interruptedDeliveryMessage); err != nil {
+		return err
+	} else if failed > 0 {
+		s.logger.Warn("interrupted report deliveries marked as failed", zap.Int64("count", failed))
+	}
+
+	for {
+		delivery, err := s.audienceRepo.ClaimReportDelivery(ctx, staleBefore)
+		if err != nil {
+			return err
+		}
+		if delivery == nil {
+			return nil
+		}
+		s.runReportDelivery(ctx, delivery)
+	}
+}
+
+// Пропущенные за время простоя запуски не догоняются: подписка получает одну отправку
+// и переносится на ближайший будущий запуск
+func (s *Service) scheduleReportDeliveries(ctx context.Context, now time.Time) error {
+	subscriptions, err := s.audienceRepo.ListDueReportSubscriptions(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for i := range subscriptions {
+		subscription := &subscriptions[i]
+		schedule, err := cron.ParseStandard(subscription.Schedule)
+		if err != nil {
+			s.logger.Error("invalid report subscription schedule",
+				zap.Int64("subscription_id", subscription.ID), zap.Error(err))
+			continue
+		}
+
+		dueAt := *subscription.NextRunAt
+		delivery, err := newReportDelivery(subscription, dueAt, scheduleLocation(subscription.Schedule))
+		if err != nil {
+			s.logger.Error("failed to prepare report delivery",
+				zap.Int64("subscription_id", subscription.ID), zap.Error(err))
+			continue
+		}
+
+		nextRunAt := schedule.Next(now)
+		scheduled, err := s.audienceRepo.ScheduleReportDelivery(ctx, delivery, dueAt, &nextRunAt)
+		if err != nil {
+			return err
+		}
+		if scheduled {
+			s.logger.Info("report delivery scheduled",
+				zap.Int64("subscription_id", subscription.ID),
+				zap.Int64("delivery_id", delivery.ID),
+				zap.Time("next_run_at", nextRunAt))
+		}
+	}
+	return nil
+}
+
+func (s *Service) runReportDelivery(ctx context.Context, delivery *domain.ReportDelivery) {
+	fileName, err := s.sendReportDelivery(ctx, delivery)
+	if errors.Is(err, errDeliveryReclaimed) {
+		s.logger.Warn("report delivery skipped", zap.Int64("delivery_id", delivery.ID), zap.Error(err))
+		return
+	}
+	// Результат сохраняется и при остановке сервиса: иначе отправленное письмо ушло бы повторно
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if err := s.audienceRepo.CompleteReportDelivery(ctx, delivery.ID, fileName); err != nil {
+			s.logger.Error("failed to complete report delivery", zap.Int64("delivery_id", delivery.ID), zap.Error(err))
+			return
+		}
+		s.logger.Info("report delivered",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int64("subscription_id", delivery.SubscriptionID),
+			zap.Strings("recipients", delivery.Recipients))
+		return
+	}
+
+	// Ошибки в параметрах и слишком большой файл повтором не исправить
+	var validationErr *domain.ValidationError
+	permanent := errors.As(err, &validationErr) || errors.Is(err, errAttachmentTooLarge) || errors.Is(err, domain.ErrNotFound)
+	if permanent || delivery.Attempts >= maxDeliveryAttempts {
+		s.logger.Error("report delivery failed",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int("attempts", delivery.Attempts),
+			zap.Error(err))
+		if err := s.audienceRepo.FailReportDelivery(ctx, delivery.ID, err.Error()); err != nil {
+			s.logger.Error("failed to mark report delivery as failed", zap.Int64("delivery_id", delivery.ID), zap.Error(err))
+		}
+		return
+	}
+
+	nextAttemptAt := time.Now().Add(deliveryRetryDelay << (delivery.Attempts - 1))
+	s.logger.Warn("report delivery will be retried",
+		zap.Int64("delivery_id", delivery.ID),
+		zap.Int("attempts", delivery.Attempts),
+		zap.Time("next_attempt_at", nextAttemptAt),
+		zap.Error(err))
+	if err := s.audienceRepo.RetryReportDelivery(ctx, delivery.ID, err.Error(), nextAttemptAt); err != nil {
+		s.logger.Error("failed to requeue report delivery", zap.Int64("delivery_id", delivery.ID), zap.Error(err))
+	}
+}
+
+// Строит отчет тем же кодом, что и фоновая выгрузка, и отправляет его вложением.
+// Файл в хранилище нужен только на время отправки
+func (s *Service) sendReportDelivery(ctx context.Context, delivery *domain.ReportDelivery) (string, error) {
+	subscription, err := s.audienceRepo.GetReportSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return "", err
+	}
+
+	key, fileName, err := s.renderExport(ctx, &domain.ExportJob{
+		OwnerID:    subscription.OwnerID,
+		ReportType: delivery.ReportType,
+		Format:     delivery.Format,
+		Params:     delivery.Params,
+	})
+	if err != nil {
+		return "", fmt.Errorf("render report: %w", err)
+	}
+	defer func() {
+		if err := s.storage.Delete(context.Background(), key); err != nil {
+			s.logger.Warn("failed to remove delivered report", zap.String("key", key), zap.Error(err))
+		}
+	}()
+
+	file, err := s.OpenExport(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxDeliveryAttachmentSize+1))
+	if err != nil {
+		return "", fmt.Errorf("read report: %w", err)
+	}
+	if len(data) > maxDeliveryAttachmentSize {
+		return "", errAttachmentTooLarge
+	}
+
+	// Даты периода в письме в часовом поясе расписания
+	loc := scheduleLocation(subscription.Schedule)
+	if delivery.PeriodStart != nil && delivery.PeriodEnd != nil {
+		start, end := delivery.PeriodStart.In(loc), delivery.PeriodEnd.In(loc)
+		delivery.PeriodStart, delivery.PeriodEnd = &start, &end
+	}
+
+	// Отметка ставится до передачи письма: зависшую после нее отправку никто не повторит
+	if delivery.StartedAt == nil {
+		return "", fmt.Errorf("report delivery %d is not claimed", delivery.ID)
+	}
+	marked, err := s.audienceRepo.MarkReportDeliveryMailed(ctx, delivery.ID, *delivery.StartedAt)
+	if err != nil {
+		return "", err
+	}
+	if !marked {
+		return "", errDeliveryReclaimed
+	}
+
+	if err := s.mailDelivery(ctx, subscription, delivery, fileName, data); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+// Письмо с отчетом во вложении
+func (s *Service) mailDelivery(ctx context.Context, subscription *domain.ReportSubscription, delivery *domain.ReportDelivery, fileName string, data []byte) error {
+	msg := mailer.Message{
+		To:      delivery.Recipients,
+		Subject: deliverySubject(subscription.Name, delivery.ScheduledAt.In(scheduleLocation(subscription.Schedule)), delivery),
+		Body:    deliveryBody(subscription, delivery),
+		Attachments: []mailer.Attachment{{
+			Name:        fileName,
+			ContentType: ExportContentType(fileName),
+			Data:        data,
+		}},
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("send report: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) applySubscriptionRequest(subscription *domain.ReportSubscription, req domain.ReportSubscriptionRequest, now time.Time) error {
+	if len(req.Params) == 0 {
+		req.Params = json.RawMessage(`{}`)
+	}
+	if req.Format == "" {
+		req.Format = ExportFormatXLSX
+	}
+
+	result := &domain.ValidationResult{}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		result.AddError("name", domain.ValidationCodeRequired, "name is required")
+	} else if len(name) > 255 {
+		result.AddError("name", domain.ValidationCodeInvalidValue, "name must not exceed 255 characters")
+	}
+
+	if err := validateReportFormat(req.ReportType, req.Format); err != nil {
+		var validationErr *domain.ValidationError
+		if !errors.As(err, &validationErr) {
+			return err
+		}
+		result.Errors = append(result.Errors, validationErr.Errors...)
+	}
+
+	if req.Period != "" {
+		if _, ok := reportPeriods[req.Period]; !ok {
+			result.AddError("period", domain.ValidationCodeInvalidValue, fmt.Sprintf("unknown period %q", req.Period))
+		} else if _, ok := reportPeriodFields[req.ReportType]; !ok && req.ReportType != "" {
+			result.AddError("period", domain.ValidationCodeInvalidValue,
+				fmt.Sprintf("%s report does not support period", req.ReportType))
+		}
+	}
+
+	recipients, err := normalizeRecipients(req.Recipients)
+	if err != nil {
+		result.AddError("recipients", domain.ValidationCodeInvalidValue, err.Error())
+	} else if len(recipients) == 0 {
+		result.AddError("recipients", domain.ValidationCodeRequired, "at least one recipient is required")
+	} else if len(recipients) > maxSubscriptionRecipients {
+		result.AddError("recipients", domain.ValidationCodeInvalidValue,
+			fmt.Sprintf("no more than %d recipients allowed", maxSubscriptionRecipients))
+	}
+
+	scheduleSpec := strings.TrimSpace(req.Schedule)
+	var schedule cron.Schedule
+	if scheduleSpec == "" {
+		result.AddError("schedule", domain.ValidationCodeRequired, "schedule is required")
+	} else if schedule, err = cron.ParseStandard(scheduleSpec); err != nil {
+		result.AddError("schedule", domain.ValidationCodeInvalidValue, fmt.Sprintf("invalid cron schedule: %v", err))
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	// Параметры проверяются так же, как у фоновой выгрузки
+	if err := s.validateExportParams(&domain.ExportJob{ReportType: req.ReportType, Params: req.Params}); err != nil {
+		return err
+	}
+
+	subscription.Name = name
+	subscription.ReportType = req.ReportType
+	subscription.Format = req.Format
+	subscription.Params = req.Params
+	subscription.Period = req.Period
+	subscription.Recipients = recipients
+	subscription.Schedule = scheduleSpec
+	if req.Enabled != nil {
+		subscription.Enabled = *req.Enabled
+	}
+	subscription.NextRunAt = nil
+	if subscription.Enabled {
+		next := schedule.Next(now)
+		subscription.NextRunAt = &next
+	}
+	return nil
+}
+
+func normalizeRecipients(recipients []string) ([]string, error) {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, recipient := range recipients {
+		if recipient = strings.TrimSpace(recipient); recipient == "" {
+			continue
+		}
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e-mail %q", recipient)
+		}
+		key := strings.ToLower(address.Address)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, address.Address)
+		}
+	}
+	return result, nil
+}
+
+// Отправка с параметрами отчета на момент at: даты периода подставляются в фильтр,
+// остальные поля фильтра берутся из подписки
+func newReportDelivery(subscription *domain.ReportSubscription, at time.Time, loc *time.Location) (*domain.ReportDelivery, error) {
+	delivery := &domain.ReportDelivery{
+		SubscriptionID: subscription.ID,
+		ReportType:     subscription.ReportType,
+		Format:         subscription.Format,
+		Params:         subscription.Params,
+		Recipients:     subscription.Recipients,
+		ScheduledAt:    at,
+	}
+
+	fields, ok := reportPeriodFields[subscription.ReportType]
+	if subscription.Period == "" || !ok {
+		return delivery, nil
+	}
+	start, end, err := resolveReportPeriod(subscription.Period, at.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]json.RawMessage{}
+	if err := json.Unmarshal(subscription.Params, &params); err != nil {
+		return nil, fmt.Errorf("decode subscription %d params: %w", subscription.ID, err)
+	}
+	for i, value := range []time.Time{start, end} {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("encode period: %w", err)
+		}
+		params[fields[i]] = encoded
+	}
+	if delivery.Params, err = json.Marshal(params); err != nil {
+		return nil, fmt.Errorf("encode delivery params: %w", err)
+	}
+	delivery.PeriodStart = &start
+	delivery.PeriodEnd = &end
+	return delivery, nil
+}
+
+// Границы периода включительно: с начала первого дня до конца последнего.
+// Отчеты по дням берут последний день целиком, см. repository.ReportDateUntil.
+// Периоды "текущая неделя" и "текущий месяц" заканчиваются моментом отправки
+func resolveReportPeriod(period string, at time.Time) (time.Time, time.Time, error) {
+	today := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	// Неделя начинается с понедельника
+	weekStart := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	monthStart := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location())
+	endOf := func(day time.Time) time.Time {
+		return day.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	switch period {
+	case domain.ReportPeriodYesterday:
+		yesterday := today.AddDate(0, 0, -1)
+		return yesterday, endOf(yesterday), nil
+	case domain.ReportPeriodLastWeek:
+		start := weekStart.AddDate(0, 0, -7)
+		return start, endOf(start.AddDate(0, 0, 6)), nil
+	case domain.ReportPeriodLastMonth:
+		start := monthStart.AddDate(0, -1, 0)
+		return start, monthStart.Add(-time.Second), nil
+	case domain.ReportPeriodLast7Days:
+		return today.AddDate(0, 0, -7), today.Add(-time.Second), nil
+	case domain.ReportPeriodLast30Days:
+		return today.AddDate(0, 0, -30), today.Add(-time.Second), nil
+	case domain.ReportPeriodWeekToDate:
+		return weekStart, at, nil
+	case domain.ReportPeriodMonthToDate:
+		return monthStart, at, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown period %q", period)
+}
+
+// Часовой пояс расписания из префикса CRON_TZ= или TZ=, иначе часовой пояс сервиса
+func scheduleLocation(spec string) *time.Location {
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if !strings.HasPrefix(spec, prefix) {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(spec, prefix), " ")
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+func deliverySubject(name string, scheduledAt time.Time, delivery *domain.ReportDelivery) string {
+	if delivery.PeriodStart == nil || delivery.PeriodEnd == nil {
+		return fmt.Sprintf("%s на %s", name, scheduledAt.Format("02.01.2006"))
+	}
+	return fmt.Sprintf("%s за %s", name, deliveryPeriodLabel(delivery))
+}
+
+func deliveryBody(subscription *domain.ReportSubscription, delivery *domain.ReportDelivery) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Отчет «%s» во вложении.\n", subscription.Name)
+	if delivery.PeriodStart != nil && delivery.PeriodEnd != nil {
+		fmt.Fprintf(&body, "Период: %s, %s.\n", reportPeriods[subscription.Period], deliveryPeriodLabel(delivery))
+	}
+	fmt.Fprintf(&body, "Сформирован: %s.\n", time.Now().Format("02.01.2006 15:04"))
+	body.WriteString("\nПисьмо отправлено автоматически по подписке на отчет.\n")
+	return body.String()
+}
+
+func deliveryPeriodLabel(delivery *domain.ReportDelivery) string {
+	start := delivery.PeriodStart.Format("02.01.2006")
+	end := delivery.PeriodEnd.Format("02.01.2006")
+	if start == end {
+		return start
+	}
+	return start + " – " + end
+}
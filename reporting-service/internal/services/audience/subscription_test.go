@@ -0,0 +1,260 @@
+package audience
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/mailer"
+	"reporting-service/internal/repository"
+)
+
+// Запоминает письма вместо отправки
+type fakeMailer struct {
+	messages []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func moscow(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	return loc
+}
+
+func TestResolveReportPeriod(t *testing.T) {
+	loc := moscow(t)
+	date := func(year int, month time.Month, day, hour, minute, sec int) time.Time {
+		return time.Date(year, month, day, hour, minute, sec, 0, loc)
+	}
+	// Среда
+	at := date(2025, time.January, 15, 9, 30, 0)
+
+	tests := []struct {
+		period     string
+		at         time.Time
+		start, end time.Time
+	}{
+		{domain.ReportPeriodYesterday, at, date(2025, 1, 14, 0, 0, 0), date(2025, 1, 14, 23, 59, 59)},
+		{domain.ReportPeriodYesterday, date(2025, 3, 1, 8, 0, 0), date(2025, 2, 28, 0, 0, 0), date(2025, 2, 28, 23, 59, 59)},
+		{domain.ReportPeriodLastWeek, at, date(2025, 1, 6, 0, 0, 0), date(2025, 1, 12, 23, 59, 59)},
+		// В понедельник прошлая неделя - полностью предыдущая, в воскресенье - неделя до текущей
+		{domain.ReportPeriodLastWeek, date(2025, 1, 13, 9, 0, 0), date(2025, 1, 6, 0, 0, 0), date(2025, 1, 12, 23, 59, 59)},
+		{domain.ReportPeriodLastWeek, date(2025, 1, 19, 9, 0, 0), date(2025, 1, 6, 0, 0, 0), date(2025, 1, 12, 23, 59, 59)},
+		{domain.ReportPeriodLastMonth, at, date(2024, 12, 1, 0, 0, 0), date(2024, 12, 31, 23, 59, 59)},
+		{domain.ReportPeriodLastMonth, date(2024, 3, 10, 9, 0, 0), date(2024, 2, 1, 0, 0, 0), date(2024, 2, 29, 23, 59, 59)},
+		{domain.ReportPeriodLast7Days, at, date(2025, 1, 8, 0, 0, 0), date(2025, 1, 14, 23, 59, 59)},
+		{domain.ReportPeriodLast30Days, at, date(2024, 12, 16, 0, 0, 0), date(2025, 1, 14, 23, 59, 59)},
+		{domain.ReportPeriodWeekToDate, at, date(2025, 1, 13, 0, 0, 0), at},
+		{domain.ReportPeriodMonthToDate, at, date(2025, 1, 1, 0, 0, 0), at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period+" "+tt.at.Format("2006-01-02"), func(t *testing.T) {
+			start, end, err := resolveReportPeriod(tt.period, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("period = %v - %v, want %v - %v", start, end, tt.start, tt.end)
+			}
+		})
+	}
+
+	if _, _, err := resolveReportPeriod("last_year", at); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
+
+func TestScheduleLocation(t *testing.T) {
+	loc := moscow(t)
+	for spec, want := range map[string]*time.Location{
+		"CRON_TZ=Europe/Moscow 0 9 * * 1": loc,
+		"TZ=Europe/Moscow 0 9 * * 1":      loc,
+		"CRON_TZ=Mars/Olympus 0 9 * * 1":  time.Local,
+		"0 9 * * 1":                       time.Local,
+	} {
+		if got := scheduleLocation(spec); got.String() != want.String() {
+			t.Errorf("scheduleLocation(%q) = %v, want %v", spec, got, want)
+		}
+	}
+}
+
+// Подписка по понедельникам в 9:00 по Москве на отчет за прошлую неделю: следующий запуск,
+// период в параметрах отчета и письмо, которое уходит получателям
+func TestScheduledDeliveryMail(t *testing.T) {
+	loc := moscow(t)
+	s := &Service{}
+	enabled := true
+
+	subscription := &domain.ReportSubscription{ID: 7, Enabled: true}
+	now := time.Date(2025, time.January, 15, 12, 0, 0, 0, loc)
+	err := s.applySubscriptionRequest(subscription, domain.ReportSubscriptionRequest{
+		Name:       " Колл-центр ",
+		ReportType: domain.ExportReportCallCenter,
+		Params:     json.RawMessage(`{"show_optional":true}`),
+		Period:     domain.ReportPeriodLastWeek,
+		Recipients: []string{"Head <head@example.com>", "HEAD@example.com", " ", "team@example.com"},
+		Schedule:   "CRON_TZ=Europe/Moscow 0 9 * * 1",
+		Enabled:    &enabled,
+	}, now)
+	if err != nil {
+		t.Fatalf("applySubscriptionRequest: %v", err)
+	}
+	if subscription.Format != ExportFormatXLSX {
+		t.Errorf("format = %q, want xlsx by default", subscription.Format)
+	}
+	if strings.Join(subscription.Recipients, ",") != "head@example.com,team@example.com" {
+		t.Errorf("recipients = %v", subscription.Recipients)
+	}
+	nextRun := time.Date(2025, time.January, 20, 9, 0, 0, 0, loc)
+	if subscription.NextRunAt == nil || !subscription.NextRunAt.Equal(nextRun) {
+		t.Fatalf("next run = %v, want %v", subscription.NextRunAt, nextRun)
+	}
+
+	delivery, err := newReportDelivery(subscription, *subscription.NextRunAt, scheduleLocation(subscription.Schedule))
+	if err != nil {
+		t.Fatalf("newReportDelivery: %v", err)
+	}
+	wantStart := time.Date(2025, time.January, 13, 0, 0, 0, 0, loc)
+	wantEnd := time.Date(2025, time.January, 19, 23, 59, 59, 0, loc)
+	if !delivery.PeriodStart.Equal(wantStart) || !delivery.PeriodEnd.Equal(wantEnd) {
+		t.Errorf("period = %v - %v, want %v - %v", delivery.PeriodStart, delivery.PeriodEnd, wantStart, wantEnd)
+	}
+
+	var filter domain.CallCenterReportFilter
+	if err := json.Unmarshal(delivery.Params, &filter); err != nil {
+		t.Fatalf("decode delivery params: %v", err)
+	}
+	if !filter.ShowOptional || filter.StartDate == nil || !filter.StartDate.Equal(wantStart) ||
+		filter.EndDate == nil || !filter.EndDate.Equal(wantEnd) {
+		t.Errorf("delivery filter = %+v", filter)
+	}
+
+	fake := &fakeMailer{}
+	s.mailer = fake
+	if err := s.mailDelivery(context.Background(), subscription, delivery, "call_center_report.xlsx", []byte("xlsx")); err != nil {
+		t.Fatalf("mailDelivery: %v", err)
+	}
+	if len(fake.messages) != 1 {
+		t.Fatalf("sent %d messages", len(fake.messages))
+	}
+	sent := fake.messages[0]
+	if sent.Subject != "Колл-центр за 13.01.2025 – 19.01.2025" {
+		t.Errorf("subject = %q", sent.Subject)
+	}
+	if !strings.Contains(sent.Body, "Период: прошлая неделя, 13.01.2025 – 19.01.2025.") {
+		t.Errorf("body = %q", sent.Body)
+	}
+	if strings.Join(sent.To, ",") != "head@example.com,team@example.com" {
+		t.Errorf("to = %v", sent.To)
+	}
+	if len(sent.Attachments) != 1 || sent.Attachments[0].Name != "call_center_report.xlsx" ||
+		sent.Attachments[0].ContentType != ExportContentType("report.xlsx") {
+		t.Errorf("attachments = %+v", sent.Attachments)
+	}
+}
+
+// Без периода в подписке фильтр не меняется, а тема письма - дата отправки
+func TestDeliveryWithoutPeriod(t *testing.T) {
+	loc := moscow(t)
+	subscription := &domain.ReportSubscription{
+		ID:         3,
+		Name:       "Регионы",
+		ReportType: domain.ExportReportRegions,
+		Format:     ExportFormatPDF,
+		Params:     json.RawMessage(`{"start_date":"2024-01-01T00:00:00Z"}`),
+		Recipients: []string{"head@example.com"},
+		Schedule:   "CRON_TZ=Europe/Moscow 30 8 1 * *",
+	}
+	at := time.Date(2025, time.February, 1, 8, 30, 0, 0, loc)
+
+	delivery, err := newReportDelivery(subscription, at, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.PeriodStart != nil || string(delivery.Params) != string(subscription.Params) {
+		t.Errorf("delivery = %+v", delivery)
+	}
+
+	fake := &fakeMailer{}
+	s := &Service{mailer: fake}
+	if err := s.mailDelivery(context.Background(), subscription, delivery, "regions.pdf", nil); err != nil {
+		t.Fatalf("mailDelivery: %v", err)
+	}
+	if len(fake.messages) != 1 || fake.messages[0].Subject != "Регионы на 01.02.2025" {
+		t.Errorf("messages = %+v", fake.messages)
+	}
+}
+
+func TestSubscriptionRequestValidation(t *testing.T) {
+	s := &Service{}
+	err := s.applySubscriptionRequest(&domain.ReportSubscription{}, domain.ReportSubscriptionRequest{
+		ReportType: domain.ExportReportAudience,
+		Period:     domain.ReportPeriodLastWeek,
+		Recipients: []string{"not an e-mail"},
+		Schedule:   "every monday",
+	}, time.Now())
+
+	validationErr, ok := err.(*domain.ValidationError)
+	if !ok {
+		t.Fatalf("err = %v, want validation error", err)
+	}
+	fields := map[string]bool{}
+	for _, fieldErr := range validationErr.Errors {
+		fields[fieldErr.Field] = true
+	}
+	for _, field := range []string{"name", "period", "recipients", "schedule"} {
+		if !fields[field] {
+			t.Errorf("no validation error for %s: %v", field, validationErr.Errors)
+		}
+	}
+}
+
+// Период подписки в том виде, в каком его получает отчет: последний день входит целиком
+func TestDeliveryPeriodReportDates(t *testing.T) {
+	loc := moscow(t)
+	tests := []struct {
+		period   string
+		at       time.Time
+		from, to string
+	}{
+		{domain.ReportPeriodYesterday, time.Date(2025, time.January, 15, 9, 0, 0, 0, loc), "2025-01-14", "2025-01-15"},
+		// Отчет колл-центра по понедельникам за прошлую неделю включает воскресенье
+		{domain.ReportPeriodLastWeek, time.Date(2025, time.January, 20, 9, 0, 0, 0, loc), "2025-01-13", "2025-01-20"},
+		{domain.ReportPeriodLastMonth, time.Date(2025, time.March, 1, 8, 0, 0, 0, loc), "2025-02-01", "2025-03-01"},
+		{domain.ReportPeriodMonthToDate, time.Date(2025, time.January, 15, 9, 0, 0, 0, loc), "2025-01-01", "2025-01-16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			subscription := &domain.ReportSubscription{
+				ReportType: domain.ExportReportCallCenter,
+				Params:     json.RawMessage(`{"show_optional":true}`),
+				Period:     tt.period,
+			}
+			delivery, err := newReportDelivery(subscription, tt.at, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var filter domain.CallCenterReportFilter
+			if err := json.Unmarshal(delivery.Params, &filter); err != nil {
+				t.Fatal(err)
+			}
+			if filter.StartDate == nil || filter.EndDate == nil {
+				t.Fatalf("filter = %+v", filter)
+			}
+			from, to := repository.ReportDateFrom(*filter.StartDate), repository.ReportDateUntil(*filter.EndDate)
+			if from != tt.from || to != tt.to {
+				t.Errorf("report dates = [%s, %s), want [%s, %s)", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package audience
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"reporting-service/internal/domain"
+	"reporting-service/internal/repository"
+)
+
+// Максимальный период фильтра аудитории
+const maxAudienceFilterRange = 366 * 24 * time.Hour
+
+// Проверяет фильтр аудитории. Ошибки формата возвращаются сразу, без обращения к БД;
+// предупреждения (дубль, устаревшие статусы) требуют запросов к MacroCRM и Postgres.
+// Пустой результат проверяет вызывающий код по уже полученным заявкам, см. addEmptyResultWarning.
+// excludeAudienceID исключает редактируемую аудиторию из поиска дублей.
+func (s *Service) validateAudienceFilter(ctx context.Context, filter *domain.AudienceCreationFilter, excludeAudienceID int64) (*domain.ValidationResult, error) {
+	result := &domain.ValidationResult{}
+
+	if filter.StartDate == nil || filter.StartDate.IsZero() {
+		result.AddError("filter.creation_date_from", domain.ValidationCodeRequired, "start date field required")
+	}
+
+	if filter.StartDate != nil && filter.EndDate != nil && !filter.EndDate.IsZero() {
+		if filter.StartDate.After(*filter.EndDate) {
+			result.AddError("filter.creation_date_to", domain.ValidationCodeInvalidRange, "start date must be before end date")
+		} else if filter.EndDate.Sub(*filter.StartDate) > maxAudienceFilterRange {
+			result.AddError("filter.creation_date_to", domain.ValidationCodeInvalidRange, "date range must be less than 366 days")
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	if err := s.checkFilterNames(ctx, filter, result); err != nil {
+		return nil, err
+	}
+
+	filters, err := s.audienceRepo.ListFilters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list filters: %w", err)
+	}
+	var duplicateIDs []int64
+	for i := range filters {
+		if filters[i].AudienceId == excludeAudienceID || !sameAudienceFilter(filter, &filters[i]) {
+			continue
+		}
+		duplicateIDs = append(duplicateIDs, filters[i].AudienceId)
+	}
+	if len(duplicateIDs) == 0 {
+		return result, nil
+	}
+
+	names, err := s.audienceRepo.GetNamesByIDs(ctx, duplicateIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get duplicate audiences: %w", err)
+	}
+	for _, id := range duplicateIDs {
+		name, ok := names[id]
+		if !ok {
+			name = fmt.Sprintf("#%d", id)
+		}
+		result.AddWarning("filter", domain.ValidationCodeDuplicate,
+			fmt.Sprintf("filter is identical to audience %q", name))
+	}
+
+	return result, nil
+}
+
+// Предупреждение о фильтре без заявок
+func addEmptyResultWarning(result *domain.ValidationResult, count int) {
+	if count == 0 {
+		result.AddWarning("filter", domain.ValidationCodeEmptyResult, "no applications match the filter")
+	}
+}
+
+// Статусы и причины, которых больше нет в estate_buys / estate_statuses_reasons
+func (s *Service) checkFilterNames(ctx context.Context, filter *domain.AudienceCreationFilter, result *domain.ValidationResult) error {
+	if len(filter.StatusIDs) > 0 || len(filter.ReasonIDs) > 0 ||
+		len(filter.RejectionReasonIDs) > 0 || len(filter.NonTargetReasonIDs) > 0 {
+		dictionary, err := s.GetFilterDictionary(ctx)
+		if err != nil {
+			return err
+		}
+		for _, id := range filter.StatusIDs {
+			if !dictionaryContains(dictionary.Statuses, id) {
+				result.AddWarning("filter.status_ids", domain.ValidationCodeUnknownStatus,
+					fmt.Sprintf("status id %d no longer exists", id))
+			}
+		}
+		for _, id := range filter.ReasonIDs {
+			if !dictionaryContains(dictionary.Reasons, id) {
+				result.AddWarning("filter.reason_ids", domain.ValidationCodeUnknownReason,
+					fmt.Sprintf("reason id %d no longer exists", id))
+			}
+		}
+		for _, id := range filter.RejectionReasonIDs {
+			if !dictionaryContains(dictionary.RejectionReasons, id) {
+				result.AddWarning("filter.rejection_reason_ids", domain.ValidationCodeUnknownReason,
+					fmt.Sprintf("rejection reason id %d is not used by rejected applications", id))
+			}
+		}
+		for _, id := range filter.NonTargetReasonIDs {
+			if !dictionaryContains(dictionary.NonTargetReasons, id) {
+				result.AddWarning("filter.non_target_reason_ids", domain.ValidationCodeUnknownReason,
+					fmt.Sprintf("non target reason id %d is not used by non target applications", id))
+			}
+		}
+	}
+
+	if len(filter.StatusNames) > 0 {
+		statuses, err := s.mysqlRepo.ListStatusNames(ctx)
+		if err != nil {
+			return fmt.Errorf("list statuses: %w", err)
+		}
+		for _, name := range filter.StatusNames {
+			if !repository.SliceConatinsString(statuses, name) {
+				result.AddWarning("filter.statuses", domain.ValidationCodeUnknownStatus,
+					fmt.Sprintf("status %q no longer exists", name))
+			}
+		}
+	}
+
+	if len(filter.RegectionReasonNames) > 0 || len(filter.NonTargetReasonNames) > 0 {
+		reasons, err := s.mysqlRepo.ListReasonNames(ctx)
+		if err != nil {
+			return fmt.Errorf("list reasons: %w", err)
+		}
+		for _, name := range filter.RegectionReasonNames {
+			if !repository.SliceConatinsString(reasons, name) {
+				result.AddWarning("filter.rejection_reasons", domain.ValidationCodeUnknownReason,
+					fmt.Sprintf("reason %q no longer exists", name))
+			}
+		}
+		for _, name := range filter.NonTargetReasonNames {
+			if !repository.SliceConatinsString(reasons, name) {
+				result.AddWarning("filter.non_target_reasons", domain.ValidationCodeUnknownReason,
+					fmt.Sprintf("reason %q no longer exists", name))
+			}
+		}
+	}
+
+	return nil
+}
+
+func sameAudienceFilter(a, b *domain.AudienceCreationFilter) bool {
+	if !sameDate(a.StartDate, b.StartDate) || !sameDate(a.EndDate, b.EndDate) {
+		return false
+	}
+	// Фильтры с идентификаторами сравниваются по ним, старые — по названиям
+	if len(a.StatusIDs) > 0 && len(b.StatusIDs) > 0 {
+		if !sameIDSet(a.StatusIDs, b.StatusIDs) {
+			return false
+		}
+	} else if !sameStringSet(a.StatusNames, b.StatusNames) {
+		return false
+	}
+	if !sameIDSet(a.ReasonIDs, b.ReasonIDs) {
+		return false
+	}
+	if len(a.RejectionReasonIDs) > 0 && len(b.RejectionReasonIDs) > 0 {
+		if !sameIDSet(a.RejectionReasonIDs, b.RejectionReasonIDs) {
+			return false
+		}
+	} else if !sameStringSet(a.RegectionReasonNames, b.RegectionReasonNames) {
+		return false
+	}
+	if len(a.NonTargetReasonIDs) > 0 && len(b.NonTargetReasonIDs) > 0 {
+		return sameIDSet(a.NonTargetReasonIDs, b.NonTargetReasonIDs)
+	}
+	return sameStringSet(a.NonTargetReasonNames, b.NonTargetReasonNames)
+}
+
+func sameIDSet(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[int64]int, len(a))
+	for _, id := range a {
+		counts[id]++
+	}
+	for _, id := range b {
+		if counts[id] == 0 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
+
+func dictionaryContains(items []domain.DictionaryItem, id int64) bool {
+	for _, item := range items {
+		if item.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sameDate(a, b *time.Time) bool {
+	aEmpty := a == nil || a.IsZero()
+	bEmpty := b == nil || b.IsZero()
+	if aEmpty || bEmpty {
+		return aEmpty == bEmpty
+	}
+	// timestamp without time zone хранит время без смещения, сравниваем по показаниям часов
+	return a.Format("2006-01-02T15:04:05") == b.Format("2006-01-02T15:04:05")
+}
+
+func sameStringSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string(nil), a...)
+	sortedB := append([]string(nil), b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	return strings.Join(sortedA, "\x00") == strings.Join(sortedB, "\x00")
+}
@@ -0,0 +1,201 @@
+package audience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"reporting-service/internal/domain"
+	MysqlRepo "reporting-service/internal/repository/mysql"
+)
+
+func (s *Service) ListApplicationViews(ctx context.Context, ownerID string) ([]domain.ApplicationView, error) {
+	views, err := s.audienceRepo.ListApplicationViews(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("list application views: %w", err)
+	}
+	return views, nil
+}
+
+// Чужие представления доступны, только если они общие
+func (s *Service) GetApplicationView(ctx context.Context, ownerID string, id int64) (*domain.ApplicationView, error) {
+	view, err := s.audienceRepo.GetApplicationView(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if view.OwnerID != ownerID && !view.Shared {
+		return nil, fmt.Errorf("application view %d: %w", id, domain.ErrNotFound)
+	}
+	return view, nil
+}
+
+func (s *Service) CreateApplicationView(ctx context.Context, ownerID string, req domain.ApplicationViewRequest) (*domain.ApplicationView, error) {
+	view := &domain.ApplicationView{OwnerID: ownerID}
+	if err := applyViewRequest(view, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.audienceRepo.CreateApplicationView(ctx, view); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// Изменять и удалять представление может только владелец
+func (s *Service) UpdateApplicationView(ctx context.Context, ownerID string, id int64, req domain.ApplicationViewRequest) (*domain.ApplicationView, error) {
+	view, err := s.ownApplicationView(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyViewRequest(view, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.audienceRepo.UpdateApplicationView(ctx, view); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+func (s *Service) DeleteApplicationView(ctx context.Context, ownerID string, id int64) error {
+	if _, err := s.ownApplicationView(ctx, ownerID, id); err != nil {
+		return err
+	}
+	return s.audienceRepo.DeleteApplicationView(ctx, id)
+}
+
+func (s *Service) ownApplicationView(ctx context.Context, ownerID string, id int64) (*domain.ApplicationView, error) {
+	view, err := s.GetApplicationView(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if view.OwnerID != ownerID {
+		return nil, fmt.Errorf("application view %d: %w", id, domain.ErrForbidden)
+	}
+	return view, nil
+}
+
+// Фильтр представления с параметрами запроса поверх него: явно переданные параметры важнее сохраненных
+func (s *Service) ApplyApplicationView(ctx context.Context, ownerID string, viewID int64, filter *domain.ApplicationFilterRequest) error {
+	view, err := s.GetApplicationView(ctx, ownerID, viewID)
+	if err != nil {
+		return err
+	}
+
+	merged := view.Filter
+	merged.OrderField = view.OrderField
+	merged.OrderDirection = view.OrderDirection
+	merged.Columns = view.Columns
+	mergeApplicationFilter(&merged, filter)
+	*filter = merged
+	return nil
+}
+
+func applyViewRequest(view *domain.ApplicationView, req domain.ApplicationViewRequest) error {
+	result := &domain.ValidationResult{}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		result.AddError("name", domain.ValidationCodeRequired, "name is required")
+	} else if len(name) > 255 {
+		result.AddError("name", domain.ValidationCodeInvalidValue, "name must not exceed 255 characters")
+	}
+
+	filter := req.Filter
+	filter.OrderField = req.OrderField
+	filter.OrderDirection = req.OrderDirection
+	if err := MysqlRepo.ValidateApplicationOrder(&filter); err != nil {
+		var validationErr *domain.ValidationError
+		if errors.As(err, &validationErr) {
+			result.Errors = append(result.Errors, validationErr.Errors...)
+		} else {
+			return err
+		}
+	}
+	if filter.DaysInStatusMin != nil && filter.DaysInStatusMax != nil && *filter.DaysInStatusMin > *filter.DaysInStatusMax {
+		result.AddError("filter.days_in_status_min", domain.ValidationCodeInvalidRange,
+			"days_in_status_min must not exceed days_in_status_max")
+	}
+	if filter.StartDate != nil && filter.EndDate != nil && filter.StartDate.After(*filter.EndDate) {
+		result.AddError("filter.created_at_from", domain.ValidationCodeInvalidRange,
+			"start date must be before end date")
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	// Сортировка хранится отдельно от фильтра
+	filter.OrderField = ""
+	filter.OrderDirection = ""
+
+	columns := []string{}
+	for _, column := range req.Columns {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	if err := MysqlRepo.ValidateApplicationColumns(columns); err != nil {
+		return err
+	}
+
+	view.Name = name
+	view.Shared = req.Shared
+	view.Filter = filter
+	view.OrderField = req.OrderField
+	view.OrderDirection = req.OrderDirection
+	view.Columns = columns
+	return nil
+}
+
+func mergeApplicationFilter(base *domain.ApplicationFilterRequest, override *domain.ApplicationFilterRequest) {
+	if override.OrderField != "" {
+		base.OrderField = override.OrderField
+		base.OrderDirection = override.OrderDirection
+	}
+	mergeStrings(&base.Statuses, override.Statuses)
+	mergeStrings(&base.ExcludedStatuses, override.ExcludedStatuses)
+	mergeStrings(&base.ProjectNames, override.ProjectNames)
+	mergeStrings(&base.ExcludedProjectNames, override.ExcludedProjectNames)
+	mergeStrings(&base.PropertyTypes, override.PropertyTypes)
+	mergeStrings(&base.ExcludedPropertyTypes, override.ExcludedPropertyTypes)
+	mergeStrings(&base.RegionNames, override.RegionNames)
+	mergeStrings(&base.ExcludedRegionNames, override.ExcludedRegionNames)
+	mergeStrings(&base.ManagerNames, override.ManagerNames)
+	mergeStrings(&base.ExcludedManagerNames, override.ExcludedManagerNames)
+	mergeIDs(&base.ManagerIDs, override.ManagerIDs)
+	mergeIDs(&base.ExcludedManagerIDs, override.ExcludedManagerIDs)
+	mergeIDs(&base.RejectionReasonIDs, override.RejectionReasonIDs)
+	mergeIDs(&base.NonTargetReasonIDs, override.NonTargetReasonIDs)
+	if override.DaysInStatusMin != nil {
+		base.DaysInStatusMin = override.DaysInStatusMin
+	}
+	if override.DaysInStatusMax != nil {
+		base.DaysInStatusMax = override.DaysInStatusMax
+	}
+	if override.StartDate != nil {
+		base.StartDate = override.StartDate
+	}
+	if override.EndDate != nil {
+		base.EndDate = override.EndDate
+	}
+	if override.AudienceName != "" {
+		base.AudienceName = override.AudienceName
+	}
+	if override.Query != "" {
+		base.Query = override.Query
+	}
+	mergeStrings(&base.Columns, override.Columns)
+}
+
+func mergeStrings(base *[]string, override []string) {
+	if len(override) > 0 {
+		*base = override
+	}
+}
+
+func mergeIDs(base *[]int64, override []int64) {
+	if len(override) > 0 {
+		*base = override
+	}
+}
@@ -0,0 +1,296 @@
+package audience
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Форматы ячеек потоковых листов
+const (
+	cellString   = "string"
+	cellNumber   = "number"
+	cellPercent  = "percent"
+	cellDate     = "date"
+	cellDateTime = "datetime"
+)
+
+// Сколько первых строк держится в памяти для расчета ширины колонок.
+// StreamWriter требует задать ширины до первой записанной строки
+const xlsxWidthSampleRows = 200
+
+const (
+	xlsxMinColumnWidth = 8
+	xlsxMaxColumnWidth = 60
+)
+
+type xlsxColumn struct {
+	Title  string
+	Format string
+}
+
+// Стили создаются один раз на файл и общие для всех листов
+type xlsxStyles struct {
+	header int
+	cells  map[string]int
+	totals map[string]int
+}
+
+func newXLSXStyles(f *excelize.File) (*xlsxStyles, error) {
+	header, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#CCCCCC"}},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create header style: %w", err)
+	}
+
+	// Встроенные форматы Excel: 0 - общий, 10 - 0.00%, 14 - дата, 22 - дата и время
+	numFmts := map[string]int{
+		cellString:   0,
+		cellNumber:   0,
+		cellPercent:  10,
+		cellDate:     14,
+		cellDateTime: 22,
+	}
+	styles := &xlsxStyles{header: header, cells: map[string]int{}, totals: map[string]int{}}
+	for format, numFmt := range numFmts {
+		if styles.cells[format], err = f.NewStyle(&excelize.Style{NumFmt: numFmt}); err != nil {
+			return nil, fmt.Errorf("create %s style: %w", format, err)
+		}
+		if styles.totals[format], err = f.NewStyle(&excelize.Style{
+			NumFmt: numFmt,
+			Font:   &excelize.Font{Bold: true},
+			Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#E0E0E0"}},
+		}); err != nil {
+			return nil, fmt.Errorf("create %s total style: %w", format, err)
+		}
+	}
+	return styles, nil
+}
+
+type xlsxRow struct {
+	values []interface{}
+	total  bool
+}
+
+// Лист, который пишется построчно через StreamWriter: закрепленная шапка, автофильтр
+// по строкам данных и ширина колонок по первым строкам
+type xlsxSheet struct {
+	stream   *excelize.StreamWriter
+	columns  []xlsxColumn
+	styles   *xlsxStyles
+	widths   []int
+	pending  []xlsxRow
+	started  bool
+	row      int
+	dataRows int
+}
+
+// Первый лист книги переименовывается из Sheet1, остальные создаются
+func newXLSXSheet(f *excelize.File, name string, columns []xlsxColumn, styles *xlsxStyles) (*xlsxSheet, error) {
+	if f.SheetCount == 1 && f.GetSheetName(0) == "Sheet1" {
+		if err := f.SetSheetName("Sheet1", name); err != nil {
+			return nil, fmt.Errorf("rename sheet: %w", err)
+		}
+	} else if _, err := f.NewSheet(name); err != nil {
+		return nil, fmt.Errorf("create sheet %s: %w", name, err)
+	}
+
+	stream, err := f.NewStreamWriter(name)
+	if err != nil {
+		return nil, fmt.Errorf("create stream writer: %w", err)
+	}
+
+	sheet := &xlsxSheet{
+		stream:  stream,
+		columns: columns,
+		styles:  styles,
+		widths:  make([]int, len(columns)),
+	}
+	for i, column := range columns {
+		sheet.measure(i, column.Title)
+	}
+	return sheet, nil
+}
+
+func (s *xlsxSheet) WriteRow(values ...interface{}) error {
+	s.dataRows++
+	return s.write(xlsxRow{values: values})
+}
+
+// Итоговая строка не попадает в автофильтр и выделяется стилем
+func (s *xlsxSheet) WriteTotal(values ...interface{}) error {
+	return s.write(xlsxRow{values: values, total: true})
+}
+
+func (s *xlsxSheet) write(row xlsxRow) error {
+	if s.started {
+		return s.setRow(row)
+	}
+
+	for i, value := range row.values {
+		if i < len(s.columns) {
+			s.measure(i, displayValue(value, s.columns[i].Format))
+		}
+	}
+	s.pending = append(s.pending, row)
+	if len(s.pending) >= xlsxWidthSampleRows {
+		return s.start()
+	}
+	return nil
+}
+
+// Ширины и закрепление задаются до первой строки, затем пишутся шапка и накопленные строки
+func (s *xlsxSheet) start() error {
+	s.started = true
+
+	for i, width := range s.widths {
+		width = max(xlsxMinColumnWidth, min(width+2, xlsxMaxColumnWidth))
+		if err := s.stream.SetColWidth(i+1, i+1, float64(width)); err != nil {
+			return fmt.Errorf("set column width: %w", err)
+		}
+	}
+	if err := s.stream.SetPanes(&excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return fmt.Errorf("freeze header: %w", err)
+	}
+
+	header := make([]interface{}, len(s.columns))
+	for i, column := range s.columns {
+		header[i] = excelize.Cell{StyleID: s.styles.header, Value: column.Title}
+	}
+	s.row = 1
+	if err := s.stream.SetRow("A1", header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	for _, row := range s.pending {
+		if err := s.setRow(row); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	return nil
+}
+
+func (s *xlsxSheet) setRow(row xlsxRow) error {
+	styles := s.styles.cells
+	if row.total {
+		styles = s.styles.totals
+	}
+
+	cells := make([]interface{}, len(row.values))
+	for i, value := range row.values {
+		format := cellString
+		if i < len(s.columns) {
+			format = s.columns[i].Format
+		}
+		cells[i] = excelize.Cell{StyleID: styles[format], Value: cellValue(value)}
+	}
+
+	s.row++
+	cell, _ := excelize.CoordinatesToCellName(1, s.row)
+	if err := s.stream.SetRow(cell, cells); err != nil {
+		return fmt.Errorf("write row %d: %w", s.row, err)
+	}
+	return nil
+}
+
+// Завершает лист. Автофильтр ставится через таблицу, если заголовки уникальны, как требует Excel
+func (s *xlsxSheet) Close() error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+
+	if s.dataRows > 0 && len(s.columns) > 0 && uniqueTitles(s.columns) {
+		lastCell, _ := excelize.CoordinatesToCellName(len(s.columns), s.dataRows+1)
+		showStripes := false
+		if err := s.stream.AddTable(&excelize.Table{
+			Range:          "A1:" + lastCell,
+			ShowRowStripes: &showStripes,
+		}); err != nil {
+			return fmt.Errorf("add autofilter: %w", err)
+		}
+	}
+
+	if err := s.stream.Flush(); err != nil {
+		return fmt.Errorf("flush sheet: %w", err)
+	}
+	return nil
+}
+
+func (s *xlsxSheet) measure(column int, text string) {
+	s.widths[column] = max(s.widths[column], utf8.RuneCountInString(text))
+}
+
+func uniqueTitles(columns []xlsxColumn) bool {
+	seen := map[string]bool{}
+	for _, column := range columns {
+		if column.Title == "" || seen[column.Title] {
+			return false
+		}
+		seen[column.Title] = true
+	}
+	return true
+}
+
+// Пустые значения и нечисловые float не пишутся, чтобы не портить ячейку
+func cellValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+	return value
+}
+
+// Текст ячейки примерно как его покажет Excel, для расчета ширины
+func displayValue(value interface{}, format string) string {
+	switch v := cellValue(value).(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if format == cellDate {
+			return v.Format("02.01.2006")
+		}
+		return v.Format("02.01.2006 15:04")
+	case float64:
+		if format == cellPercent {
+			return fmt.Sprintf("%.2f%%", v*100)
+		}
+		return fmt.Sprintf("%.2f", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Формат ячейки по формату заголовка ответа API
+func headerCellFormat(format string) string {
+	switch format {
+	case "number":
+		return cellNumber
+	case "percent":
+		return cellPercent
+	case "date":
+		return cellDateTime
+	default:
+		return cellString
+	}
+}
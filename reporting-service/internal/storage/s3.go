@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3-совместимое хранилище (AWS S3, MinIO). Бакет адресуется в пути, как требует MinIO по умолчанию
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Storage(cfg Config) (*S3Storage, error) {
+	if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("s3 storage requires endpoint and bucket")
+	}
+	if cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+		return nil, fmt.Errorf("s3 storage requires access and secret keys")
+	}
+
+	// Адрес можно задать со схемой, тогда она важнее S3UseSSL
+	host, secure := cfg.S3Endpoint, cfg.S3UseSSL
+	if strings.Contains(host, "://") {
+		parsed, err := url.Parse(host)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.S3Endpoint)
+		}
+		host, secure = parsed.Host, parsed.Scheme == "https"
+	}
+
+	// С явным регионом клиент не запрашивает расположение бакета
+	region := cfg.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	client, err := minio.New(host, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure:       secure,
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %w", err)
+	}
+
+	return &S3Storage{
+		client: client,
+		bucket: cfg.S3Bucket,
+		prefix: strings.Trim(cfg.S3Prefix, "/"),
+	}, nil
+}
+
+// Содержимое не хешируется (UNSIGNED-PAYLOAD): иначе по http файл пришлось бы подписывать по частям
+func (s *S3Storage) Save(ctx context.Context, key string, r io.Reader, size int64) error {
+	objectName, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.PutObject(ctx, s.bucket, objectName, r, size, minio.PutObjectOptions{
+		DisableContentSha256: true,
+	}); err != nil {
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// Объект запрашивается сразу, чтобы отсутствие файла было видно до начала ответа
+func (s *S3Storage) Open(ctx context.Context, key string) (*Object, error) {
+	objectName, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, s.bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", key, err)
+	}
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if isS3NotFound(err) {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("download %s: %w", key, err)
+	}
+	return &Object{ReadSeekCloser: object, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	objectName, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{}); err != nil && !isS3NotFound(err) {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) objectName(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if s.prefix != "" {
+		cleaned = s.prefix + "/" + cleaned
+	}
+	return cleaned, nil
+}
+
+func isS3NotFound(err error) bool {
+	response := minio.ToErrorResponse(err)
+	return response.StatusCode == http.StatusNotFound || response.Code == "NoSuchKey"
+}
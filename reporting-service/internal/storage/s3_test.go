@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// S3 в памяти: только запросы к объектам, которые делает S3Storage
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	paths   []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		http.ServeContent(w, r, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(data))
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3Storage(t *testing.T) (*S3Storage, *fakeS3) {
+	t.Helper()
+	backend := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(backend)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3Storage(Config{
+		Backend:     BackendS3,
+		S3Endpoint:  server.URL,
+		S3Bucket:    "exports",
+		S3AccessKey: "access",
+		S3SecretKey: "secret",
+		S3Prefix:    "/reports/",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+	return s, backend
+}
+
+func TestS3StorageSaveOpenDelete(t *testing.T) {
+	s, backend := newTestS3Storage(t)
+	ctx := context.Background()
+	content := []byte("Регион;Заявки\nМосква;10\n")
+
+	if err := s.Save(ctx, "REGIONS_REPORTS/../REGIONS_REPORTS/report.csv", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, ok := backend.objects["/exports/reports/REGIONS_REPORTS/report.csv"]; !ok {
+		t.Fatalf("object stored under unexpected path, requests: %v", backend.paths)
+	}
+
+	object, err := s.Open(ctx, "REGIONS_REPORTS/report.csv")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if object.Size != int64(len(content)) {
+		t.Errorf("Size = %d, want %d", object.Size, len(content))
+	}
+	if !object.ModTime.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("ModTime = %v", object.ModTime)
+	}
+
+	// Отдача диапазона: после Seek читается хвост файла
+	offset := int64(len("Регион;Заявки\n"))
+	if _, err := object.Seek(offset, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	tail, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(tail) != "Москва;10\n" {
+		t.Errorf("tail = %q", tail)
+	}
+	object.Close()
+
+	if err := s.Delete(ctx, "REGIONS_REPORTS/report.csv"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Open(ctx, "REGIONS_REPORTS/report.csv"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open after delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3StorageMissingObject(t *testing.T) {
+	s, _ := newTestS3Storage(t)
+	ctx := context.Background()
+
+	if _, err := s.Open(ctx, "missing.xlsx"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "missing.xlsx"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+}
+
+func TestNewS3StorageValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"no endpoint", Config{S3Bucket: "exports", S3AccessKey: "a", S3SecretKey: "s"}},
+		{"no bucket", Config{S3Endpoint: "localhost:9000", S3AccessKey: "a", S3SecretKey: "s"}},
+		{"no keys", Config{S3Endpoint: "localhost:9000", S3Bucket: "exports"}},
+		{"bad endpoint", Config{S3Endpoint: "http://", S3Bucket: "exports", S3AccessKey: "a", S3SecretKey: "s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewS3Storage(tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
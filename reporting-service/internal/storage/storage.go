@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Хранилище файлов выгрузок. Ключ - относительный путь вида "REGIONS_REPORTS/regions_report_20240101_120000.xlsx"
+type Storage interface {
+	Save(ctx context.Context, key string, r io.Reader, size int64) error
+	Open(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var ErrNotFound = errors.New("file not found")
+
+// Открытый файл хранилища. Поддерживает Seek, чтобы файл можно было отдавать по частям (Range)
+type Object struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+type Config struct {
+	Backend string `yaml:"backend"`
+	// Каталог для локального хранилища
+	LocalPath string `yaml:"local_path"`
+	// S3-совместимое хранилище, например MinIO
+	S3Endpoint  string `yaml:"s3_endpoint"`
+	S3Region    string `yaml:"s3_region"`
+	S3Bucket    string `yaml:"s3_bucket"`
+	S3AccessKey string `yaml:"s3_access_key"`
+	S3SecretKey string `yaml:"s3_secret_key" json:"-"`
+	S3UseSSL    bool   `yaml:"s3_use_ssl"`
+	S3Prefix    string `yaml:"s3_prefix"`
+}
+
+func New(cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		return NewLocalStorage(cfg.LocalPath)
+	case BackendS3:
+		return NewS3Storage(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// Ключ без выхода за пределы хранилища
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "" || cleaned == "." {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return cleaned, nil
+}